@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"ecommerce-app/models"
+	"log"
+	"os"
+	"time"
+)
+
+// defaultCartTTL is how long a cart is kept after it was last changed
+const defaultCartTTL = 30 * 24 * time.Hour
+
+// cartTTLFromEnv reads CART_TTL, e.g. "720h", falling back to defaultCartTTL.
+func cartTTLFromEnv() time.Duration {
+	v := os.Getenv("CART_TTL")
+	if v == "" {
+		return defaultCartTTL
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil || ttl <= 0 {
+		log.Printf("Invalid CART_TTL %q, using %s", v, defaultCartTTL)
+		return defaultCartTTL
+	}
+	return ttl
+}
+
+// pruneCarts deletes abandoned carts, those left unchanged for longer than
+// ttl, now and then every hour.
+func pruneCarts(store *models.Store, ttl time.Duration) {
+	for {
+		n, err := store.Carts.Prune(context.Background(), time.Now().Add(-ttl))
+		if err != nil {
+			log.Printf("Error pruning abandoned carts: %v", err)
+		} else if n > 0 {
+			log.Printf("Pruned %d abandoned cart(s)", n)
+		}
+		time.Sleep(time.Hour)
+	}
+}
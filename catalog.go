@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"ecommerce-app/models"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// defaultCatalogFile is the sample catalog loaded into an empty store
+const defaultCatalogFile = "data/catalog.csv"
+
+// runCatalogCommand handles `catalog import [-dry-run] [-prune] file` and
+// `catalog export [-format csv|json] [file]`.
+func runCatalogCommand(store *models.Store, args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: catalog import [-dry-run] [-prune] <file.csv|file.json>")
+		fmt.Fprintln(os.Stderr, "       catalog export [-format csv|json] [file]")
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "import":
+		flags := flag.NewFlagSet("catalog import", flag.ExitOnError)
+		dryRun := flags.Bool("dry-run", false, "show the changes without saving them")
+		prune := flags.Bool("prune", false, "archive products missing from the file")
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			usage()
+		}
+
+		result, err := importCatalogFile(ctx, store, flags.Arg(0), models.ImportOptions{DryRun: *dryRun, Prune: *prune})
+		if result != nil {
+			printImportResult(result)
+		}
+		if err != nil {
+			log.Fatalf("Import failed: %v", err)
+		}
+		if len(result.Errors) > 0 {
+			os.Exit(1)
+		}
+
+	case "export":
+		flags := flag.NewFlagSet("catalog export", flag.ExitOnError)
+		formatName := flags.String("format", "", "csv or json (default: from the file name, else csv)")
+		flags.Parse(args[1:])
+
+		out := os.Stdout
+		name := flags.Arg(0)
+		if name != "" {
+			f, err := os.Create(name)
+			if err != nil {
+				log.Fatalf("Error creating %s: %v", name, err)
+			}
+			defer f.Close()
+			out = f
+		}
+		if *formatName == "" {
+			*formatName = string(models.CatalogCSV)
+			if name != "" {
+				*formatName = name
+			}
+		}
+		format, err := models.ParseCatalogFormat(*formatName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := models.ExportCatalog(ctx, store.Products, store.Categories, out, format); err != nil {
+			log.Fatalf("Export failed: %v", err)
+		}
+
+	default:
+		usage()
+	}
+}
+
+// importCatalogFile imports the catalog file at path, its format given by
+// the extension.
+func importCatalogFile(ctx context.Context, store *models.Store, path string, opts models.ImportOptions) (*models.ImportResult, error) {
+	format, err := models.ParseCatalogFormat(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return models.ImportCatalog(ctx, store.Products, store.Categories, f, format, opts)
+}
+
+// printImportResult lists an import's changes and row errors.
+func printImportResult(result *models.ImportResult) {
+	for _, changes := range [][]models.ImportChange{result.Creates, result.Updates, result.Deletes} {
+		for _, c := range changes {
+			line := fmt.Sprintf("%-7s %s  %s", c.Action, c.ProductID, c.Name)
+			if len(c.Fields) > 0 {
+				line += "  (" + strings.Join(c.Fields, ", ") + ")"
+			}
+			fmt.Println(line)
+		}
+	}
+	for _, e := range result.Errors {
+		fmt.Println("error   " + e.Error())
+	}
+
+	summary := fmt.Sprintf("%d to create, %d to update, %d to delete, %d unchanged",
+		len(result.Creates), len(result.Updates), len(result.Deletes), result.Unchanged)
+	switch {
+	case result.Applied:
+		summary = strings.NewReplacer("to create", "created", "to update", "updated", "to delete", "deleted").Replace(summary)
+	case len(result.Errors) > 0:
+		summary += fmt.Sprintf("; nothing saved because of %d error(s)", len(result.Errors))
+	default:
+		summary += " (dry run)"
+	}
+	fmt.Println(summary)
+}
+
+// seedCatalog imports the sample catalog (CATALOG_SEED_FILE, by default
+// data/catalog.csv) when the store has no products yet, so that changes made
+// since are never overwritten.
+func seedCatalog(store *models.Store) {
+	ctx := context.Background()
+	existing, err := store.Products.List(ctx, models.ProductFilter{IncludeArchived: true})
+	if err != nil {
+		log.Fatalf("Error checking for products: %v", err)
+	}
+	if len(existing) > 0 {
+		return
+	}
+
+	path := os.Getenv("CATALOG_SEED_FILE")
+	if path == "" {
+		path = defaultCatalogFile
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		log.Printf("The catalog is empty and there is no %s to seed it from", path)
+		return
+	}
+	result, err := importCatalogFile(ctx, store, path, models.ImportOptions{})
+	if err != nil {
+		log.Fatalf("Error seeding the catalog from %s: %v", path, err)
+	}
+	if len(result.Errors) > 0 {
+		for _, e := range result.Errors {
+			log.Printf("%s: %v", path, e)
+		}
+		log.Fatalf("Error seeding the catalog from %s: %d invalid row(s)", path, len(result.Errors))
+	}
+	log.Printf("Seeded %d product(s) from %s", len(result.Creates), path)
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config holds the database connection settings.
+type Config struct {
+	Driver          string        // "sqlite" (default) or "postgres"
+	DSN             string        // file path for SQLite, connection URL for PostgreSQL
+	MaxOpenConns    int           // 0 means unlimited
+	MaxIdleConns    int           // 0 keeps database/sql's default
+	ConnMaxLifetime time.Duration // 0 means connections are reused forever
+	BusyTimeout     time.Duration // SQLite only: how long to wait on a locked database
+	WAL             bool          // SQLite only: use write-ahead logging
+	ForeignKeys     bool          // SQLite only: enforce FOREIGN KEY constraints
+}
+
+// ConfigFromEnv builds a Config from DB_* environment variables, defaulting to
+// an on-disk SQLite database named ecommerce.db.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Driver:          envString("DB_DRIVER", "sqlite"),
+		MaxOpenConns:    envInt("DB_MAX_OPEN_CONNS", 0),
+		MaxIdleConns:    envInt("DB_MAX_IDLE_CONNS", 0),
+		ConnMaxLifetime: envDuration("DB_CONN_MAX_LIFETIME", 0),
+		BusyTimeout:     envDuration("DB_BUSY_TIMEOUT", 5*time.Second),
+		WAL:             envBool("DB_WAL", true),
+		ForeignKeys:     envBool("DB_FOREIGN_KEYS", true),
+	}
+
+	defaultDSN := "ecommerce.db"
+	if cfg.Driver == "postgres" {
+		defaultDSN = "postgres://localhost:5432/ecommerce?sslmode=disable"
+	}
+	cfg.DSN = envString("DB_DSN", defaultDSN)
+
+	return cfg
+}
+
+func envString(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using %d", key, v, fallback)
+		return fallback
+	}
+	return n
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using %s", key, v, fallback)
+		return fallback
+	}
+	return d
+}
+
+func envBool(key string, fallback bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using %t", key, v, fallback)
+		return fallback
+	}
+	return b
+}
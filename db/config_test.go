@@ -0,0 +1,33 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		for _, key := range []string{"DB_DRIVER", "DB_DSN", "DB_MAX_OPEN_CONNS", "DB_MAX_IDLE_CONNS", "DB_CONN_MAX_LIFETIME", "DB_BUSY_TIMEOUT", "DB_WAL", "DB_FOREIGN_KEYS"} {
+			t.Setenv(key, "")
+		}
+		want := Config{Driver: "sqlite", DSN: "ecommerce.db", BusyTimeout: 5 * time.Second, WAL: true, ForeignKeys: true}
+		if got := ConfigFromEnv(); got != want {
+			t.Errorf("ConfigFromEnv = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		t.Setenv("DB_DRIVER", "postgres")
+		t.Setenv("DB_DSN", "")
+		t.Setenv("DB_MAX_OPEN_CONNS", "20")
+		t.Setenv("DB_CONN_MAX_LIFETIME", "30m")
+		t.Setenv("DB_WAL", "not a bool") // ignored with a warning
+		cfg := ConfigFromEnv()
+		if cfg.Driver != "postgres" || cfg.DSN != "postgres://localhost:5432/ecommerce?sslmode=disable" {
+			t.Errorf("driver %q with DSN %q, want postgres on localhost", cfg.Driver, cfg.DSN)
+		}
+		if cfg.MaxOpenConns != 20 || cfg.ConnMaxLifetime != 30*time.Minute || !cfg.WAL {
+			t.Errorf("ConfigFromEnv = %+v, want 20 connections living 30m, and the WAL default", cfg)
+		}
+	})
+}
@@ -4,67 +4,107 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
+	"net/url"
+	"strings"
 
-	_ "modernc.org/sqlite" // Import the pure Go SQLite driver
+	_ "github.com/jackc/pgx/v5/stdlib" // Register the "pgx" PostgreSQL driver
+	_ "modernc.org/sqlite"             // Import the pure Go SQLite driver
 )
 
 var DB *sql.DB
 
-// InitDB initializes the database connection and creates tables.
-func InitDB() {
+// Dialect names the SQL dialect of DB and selects the migrations that apply to it.
+var Dialect = "sqlite"
+
+// InitDB opens the database described by cfg and applies its pool settings.
+// The schema itself is managed by the versioned migrations in db/migrations
+// (see MigrateUp and CheckSchema).
+func InitDB(cfg Config) {
 	var err error
-	// Use an in-memory database for simplicity. For persistent storage, use a file path like "ecommerce.db"
-	DB, err = sql.Open("sqlite", ":memory:")
+	switch cfg.Driver {
+	case "sqlite":
+		DB, err = sql.Open("sqlite", sqliteDSN(cfg))
+	case "postgres":
+		DB, err = sql.Open("pgx", cfg.DSN)
+	default:
+		log.Fatalf("Unsupported DB_DRIVER %q (expected sqlite or postgres)", cfg.Driver)
+	}
 	if err != nil {
 		log.Fatalf("Error opening database: %v", err)
 	}
+	Dialect = cfg.Driver
 
-	// Create products table
-	createProductsTableSQL := `CREATE TABLE IF NOT EXISTS products (
-		id TEXT PRIMARY KEY,
-		name TEXT NOT NULL,
-		description TEXT,
-		price REAL NOT NULL,
-		image_url TEXT
-	);`
+	if cfg.Driver == "sqlite" && isSQLiteMemory(cfg.DSN) {
+		// Every new connection to ":memory:" gets its own empty database, so keep exactly one.
+		DB.SetMaxOpenConns(1)
+	} else if cfg.MaxOpenConns > 0 {
+		DB.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		DB.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		DB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
 
-	_, err = DB.Exec(createProductsTableSQL)
-	if err != nil {
-		log.Fatalf("Error creating products table: %v", err)
+	if err := DB.Ping(); err != nil {
+		log.Fatalf("Error connecting to %s database: %v", cfg.Driver, err)
 	}
 
-	// Create orders table
-	createOrdersTableSQL := `CREATE TABLE IF NOT EXISTS orders (
-		id TEXT PRIMARY KEY,
-		customer_email TEXT NOT NULL,
-		total_amount REAL NOT NULL,
-		status TEXT NOT NULL,
-		stripe_id TEXT,
-		created_at DATETIME,
-		updated_at DATETIME
-	);`
+	log.Printf("Database connection opened (%s).", cfg.Driver)
+}
 
-	_, err = DB.Exec(createOrdersTableSQL)
-	if err != nil {
-		log.Fatalf("Error creating orders table: %v", err)
+// sqliteDSN appends the busy timeout, journal mode and foreign key pragmas to
+// the configured path so that they apply to every pooled connection.
+func sqliteDSN(cfg Config) string {
+	params := url.Values{}
+	params.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", cfg.BusyTimeout.Milliseconds()))
+	if cfg.ForeignKeys {
+		params.Add("_pragma", "foreign_keys(1)")
 	}
+	if cfg.WAL && !isSQLiteMemory(cfg.DSN) {
+		params.Add("_pragma", "journal_mode(WAL)")
+	}
+
+	dsn := cfg.DSN
+	if !strings.HasPrefix(dsn, "file:") && !isSQLiteMemory(dsn) {
+		dsn = "file:" + dsn
+	}
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	return dsn + separator + params.Encode()
+}
 
-	// Create order_items table
-	createOrderItemsTableSQL := `CREATE TABLE IF NOT EXISTS order_items (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		order_id TEXT NOT NULL,
-		product_id TEXT NOT NULL,
-		product_name TEXT NOT NULL,
-		quantity INTEGER NOT NULL,
-		unit_price REAL NOT NULL,
-		FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
-	);`
+func isSQLiteMemory(dsn string) bool {
+	return dsn == ":memory:" || strings.Contains(dsn, "mode=memory")
+}
 
-	_, err = DB.Exec(createOrderItemsTableSQL)
-	if err != nil {
-		log.Fatalf("Error creating order_items table: %v", err)
+// Rebind rewrites "?" placeholders into the "$1, $2, ..." form when dialect
+// is PostgreSQL. Queries throughout the app are written with "?".
+func Rebind(dialect, query string) string {
+	if dialect != "postgres" {
+		return query
 	}
 
-	log.Println("Database initialized and tables created.")
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+			b.WriteRune(r)
+		case r == '?' && !inString:
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
 }
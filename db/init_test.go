@@ -0,0 +1,56 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		dialect string
+		query   string
+		want    string
+	}{
+		{"sqlite", "SELECT * FROM orders WHERE id = ? AND status = ?", "SELECT * FROM orders WHERE id = ? AND status = ?"},
+		{"postgres", "SELECT * FROM orders WHERE id = ? AND status = ?", "SELECT * FROM orders WHERE id = $1 AND status = $2"},
+		{"postgres", "INSERT INTO t (a, b, c) VALUES (?, ?, ?)", "INSERT INTO t (a, b, c) VALUES ($1, $2, $3)"},
+		{"postgres", "SELECT * FROM products WHERE name = 'why?' AND id = ?", "SELECT * FROM products WHERE name = 'why?' AND id = $1"},
+		{"postgres", "UPDATE t SET note = 'it''s ?' WHERE id = ?", "UPDATE t SET note = 'it''s ?' WHERE id = $1"},
+		{"postgres", "SELECT 1", "SELECT 1"},
+	}
+	for _, tt := range tests {
+		if got := Rebind(tt.dialect, tt.query); got != tt.want {
+			t.Errorf("Rebind(%s, %q) = %q, want %q", tt.dialect, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSQLiteDSN(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{
+			name: "file",
+			cfg:  Config{DSN: "ecommerce.db", BusyTimeout: 5 * time.Second, WAL: true, ForeignKeys: true},
+			want: "file:ecommerce.db?_pragma=busy_timeout%285000%29&_pragma=foreign_keys%281%29&_pragma=journal_mode%28WAL%29",
+		},
+		{
+			name: "file with parameters",
+			cfg:  Config{DSN: "file:shop.db?cache=shared", BusyTimeout: time.Second},
+			want: "file:shop.db?cache=shared&_pragma=busy_timeout%281000%29",
+		},
+		{
+			// An in-memory database cannot use write-ahead logging
+			name: "in memory",
+			cfg:  Config{DSN: ":memory:", WAL: true, ForeignKeys: true},
+			want: ":memory:?_pragma=busy_timeout%280%29&_pragma=foreign_keys%281%29",
+		},
+	}
+	for _, tt := range tests {
+		if got := sqliteDSN(tt.cfg); got != tt.want {
+			t.Errorf("%s: sqliteDSN = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrationFilePattern matches files such as "0002_add_currency.up.sql".
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is a single versioned schema change with its up and down steps.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // SHA-256 of the up step, used to detect edited migrations
+}
+
+// MigrationState describes a known migration and whether it has been applied.
+type MigrationState struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	Modified  bool // applied checksum differs from the embedded file
+}
+
+// appliedMigration is a row of the schema_migrations table.
+type appliedMigration struct {
+	Version   int
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// LoadMigrations reads the embedded migrations for the given dialect, sorted by version.
+func LoadMigrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations for %s: %w", dialect, err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		contents, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d has conflicting names %s and %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(contents)
+			sum := sha256.Sum256(contents)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up step", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// ensureMigrationsTable creates the schema_migrations bookkeeping table.
+func ensureMigrationsTable(conn *sql.DB) error {
+	_, err := conn.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// appliedMigrations returns the rows of schema_migrations keyed by version.
+func appliedMigrations(conn *sql.DB) (map[int]appliedMigration, error) {
+	rows, err := conn.Query("SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error fetching applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.AppliedAt); err != nil {
+			return nil, fmt.Errorf("error scanning applied migration row: %w", err)
+		}
+		applied[a.Version] = a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating through applied migration rows: %w", err)
+	}
+
+	return applied, nil
+}
+
+// MigrationStatus lists every known migration alongside its applied state.
+func MigrationStatus(conn *sql.DB, dialect string) ([]MigrationState, error) {
+	migrations, err := LoadMigrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureMigrationsTable(conn); err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		state := MigrationState{Migration: m}
+		if a, ok := applied[m.Version]; ok {
+			state.Applied = true
+			state.AppliedAt = a.AppliedAt
+			state.Modified = a.Checksum != m.Checksum
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// verifyApplied makes sure every applied migration still matches its embedded file
+// and that the database has not been migrated by a newer build.
+func verifyApplied(migrations []Migration, applied map[int]appliedMigration) error {
+	known := make(map[int]Migration, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = m
+	}
+	for version, a := range applied {
+		m, ok := known[version]
+		if !ok {
+			return fmt.Errorf("database has migration %04d_%s applied which this build does not know about", version, a.Name)
+		}
+		if a.Checksum != m.Checksum {
+			return fmt.Errorf("migration %04d_%s was edited after being applied (checksum mismatch)", version, m.Name)
+		}
+	}
+	return nil
+}
+
+// MigrateUp applies every pending migration in order, each in its own transaction.
+// It returns the number of migrations applied.
+func MigrateUp(conn *sql.DB, dialect string) (int, error) {
+	migrations, err := LoadMigrations(dialect)
+	if err != nil {
+		return 0, err
+	}
+	if err := ensureMigrationsTable(conn); err != nil {
+		return 0, err
+	}
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return 0, err
+	}
+	if err := verifyApplied(migrations, applied); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := applyMigration(conn, dialect, m); err != nil {
+			return count, err
+		}
+		log.Printf("Applied migration %04d_%s.", m.Version, m.Name)
+		count++
+	}
+	return count, nil
+}
+
+// MigrateDown reverts the most recently applied migrations, up to steps of them.
+// It returns the number of migrations reverted.
+func MigrateDown(conn *sql.DB, dialect string, steps int) (int, error) {
+	migrations, err := LoadMigrations(dialect)
+	if err != nil {
+		return 0, err
+	}
+	if err := ensureMigrationsTable(conn); err != nil {
+		return 0, err
+	}
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return 0, err
+	}
+	if err := verifyApplied(migrations, applied); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == "" {
+			return count, fmt.Errorf("migration %04d_%s has no down step", m.Version, m.Name)
+		}
+		if err := revertMigration(conn, dialect, m); err != nil {
+			return count, err
+		}
+		log.Printf("Reverted migration %04d_%s.", m.Version, m.Name)
+		count++
+	}
+	return count, nil
+}
+
+// CheckSchema returns an error if the database schema is behind the embedded
+// migrations or if an applied migration has been modified since.
+func CheckSchema(conn *sql.DB, dialect string) error {
+	migrations, err := LoadMigrations(dialect)
+	if err != nil {
+		return err
+	}
+	if err := ensureMigrationsTable(conn); err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return err
+	}
+	if err := verifyApplied(migrations, applied); err != nil {
+		return err
+	}
+
+	pending := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("database schema is behind: %d pending migration(s)", pending)
+	}
+	return nil
+}
+
+// applyMigration runs the up step and records it in schema_migrations atomically.
+func applyMigration(conn *sql.DB, dialect string, m Migration) error {
+	tx, err := conn.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if commit fails
+
+	if _, err := tx.Exec(m.Up); err != nil {
+		return fmt.Errorf("error applying migration %04d_%s: %w", m.Version, m.Name, err)
+	}
+	_, err = tx.Exec(
+		Rebind(dialect, "INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)"),
+		m.Version, m.Name, m.Checksum, time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("error recording migration %04d_%s: %w", m.Version, m.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing migration %04d_%s: %w", m.Version, m.Name, err)
+	}
+	return nil
+}
+
+// revertMigration runs the down step and removes its schema_migrations row atomically.
+func revertMigration(conn *sql.DB, dialect string, m Migration) error {
+	tx, err := conn.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if commit fails
+
+	if _, err := tx.Exec(m.Down); err != nil {
+		return fmt.Errorf("error reverting migration %04d_%s: %w", m.Version, m.Name, err)
+	}
+	if _, err := tx.Exec(Rebind(dialect, "DELETE FROM schema_migrations WHERE version = ?"), m.Version); err != nil {
+		return fmt.Errorf("error removing migration record %04d_%s: %w", m.Version, m.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing revert of %04d_%s: %w", m.Version, m.Name, err)
+	}
+	return nil
+}
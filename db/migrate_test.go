@@ -0,0 +1,172 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestDB returns an empty in-memory SQLite database, closed when the test ends.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	conn, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetMaxOpenConns(1) // every connection would get its own in-memory database
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// migratedTestDB returns an in-memory SQLite database with every migration applied.
+func migratedTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	conn := newTestDB(t)
+	if _, err := MigrateUp(conn, "sqlite"); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestLoadMigrations(t *testing.T) {
+	sqlite, err := LoadMigrations("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	postgres, err := LoadMigrations("postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("%d sqlite migrations, %d postgres ones", len(sqlite), len(postgres))
+	}
+	for i, m := range sqlite {
+		if m.Version != i+1 {
+			t.Errorf("migration %d has version %d, want versions numbered from 1 without gaps", i, m.Version)
+		}
+		if p := postgres[i]; p.Version != m.Version || p.Name != m.Name {
+			t.Errorf("sqlite has %04d_%s where postgres has %04d_%s", m.Version, m.Name, p.Version, p.Name)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %04d_%s has no down step", m.Version, m.Name)
+		}
+	}
+}
+
+func TestMigrateUpFreshDatabase(t *testing.T) {
+	migrations, err := LoadMigrations("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := newTestDB(t)
+
+	applied, err := MigrateUp(conn, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("applied %d migrations, want %d", applied, len(migrations))
+	}
+	if err := CheckSchema(conn, "sqlite"); err != nil {
+		t.Errorf("CheckSchema after migrating: %v", err)
+	}
+	states, err := MigrationStatus(conn, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range states {
+		if !s.Applied || s.Modified || s.AppliedAt.IsZero() {
+			t.Errorf("migration %04d_%s: applied %v at %v, modified %v", s.Version, s.Name, s.Applied, s.AppliedAt, s.Modified)
+		}
+	}
+
+	// Nothing is left to apply
+	if applied, err := MigrateUp(conn, "sqlite"); err != nil || applied != 0 {
+		t.Errorf("migrating again applied %d: %v", applied, err)
+	}
+
+	// Every down step reverts its up step, so the schema can be built again
+	if reverted, err := MigrateDown(conn, "sqlite", len(migrations)); err != nil || reverted != len(migrations) {
+		t.Fatalf("reverted %d of %d migrations: %v", reverted, len(migrations), err)
+	}
+	if applied, err := MigrateUp(conn, "sqlite"); err != nil || applied != len(migrations) {
+		t.Errorf("migrating after reverting applied %d of %d: %v", applied, len(migrations), err)
+	}
+}
+
+func TestMigrateTamperedChecksum(t *testing.T) {
+	conn := migratedTestDB(t)
+	if _, err := conn.Exec("UPDATE schema_migrations SET checksum = 'edited' WHERE version = 5"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MigrateUp(conn, "sqlite"); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("MigrateUp = %v, want a checksum mismatch", err)
+	}
+	if _, err := MigrateDown(conn, "sqlite", 1); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("MigrateDown = %v, want a checksum mismatch", err)
+	}
+	if err := CheckSchema(conn, "sqlite"); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("CheckSchema = %v, want a checksum mismatch", err)
+	}
+	states, err := MigrationStatus(conn, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range states {
+		if s.Modified != (s.Version == 5) {
+			t.Errorf("migration %04d_%s: modified %v", s.Version, s.Name, s.Modified)
+		}
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, conn *sql.DB)
+		wantErr string // empty for none
+	}{
+		{name: "up to date"},
+		{
+			name: "migrations pending",
+			setup: func(t *testing.T, conn *sql.DB) {
+				if _, err := MigrateDown(conn, "sqlite", 2); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "2 pending migration(s)",
+		},
+		{
+			name: "migrated by a newer build",
+			setup: func(t *testing.T, conn *sql.DB) {
+				_, err := conn.Exec("INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (9999, 'from_the_future', 'x', ?)", time.Now().UTC())
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "does not know about",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := migratedTestDB(t)
+			if tt.setup != nil {
+				tt.setup(t, conn)
+			}
+			err := CheckSchema(conn, "sqlite")
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("CheckSchema = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("CheckSchema = %v, want an error with %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("empty database", func(t *testing.T) {
+		if err := CheckSchema(newTestDB(t), "sqlite"); err == nil {
+			t.Error("CheckSchema passed a database that was never migrated")
+		}
+	})
+}
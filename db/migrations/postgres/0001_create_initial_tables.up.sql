@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS products (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	description TEXT,
+	price DOUBLE PRECISION NOT NULL,
+	image_url TEXT
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+	id TEXT PRIMARY KEY,
+	customer_email TEXT NOT NULL,
+	total_amount DOUBLE PRECISION NOT NULL,
+	status TEXT NOT NULL,
+	stripe_id TEXT,
+	created_at TIMESTAMPTZ,
+	updated_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS order_items (
+	id BIGSERIAL PRIMARY KEY,
+	order_id TEXT NOT NULL,
+	product_id TEXT NOT NULL,
+	product_name TEXT NOT NULL,
+	quantity INTEGER NOT NULL,
+	unit_price DOUBLE PRECISION NOT NULL,
+	FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
@@ -0,0 +1,7 @@
+ALTER TABLE order_items ALTER COLUMN unit_price TYPE DOUBLE PRECISION USING unit_price / 100.0;
+
+ALTER TABLE orders DROP COLUMN currency;
+ALTER TABLE orders ALTER COLUMN total_amount TYPE DOUBLE PRECISION USING total_amount / 100.0;
+
+ALTER TABLE products DROP COLUMN currency;
+ALTER TABLE products ALTER COLUMN price TYPE DOUBLE PRECISION USING price / 100.0;
@@ -0,0 +1,9 @@
+-- Prices and totals move from DOUBLE PRECISION to BIGINT minor units (cents) with an ISO currency code.
+
+ALTER TABLE products ALTER COLUMN price TYPE BIGINT USING ROUND(price * 100)::BIGINT;
+ALTER TABLE products ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD';
+
+ALTER TABLE orders ALTER COLUMN total_amount TYPE BIGINT USING ROUND(total_amount * 100)::BIGINT;
+ALTER TABLE orders ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD';
+
+ALTER TABLE order_items ALTER COLUMN unit_price TYPE BIGINT USING ROUND(unit_price * 100)::BIGINT;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS stock_reservations;
+
+ALTER TABLE products DROP COLUMN track_inventory;
+ALTER TABLE products DROP COLUMN stock;
@@ -0,0 +1,19 @@
+-- Stock levels on products and reservations taken while a checkout is in progress.
+-- Products with track_inventory = FALSE are never out of stock.
+
+ALTER TABLE products ADD COLUMN stock INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN track_inventory BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS stock_reservations (
+	id BIGSERIAL PRIMARY KEY,
+	order_id TEXT NOT NULL,
+	product_id TEXT NOT NULL,
+	quantity INTEGER NOT NULL,
+	status TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL,
+	FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+	FOREIGN KEY (product_id) REFERENCES products(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_reservations_order_id ON stock_reservations(order_id);
@@ -0,0 +1,10 @@
+ALTER TABLE stock_reservations DROP COLUMN variant_id;
+
+ALTER TABLE order_items DROP COLUMN variant_title;
+ALTER TABLE order_items DROP COLUMN sku;
+ALTER TABLE order_items DROP COLUMN variant_id;
+
+DROP TABLE IF EXISTS product_variant_prices;
+DROP TABLE IF EXISTS product_variant_options;
+DROP TABLE IF EXISTS product_variants;
+DROP TABLE IF EXISTS product_options;
@@ -0,0 +1,45 @@
+-- Product options (e.g. Size, Color) and the variants built from them. Each variant
+-- has its own SKU, stock, optional image and optional per-currency price override.
+
+CREATE TABLE IF NOT EXISTS product_options (
+	product_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	position INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (product_id, name),
+	FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS product_variants (
+	id TEXT PRIMARY KEY,
+	product_id TEXT NOT NULL,
+	sku TEXT NOT NULL UNIQUE,
+	image_url TEXT NOT NULL DEFAULT '',
+	stock INTEGER NOT NULL DEFAULT 0,
+	track_inventory BOOLEAN NOT NULL DEFAULT FALSE,
+	position INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_variants_product_id ON product_variants(product_id);
+
+CREATE TABLE IF NOT EXISTS product_variant_options (
+	variant_id TEXT NOT NULL,
+	option_name TEXT NOT NULL,
+	value TEXT NOT NULL,
+	PRIMARY KEY (variant_id, option_name),
+	FOREIGN KEY (variant_id) REFERENCES product_variants(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS product_variant_prices (
+	variant_id TEXT NOT NULL,
+	currency TEXT NOT NULL,
+	amount BIGINT NOT NULL,
+	PRIMARY KEY (variant_id, currency),
+	FOREIGN KEY (variant_id) REFERENCES product_variants(id) ON DELETE CASCADE
+);
+
+ALTER TABLE order_items ADD COLUMN variant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE order_items ADD COLUMN sku TEXT NOT NULL DEFAULT '';
+ALTER TABLE order_items ADD COLUMN variant_title TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE stock_reservations ADD COLUMN variant_id TEXT NOT NULL DEFAULT '';
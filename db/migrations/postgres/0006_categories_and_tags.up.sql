@@ -0,0 +1,30 @@
+-- Hierarchical categories and free-form tags, both assigned to products many-to-many.
+
+CREATE TABLE IF NOT EXISTS categories (
+	id TEXT PRIMARY KEY,
+	parent_id TEXT,
+	slug TEXT NOT NULL UNIQUE,
+	name TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	position INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY (parent_id) REFERENCES categories(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS product_categories (
+	product_id TEXT NOT NULL,
+	category_id TEXT NOT NULL,
+	PRIMARY KEY (product_id, category_id),
+	FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE,
+	FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_categories_category_id ON product_categories(category_id);
+
+CREATE TABLE IF NOT EXISTS product_tags (
+	product_id TEXT NOT NULL,
+	tag TEXT NOT NULL,
+	PRIMARY KEY (product_id, tag),
+	FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_tags_tag ON product_tags(tag);
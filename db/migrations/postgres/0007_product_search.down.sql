@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS product_variants_search_refresh ON product_variants;
+DROP TRIGGER IF EXISTS product_tags_search_refresh ON product_tags;
+DROP TRIGGER IF EXISTS products_search_refresh ON products;
+DROP FUNCTION IF EXISTS product_search_trigger();
+DROP FUNCTION IF EXISTS refresh_product_search(TEXT);
+DROP TABLE IF EXISTS product_search;
@@ -0,0 +1,54 @@
+-- Full-text search index over product names, descriptions, tags and variant
+-- SKUs. PostgreSQL has no FTS5, so the same search is served from a weighted
+-- tsvector kept in step with its source tables by triggers.
+
+CREATE TABLE IF NOT EXISTS product_search (
+	product_id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	description TEXT NOT NULL,
+	tags TEXT NOT NULL DEFAULT '',
+	skus TEXT NOT NULL DEFAULT '',
+	document TSVECTOR NOT NULL,
+	FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_search_document ON product_search USING GIN (document);
+
+CREATE OR REPLACE FUNCTION refresh_product_search(pid TEXT) RETURNS VOID AS $$
+BEGIN
+	DELETE FROM product_search WHERE product_id = pid;
+	INSERT INTO product_search (product_id, name, description, tags, skus, document)
+	SELECT p.id, p.name, p.description, t.tags, s.skus,
+		setweight(to_tsvector('simple', p.name), 'A') ||
+		setweight(to_tsvector('simple', t.tags), 'B') ||
+		setweight(to_tsvector('simple', s.skus), 'B') ||
+		setweight(to_tsvector('simple', p.description), 'C')
+	FROM products p,
+		LATERAL (SELECT COALESCE(string_agg(tag, ' '), '') AS tags FROM product_tags WHERE product_id = p.id) t,
+		LATERAL (SELECT COALESCE(string_agg(sku, ' '), '') AS skus FROM product_variants WHERE product_id = p.id) s
+	WHERE p.id = pid;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION product_search_trigger() RETURNS TRIGGER AS $$
+BEGIN
+	IF TG_TABLE_NAME = 'products' THEN
+		PERFORM refresh_product_search(NEW.id);
+	ELSIF TG_OP = 'DELETE' THEN
+		PERFORM refresh_product_search(OLD.product_id);
+	ELSE
+		PERFORM refresh_product_search(NEW.product_id);
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Deleting a product removes its row through the foreign key.
+CREATE TRIGGER products_search_refresh AFTER INSERT OR UPDATE OF name, description ON products
+	FOR EACH ROW EXECUTE FUNCTION product_search_trigger();
+CREATE TRIGGER product_tags_search_refresh AFTER INSERT OR DELETE ON product_tags
+	FOR EACH ROW EXECUTE FUNCTION product_search_trigger();
+CREATE TRIGGER product_variants_search_refresh AFTER INSERT OR UPDATE OF sku OR DELETE ON product_variants
+	FOR EACH ROW EXECUTE FUNCTION product_search_trigger();
+
+SELECT refresh_product_search(id) FROM products;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_order_items_product_id;
+DROP INDEX IF EXISTS idx_product_prices_currency_amount;
+DROP INDEX IF EXISTS idx_products_name;
+DROP INDEX IF EXISTS idx_products_created_at;
+ALTER TABLE products DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,9 @@
+-- Creation time for sorting the catalog by newest, plus indexes for the
+-- listing's sort orders.
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_products_created_at ON products(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_products_name ON products(name, id);
+CREATE INDEX IF NOT EXISTS idx_product_prices_currency_amount ON product_prices(currency, amount);
+CREATE INDEX IF NOT EXISTS idx_order_items_product_id ON order_items(product_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_product_images_product_id;
+DROP TABLE IF EXISTS product_images;
@@ -0,0 +1,29 @@
+-- Shopping carts kept between visits: an anonymous visitor's cart by session
+-- ID, a signed-in customer's cart by customer ID. Unit prices are the prices
+-- when each item was added.
+
+CREATE TABLE IF NOT EXISTS carts (
+	id TEXT PRIMARY KEY,
+	session_id TEXT UNIQUE,
+	customer_id TEXT UNIQUE,
+	currency TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_carts_updated_at ON carts(updated_at);
+
+CREATE TABLE IF NOT EXISTS cart_items (
+	cart_id TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	product_id TEXT NOT NULL,
+	product_name TEXT NOT NULL,
+	variant_id TEXT NOT NULL DEFAULT '',
+	sku TEXT NOT NULL DEFAULT '',
+	variant_title TEXT NOT NULL DEFAULT '',
+	image_url TEXT NOT NULL DEFAULT '',
+	quantity INTEGER NOT NULL,
+	unit_price INTEGER NOT NULL,
+	PRIMARY KEY (cart_id, position),
+	FOREIGN KEY (cart_id) REFERENCES carts(id) ON DELETE CASCADE
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_order_discounts_code;
+DROP TABLE IF EXISTS order_discounts;
+DROP TABLE IF EXISTS coupon_categories;
+DROP TABLE IF EXISTS coupon_products;
+DROP TABLE IF EXISTS coupons;
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS order_item_taxes;
+
+ALTER TABLE orders DROP COLUMN tax_inclusive;
+
+ALTER TABLE carts DROP COLUMN postal_code;
+ALTER TABLE carts DROP COLUMN state;
+ALTER TABLE carts DROP COLUMN country;
+
+ALTER TABLE order_items DROP COLUMN tax_class;
+ALTER TABLE cart_items DROP COLUMN tax_class;
+ALTER TABLE products DROP COLUMN tax_class;
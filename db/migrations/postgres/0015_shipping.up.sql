@@ -0,0 +1,23 @@
+-- Shipping: product weights in grams, the full shipping address and chosen
+-- shipping method of carts, and the address, method and shipping charge of
+-- orders.
+
+ALTER TABLE products ADD COLUMN weight INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE cart_items ADD COLUMN weight INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE carts ADD COLUMN name TEXT NOT NULL DEFAULT '';
+ALTER TABLE carts ADD COLUMN line1 TEXT NOT NULL DEFAULT '';
+ALTER TABLE carts ADD COLUMN line2 TEXT NOT NULL DEFAULT '';
+ALTER TABLE carts ADD COLUMN city TEXT NOT NULL DEFAULT '';
+ALTER TABLE carts ADD COLUMN shipping_method TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE orders ADD COLUMN ship_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN ship_line1 TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN ship_line2 TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN ship_city TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN ship_state TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN ship_postal_code TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN ship_country TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN shipping_method TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN shipping_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN shipping_amount BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,18 @@
+-- The order lifecycle: completed orders become paid and failed ones cancelled,
+-- and every status change is recorded with who or what caused it.
+
+UPDATE orders SET status = 'paid' WHERE status = 'completed';
+UPDATE orders SET status = 'cancelled' WHERE status = 'failed';
+
+CREATE TABLE IF NOT EXISTS order_status_history (
+	id BIGSERIAL PRIMARY KEY,
+	order_id TEXT NOT NULL,
+	from_status TEXT NOT NULL,
+	to_status TEXT NOT NULL,
+	actor TEXT NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL,
+	FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_status_history_order_id ON order_status_history(order_id);
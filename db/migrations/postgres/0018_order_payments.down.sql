@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_orders_payment_intent_id;
+
+ALTER TABLE orders DROP COLUMN dispute_status;
+ALTER TABLE orders DROP COLUMN charge_id;
+ALTER TABLE orders DROP COLUMN payment_intent_id;
@@ -0,0 +1,10 @@
+-- When the payment reconciler found each order's status to agree with its
+-- checkout session at the payment provider, once the session could no longer
+-- change. Orders placed before there was a reconciler count as reconciled,
+-- except those still waiting for payment.
+
+ALTER TABLE orders ADD COLUMN reconciled_at TIMESTAMPTZ;
+
+UPDATE orders SET reconciled_at = updated_at WHERE status <> 'pending';
+
+CREATE INDEX IF NOT EXISTS idx_orders_unreconciled ON orders(created_at) WHERE reconciled_at IS NULL;
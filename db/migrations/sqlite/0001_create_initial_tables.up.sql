@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS products (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	description TEXT,
+	price REAL NOT NULL,
+	image_url TEXT
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+	id TEXT PRIMARY KEY,
+	customer_email TEXT NOT NULL,
+	total_amount REAL NOT NULL,
+	status TEXT NOT NULL,
+	stripe_id TEXT,
+	created_at DATETIME,
+	updated_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS order_items (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	order_id TEXT NOT NULL,
+	product_id TEXT NOT NULL,
+	product_name TEXT NOT NULL,
+	quantity INTEGER NOT NULL,
+	unit_price REAL NOT NULL,
+	FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
@@ -0,0 +1,18 @@
+-- Prices and totals move from REAL to INTEGER minor units (cents) with an ISO currency code.
+
+ALTER TABLE products ADD COLUMN price_minor INTEGER NOT NULL DEFAULT 0;
+UPDATE products SET price_minor = CAST(ROUND(price * 100) AS INTEGER);
+ALTER TABLE products DROP COLUMN price;
+ALTER TABLE products RENAME COLUMN price_minor TO price;
+ALTER TABLE products ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD';
+
+ALTER TABLE orders ADD COLUMN total_minor INTEGER NOT NULL DEFAULT 0;
+UPDATE orders SET total_minor = CAST(ROUND(total_amount * 100) AS INTEGER);
+ALTER TABLE orders DROP COLUMN total_amount;
+ALTER TABLE orders RENAME COLUMN total_minor TO total_amount;
+ALTER TABLE orders ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD';
+
+ALTER TABLE order_items ADD COLUMN unit_price_minor INTEGER NOT NULL DEFAULT 0;
+UPDATE order_items SET unit_price_minor = CAST(ROUND(unit_price * 100) AS INTEGER);
+ALTER TABLE order_items DROP COLUMN unit_price;
+ALTER TABLE order_items RENAME COLUMN unit_price_minor TO unit_price;
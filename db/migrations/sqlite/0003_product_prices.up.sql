@@ -0,0 +1,12 @@
+-- Per-currency price lists. products.price remains the price in the product's base currency.
+
+CREATE TABLE IF NOT EXISTS product_prices (
+	product_id TEXT NOT NULL,
+	currency TEXT NOT NULL,
+	amount INTEGER NOT NULL,
+	PRIMARY KEY (product_id, currency),
+	FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+);
+
+INSERT INTO product_prices (product_id, currency, amount)
+SELECT id, currency, price FROM products;
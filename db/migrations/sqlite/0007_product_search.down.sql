@@ -0,0 +1,9 @@
+DROP TRIGGER IF EXISTS product_variants_fts_delete;
+DROP TRIGGER IF EXISTS product_variants_fts_update;
+DROP TRIGGER IF EXISTS product_variants_fts_insert;
+DROP TRIGGER IF EXISTS product_tags_fts_delete;
+DROP TRIGGER IF EXISTS product_tags_fts_insert;
+DROP TRIGGER IF EXISTS products_fts_delete;
+DROP TRIGGER IF EXISTS products_fts_update;
+DROP TRIGGER IF EXISTS products_fts_insert;
+DROP TABLE IF EXISTS products_fts;
@@ -0,0 +1,62 @@
+-- Full-text search index over product names, descriptions, tags and variant
+-- SKUs. Triggers keep it in step with the tables it is built from.
+
+CREATE VIRTUAL TABLE IF NOT EXISTS products_fts USING fts5(
+	product_id UNINDEXED,
+	name,
+	description,
+	tags,
+	skus,
+	tokenize = 'unicode61 remove_diacritics 2'
+);
+
+INSERT INTO products_fts (product_id, name, description, tags, skus)
+SELECT p.id, p.name, p.description,
+	COALESCE((SELECT group_concat(t.tag, ' ') FROM product_tags t WHERE t.product_id = p.id), ''),
+	COALESCE((SELECT group_concat(v.sku, ' ') FROM product_variants v WHERE v.product_id = p.id), '')
+FROM products p;
+
+CREATE TRIGGER IF NOT EXISTS products_fts_insert AFTER INSERT ON products BEGIN
+	INSERT INTO products_fts (product_id, name, description, tags, skus)
+	VALUES (NEW.id, NEW.name, NEW.description,
+		COALESCE((SELECT group_concat(tag, ' ') FROM product_tags WHERE product_id = NEW.id), ''),
+		COALESCE((SELECT group_concat(sku, ' ') FROM product_variants WHERE product_id = NEW.id), ''));
+END;
+
+CREATE TRIGGER IF NOT EXISTS products_fts_update AFTER UPDATE OF name, description ON products BEGIN
+	UPDATE products_fts SET name = NEW.name, description = NEW.description WHERE product_id = NEW.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS products_fts_delete AFTER DELETE ON products BEGIN
+	DELETE FROM products_fts WHERE product_id = OLD.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS product_tags_fts_insert AFTER INSERT ON product_tags BEGIN
+	UPDATE products_fts
+	SET tags = COALESCE((SELECT group_concat(tag, ' ') FROM product_tags WHERE product_id = NEW.product_id), '')
+	WHERE product_id = NEW.product_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS product_tags_fts_delete AFTER DELETE ON product_tags BEGIN
+	UPDATE products_fts
+	SET tags = COALESCE((SELECT group_concat(tag, ' ') FROM product_tags WHERE product_id = OLD.product_id), '')
+	WHERE product_id = OLD.product_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS product_variants_fts_insert AFTER INSERT ON product_variants BEGIN
+	UPDATE products_fts
+	SET skus = COALESCE((SELECT group_concat(sku, ' ') FROM product_variants WHERE product_id = NEW.product_id), '')
+	WHERE product_id = NEW.product_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS product_variants_fts_update AFTER UPDATE OF sku ON product_variants BEGIN
+	UPDATE products_fts
+	SET skus = COALESCE((SELECT group_concat(sku, ' ') FROM product_variants WHERE product_id = NEW.product_id), '')
+	WHERE product_id = NEW.product_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS product_variants_fts_delete AFTER DELETE ON product_variants BEGIN
+	UPDATE products_fts
+	SET skus = COALESCE((SELECT group_concat(sku, ' ') FROM product_variants WHERE product_id = OLD.product_id), '')
+	WHERE product_id = OLD.product_id;
+END;
@@ -0,0 +1,11 @@
+-- Creation time for sorting the catalog by newest, plus indexes for the
+-- listing's sort orders. SQLite cannot add a column with a CURRENT_TIMESTAMP
+-- default, so existing rows are backfilled and new rows set it on insert.
+
+ALTER TABLE products ADD COLUMN created_at DATETIME;
+UPDATE products SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_products_created_at ON products(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_products_name ON products(name, id);
+CREATE INDEX IF NOT EXISTS idx_product_prices_currency_amount ON product_prices(currency, amount);
+CREATE INDEX IF NOT EXISTS idx_order_items_product_id ON order_items(product_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_products_archived_position;
+ALTER TABLE products DROP COLUMN position;
+ALTER TABLE products DROP COLUMN archived;
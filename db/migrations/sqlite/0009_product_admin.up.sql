@@ -0,0 +1,10 @@
+-- Products managed from the admin area: a merchandising position for the
+-- default listing order, and an archived flag that hides a product from the
+-- storefront without deleting it (orders and reservations still refer to it).
+
+ALTER TABLE products ADD COLUMN archived BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE products ADD COLUMN position INTEGER NOT NULL DEFAULT 0;
+
+UPDATE products SET position = (SELECT COUNT(*) FROM products earlier WHERE earlier.id < products.id);
+
+CREATE INDEX IF NOT EXISTS idx_products_archived_position ON products(archived, position, id);
@@ -0,0 +1,20 @@
+-- Uploaded product images, in display order. Each is stored in the blob store
+-- as thumbnail, medium and large renditions under its storage key.
+
+CREATE TABLE IF NOT EXISTS product_images (
+	id TEXT PRIMARY KEY,
+	product_id TEXT NOT NULL,
+	position INTEGER NOT NULL DEFAULT 0,
+	alt TEXT NOT NULL DEFAULT '',
+	storage_key TEXT NOT NULL,
+	ext TEXT NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	thumb_url TEXT NOT NULL,
+	medium_url TEXT NOT NULL,
+	large_url TEXT NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_images_product_id ON product_images(product_id, position);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS cart_items;
+DROP INDEX IF EXISTS idx_carts_updated_at;
+DROP TABLE IF EXISTS carts;
@@ -0,0 +1,9 @@
+-- Coupon codes applied to carts, in the order they were applied.
+
+CREATE TABLE IF NOT EXISTS cart_coupons (
+	cart_id TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	code TEXT NOT NULL,
+	PRIMARY KEY (cart_id, position),
+	FOREIGN KEY (cart_id) REFERENCES carts(id) ON DELETE CASCADE
+);
@@ -0,0 +1,48 @@
+-- Discount codes, the products and categories they are limited to, and the
+-- discounts taken off each order. Amounts are in minor units of the coupon's
+-- currency; uses are counted from order_discounts.
+
+CREATE TABLE IF NOT EXISTS coupons (
+	code TEXT PRIMARY KEY,
+	description TEXT NOT NULL DEFAULT '',
+	kind TEXT NOT NULL,
+	percent_off INTEGER NOT NULL DEFAULT 0,
+	amount_off INTEGER NOT NULL DEFAULT 0,
+	buy_quantity INTEGER NOT NULL DEFAULT 0,
+	get_quantity INTEGER NOT NULL DEFAULT 0,
+	min_subtotal INTEGER NOT NULL DEFAULT 0,
+	currency TEXT NOT NULL DEFAULT '',
+	starts_at DATETIME,
+	ends_at DATETIME,
+	max_uses INTEGER NOT NULL DEFAULT 0,
+	max_uses_per_customer INTEGER NOT NULL DEFAULT 0,
+	active BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS coupon_products (
+	code TEXT NOT NULL,
+	product_id TEXT NOT NULL,
+	PRIMARY KEY (code, product_id),
+	FOREIGN KEY (code) REFERENCES coupons(code) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS coupon_categories (
+	code TEXT NOT NULL,
+	category_id TEXT NOT NULL,
+	PRIMARY KEY (code, category_id),
+	FOREIGN KEY (code) REFERENCES coupons(code) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS order_discounts (
+	order_id TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	code TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	amount INTEGER NOT NULL,
+	free_shipping BOOLEAN NOT NULL DEFAULT FALSE,
+	PRIMARY KEY (order_id, position),
+	FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_discounts_code ON order_discounts(code);
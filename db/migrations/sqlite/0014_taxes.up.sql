@@ -0,0 +1,25 @@
+-- Tax: each product's tax class, the address carts are taxed for, and the
+-- tax lines charged on each order item. Order items are numbered by their
+-- place in the order (item_position), in the order they were saved; rates
+-- are in thousandths of a percent.
+
+ALTER TABLE products ADD COLUMN tax_class TEXT NOT NULL DEFAULT 'standard';
+ALTER TABLE cart_items ADD COLUMN tax_class TEXT NOT NULL DEFAULT 'standard';
+ALTER TABLE order_items ADD COLUMN tax_class TEXT NOT NULL DEFAULT 'standard';
+
+ALTER TABLE carts ADD COLUMN country TEXT NOT NULL DEFAULT '';
+ALTER TABLE carts ADD COLUMN state TEXT NOT NULL DEFAULT '';
+ALTER TABLE carts ADD COLUMN postal_code TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE orders ADD COLUMN tax_inclusive BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS order_item_taxes (
+	order_id TEXT NOT NULL,
+	item_position INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	rate INTEGER NOT NULL,
+	amount INTEGER NOT NULL,
+	PRIMARY KEY (order_id, item_position, position),
+	FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
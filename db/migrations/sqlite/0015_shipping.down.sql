@@ -0,0 +1,19 @@
+ALTER TABLE orders DROP COLUMN shipping_amount;
+ALTER TABLE orders DROP COLUMN shipping_name;
+ALTER TABLE orders DROP COLUMN shipping_method;
+ALTER TABLE orders DROP COLUMN ship_country;
+ALTER TABLE orders DROP COLUMN ship_postal_code;
+ALTER TABLE orders DROP COLUMN ship_state;
+ALTER TABLE orders DROP COLUMN ship_city;
+ALTER TABLE orders DROP COLUMN ship_line2;
+ALTER TABLE orders DROP COLUMN ship_line1;
+ALTER TABLE orders DROP COLUMN ship_name;
+
+ALTER TABLE carts DROP COLUMN shipping_method;
+ALTER TABLE carts DROP COLUMN city;
+ALTER TABLE carts DROP COLUMN line2;
+ALTER TABLE carts DROP COLUMN line1;
+ALTER TABLE carts DROP COLUMN name;
+
+ALTER TABLE cart_items DROP COLUMN weight;
+ALTER TABLE products DROP COLUMN weight;
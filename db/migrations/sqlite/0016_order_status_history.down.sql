@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS order_status_history;
+
+UPDATE orders SET status = 'completed' WHERE status IN ('paid', 'processing', 'shipped', 'delivered', 'refunded', 'partially_refunded');
+UPDATE orders SET status = 'failed' WHERE status IN ('cancelled', 'expired');
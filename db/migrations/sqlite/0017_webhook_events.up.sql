@@ -0,0 +1,16 @@
+-- Webhook events from the payment provider as they were received, so that a
+-- redelivered event is not handled twice and a stored event can be replayed.
+-- outcome is processing, processed or failed.
+
+CREATE TABLE IF NOT EXISTS webhook_events (
+	id TEXT PRIMARY KEY,
+	type TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	outcome TEXT NOT NULL,
+	error TEXT NOT NULL DEFAULT '',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	received_at DATETIME NOT NULL,
+	processed_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_events_received_at ON webhook_events(received_at);
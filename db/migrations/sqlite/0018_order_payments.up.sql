@@ -0,0 +1,8 @@
+-- The Stripe PaymentIntent and Charge of each paid order, and the status of a
+-- dispute of the charge, if any.
+
+ALTER TABLE orders ADD COLUMN payment_intent_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN charge_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN dispute_status TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_orders_payment_intent_id ON orders(payment_intent_id);
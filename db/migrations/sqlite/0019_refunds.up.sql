@@ -0,0 +1,28 @@
+-- Money given back to customers, for a whole order or some of its items.
+-- provider_id is the payment provider's ID for the refund, and restocked says
+-- whether the refunded items were put back in stock.
+
+CREATE TABLE IF NOT EXISTS refunds (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	order_id TEXT NOT NULL,
+	provider_id TEXT NOT NULL,
+	amount INTEGER NOT NULL,
+	currency TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	restocked BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at DATETIME NOT NULL,
+	FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_refunds_order_id ON refunds(order_id);
+
+-- The items a refund was for and what was refunded for each
+CREATE TABLE IF NOT EXISTS refund_items (
+	refund_id INTEGER NOT NULL,
+	product_id TEXT NOT NULL,
+	variant_id TEXT NOT NULL DEFAULT '',
+	quantity INTEGER NOT NULL,
+	amount INTEGER NOT NULL,
+	PRIMARY KEY (refund_id, product_id, variant_id),
+	FOREIGN KEY (refund_id) REFERENCES refunds(id) ON DELETE CASCADE
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_orders_unreconciled;
+
+ALTER TABLE orders DROP COLUMN reconciled_at;
@@ -0,0 +1,7 @@
+-- When handling of each webhook event last started, so that an event left
+-- processing by a crash can be claimed again once it has been processing too
+-- long.
+
+ALTER TABLE webhook_events ADD COLUMN claimed_at DATETIME;
+
+UPDATE webhook_events SET claimed_at = COALESCE(processed_at, received_at);
@@ -0,0 +1,7 @@
+-- How much of each order has been refunded, in minor units. Refunds claim
+-- their amount here with a conditional update before the money is given back,
+-- so that two refunds of the same order cannot both use what is left.
+
+ALTER TABLE orders ADD COLUMN refunded_amount INTEGER NOT NULL DEFAULT 0;
+
+UPDATE orders SET refunded_amount = COALESCE((SELECT SUM(amount) FROM refunds WHERE refunds.order_id = orders.id), 0);
@@ -0,0 +1,11 @@
+-- The order lines each discount applies to, by the line's variant or product
+-- ID, so that it is shared out over those lines only when taxing and
+-- refunding them. A discount without rows here applies to every line.
+
+CREATE TABLE IF NOT EXISTS order_discount_items (
+	order_id TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	item_key TEXT NOT NULL,
+	PRIMARY KEY (order_id, position, item_key),
+	FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
@@ -0,0 +1,690 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecommerce-app/imaging"
+	"ecommerce-app/models"
+	"ecommerce-app/storage"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/basicauth"
+	"github.com/gofiber/fiber/v2/middleware/csrf"
+)
+
+// maxImageSize is the largest product image accepted, in bytes
+const maxImageSize = 5 << 20
+
+// MaxBodySize is the largest request body the app should accept, in bytes. It
+// leaves room for several images of up to maxImageSize in one product form,
+// so that the form, rather than a bare 413 from Fiber, says which is too large.
+const MaxBodySize = 8 * maxImageSize
+
+// AdminHandler serves the product management area under /admin.
+type AdminHandler struct {
+	store    *models.Store
+	blobs    storage.BlobStore
+	payments models.PaymentProvider
+	username string
+	password string
+}
+
+// NewAdminHandler returns an AdminHandler protected by HTTP basic auth with
+// the given credentials, saving uploaded product images in blobs and making
+// refunds through payments. An empty password leaves the admin area disabled.
+func NewAdminHandler(store *models.Store, blobs storage.BlobStore, payments models.PaymentProvider, username, password string) *AdminHandler {
+	if username == "" {
+		username = "admin"
+	}
+	return &AdminHandler{store: store, blobs: blobs, payments: payments, username: username, password: password}
+}
+
+// RegisterRoutes registers all admin routes behind authentication and CSRF protection
+func (h *AdminHandler) RegisterRoutes(app *fiber.App) {
+	if h.password == "" {
+		log.Println("Admin area disabled: set ADMIN_PASSWORD to enable /admin")
+		return
+	}
+
+	admin := app.Group("/admin",
+		basicauth.New(basicauth.Config{
+			Realm:      "Store admin",
+			Authorizer: h.authorize,
+		}),
+		csrf.New(csrf.Config{
+			KeyLookup:      "form:_csrf",
+			CookieName:     "csrf_admin",
+			CookieSameSite: "Strict",
+			CookieHTTPOnly: true,
+			ContextKey:     "CSRFToken", // available to views as .CSRFToken
+		}),
+	)
+	admin.Get("/", func(c *fiber.Ctx) error { return c.Redirect("/admin/products") })
+	admin.Get("/products", h.ListProducts)
+	admin.Get("/products/new", h.NewProduct)
+	admin.Post("/products", h.CreateProduct)
+	admin.Post("/products/preview", h.PreviewProduct)
+	admin.Get("/products/:id/edit", h.EditProduct)
+	admin.Post("/products/:id", h.UpdateProduct)
+	admin.Post("/products/:id/preview", h.PreviewProduct)
+	admin.Post("/products/:id/archive", h.ArchiveProduct)
+	admin.Post("/products/:id/restore", h.RestoreProduct)
+	admin.Post("/products/:id/move", h.MoveProduct)
+	admin.Post("/products/:id/images/:imageID/delete", h.DeleteImage)
+	admin.Post("/products/:id/images/:imageID/move", h.MoveImage)
+	admin.Get("/coupons", h.ListCoupons)
+	admin.Get("/coupons/new", h.NewCoupon)
+	admin.Post("/coupons", h.CreateCoupon)
+	admin.Get("/coupons/:code/edit", h.EditCoupon)
+	admin.Post("/coupons/:code", h.UpdateCoupon)
+	admin.Get("/orders", h.ListOrders)
+	admin.Get("/orders/:id", h.ShowOrder)
+	admin.Post("/orders/:id/status", h.UpdateOrderStatus)
+	admin.Post("/orders/:id/refunds", h.RefundOrder)
+	admin.Get("/webhooks", h.ListWebhookEvents)
+	admin.Get("/webhooks/:id", h.ShowWebhookEvent)
+	admin.Post("/webhooks/:id/replay", h.ReplayWebhookEvent)
+	admin.Get("/catalog", h.Catalog)
+	admin.Get("/catalog/export", h.ExportCatalog)
+	admin.Post("/catalog/import", h.ImportCatalog)
+}
+
+// authorize checks basic auth credentials in constant time.
+func (h *AdminHandler) authorize(username, password string) bool {
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(h.username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(password), []byte(h.password)) == 1
+	return userOK && passOK
+}
+
+// ListProducts renders every product, archived ones included, in merchandising order
+func (h *AdminHandler) ListProducts(c *fiber.Ctx) error {
+	products, err := h.store.Products.List(c.UserContext(), models.ProductFilter{IncludeArchived: true})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to load products")
+	}
+	return c.Render("admin/products", fiber.Map{
+		"Title":    "Manage Products",
+		"Products": products,
+		"Saved":    c.Query("saved"),
+	})
+}
+
+// NewProduct renders an empty product form
+func (h *AdminHandler) NewProduct(c *fiber.Ctx) error {
+	p := models.Product{Price: models.Money{Currency: models.DefaultCurrency}}
+	return h.renderForm(c, newProductForm(p), nil, true)
+}
+
+// CreateProduct validates the submitted form and adds the product, or renders
+// the form again with the validation errors
+func (h *AdminHandler) CreateProduct(c *fiber.Ctx) error {
+	form := productFormFromRequest(c)
+	if form.ID == "" {
+		form.ID = models.NewProductID()
+	}
+	p := models.Product{ID: form.ID}
+	errs := form.apply(&p)
+	if msg := checkImageUploads(c); msg != "" {
+		errs["images"] = msg
+	}
+	if len(errs) > 0 {
+		return h.renderForm(c, form, mergeErrors(errs, p.Validate()), true)
+	}
+
+	err := models.CreateProduct(c.UserContext(), h.store.Products, p)
+	var invalid models.ValidationErrors
+	if errors.As(err, &invalid) {
+		return h.renderForm(c, form, invalid, true)
+	}
+	if err != nil {
+		log.Printf("Error creating product %s: %v", p.ID, err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to create product")
+	}
+	log.Printf("Admin created product %s", p.ID)
+
+	// Images can only be added once the product exists
+	if msg := h.uploadImages(c, p.ID); msg != "" {
+		return h.renderForm(c, form, models.ValidationErrors{"images": msg}, false)
+	}
+	return c.Redirect("/admin/products?saved=" + p.ID)
+}
+
+// EditProduct renders the form for an existing product
+func (h *AdminHandler) EditProduct(c *fiber.Ctx) error {
+	p, err := h.store.Products.GetByID(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return c.Redirect("/admin/products")
+	}
+	return h.renderForm(c, newProductForm(p), nil, false)
+}
+
+// UpdateProduct validates the submitted form and saves it over the product,
+// or renders the form again with the validation errors
+func (h *AdminHandler) UpdateProduct(c *fiber.Ctx) error {
+	p, err := h.store.Products.GetByID(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return c.Redirect("/admin/products")
+	}
+
+	form := productFormFromRequest(c)
+	form.ID = p.ID
+	form.Archived = p.Archived
+	loaded := form.loadedStock(p)
+	errs := form.apply(&p)
+	if msg := checkImageUploads(c); msg != "" {
+		errs["images"] = msg
+	}
+	if len(errs) > 0 {
+		return h.renderForm(c, form, mergeErrors(errs, p.Validate()), false)
+	}
+
+	err = models.UpdateProduct(c.UserContext(), h.store.Products, p, models.StockChangesBetween(loaded, p))
+	var invalid models.ValidationErrors
+	if errors.As(err, &invalid) {
+		return h.renderForm(c, form, invalid, false)
+	}
+	if err != nil {
+		log.Printf("Error updating product %s: %v", p.ID, err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to update product")
+	}
+	log.Printf("Admin updated product %s", p.ID)
+
+	if msg := h.uploadImages(c, p.ID); msg != "" {
+		return h.renderForm(c, form, models.ValidationErrors{"images": msg}, false)
+	}
+	return c.Redirect("/admin/products?saved=" + p.ID)
+}
+
+// PreviewProduct renders the storefront product page with the submitted,
+// unsaved form values
+func (h *AdminHandler) PreviewProduct(c *fiber.Ctx) error {
+	p := models.Product{ID: "preview"}
+	if id := c.Params("id"); id != "" {
+		stored, err := h.store.Products.GetByID(c.UserContext(), id)
+		if err != nil {
+			return c.Redirect("/admin/products")
+		}
+		p = stored
+	}
+	productFormFromRequest(c).apply(&p)
+
+	var breadcrumbs []models.Category
+	if len(p.CategoryIDs) > 0 {
+		all, err := h.store.Categories.List(c.UserContext())
+		if err == nil {
+			breadcrumbs = models.CategoryPath(all, p.CategoryIDs[0])
+		}
+	}
+
+	localized, available := p.InCurrency(CurrentCurrency(c))
+	return c.Render("product", fiber.Map{
+		"Title":       "Preview: " + p.Name,
+		"Product":     &localized,
+		"Breadcrumbs": breadcrumbs,
+		"Available":   available,
+		"InStock":     localized.InStock(),
+		"Preview":     true,
+	})
+}
+
+// ArchiveProduct hides a product from the storefront
+func (h *AdminHandler) ArchiveProduct(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := models.ArchiveProduct(c.UserContext(), h.store.Products, id); err != nil {
+		log.Printf("Error archiving product %s: %v", id, err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to archive product")
+	}
+	log.Printf("Admin archived product %s", id)
+	return c.Redirect("/admin/products")
+}
+
+// RestoreProduct puts an archived product back on the storefront
+func (h *AdminHandler) RestoreProduct(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := models.RestoreProduct(c.UserContext(), h.store.Products, id); err != nil {
+		log.Printf("Error restoring product %s: %v", id, err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to restore product")
+	}
+	log.Printf("Admin restored product %s", id)
+	return c.Redirect("/admin/products")
+}
+
+// MoveProduct moves a product one place up or down (direction=up|down) in the
+// featured order
+func (h *AdminHandler) MoveProduct(c *fiber.Ctx) error {
+	offset := 1
+	if c.FormValue("direction") == "up" {
+		offset = -1
+	}
+	if err := models.MoveProduct(c.UserContext(), h.store.Products, c.Params("id"), offset); err != nil {
+		log.Printf("Error moving product %s: %v", c.Params("id"), err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to reorder products")
+	}
+	return c.Redirect("/admin/products")
+}
+
+// DeleteImage removes one of a product's images and its renditions
+func (h *AdminHandler) DeleteImage(c *fiber.Ctx) error {
+	id, imageID := c.Params("id"), c.Params("imageID")
+	err := models.DeleteProductImage(c.UserContext(), h.blobs, h.store.Images, id, imageID)
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		log.Printf("Error deleting image %s of product %s: %v", imageID, id, err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to delete image")
+	}
+	log.Printf("Admin deleted image %s of product %s", imageID, id)
+	return c.Redirect("/admin/products/" + id + "/edit")
+}
+
+// MoveImage moves one of a product's images one place earlier or later
+// (direction=up|down); the first image is the product's main image
+func (h *AdminHandler) MoveImage(c *fiber.Ctx) error {
+	offset := 1
+	if c.FormValue("direction") == "up" {
+		offset = -1
+	}
+	id, imageID := c.Params("id"), c.Params("imageID")
+	err := models.MoveProductImage(c.UserContext(), h.store.Images, id, imageID, offset)
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		log.Printf("Error moving image %s of product %s: %v", imageID, id, err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to reorder images")
+	}
+	return c.Redirect("/admin/products/" + id + "/edit")
+}
+
+// Catalog renders the catalog import and export page
+func (h *AdminHandler) Catalog(c *fiber.Ctx) error {
+	return c.Render("admin/catalog", fiber.Map{
+		"Title": "Import & Export",
+	})
+}
+
+// ExportCatalog downloads the whole catalog as CSV or JSON (?format=json)
+func (h *AdminHandler) ExportCatalog(c *fiber.Ctx) error {
+	format, err := models.ParseCatalogFormat(c.Query("format", string(models.CatalogCSV)))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := models.ExportCatalog(c.UserContext(), h.store.Products, h.store.Categories, &buf, format); err != nil {
+		log.Printf("Error exporting catalog: %v", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to export the catalog")
+	}
+	c.Set(fiber.HeaderContentType, format.ContentType())
+	c.Attachment("catalog-" + time.Now().Format("2006-01-02") + "." + string(format))
+	return c.Send(buf.Bytes())
+}
+
+// ImportCatalog imports an uploaded CSV or JSON catalog file, or with
+// dry_run set only shows the changes it would make
+func (h *AdminHandler) ImportCatalog(c *fiber.Ctx) error {
+	values := fiber.Map{"Title": "Import & Export"}
+	file, err := c.FormFile("file")
+	if err != nil {
+		values["ImportError"] = "Choose a CSV or JSON file to import."
+		return c.Status(fiber.StatusBadRequest).Render("admin/catalog", values)
+	}
+	format, err := models.ParseCatalogFormat(file.Filename)
+	if err != nil {
+		values["ImportError"] = "The file must end in .csv or .json."
+		return c.Status(fiber.StatusBadRequest).Render("admin/catalog", values)
+	}
+	f, err := file.Open()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to read the uploaded file")
+	}
+	defer f.Close()
+
+	opts := models.ImportOptions{
+		DryRun: c.FormValue("dry_run") != "",
+		Prune:  c.FormValue("prune") != "",
+	}
+	result, err := models.ImportCatalog(c.UserContext(), h.store.Products, h.store.Categories, f, format, opts)
+	if err != nil {
+		log.Printf("Error importing catalog %s: %v", file.Filename, err)
+		values["ImportError"] = err.Error()
+	}
+	if result != nil && result.Applied {
+		log.Printf("Admin imported catalog %s: %d created, %d updated, %d deleted",
+			file.Filename, len(result.Creates), len(result.Updates), len(result.Deletes))
+	}
+	values["Result"] = result
+	values["FileName"] = file.Filename
+	values["DryRun"] = opts.DryRun
+	values["Prune"] = opts.Prune
+	return c.Render("admin/catalog", values)
+}
+
+// renderForm renders the product form with any validation errors
+func (h *AdminHandler) renderForm(c *fiber.Ctx, form productForm, errs models.ValidationErrors, isNew bool) error {
+	all, err := h.store.Categories.List(c.UserContext())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to load categories")
+	}
+
+	var options []categoryOption
+	var addOptions func(parentID string, depth int)
+	addOptions = func(parentID string, depth int) {
+		for _, cat := range models.ChildCategories(all, parentID) {
+			options = append(options, categoryOption{
+				ID:      cat.ID,
+				Name:    strings.Repeat("— ", depth) + cat.Name,
+				Checked: containsValue(form.CategoryIDs, cat.ID),
+			})
+			addOptions(cat.ID, depth+1)
+		}
+	}
+	addOptions("", 0)
+
+	title := "New Product"
+	action := "/admin/products"
+	var images []models.ProductImage
+	if !isNew {
+		title = "Edit " + form.Name
+		action = "/admin/products/" + form.ID
+		images, err = h.store.Images.List(c.UserContext(), form.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Failed to load images")
+		}
+	}
+
+	status := fiber.StatusOK
+	if len(errs) > 0 {
+		status = fiber.StatusUnprocessableEntity
+	}
+	return c.Status(status).Render("admin/product_form", fiber.Map{
+		"Title":            title,
+		"Form":             form,
+		"Errors":           errs,
+		"IsNew":            isNew,
+		"Action":           action,
+		"CategoryOptions":  options,
+		"Images":           images,
+		"PriceCurrencies":  models.SupportedCurrencies,
+		"TaxClasses":       models.TaxClasses,
+		"DefaultCurrency":  models.DefaultCurrency,
+		"MaxImageSizeInMB": maxImageSize >> 20,
+	})
+}
+
+// productForm holds the product form's values as entered, so that they can be
+// shown again next to validation errors.
+type productForm struct {
+	ID             string
+	Name           string
+	Description    string
+	ImageURL       string
+	Prices         map[string]string // decimal amounts by currency code
+	Stock          string
+	LoadedStock    string // the stock when the form was first shown
+	TrackInventory bool
+	TaxClass       string
+	Weight         string // grams
+	CategoryIDs    []string
+	Tags           string // comma-separated
+	Variants       []variantForm
+	Archived       bool
+}
+
+// variantForm holds the editable fields of one variant.
+type variantForm struct {
+	ID             string
+	Title          string
+	SKU            string
+	Stock          string
+	LoadedStock    string
+	TrackInventory bool
+}
+
+// categoryOption is a category checkbox on the product form.
+type categoryOption struct {
+	ID      string
+	Name    string
+	Checked bool
+}
+
+// newProductForm fills the form from a stored product.
+func newProductForm(p models.Product) productForm {
+	form := productForm{
+		ID:             p.ID,
+		Name:           p.Name,
+		Description:    p.Description,
+		ImageURL:       p.ImageURL,
+		Prices:         make(map[string]string),
+		Stock:          strconv.Itoa(p.Stock),
+		LoadedStock:    strconv.Itoa(p.Stock),
+		TrackInventory: p.TrackInventory,
+		TaxClass:       p.EffectiveTaxClass(),
+		Weight:         strconv.Itoa(p.Weight),
+		CategoryIDs:    p.CategoryIDs,
+		Tags:           strings.Join(p.Tags, ", "),
+		Archived:       p.Archived,
+	}
+	for _, currency := range models.SupportedCurrencies {
+		if price, ok := p.PriceIn(currency); ok && price.Amount > 0 {
+			form.Prices[currency] = price.Decimal()
+		}
+	}
+	for _, v := range p.Variants {
+		form.Variants = append(form.Variants, variantForm{
+			ID:             v.ID,
+			Title:          p.VariantTitle(v),
+			SKU:            v.SKU,
+			Stock:          strconv.Itoa(v.Stock),
+			LoadedStock:    strconv.Itoa(v.Stock),
+			TrackInventory: v.TrackInventory,
+		})
+	}
+	return form
+}
+
+// productFormFromRequest reads the submitted product form.
+func productFormFromRequest(c *fiber.Ctx) productForm {
+	form := productForm{
+		ID:             strings.TrimSpace(c.FormValue("id")),
+		Name:           c.FormValue("name"),
+		Description:    c.FormValue("description"),
+		ImageURL:       strings.TrimSpace(c.FormValue("image_url")),
+		Prices:         make(map[string]string),
+		Stock:          strings.TrimSpace(c.FormValue("stock")),
+		LoadedStock:    c.FormValue("loaded_stock"),
+		TrackInventory: c.FormValue("track_inventory") != "",
+		TaxClass:       c.FormValue("tax_class"),
+		Weight:         strings.TrimSpace(c.FormValue("weight")),
+		CategoryIDs:    formValues(c, "category_ids"),
+		Tags:           c.FormValue("tags"),
+	}
+	for _, currency := range models.SupportedCurrencies {
+		form.Prices[currency] = strings.TrimSpace(c.FormValue("price_" + currency))
+	}
+	for i := 0; c.FormValue(fmt.Sprintf("variants.%d.id", i)) != ""; i++ {
+		prefix := fmt.Sprintf("variants.%d.", i)
+		form.Variants = append(form.Variants, variantForm{
+			ID:             c.FormValue(prefix + "id"),
+			Title:          c.FormValue(prefix + "title"),
+			SKU:            c.FormValue(prefix + "sku"),
+			Stock:          strings.TrimSpace(c.FormValue(prefix + "stock")),
+			LoadedStock:    c.FormValue(prefix + "loaded_stock"),
+			TrackInventory: c.FormValue(prefix+"track_inventory") != "",
+		})
+	}
+	return form
+}
+
+// apply copies the form's values onto p, returning the fields that could not
+// be parsed. The remaining checks are left to models.Product.Validate.
+func (f productForm) apply(p *models.Product) models.ValidationErrors {
+	errs := models.ValidationErrors{}
+
+	p.Name = f.Name
+	p.Description = f.Description
+	p.ImageURL = f.ImageURL
+	p.TrackInventory = f.TrackInventory
+	p.TaxClass = f.TaxClass
+	p.CategoryIDs = f.CategoryIDs
+	p.Tags = strings.Split(f.Tags, ",")
+
+	p.Prices = make(map[string]models.Money)
+	for _, currency := range models.SupportedCurrencies {
+		input := f.Prices[currency]
+		if input == "" {
+			if currency == models.DefaultCurrency {
+				errs["prices."+currency] = "Price is required"
+			}
+			continue
+		}
+		price, err := models.ParseMoney(input, currency)
+		if err != nil {
+			errs["prices."+currency] = "Enter an amount such as 19.99"
+			continue
+		}
+		p.Prices[currency] = price
+		if currency == models.DefaultCurrency {
+			p.Price = price
+		}
+	}
+
+	if f.Stock == "" {
+		p.Stock = 0
+	} else if stock, err := strconv.Atoi(f.Stock); err != nil {
+		errs["stock"] = "Enter a whole number"
+	} else {
+		p.Stock = stock
+	}
+
+	if f.Weight == "" {
+		p.Weight = 0
+	} else if weight, err := strconv.Atoi(f.Weight); err != nil {
+		errs["weight"] = "Enter a weight in grams"
+	} else {
+		p.Weight = weight
+	}
+
+	// Only variants already on the product are edited; the form cannot add or remove them
+	for i, vf := range f.Variants {
+		for j := range p.Variants {
+			if p.Variants[j].ID != vf.ID {
+				continue
+			}
+			p.Variants[j].SKU = vf.SKU
+			p.Variants[j].TrackInventory = vf.TrackInventory
+			if stock, err := strconv.Atoi(vf.Stock); err != nil {
+				errs[fmt.Sprintf("variants.%d.stock", i)] = "Enter a whole number"
+			} else {
+				p.Variants[j].Stock = stock
+			}
+		}
+	}
+	return errs
+}
+
+// loadedStock returns the stock of the stored product as the form showed it,
+// so that saving the form changes the stock by as much as the admin changed
+// it, and keeps the units reserved, sold or restocked since. Stock the form
+// did not carry is taken from stored.
+func (f productForm) loadedStock(stored models.Product) models.Product {
+	loaded := models.Product{Stock: stored.Stock}
+	if stock, err := strconv.Atoi(f.LoadedStock); err == nil {
+		loaded.Stock = stock
+	}
+	for _, v := range stored.Variants {
+		for _, vf := range f.Variants {
+			if stock, err := strconv.Atoi(vf.LoadedStock); err == nil && vf.ID == v.ID {
+				v.Stock = stock
+			}
+		}
+		loaded.Variants = append(loaded.Variants, v)
+	}
+	return loaded
+}
+
+// imageUploads returns the files uploaded in the "images" field.
+func imageUploads(c *fiber.Ctx) []*multipart.FileHeader {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil
+	}
+	var files []*multipart.FileHeader
+	for _, file := range form.File["images"] {
+		if file.Size > 0 {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// checkImageUploads returns a message for the form if an uploaded image is
+// too large, so that nothing is saved.
+func checkImageUploads(c *fiber.Ctx) string {
+	for _, file := range imageUploads(c) {
+		if file.Size > maxImageSize {
+			return fmt.Sprintf("%s is larger than %d MB", file.Filename, maxImageSize>>20)
+		}
+	}
+	return ""
+}
+
+// uploadImages adds the uploaded images to the product, with the alt text
+// entered for them. It returns a message for the form if one could not be added.
+func (h *AdminHandler) uploadImages(c *fiber.Ctx, productID string) string {
+	alt := c.FormValue("image_alt")
+	for _, file := range imageUploads(c) {
+		f, err := file.Open()
+		if err != nil {
+			log.Printf("Error opening upload %s: %v", file.Filename, err)
+			return file.Filename + " could not be read"
+		}
+		img, err := models.UploadProductImage(c.UserContext(), h.blobs, h.store.Images, productID, f, alt)
+		f.Close()
+		if errors.Is(err, imaging.ErrUnsupportedImage) {
+			return file.Filename + " is not a JPEG, PNG, GIF or WebP image"
+		}
+		if err != nil {
+			log.Printf("Error saving image %s for product %s: %v", file.Filename, productID, err)
+			return file.Filename + " could not be saved"
+		}
+		log.Printf("Admin added image %s to product %s", img.ID, productID)
+	}
+	return ""
+}
+
+// mergeErrors adds the model's validation errors to the form's parse errors,
+// keeping the parse error where a field has both.
+func mergeErrors(parse, model models.ValidationErrors) models.ValidationErrors {
+	for field, msg := range model {
+		if _, ok := parse[field]; !ok {
+			parse[field] = msg
+		}
+	}
+	return parse
+}
+
+// formValues returns every submitted value of a repeated form field.
+func formValues(c *fiber.Ctx, key string) []string {
+	if form, err := c.MultipartForm(); err == nil {
+		return form.Value[key]
+	}
+	var values []string
+	for _, v := range c.Request().PostArgs().PeekMulti(key) {
+		values = append(values, string(v))
+	}
+	return values
+}
+
+// containsValue reports whether values contains s.
+func containsValue(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecommerce-app/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// couponTimeLayout is the format of datetime-local inputs, read in the server's time zone.
+const couponTimeLayout = "2006-01-02T15:04"
+
+// ListCoupons renders every coupon with the number of orders that used it
+func (h *AdminHandler) ListCoupons(c *fiber.Ctx) error {
+	coupons, err := h.store.Coupons.List(c.UserContext())
+	if err != nil {
+		log.Printf("Error listing coupons: %v", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to load coupons")
+	}
+	uses := make(map[string]int, len(coupons))
+	for _, coupon := range coupons {
+		uses[coupon.Code], _, err = h.store.Coupons.Uses(c.UserContext(), coupon.Code, "")
+		if err != nil {
+			log.Printf("Error counting uses of coupon %s: %v", coupon.Code, err)
+		}
+	}
+	return c.Render("admin/coupons", fiber.Map{
+		"Title":   "Coupons",
+		"Coupons": coupons,
+		"Uses":    uses,
+		"Saved":   c.Query("saved"),
+	})
+}
+
+// NewCoupon renders an empty coupon form
+func (h *AdminHandler) NewCoupon(c *fiber.Ctx) error {
+	form := couponForm{Kind: string(models.CouponPercentOff), Currency: models.DefaultCurrency, Active: true}
+	return h.renderCouponForm(c, form, nil, true)
+}
+
+// CreateCoupon validates the submitted form and adds the coupon, or renders
+// the form again with the validation errors
+func (h *AdminHandler) CreateCoupon(c *fiber.Ctx) error {
+	form := couponFormFromRequest(c)
+	var coupon models.Coupon
+	errs := form.apply(&coupon)
+	if len(errs) > 0 {
+		return h.renderCouponForm(c, form, mergeErrors(errs, coupon.Validate()), true)
+	}
+
+	err := models.CreateCoupon(c.UserContext(), h.store.Coupons, coupon)
+	var invalid models.ValidationErrors
+	if errors.As(err, &invalid) {
+		return h.renderCouponForm(c, form, invalid, true)
+	}
+	if err != nil {
+		log.Printf("Error creating coupon %s: %v", coupon.Code, err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to create coupon")
+	}
+	log.Printf("Admin created coupon %s", models.NormalizeCouponCode(coupon.Code))
+	return c.Redirect("/admin/coupons?saved=" + models.NormalizeCouponCode(coupon.Code))
+}
+
+// EditCoupon renders the form for an existing coupon
+func (h *AdminHandler) EditCoupon(c *fiber.Ctx) error {
+	coupon, err := h.store.Coupons.GetByCode(c.UserContext(), c.Params("code"))
+	if err != nil {
+		return c.Redirect("/admin/coupons")
+	}
+	return h.renderCouponForm(c, newCouponForm(coupon), nil, false)
+}
+
+// UpdateCoupon validates the submitted form and saves it over the coupon, or
+// renders the form again with the validation errors. The code cannot change,
+// since orders refer to it.
+func (h *AdminHandler) UpdateCoupon(c *fiber.Ctx) error {
+	coupon, err := h.store.Coupons.GetByCode(c.UserContext(), c.Params("code"))
+	if err != nil {
+		return c.Redirect("/admin/coupons")
+	}
+
+	form := couponFormFromRequest(c)
+	form.Code = coupon.Code
+	errs := form.apply(&coupon)
+	if len(errs) > 0 {
+		return h.renderCouponForm(c, form, mergeErrors(errs, coupon.Validate()), false)
+	}
+
+	err = models.UpdateCoupon(c.UserContext(), h.store.Coupons, coupon)
+	var invalid models.ValidationErrors
+	if errors.As(err, &invalid) {
+		return h.renderCouponForm(c, form, invalid, false)
+	}
+	if err != nil {
+		log.Printf("Error updating coupon %s: %v", coupon.Code, err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to update coupon")
+	}
+	log.Printf("Admin updated coupon %s", coupon.Code)
+	return c.Redirect("/admin/coupons?saved=" + coupon.Code)
+}
+
+// renderCouponForm renders the coupon form with any validation errors
+func (h *AdminHandler) renderCouponForm(c *fiber.Ctx, form couponForm, errs models.ValidationErrors, isNew bool) error {
+	all, err := h.store.Categories.List(c.UserContext())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to load categories")
+	}
+
+	var options []categoryOption
+	var addOptions func(parentID string, depth int)
+	addOptions = func(parentID string, depth int) {
+		for _, cat := range models.ChildCategories(all, parentID) {
+			options = append(options, categoryOption{
+				ID:      cat.ID,
+				Name:    strings.Repeat("— ", depth) + cat.Name,
+				Checked: containsValue(form.CategoryIDs, cat.ID),
+			})
+			addOptions(cat.ID, depth+1)
+		}
+	}
+	addOptions("", 0)
+
+	title := "New Coupon"
+	action := "/admin/coupons"
+	if !isNew {
+		title = "Edit " + form.Code
+		action = "/admin/coupons/" + form.Code
+	}
+
+	status := fiber.StatusOK
+	if len(errs) > 0 {
+		status = fiber.StatusUnprocessableEntity
+	}
+	return c.Status(status).Render("admin/coupon_form", fiber.Map{
+		"Title":           title,
+		"Form":            form,
+		"Errors":          errs,
+		"IsNew":           isNew,
+		"Action":          action,
+		"Kinds":           models.CouponKinds,
+		"Currencies":      models.SupportedCurrencies,
+		"CategoryOptions": options,
+	})
+}
+
+// couponForm holds the coupon form's values as entered, so that they can be
+// shown again next to validation errors.
+type couponForm struct {
+	Code               string
+	Description        string
+	Kind               string
+	PercentOff         string
+	AmountOff          string // decimal amount in Currency
+	Currency           string
+	MinSubtotal        string // decimal amount in Currency
+	BuyQuantity        string
+	GetQuantity        string
+	StartsAt           string // in couponTimeLayout
+	EndsAt             string
+	MaxUses            string
+	MaxUsesPerCustomer string
+	ProductIDs         string // comma-separated
+	CategoryIDs        []string
+	Active             bool
+}
+
+// newCouponForm fills the form from a stored coupon.
+func newCouponForm(coupon models.Coupon) couponForm {
+	form := couponForm{
+		Code:               coupon.Code,
+		Description:        coupon.Description,
+		Kind:               string(coupon.Kind),
+		Currency:           coupon.Currency(),
+		MaxUses:            strconv.Itoa(coupon.MaxUses),
+		MaxUsesPerCustomer: strconv.Itoa(coupon.MaxUsesPerCustomer),
+		ProductIDs:         strings.Join(coupon.ProductIDs, ", "),
+		CategoryIDs:        coupon.CategoryIDs,
+		Active:             coupon.Active,
+	}
+	if form.Currency == "" {
+		form.Currency = models.DefaultCurrency
+	}
+	if coupon.PercentOff > 0 {
+		form.PercentOff = strconv.Itoa(coupon.PercentOff)
+	}
+	if coupon.AmountOff.Amount > 0 {
+		form.AmountOff = coupon.AmountOff.Decimal()
+	}
+	if coupon.MinSubtotal.Amount > 0 {
+		form.MinSubtotal = coupon.MinSubtotal.Decimal()
+	}
+	if coupon.BuyQuantity > 0 {
+		form.BuyQuantity = strconv.Itoa(coupon.BuyQuantity)
+		form.GetQuantity = strconv.Itoa(coupon.GetQuantity)
+	}
+	if !coupon.StartsAt.IsZero() {
+		form.StartsAt = coupon.StartsAt.Local().Format(couponTimeLayout)
+	}
+	if !coupon.EndsAt.IsZero() {
+		form.EndsAt = coupon.EndsAt.Local().Format(couponTimeLayout)
+	}
+	return form
+}
+
+// couponFormFromRequest reads the submitted coupon form.
+func couponFormFromRequest(c *fiber.Ctx) couponForm {
+	return couponForm{
+		Code:               strings.TrimSpace(c.FormValue("code")),
+		Description:        c.FormValue("description"),
+		Kind:               c.FormValue("kind"),
+		PercentOff:         strings.TrimSpace(c.FormValue("percent_off")),
+		AmountOff:          strings.TrimSpace(c.FormValue("amount_off")),
+		Currency:           c.FormValue("currency"),
+		MinSubtotal:        strings.TrimSpace(c.FormValue("min_subtotal")),
+		BuyQuantity:        strings.TrimSpace(c.FormValue("buy_quantity")),
+		GetQuantity:        strings.TrimSpace(c.FormValue("get_quantity")),
+		StartsAt:           strings.TrimSpace(c.FormValue("starts_at")),
+		EndsAt:             strings.TrimSpace(c.FormValue("ends_at")),
+		MaxUses:            strings.TrimSpace(c.FormValue("max_uses")),
+		MaxUsesPerCustomer: strings.TrimSpace(c.FormValue("max_uses_per_customer")),
+		ProductIDs:         c.FormValue("product_ids"),
+		CategoryIDs:        formValues(c, "category_ids"),
+		Active:             c.FormValue("active") != "",
+	}
+}
+
+// apply copies the form's values onto coupon, returning the fields that could
+// not be parsed. The remaining checks are left to models.Coupon.Validate.
+// Only the fields of the chosen kind of discount are kept.
+func (f couponForm) apply(coupon *models.Coupon) models.ValidationErrors {
+	errs := models.ValidationErrors{}
+
+	coupon.Code = f.Code
+	coupon.Description = f.Description
+	coupon.Kind = models.CouponKind(f.Kind)
+	coupon.Active = f.Active
+	coupon.CategoryIDs = f.CategoryIDs
+	coupon.ProductIDs = nil
+	for _, id := range strings.Split(f.ProductIDs, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			coupon.ProductIDs = append(coupon.ProductIDs, id)
+		}
+	}
+
+	currency := f.Currency
+	if !models.IsSupportedCurrency(currency) {
+		errs["currency"] = "Choose a currency"
+		currency = models.DefaultCurrency
+	}
+	parseInt := func(field, input string) int {
+		if input == "" {
+			return 0
+		}
+		n, err := strconv.Atoi(input)
+		if err != nil {
+			errs[field] = "Enter a whole number"
+		}
+		return n
+	}
+	parseMoney := func(field, input string) models.Money {
+		if input == "" {
+			return models.Money{}
+		}
+		amount, err := models.ParseMoney(input, currency)
+		if err != nil {
+			errs[field] = "Enter an amount such as 10.00"
+		}
+		return amount
+	}
+	parseTime := func(field, input string) time.Time {
+		if input == "" {
+			return time.Time{}
+		}
+		t, err := time.ParseInLocation(couponTimeLayout, input, time.Local)
+		if err != nil {
+			errs[field] = "Enter a date and time"
+		}
+		return t
+	}
+
+	coupon.PercentOff, coupon.AmountOff, coupon.BuyQuantity, coupon.GetQuantity = 0, models.Money{}, 0, 0
+	switch coupon.Kind {
+	case models.CouponPercentOff:
+		coupon.PercentOff = parseInt("percent_off", f.PercentOff)
+	case models.CouponAmountOff:
+		coupon.AmountOff = parseMoney("amount_off", f.AmountOff)
+	case models.CouponBuyXGetY:
+		coupon.BuyQuantity = parseInt("buy_quantity", f.BuyQuantity)
+		coupon.GetQuantity = parseInt("get_quantity", f.GetQuantity)
+	}
+	coupon.MinSubtotal = parseMoney("min_subtotal", f.MinSubtotal)
+	coupon.StartsAt = parseTime("starts_at", f.StartsAt)
+	coupon.EndsAt = parseTime("ends_at", f.EndsAt)
+	coupon.MaxUses = parseInt("max_uses", f.MaxUses)
+	coupon.MaxUsesPerCustomer = parseInt("max_uses_per_customer", f.MaxUsesPerCustomer)
+	return errs
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"ecommerce-app/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// adminOrderLimit is the most orders the order list shows.
+const adminOrderLimit = 200
+
+// adminOrderStatuses are the statuses staff may move orders to by hand.
+// Orders are paid and expired by the payment provider, and refunded with
+// RefundOrder.
+var adminOrderStatuses = []models.OrderStatus{
+	models.OrderStatusProcessing, models.OrderStatusShipped, models.OrderStatusDelivered, models.OrderStatusCancelled,
+}
+
+// ListOrders renders the most recent orders, optionally only those with the
+// status given by ?status=
+func (h *AdminHandler) ListOrders(c *fiber.Ctx) error {
+	status := models.OrderStatus(c.Query("status"))
+	if !slices.Contains(models.OrderStatuses, status) {
+		status = ""
+	}
+	orders, err := h.store.Orders.List(c.UserContext(), models.OrderFilter{Status: status, Limit: adminOrderLimit})
+	if err != nil {
+		log.Printf("Error listing orders: %v", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to load orders")
+	}
+	return c.Render("admin/orders", fiber.Map{
+		"Title":    "Orders",
+		"Orders":   orders,
+		"Status":   status,
+		"Statuses": models.OrderStatuses,
+	})
+}
+
+// ShowOrder renders an order with its status history and the statuses it
+// can be moved to
+func (h *AdminHandler) ShowOrder(c *fiber.Ctx) error {
+	order, err := h.store.Orders.GetByID(c.UserContext(), c.Params("id"))
+	if errors.Is(err, models.ErrNotFound) {
+		return c.Redirect("/admin/orders")
+	}
+	if err != nil {
+		log.Printf("Error loading order %s: %v", c.Params("id"), err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to load order")
+	}
+	history, err := h.store.Orders.StatusHistory(c.UserContext(), order.ID)
+	if err != nil {
+		log.Printf("Error loading status history of order %s: %v", order.ID, err)
+	}
+	refunds, err := h.store.Refunds.ListByOrder(c.UserContext(), order.ID)
+	if err != nil {
+		log.Printf("Error loading refunds of order %s: %v", order.ID, err)
+	}
+
+	var next []models.OrderStatus
+	for _, status := range order.Status.Next() {
+		if slices.Contains(adminOrderStatuses, status) {
+			next = append(next, status)
+		}
+	}
+	return c.Render("admin/order", fiber.Map{
+		"Title":      "Order " + order.ID,
+		"Order":      order,
+		"History":    history,
+		"NextStatus": next,
+		"Refunds":    refunds,
+		"Refundable": refundableLines(order, refunds),
+		"ItemNames":  itemNames(order),
+		"Refunded":   order.RefundedAmount, // including refunds made at the provider, e.g. on the Stripe dashboard
+		"CanRefund":  order.Status.CanTransitionTo(models.OrderStatusRefunded),
+		"Error":      c.Query("error"),
+	})
+}
+
+// refundableLine is an order line with the units not refunded yet.
+type refundableLine struct {
+	models.OrderItem
+	Left int
+}
+
+// refundableLines lists the order's lines with how many of their units are
+// left to refund.
+func refundableLines(order *models.Order, refunds []models.Refund) []refundableLine {
+	refunded := models.RefundedQuantities(refunds)
+	lines := make([]refundableLine, len(order.Items))
+	for i, item := range order.Items {
+		lines[i] = refundableLine{OrderItem: item, Left: item.Quantity - refunded[item.Key()]}
+	}
+	return lines
+}
+
+// UpdateOrderStatus moves an order to the submitted status, recording the
+// optional reason. Cancelling an order returns its reserved stock.
+func (h *AdminHandler) UpdateOrderStatus(c *fiber.Ctx) error {
+	order, err := h.store.Orders.GetByID(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return c.Redirect("/admin/orders")
+	}
+	back := "/admin/orders/" + order.ID
+
+	status := models.OrderStatus(c.FormValue("status"))
+	wasPending := order.Status == models.OrderStatusPending
+	if !slices.Contains(adminOrderStatuses, status) {
+		return c.Redirect(back + "?error=invalid_transition")
+	}
+	reason := strings.TrimSpace(c.FormValue("reason"))
+
+	err = models.UpdateOrderStatus(c.UserContext(), h.store.Orders, order, status, models.ActorAdmin, reason)
+	if errors.Is(err, models.ErrInvalidTransition) {
+		log.Printf("Admin could not update order %s: %v", order.ID, err)
+		return c.Redirect(back + "?error=invalid_transition")
+	}
+	if err != nil {
+		log.Printf("Error updating status of order %s: %v", order.ID, err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to update order")
+	}
+	if status == models.OrderStatusCancelled {
+		if err := h.store.Inventory.Release(c.UserContext(), order.ID); err != nil {
+			log.Printf("Error releasing stock for cancelled order %s: %v", order.ID, err)
+		}
+	}
+	if status == models.OrderStatusCancelled && wasPending && order.StripeID != "" {
+		// Stop the customer from paying for it; the reconciler tries again if this fails
+		if err := h.payments.ExpireCheckoutSession(c.UserContext(), order.StripeID); err != nil {
+			log.Printf("Warning: Could not expire checkout session %s of cancelled order %s: %v", order.StripeID, order.ID, err)
+		}
+	}
+	log.Printf("Admin moved order %s to %s", order.ID, status)
+	return c.Redirect(back)
+}
+
+// itemNames maps the keys of the order's lines to their names.
+func itemNames(order *models.Order) map[string]string {
+	names := make(map[string]string, len(order.Items))
+	for _, item := range order.Items {
+		names[item.Key()] = item.DisplayName()
+	}
+	return names
+}
+
+// RefundOrder refunds the whole order, or the submitted quantities of its
+// lines (qty_<line key>), through the payment provider, with the required
+// reason. Ticking restock puts the refunded units back in stock.
+func (h *AdminHandler) RefundOrder(c *fiber.Ctx) error {
+	order, err := h.store.Orders.GetByID(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return c.Redirect("/admin/orders")
+	}
+	back := "/admin/orders/" + order.ID
+
+	req := models.RefundRequest{
+		Whole:      c.FormValue("whole") != "",
+		Quantities: make(map[string]int),
+		Reason:     strings.TrimSpace(c.FormValue("reason")),
+		Restock:    c.FormValue("restock") != "",
+	}
+	if req.Reason == "" {
+		return c.Redirect(back + "?error=refund_reason")
+	}
+	for _, item := range order.Items {
+		if quantity, err := strconv.Atoi(c.FormValue("qty_" + item.Key())); err == nil {
+			req.Quantities[item.Key()] = quantity
+		}
+	}
+
+	refund, err := models.RefundOrder(c.UserContext(), h.store, h.payments, order, req)
+	if errors.Is(err, models.ErrInvalidRefund) {
+		log.Printf("Admin could not refund order %s: %v", order.ID, err)
+		return c.Redirect(back + "?error=invalid_refund")
+	}
+	if errors.Is(err, models.ErrConflict) {
+		log.Printf("Admin could not refund order %s: %v", order.ID, err)
+		return c.Redirect(back + "?error=refund_conflict")
+	}
+	if errors.Is(err, models.ErrRefundNotRecorded) {
+		// The money was given back, so it must not be refunded again
+		log.Printf("Error recording refund of order %s: %v", order.ID, err)
+		return c.Redirect(back + "?error=refund_not_recorded")
+	}
+	if err != nil {
+		log.Printf("Error refunding order %s: %v", order.ID, err)
+		return c.Redirect(back + "?error=refund_failed")
+	}
+	log.Printf("Admin refunded %s of order %s", refund.Amount, order.ID)
+	return c.Redirect(back)
+}
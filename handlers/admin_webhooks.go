@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"slices"
+
+	"ecommerce-app/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// adminWebhookLimit is the most webhook events the event list shows.
+const adminWebhookLimit = 200
+
+// webhookOutcomes are the outcomes the webhook event list can be filtered by.
+var webhookOutcomes = []models.WebhookOutcome{models.WebhookProcessing, models.WebhookProcessed, models.WebhookFailed}
+
+// ListWebhookEvents renders the most recently received webhook events,
+// optionally only those with the outcome given by ?outcome=
+func (h *AdminHandler) ListWebhookEvents(c *fiber.Ctx) error {
+	outcome := models.WebhookOutcome(c.Query("outcome"))
+	if !slices.Contains(webhookOutcomes, outcome) {
+		outcome = ""
+	}
+	events, err := h.store.WebhookEvents.List(c.UserContext(), outcome, adminWebhookLimit)
+	if err != nil {
+		log.Printf("Error listing webhook events: %v", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to load webhook events")
+	}
+	return c.Render("admin/webhooks", fiber.Map{
+		"Title":    "Webhook Events",
+		"Events":   events,
+		"Outcome":  outcome,
+		"Outcomes": webhookOutcomes,
+	})
+}
+
+// ShowWebhookEvent renders a webhook event with its payload
+func (h *AdminHandler) ShowWebhookEvent(c *fiber.Ctx) error {
+	event, err := h.store.WebhookEvents.GetByID(c.UserContext(), c.Params("id"))
+	if errors.Is(err, models.ErrNotFound) {
+		return c.Redirect("/admin/webhooks")
+	}
+	if err != nil {
+		log.Printf("Error loading webhook event %s: %v", c.Params("id"), err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to load webhook event")
+	}
+	return c.Render("admin/webhook", fiber.Map{
+		"Title":    "Webhook Event " + event.ID,
+		"Event":    event,
+		"Replayed": c.Query("replayed") != "",
+	})
+}
+
+// ReplayWebhookEvent handles a stored webhook event again. The outcome is
+// shown on the event page.
+func (h *AdminHandler) ReplayWebhookEvent(c *fiber.Ctx) error {
+	id := c.Params("id")
+	err := models.ReplayWebhookEvent(c.UserContext(), h.store, h.payments, id)
+	if errors.Is(err, models.ErrNotFound) {
+		return c.Redirect("/admin/webhooks")
+	}
+	if err != nil {
+		log.Printf("Replaying webhook event %s failed: %v", id, err)
+	}
+	log.Printf("Admin replayed webhook event %s", id)
+	return c.Redirect("/admin/webhooks/" + url.PathEscape(id) + "?replayed=1")
+}
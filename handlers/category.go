@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"errors"
+
+	"ecommerce-app/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CategoryHandler serves the category browsing pages.
+type CategoryHandler struct {
+	products   models.ProductRepository
+	categories models.CategoryRepository
+}
+
+// NewCategoryHandler returns a CategoryHandler reading from the given repositories.
+func NewCategoryHandler(products models.ProductRepository, categories models.CategoryRepository) *CategoryHandler {
+	return &CategoryHandler{products: products, categories: categories}
+}
+
+// RegisterRoutes registers all category-related routes
+func (h *CategoryHandler) RegisterRoutes(app *fiber.App) {
+	app.Get("/categories/:slug", h.GetCategory)
+}
+
+// GetCategory renders the products of a category and of every category below it
+func (h *CategoryHandler) GetCategory(c *fiber.Ctx) error {
+	category, err := h.categories.GetBySlug(c.UserContext(), c.Params("slug"))
+	if errors.Is(err, models.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).Redirect("/products")
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to load category")
+	}
+
+	all, err := h.categories.List(c.UserContext())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to load categories")
+	}
+
+	return renderListing(c, h.products, all, category, category.URL(), fiber.Map{
+		"Title":         category.Name,
+		"Breadcrumbs":   models.CategoryPath(all, category.ID),
+		"Subcategories": models.ChildCategories(all, category.ID),
+	})
+}
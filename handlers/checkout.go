@@ -2,54 +2,152 @@ package handlers
 
 import (
 	"ecommerce-app/models"
+	"errors"
 	"fmt"
 	"log"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/session"
 )
 
-// Cart session key
-const SessionCartKey = "cart"
+// SessionCustomerKey is the session key holding the signed-in customer's ID
+const SessionCustomerKey = "customer_id"
 
-var sessionStore *session.Store // Declare sessionStore here
+// SessionCheckoutOrderKey is the session key holding the ID of the order the
+// visitor was sent to pay for, the only one they can cancel
+const SessionCheckoutOrderKey = "checkout_order_id"
 
-func InitSessionStore(s *session.Store) {
-	sessionStore = s
+// CheckoutHandler serves the cart, checkout and payment routes.
+type CheckoutHandler struct {
+	store    *models.Store
+	sessions *session.Store
+	taxes    models.TaxCalculator
+	shipping *models.ShippingTable
+	payments models.PaymentProvider
 }
 
-// RegisterCheckoutRoutes registers all checkout-related routes
-func RegisterCheckoutRoutes(app *fiber.App) {
-	app.Get("/cart", ViewCart)
-	app.Post("/cart/add/:id", AddToCart)
-	app.Post("/cart/remove/:id", RemoveFromCart)
-	app.Get("/checkout", Checkout)
-	app.Post("/checkout", Checkout)
-	app.Get("/checkout/success", CheckoutSuccess)
-	app.Get("/checkout/cancel", CheckoutCancel)
-	app.Post("/webhook/stripe", StripeWebhook)
+// NewCheckoutHandler returns a CheckoutHandler using the given repositories,
+// session store, tax calculator, shipping rates and payment provider.
+func NewCheckoutHandler(store *models.Store, sessions *session.Store, taxes models.TaxCalculator, shipping *models.ShippingTable, payments models.PaymentProvider) *CheckoutHandler {
+	return &CheckoutHandler{store: store, sessions: sessions, taxes: taxes, shipping: shipping, payments: payments}
+}
+
+// RegisterRoutes registers all checkout-related routes
+func (h *CheckoutHandler) RegisterRoutes(app *fiber.App) {
+	app.Get("/cart", h.ViewCart)
+	app.Post("/cart/add/:id", h.AddToCart)
+	app.Post("/cart/update/:id", h.UpdateCart)
+	app.Post("/cart/remove/:id", h.RemoveFromCart)
+	app.Post("/cart/coupon", h.ApplyCoupon)
+	app.Post("/cart/coupon/remove/:code", h.RemoveCoupon)
+	app.Post("/currency", h.SetCurrency)
+	app.Get("/checkout", h.Checkout)
+	app.Post("/checkout", h.Checkout)
+	app.Post("/checkout/address", h.SetShippingAddress)
+	app.Post("/checkout/shipping", h.SetShippingMethod)
+	app.Get("/checkout/success", h.CheckoutSuccess)
+	app.Get("/checkout/cancel", h.CheckoutCancel)
+	app.Post("/webhook/stripe", h.StripeWebhook)
 }
 
 // ViewCart displays the current shopping cart
-func ViewCart(c *fiber.Ctx) error {
-	cart := getCart(c)
+func (h *CheckoutHandler) ViewCart(c *fiber.Ctx) error {
+	cart := h.getCart(c)
+	problems, err := models.ApplyCoupons(c.UserContext(), h.store, cart, "")
+	if err != nil {
+		log.Printf("Error applying coupons to cart %s: %v", cart.ID, err)
+	}
+	// Shipping and tax are estimated once the shipping address is known
+	models.ApplyShipping(h.shipping, cart)
+	if err := models.ApplyTax(c.UserContext(), h.taxes, cart); err != nil {
+		log.Printf("Error estimating tax on cart %s: %v", cart.ID, err)
+	}
 
-	var totalAmount float64
-	for _, item := range cart.Items {
-		totalAmount += item.UnitPrice * float64(item.Quantity)
+	// Every code on the cart is listed, with its discount or why it does not apply yet
+	var coupons []cartCoupon
+	for _, code := range cart.CouponCodes {
+		coupon := cartCoupon{Code: code}
+		for i := range cart.Discounts {
+			if cart.Discounts[i].Code == code {
+				coupon.Discount = &cart.Discounts[i]
+			}
+		}
+		for _, p := range problems {
+			if p.Code == code {
+				coupon.Problem = p.Message()
+			}
+		}
+		coupons = append(coupons, coupon)
 	}
 
 	return c.Render("cart", fiber.Map{
-		"Title":    "Your Shopping Cart",
-		"Cart":     cart,
-		"Total":    fmt.Sprintf("$%.2f", totalAmount),
-		"HasItems": len(cart.Items) > 0,
+		"Title":           "Your Shopping Cart",
+		"Cart":            cart,
+		"Totals":          cart.Totals(),
+		"TaxLines":        cart.TaxLines(),
+		"NoShipping":      !cart.ShippingAddress.IsZero() && len(cart.ShippingRates) == 0,
+		"Coupons":         coupons,
+		"CouponCode":      c.Query("coupon"),
+		"CouponError":     models.CouponProblem(c.Query("coupon_error")).Message(),
+		"HasItems":        !cart.IsEmpty(),
+		"OutOfStock":      c.Query("error") == "out_of_stock",
+		"InvalidQuantity": c.Query("error") == "invalid_quantity",
+		"MaxQuantity":     models.MaxItemQuantity,
 	})
 }
 
+// cartCoupon is a coupon code shown on the cart page.
+type cartCoupon struct {
+	Code     string
+	Discount *models.Discount // nil if the code does not apply to the cart
+	Problem  string           // why the code does not apply
+}
+
+// ApplyCoupon adds a coupon code to the cart if it can be used on it, or
+// sends the shopper back to the cart with the reason it cannot
+func (h *CheckoutHandler) ApplyCoupon(c *fiber.Ctx) error {
+	code := models.NormalizeCouponCode(c.FormValue("code"))
+	if code == "" {
+		return c.Redirect("/cart")
+	}
+	rejected := func(problem models.CouponProblem) error {
+		return c.Redirect("/cart?coupon_error=" + string(problem) + "&coupon=" + url.QueryEscape(code))
+	}
+
+	cart := h.getCart(c)
+	var couponErr *models.CouponError
+	if err := cart.AddCoupon(code); errors.As(err, &couponErr) {
+		return rejected(couponErr.Problem)
+	}
+	problems, err := models.ApplyCoupons(c.UserContext(), h.store, cart, "")
+	if err != nil {
+		log.Printf("Error applying coupon %s: %v", code, err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error applying coupon")
+	}
+	for _, p := range problems {
+		if p.Code == code {
+			return rejected(p.Problem)
+		}
+	}
+
+	h.saveCart(c, cart)
+	return c.Redirect("/cart")
+}
+
+// RemoveCoupon takes a coupon code off the cart
+func (h *CheckoutHandler) RemoveCoupon(c *fiber.Ctx) error {
+	cart := h.getCart(c)
+	if cart.RemoveCoupon(c.Params("code")) {
+		h.saveCart(c, cart)
+	}
+	return c.Redirect("/cart")
+}
+
 // AddToCart adds a product to the cart
-func AddToCart(c *fiber.Ctx) error {
+func (h *CheckoutHandler) AddToCart(c *fiber.Ctx) error {
 	productID := c.Params("id")
 
 	// Get quantity from form, default to 1
@@ -63,109 +161,353 @@ func AddToCart(c *fiber.Ctx) error {
 	}
 
 	// Get product
-	product, err := models.GetProductByID(productID)
-	if err != nil {
+	product, err := h.store.Products.GetByID(c.UserContext(), productID)
+	if err != nil || product.Archived {
 		return c.Status(fiber.StatusNotFound).Redirect("/products")
 	}
 
-	// Get the current cart from session
-	cart := getCart(c)
+	// Get the current cart
+	cart := h.getCart(c)
+
+	// Products with variants are bought as a specific variant
+	variantID := c.FormValue("variant_id")
+	var variant models.Variant
+	if product.HasVariants() {
+		var ok bool
+		if variant, ok = product.FindVariant(variantID); !ok {
+			return c.Redirect("/products/" + productID + "?error=choose_variant")
+		}
+	}
+
+	// Make sure the stock covers what is already in the cart plus this addition
+	inCart := cart.Quantity(productID)
+	if product.HasVariants() {
+		inCart = cart.Quantity(variantID)
+	}
+	if err := models.ValidateQuantity(inCart + quantity); err != nil {
+		return c.Redirect("/products/" + productID + "?error=invalid_quantity")
+	}
+	available := product.CanFulfil(inCart + quantity)
+	if product.HasVariants() {
+		available = variant.CanFulfil(inCart + quantity)
+	}
+	if !available {
+		return c.Redirect("/products/" + productID + "?error=out_of_stock")
+	}
 
 	// Add product to cart
-	cart.AddItem(product, quantity)
+	if err := cart.AddItem(product, variantID, quantity); err != nil {
+		log.Printf("Error adding product %s to cart: %v", productID, err)
+		return c.Redirect("/products/" + productID)
+	}
 
-	// Save cart to session
-	saveCart(c, cart)
+	// Save the cart
+	h.saveCart(c, cart)
 
 	// Redirect back to products or to cart
 	return c.Redirect("/cart")
 }
 
-// RemoveFromCart removes a product from the cart
-func RemoveFromCart(c *fiber.Ctx) error {
-	productID := c.Params("id")
+// UpdateCart sets the quantity of a cart line, identified like in
+// RemoveFromCart; a quantity of 0 removes the line
+func (h *CheckoutHandler) UpdateCart(c *fiber.Ctx) error {
+	key := c.Params("id")
+	quantity, err := strconv.Atoi(c.FormValue("quantity"))
+	if err != nil || (quantity != 0 && models.ValidateQuantity(quantity) != nil) {
+		return c.Redirect("/cart?error=invalid_quantity")
+	}
 
-	// Get the current cart from session
-	cart := getCart(c)
+	cart := h.getCart(c)
+	var line *models.CartItem
+	for i := range cart.Items {
+		if cart.Items[i].Key() == key {
+			line = &cart.Items[i]
+		}
+	}
+	if line == nil {
+		return c.Redirect("/cart")
+	}
 
-	// Remove item from cart
-	for i, item := range cart.Items {
-		if item.ProductID == productID {
-			// Remove the item from the slice
-			cart.Items = append(cart.Items[:i], cart.Items[i+1:]...)
-			break
+	// Only a larger quantity needs checking against the stock
+	if quantity > line.Quantity {
+		product, err := h.store.Products.GetByID(c.UserContext(), line.ProductID)
+		if err != nil || product.Archived {
+			return c.Redirect("/cart?error=out_of_stock")
+		}
+		available := product.CanFulfil(quantity)
+		if variant, ok := product.FindVariant(line.VariantID); ok {
+			available = variant.CanFulfil(quantity)
+		}
+		if !available {
+			return c.Redirect("/cart?error=out_of_stock")
 		}
 	}
 
-	// Recalculate total
-	cart.CalculateTotal()
+	if err := cart.SetItemQuantity(key, quantity); err != nil {
+		log.Printf("Error updating cart line %s: %v", key, err)
+	}
+	h.saveCart(c, cart)
+	return c.Redirect("/cart")
+}
 
-	// Save cart to session
-	saveCart(c, cart)
+// RemoveFromCart removes a cart line, identified by variant ID or (for products
+// without variants) product ID, from the cart
+func (h *CheckoutHandler) RemoveFromCart(c *fiber.Ctx) error {
+	key := c.Params("id")
+
+	// Get the current cart
+	cart := h.getCart(c)
+
+	// Remove item from cart; a line that is already gone needs no change
+	if err := cart.SetItemQuantity(key, 0); err == nil {
+		h.saveCart(c, cart)
+	}
 
 	return c.Redirect("/cart")
 }
 
-// Checkout processes the checkout and redirects to Stripe
-func Checkout(c *fiber.Ctx) error {
+// Checkout checks the cart against the catalog and shows it for review,
+// explaining any adjustments. Once the customer confirms it with their email,
+// it creates the order and redirects to the payment provider
+func (h *CheckoutHandler) Checkout(c *fiber.Ctx) error {
 	// Get the current cart
-	cart := getCart(c)
+	cart := h.getCart(c)
 
 	// Make sure we have items in cart
-	if len(cart.Items) == 0 {
+	if cart.IsEmpty() {
 		return c.Redirect("/cart")
 	}
 
-	// Get email from form
-	email := c.FormValue("email")
-	if email == "" {
-		return c.Render("checkout_email", fiber.Map{
-			"Title": "Enter Email to Checkout",
-		})
+	// Re-price the cart and drop what can no longer be bought
+	validated, adjustments, err := models.ValidateCart(c.UserContext(), h.store.Products, cart)
+	if err != nil {
+		log.Printf("Error validating cart: %v", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error processing order")
+	}
+
+	// Work out the discounts, checking the per-customer limits once the email
+	// is known; codes that cannot be used are taken off the cart
+	email := strings.TrimSpace(c.FormValue("email"))
+	couponProblems, err := models.ApplyCoupons(c.UserContext(), h.store, validated, email)
+	if err != nil {
+		log.Printf("Error applying coupons: %v", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error processing order")
+	}
+	// A code other orders used up while this one was being placed was taken
+	// off the cart already; say why it is gone
+	if problem := models.CouponProblem(c.Query("coupon_problem")); problem == models.CouponUsedUp || problem == models.CouponUsedByCustomer {
+		couponProblems = append(couponProblems, &models.CouponError{Code: models.NormalizeCouponCode(c.Query("code")), Problem: problem})
+	}
+	for _, p := range couponProblems {
+		validated.RemoveCoupon(p.Code)
+	}
+	if len(adjustments) > 0 || len(couponProblems) > 0 {
+		h.saveCart(c, validated)
 	}
 
-	// Create an order from the cart
-	order := models.NewOrder(email)
+	// Shipping and tax depend on the shipping address, which is asked for on
+	// the review page
+	models.ApplyShipping(h.shipping, validated)
+	if err := models.ApplyTax(c.UserContext(), h.taxes, validated); err != nil {
+		log.Printf("Error calculating tax: %v", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error processing order")
+	}
 
-	// Copy items from cart to order
-	for _, item := range cart.Items {
-		product, _ := models.GetProductByID(item.ProductID)
-		order.AddItem(product, item.Quantity)
+	// Show the review page first, and again whenever the cart had to change,
+	// so that the customer never pays for something they have not seen
+	ready := validated.ShippingAddress.IsComplete() && validated.Shipping != nil
+	if c.Method() != fiber.MethodPost || email == "" || !ready ||
+		len(adjustments) > 0 || len(couponProblems) > 0 || validated.IsEmpty() {
+		var addressErrs models.ValidationErrors
+		if c.Query("error") == "invalid_address" || (c.Method() == fiber.MethodPost && !ready) {
+			address := validated.ShippingAddress
+			addressErrs = address.Validate()
+		}
+		return c.Render("checkout_review", fiber.Map{
+			"Title":          "Review Your Order",
+			"Cart":           validated,
+			"Totals":         validated.Totals(),
+			"TaxLines":       validated.TaxLines(),
+			"Adjustments":    adjustments,
+			"CouponProblems": couponProblems,
+			"Email":          email,
+			"Countries":      models.Countries,
+			"AddressErrors":  addressErrs,
+			"NoShipping":     !validated.ShippingAddress.IsZero() && len(validated.ShippingRates) == 0,
+		})
 	}
 
+	// The order only exists from here on, once the customer has committed to it
+	order := validated.ToOrder(email)
+
 	// Save the order to the database *before* creating the Stripe session
 	// This ensures the order exists when the webhook is received.
-	err := order.Save()
+	err = h.store.Orders.Save(c.UserContext(), order)
+	var couponErr *models.CouponError
+	if errors.As(err, &couponErr) {
+		// Other orders used up a coupon since the limits were checked above
+		validated.RemoveCoupon(couponErr.Code)
+		h.saveCart(c, validated)
+		return c.Redirect("/checkout?coupon_problem=" + url.QueryEscape(string(couponErr.Problem)) + "&code=" + url.QueryEscape(couponErr.Code))
+	}
 	if err != nil {
 		log.Printf("Error saving order to database before checkout: %v", err)
 		return c.Status(fiber.StatusInternalServerError).SendString("Error processing order")
 	}
 
+	// Hold the stock while the customer pays. It is committed or released by the webhook.
+	if err := h.store.Inventory.Reserve(c.UserContext(), order.ID, order.Items); err != nil {
+		if statusErr := models.UpdateOrderStatus(c.UserContext(), h.store.Orders, order, models.OrderStatusCancelled, models.ActorSystem, "stock could not be reserved"); statusErr != nil {
+			log.Printf("Error cancelling order %s: %v", order.ID, statusErr)
+		}
+		if errors.Is(err, models.ErrOutOfStock) {
+			log.Printf("Checkout for order %s stopped: %v", order.ID, err)
+			return c.Redirect("/cart?error=out_of_stock")
+		}
+		log.Printf("Error reserving stock for order %s: %v", order.ID, err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error processing order")
+	}
+
 	// Create success and cancel URLs
 	successURL := fmt.Sprintf("%s/checkout/success?session_id={CHECKOUT_SESSION_ID}", c.BaseURL())
-	cancelURL := fmt.Sprintf("%s/checkout/cancel", c.BaseURL())
+	cancelURL := fmt.Sprintf("%s/checkout/cancel?order_id=%s", c.BaseURL(), order.ID)
 
-	// Create Stripe checkout session
-	checkoutURL, err := models.CreateCheckoutSession(order, successURL, cancelURL)
+	// Create the checkout session with the payment provider
+	checkoutURL, err := h.payments.CreateCheckoutSession(c.UserContext(), order, successURL, cancelURL)
 	if err != nil {
-		log.Printf("Error creating Stripe checkout session: %v", err)
-		// Depending on your error handling, you might want to mark the order as failed
-		// order.UpdateOrderStatus(models.OrderStatusFailed)
+		log.Printf("Error creating %s checkout session: %v", h.payments.Name(), err)
+		h.abandonOrder(c, order, "checkout session could not be created")
 		return c.Status(fiber.StatusInternalServerError).SendString("Error creating checkout session")
 	}
 
-	// The Stripe Session ID is set within models.CreateCheckoutSession
+	// Record the session ID on the order. Only that column is written, as the
+	// provider's webhooks may already have moved the order on.
+	if err := h.store.Orders.SetCheckoutSession(c.UserContext(), order.ID, order.StripeID); err != nil {
+		// The session's webhooks could not be matched to the order, so it must not be paid
+		log.Printf("Error recording checkout session %s of order %s: %v", order.StripeID, order.ID, err)
+		if err := h.payments.ExpireCheckoutSession(c.UserContext(), order.StripeID); err != nil {
+			log.Printf("Error expiring checkout session %s of order %s: %v", order.StripeID, order.ID, err)
+		}
+		h.abandonOrder(c, order, "checkout session could not be recorded")
+		return c.Status(fiber.StatusInternalServerError).SendString("Error creating checkout session")
+	}
 
 	// Clear the cart after creating the order and checkout session
-	clearCart(c)
+	h.clearCart(c)
+	h.setCheckoutOrder(c, order.ID)
 
-	// Redirect to Stripe checkout URL
+	// Redirect to the provider's checkout page
 	return c.Redirect(checkoutURL, fiber.StatusSeeOther)
 }
 
+// abandonOrder cancels an order whose checkout could not be started and gives
+// back its stock. Failures are only logged: the reconciler cancels pending
+// orders without a checkout session later.
+func (h *CheckoutHandler) abandonOrder(c *fiber.Ctx, order *models.Order, reason string) {
+	// The stock is only given back once the order can no longer be paid
+	if err := models.UpdateOrderStatus(c.UserContext(), h.store.Orders, order, models.OrderStatusCancelled, models.ActorSystem, reason); err != nil {
+		log.Printf("Error cancelling order %s: %v", order.ID, err)
+		return
+	}
+	if err := h.store.Inventory.Release(c.UserContext(), order.ID); err != nil {
+		log.Printf("Error releasing stock for order %s: %v", order.ID, err)
+	}
+}
+
+// SetShippingAddress saves the address the order will be shipped to on the
+// cart and shows the review page again, with shipping and tax for the new
+// address. An incomplete address is kept, so that the customer can correct
+// it next to the errors.
+func (h *CheckoutHandler) SetShippingAddress(c *fiber.Ctx) error {
+	address := models.Address{
+		Name:       c.FormValue("name"),
+		Line1:      c.FormValue("line1"),
+		Line2:      c.FormValue("line2"),
+		City:       c.FormValue("city"),
+		State:      c.FormValue("state"),
+		PostalCode: c.FormValue("postal_code"),
+		Country:    c.FormValue("country"),
+	}
+	errs := address.Validate()
+	if _, ok := errs["country"]; ok {
+		address.Country = ""
+	}
+
+	cart := h.getCart(c)
+	if cart.IsEmpty() {
+		return c.Redirect("/cart")
+	}
+	cart.ShippingAddress = address
+	h.saveCart(c, cart)
+	if errs != nil {
+		return c.Redirect("/checkout?error=invalid_address")
+	}
+	return c.Redirect("/checkout")
+}
+
+// SetShippingMethod saves the shipping method the customer chose on the cart
+// and shows the review page again
+func (h *CheckoutHandler) SetShippingMethod(c *fiber.Ctx) error {
+	cart := h.getCart(c)
+	if cart.IsEmpty() {
+		return c.Redirect("/cart")
+	}
+	cart.ShippingMethod = c.FormValue("method")
+	h.saveCart(c, cart)
+	return c.Redirect("/checkout")
+}
+
+// SetCurrency stores the selected currency in the session and re-prices the cart in it
+func (h *CheckoutHandler) SetCurrency(c *fiber.Ctx) error {
+	currency := c.FormValue("currency")
+	if !models.IsSupportedCurrency(currency) {
+		return c.Status(fiber.StatusBadRequest).SendString("Unsupported currency")
+	}
+
+	sess, err := h.sessions.Get(c)
+	if err != nil {
+		log.Printf("Error getting session to set currency: %v", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error changing currency")
+	}
+	sess.Set(SessionCurrencyKey, currency)
+	if err := sess.Save(); err != nil {
+		log.Printf("Error saving session with currency: %v", err)
+	}
+	c.Locals("Currency", currency)
+
+	// getCart re-prices an existing cart into the newly selected currency
+	h.getCart(c)
+
+	referer := c.Get(fiber.HeaderReferer)
+	if referer == "" {
+		referer = "/"
+	}
+	return c.Redirect(referer)
+}
+
+// repriceCart moves the cart into a new currency, dropping items that have no
+// price in it.
+func (h *CheckoutHandler) repriceCart(c *fiber.Ctx, cart *models.Cart, currency string) *models.Cart {
+	repriced := models.NewCart(currency)
+	repriced.CouponCodes = cart.CouponCodes
+	repriced.ShippingAddress = cart.ShippingAddress
+	repriced.ShippingMethod = cart.ShippingMethod
+	for _, item := range cart.Items {
+		product, err := h.store.Products.GetByID(c.UserContext(), item.ProductID)
+		if err != nil {
+			log.Printf("Dropping product %s from cart: %v", item.ProductID, err)
+			continue
+		}
+		if err := repriced.AddItem(product, item.VariantID, item.Quantity); err != nil {
+			log.Printf("Dropping product %s from cart: %v", item.ProductID, err)
+		}
+	}
+	return repriced
+}
+
 // CheckoutSuccess handles successful checkout
-func CheckoutSuccess(c *fiber.Ctx) error {
-	// The Stripe webhook handler will update the order status in the DB
+func (h *CheckoutHandler) CheckoutSuccess(c *fiber.Ctx) error {
+	// The webhook handler will update the order status in the DB
 
 	// Get the session ID from the query parameter
 	sessionID := c.Query("session_id")
@@ -179,7 +521,7 @@ func CheckoutSuccess(c *fiber.Ctx) error {
 	}
 
 	// Retrieve the order from the database using the sessionID
-	order, err := models.GetOrderByStripeID(sessionID)
+	order, err := h.store.Orders.GetByStripeID(c.UserContext(), sessionID)
 	if err != nil {
 		log.Printf("Error retrieving order for success page (Stripe ID %s): %v", sessionID, err)
 		// Continue rendering success page even if order retrieval fails
@@ -198,21 +540,40 @@ func CheckoutSuccess(c *fiber.Ctx) error {
 }
 
 // CheckoutCancel handles cancelled checkout
-func CheckoutCancel(c *fiber.Ctx) error {
-	// Optionally retrieve the order based on session_id (if passed) and update status to cancelled
-	// For this example, we just render the cancel page.
+func (h *CheckoutHandler) CheckoutCancel(c *fiber.Ctx) error {
+	// Stripe sends the customer back with the order ID we put in the cancel URL
+	// (older links may carry a session_id instead).
+	var order *models.Order
+	var err error
+	if orderID := c.Query("order_id"); orderID != "" {
+		order, err = h.store.Orders.GetByID(c.UserContext(), orderID)
+	} else if sessionID := c.Query("session_id"); sessionID != "" {
+		order, err = h.store.Orders.GetByStripeID(c.UserContext(), sessionID)
+	}
+	if err != nil {
+		log.Printf("Error retrieving order for cancel page: %v", err)
+	}
+	// Anyone can follow a cancel link, so only the visitor who was sent to pay
+	// for the order cancels it. Other orders expire with their session.
+	if order != nil && order.ID != h.checkoutOrder(c) {
+		log.Printf("Warning: Not cancelling order %s for a visitor who did not place it", order.ID)
+		order = nil
+	}
 
-	// Get the session ID from the query parameter (optional)
-	sessionID := c.Query("session_id")
-	if sessionID != "" {
-		// Attempt to retrieve the order and update its status to cancelled
-		order, err := models.GetOrderByStripeID(sessionID)
+	// Only a checkout that is still waiting for payment can be cancelled. The
+	// stock is given back only if cancelling succeeds, so that an order paid
+	// meanwhile keeps it.
+	if order != nil && order.Status == models.OrderStatusPending {
+		err = models.UpdateOrderStatus(c.UserContext(), h.store.Orders, order, models.OrderStatusCancelled, models.ActorCustomer, "left the payment page")
 		if err != nil {
-			log.Printf("Error retrieving order for cancel page (Stripe ID %s): %v", sessionID, err)
+			log.Printf("Error updating order status to cancelled for order %s: %v", order.ID, err)
 		} else {
-			err = order.UpdateOrderStatus(models.OrderStatusFailed) // Assuming 'failed' or similar indicates cancellation
-			if err != nil {
-				log.Printf("Error updating order status to cancelled for order %s: %v", order.ID, err)
+			if err := h.store.Inventory.Release(c.UserContext(), order.ID); err != nil {
+				log.Printf("Error releasing stock for cancelled order %s: %v", order.ID, err)
+			}
+			if err := h.payments.ExpireCheckoutSession(c.UserContext(), order.StripeID); err != nil {
+				// The reconciler tries again later
+				log.Printf("Warning: Could not expire checkout session %s of cancelled order %s: %v", order.StripeID, order.ID, err)
 			}
 		}
 	}
@@ -223,91 +584,124 @@ func CheckoutCancel(c *fiber.Ctx) error {
 	})
 }
 
-// StripeWebhook handles Stripe webhook events
-func StripeWebhook(c *fiber.Ctx) error {
-	// This handler will be fully implemented in the next step.
+// StripeWebhook handles webhook events from the payment provider, in
+// Stripe's format
+func (h *CheckoutHandler) StripeWebhook(c *fiber.Ctx) error {
+	// Check the signature header against the request body
+	event, err := h.payments.VerifyWebhook(c.Body(), c.Get("Stripe-Signature"))
+	if err != nil {
+		log.Printf("Webhook verification error: %v", err)
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
 
-	// Get the signature header
-	signature := c.Get("Stripe-Signature")
+	// Record the event and process it, unless it was delivered and processed before
+	if err := models.ProcessWebhookEvent(c.UserContext(), h.store, h.payments, event, c.Body()); err != nil {
+		log.Printf("Webhook handling error for event %s (%s): %v", event.ID, event.Type, err)
+		return c.SendStatus(fiber.StatusInternalServerError) // The provider retries the delivery
+	}
 
-	// Read the request body
-	payload := c.Body()
+	// Return 200 OK for successful processing
+	return c.SendStatus(fiber.StatusOK)
+}
 
-	// Process the webhook using the function in models/stripe.go
-	err := models.HandleStripeWebhook(payload, signature)
+// cartKey returns the owner of the visitor's cart: the signed-in customer if
+// there is one, otherwise the session.
+func (h *CheckoutHandler) cartKey(c *fiber.Ctx) (models.CartKey, error) {
+	sess, err := h.sessions.Get(c)
 	if err != nil {
-		log.Printf("Stripe webhook handling error: %v", err)
-		return c.SendStatus(fiber.StatusBadRequest) // Return 400 for invalid signature or processing errors
+		return models.CartKey{}, err
 	}
+	key := models.CartKey{SessionID: sess.ID()}
+	key.CustomerID, _ = sess.Get(SessionCustomerKey).(string)
 
-	// Return 200 OK for successful processing
-	return c.SendStatus(fiber.StatusOK)
+	// Send the session cookie now, so that the cart is found on the next visit
+	if sess.Fresh() {
+		if err := sess.Save(); err != nil {
+			return models.CartKey{}, err
+		}
+	}
+	return key, nil
 }
 
-// Helper function to get cart from session
-func getCart(c *fiber.Ctx) *models.Order {
-	// Get the session store
-	sess, err := sessionStore.Get(c)
+// Helper function to get the visitor's cart, empty if they have none yet
+func (h *CheckoutHandler) getCart(c *fiber.Ctx) *models.Cart {
+	key, err := h.cartKey(c)
 	if err != nil {
 		log.Printf("Error getting session: %v", err)
 		// Return a new empty cart in case of session error
-		return models.NewOrder("")
+		return models.NewCart(CurrentCurrency(c))
 	}
 
-	// Get cart from session
-	cartData := sess.Get(SessionCartKey)
-	if cartData == nil {
-		// Create a new cart if none exists
-		cart := models.NewOrder("")
-		saveCart(c, cart)
-		return cart
+	cart, err := h.store.Carts.Get(c.UserContext(), key)
+	if err != nil {
+		if !errors.Is(err, models.ErrNotFound) {
+			log.Printf("Error loading cart: %v", err)
+		}
+		// Empty carts are not stored until something is added
+		return models.NewCart(CurrentCurrency(c))
 	}
 
-	// Type assert cart data
-	cart, ok := cartData.(*models.Order)
-	if !ok {
-		log.Println("Error asserting cart data from session, creating new cart.")
-		// Return a new empty cart if type assertion fails
-		cart := models.NewOrder("")
-		saveCart(c, cart)
-		return cart
+	// Keep the cart in the currency the shopper has selected
+	if cart.Currency != CurrentCurrency(c) {
+		cart = h.repriceCart(c, cart, CurrentCurrency(c))
+		h.saveCart(c, cart)
 	}
 
 	return cart
 }
 
-// Helper function to save cart to session
-func saveCart(c *fiber.Ctx, cart *models.Order) {
-	// Get the session store
-	sess, err := sessionStore.Get(c)
+// Helper function to save the visitor's cart, deleting it once it is empty
+func (h *CheckoutHandler) saveCart(c *fiber.Ctx, cart *models.Cart) {
+	key, err := h.cartKey(c)
 	if err != nil {
 		log.Printf("Error getting session to save cart: %v", err)
-		return // Cannot save cart if session store is unavailable
+		return // Cannot save cart without knowing whose it is
 	}
 
-	// Set cart in session
-	sess.Set(SessionCartKey, cart)
-
-	// Save session
-	if err := sess.Save(); err != nil {
-		log.Printf("Error saving session with cart: %v", err)
+	if cart.IsEmpty() {
+		err = h.store.Carts.Delete(c.UserContext(), key)
+	} else {
+		err = h.store.Carts.Save(c.UserContext(), key, cart)
+	}
+	if err != nil {
+		log.Printf("Error saving cart of %s: %v", key, err)
 	}
 }
 
-// Helper function to clear the cart from session
-func clearCart(c *fiber.Ctx) {
-	// Get the session store
-	sess, err := sessionStore.Get(c)
+// Helper function to clear the visitor's cart
+func (h *CheckoutHandler) clearCart(c *fiber.Ctx) {
+	key, err := h.cartKey(c)
 	if err != nil {
 		log.Printf("Error getting session to clear cart: %v", err)
-		return // Cannot clear cart if session store is unavailable
+		return // Cannot clear cart without knowing whose it is
 	}
 
-	// Delete cart from session
-	sess.Delete(SessionCartKey)
+	if err := h.store.Carts.Delete(c.UserContext(), key); err != nil {
+		log.Printf("Error clearing cart of %s: %v", key, err)
+	}
+}
 
-	// Save session
+// setCheckoutOrder remembers on the session the order the visitor is paying for.
+func (h *CheckoutHandler) setCheckoutOrder(c *fiber.Ctx, orderID string) {
+	sess, err := h.sessions.Get(c)
+	if err != nil {
+		log.Printf("Error getting session to record order %s: %v", orderID, err)
+		return
+	}
+	sess.Set(SessionCheckoutOrderKey, orderID)
 	if err := sess.Save(); err != nil {
-		log.Printf("Error saving session after clearing cart: %v", err)
+		log.Printf("Error saving session with order %s: %v", orderID, err)
+	}
+}
+
+// checkoutOrder returns the ID of the order the visitor was last sent to pay
+// for, or "" if there is none.
+func (h *CheckoutHandler) checkoutOrder(c *fiber.Ctx) string {
+	sess, err := h.sessions.Get(c)
+	if err != nil {
+		log.Printf("Error getting session: %v", err)
+		return ""
 	}
+	orderID, _ := sess.Get(SessionCheckoutOrderKey).(string)
+	return orderID
 }
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"ecommerce-app/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// newCheckoutApp returns an app serving the checkout routes from a memory
+// store holding a $10.00 tee, without tax or shipping charges, and paid
+// through the fake provider.
+func newCheckoutApp(t *testing.T) (*fiber.App, *models.Store, *models.FakePaymentProvider) {
+	t.Helper()
+	store := models.NewMemoryStore()
+	tee := models.Product{ID: "tee", Name: "Tee", Price: models.Money{Amount: 1000, Currency: "USD"}, Stock: 5, TrackInventory: true}
+	if err := store.Products.Create(context.Background(), tee); err != nil {
+		t.Fatal(err)
+	}
+	payments, err := models.NewFakePaymentProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	taxes, err := models.NewTaxCalculator(models.TaxConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, sessions := newTestApp(t)
+	NewCheckoutHandler(store, sessions, taxes, &models.ShippingTable{}, payments).RegisterRoutes(app)
+	return app, store, payments
+}
+
+// post submits the form to the app, sending the cookies if any.
+func post(t *testing.T, app *fiber.App, target string, form url.Values, cookies ...*http.Cookie) (*http.Response, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	return send(t, app, req)
+}
+
+// addToCart puts quantity tees in a new visitor's cart and returns the
+// visitor's session cookies.
+func addToCart(t *testing.T, app *fiber.App, quantity string) []*http.Cookie {
+	t.Helper()
+	resp, _ := post(t, app, "/cart/add/tee", url.Values{"quantity": {quantity}})
+	if resp.Header.Get("Location") != "/cart" || len(resp.Cookies()) == 0 {
+		t.Fatalf("adding to the cart: %s to %q with cookies %v", resp.Status, resp.Header.Get("Location"), resp.Cookies())
+	}
+	return resp.Cookies()
+}
+
+func TestCheckoutReviewReprices(t *testing.T) {
+	ctx := context.Background()
+	app, store, _ := newCheckoutApp(t)
+	cookies := addToCart(t, app, "2")
+
+	// The price goes up after the tee was added
+	tee, err := store.Products.GetByID(ctx, "tee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tee.Price.Amount = 1200
+	if err := store.Products.Update(ctx, tee, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Paying is stopped to show the change
+	resp, body := post(t, app, "/checkout", url.Values{"email": {"ann@example.com"}}, cookies...)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "Review Your Order") {
+		t.Fatalf("POST /checkout: %s, want the review page", resp.Status)
+	}
+	if !strings.Contains(body, "changed from $10.00 to $12.00") {
+		t.Error("review page does not say the price changed")
+	}
+	if orders, err := store.Orders.List(ctx, models.OrderFilter{}); err != nil || len(orders) != 0 {
+		t.Errorf("placed %d orders (%v) for a cart whose price changed", len(orders), err)
+	}
+
+	// The cart was saved re-priced, so the change is only reported once
+	_, body = get(t, app, "/checkout", cookies...)
+	if strings.Contains(body, "Your cart has changed") {
+		t.Error("review page reports the change again")
+	}
+	if !strings.Contains(body, "$24.00") {
+		t.Error("review page does not total the tees at the new price")
+	}
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"ecommerce-app/models"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+)
+
+// Currency session key
+const SessionCurrencyKey = "currency"
+
+// CurrencyMiddleware resolves the shopper's currency from the session and their
+// locale from Accept-Language, and exposes both (plus the currency list) to
+// handlers and views through c.Locals.
+func CurrencyMiddleware(sessions *session.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		currency := models.DefaultCurrency
+		sess, err := sessions.Get(c)
+		if err != nil {
+			log.Printf("Error getting session for currency: %v", err)
+		} else if code, ok := sess.Get(SessionCurrencyKey).(string); ok && models.IsSupportedCurrency(code) {
+			currency = code
+		}
+
+		c.Locals("Currency", currency)
+		c.Locals("Currencies", models.SupportedCurrencies)
+		c.Locals("Locale", models.MatchLocale(c.Get(fiber.HeaderAcceptLanguage), currency))
+		return c.Next()
+	}
+}
+
+// CurrentCurrency returns the currency resolved by CurrencyMiddleware.
+func CurrentCurrency(c *fiber.Ctx) string {
+	if currency, ok := c.Locals("Currency").(string); ok {
+		return currency
+	}
+	return models.DefaultCurrency
+}
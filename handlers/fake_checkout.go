@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+
+	"ecommerce-app/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// FakeCheckoutHandler serves the checkout pages of the fake payment provider,
+// standing in for Stripe's hosted checkout during development.
+type FakeCheckoutHandler struct {
+	payments *models.FakePaymentProvider
+}
+
+// NewFakeCheckoutHandler returns a FakeCheckoutHandler for the provider's sessions.
+func NewFakeCheckoutHandler(payments *models.FakePaymentProvider) *FakeCheckoutHandler {
+	return &FakeCheckoutHandler{payments: payments}
+}
+
+// RegisterRoutes registers the fake checkout pages
+func (h *FakeCheckoutHandler) RegisterRoutes(app *fiber.App) {
+	app.Get(models.FakeCheckoutPath+":id", h.ShowCheckout)
+	app.Post(models.FakeCheckoutPath+":id/pay", h.Pay)
+	app.Post(models.FakeCheckoutPath+":id/decline", h.Decline)
+	app.Post(models.FakeCheckoutPath+":id/pay-later", h.PayLater)
+	app.Post(models.FakeCheckoutPath+":id/settle", h.Settle)
+	app.Post(models.FakeCheckoutPath+":id/expire", h.Expire)
+}
+
+// ShowCheckout renders the payment page of a session
+func (h *FakeCheckoutHandler) ShowCheckout(c *fiber.Ctx) error {
+	session, err := h.payments.Session(c.Params("id"))
+	if errors.Is(err, models.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).SendString("Checkout session not found")
+	}
+	return c.Render("fake_checkout", fiber.Map{
+		"Title":   "Test Payment",
+		"Session": session,
+	})
+}
+
+// Pay pays the session, which sends the payment_intent.succeeded and
+// checkout.session.completed webhooks, and sends the customer to the success page
+func (h *FakeCheckoutHandler) Pay(c *fiber.Ctx) error {
+	next, err := h.payments.Pay(c.UserContext(), c.Params("id"), h.webhookURL(c))
+	return h.finish(c, next, err)
+}
+
+// Decline declines a payment attempt, which sends the
+// payment_intent.payment_failed webhook, and shows the payment page again
+func (h *FakeCheckoutHandler) Decline(c *fiber.Ctx) error {
+	next, err := h.payments.Decline(c.UserContext(), c.Params("id"), h.webhookURL(c))
+	return h.finish(c, next, err)
+}
+
+// PayLater completes the session with a delayed payment method, which sends
+// checkout.session.completed still unpaid, and sends the customer to the
+// success page
+func (h *FakeCheckoutHandler) PayLater(c *fiber.Ctx) error {
+	next, err := h.payments.PayLater(c.UserContext(), c.Params("id"), h.webhookURL(c))
+	return h.finish(c, next, err)
+}
+
+// Settle settles a delayed payment as succeeded, or as failed if the form's
+// outcome is "fail", which sends the matching async payment webhook
+func (h *FakeCheckoutHandler) Settle(c *fiber.Ctx) error {
+	succeeded := c.FormValue("outcome") != "fail"
+	next, err := h.payments.Settle(c.UserContext(), c.Params("id"), h.webhookURL(c), succeeded)
+	return h.finish(c, next, err)
+}
+
+// Expire expires the session, which sends the checkout.session.expired
+// webhook, and sends the customer back to the cancel page
+func (h *FakeCheckoutHandler) Expire(c *fiber.Ctx) error {
+	next, err := h.payments.Expire(c.UserContext(), c.Params("id"), h.webhookURL(c))
+	return h.finish(c, next, err)
+}
+
+// finish redirects the customer on. A webhook that failed is only logged,
+// as Stripe would retry it later rather than hold up the customer.
+func (h *FakeCheckoutHandler) finish(c *fiber.Ctx, next string, err error) error {
+	if next == "" {
+		log.Printf("Fake checkout session %s: %v", c.Params("id"), err)
+		return c.Redirect(models.FakeCheckoutPath + c.Params("id"))
+	}
+	if err != nil {
+		log.Printf("Warning: fake checkout session %s: %v", c.Params("id"), err)
+	}
+	return c.Redirect(next)
+}
+
+// webhookURL is the app's own Stripe webhook endpoint.
+func (h *FakeCheckoutHandler) webhookURL(c *fiber.Ctx) string {
+	return c.BaseURL() + "/webhook/stripe"
+}
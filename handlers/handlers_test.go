@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ecommerce-app/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+	"github.com/gofiber/template/html/v2"
+)
+
+// newTestApp returns an app set up as main sets it up, rendering the views
+// of the repository, and the session store its handlers should share.
+func newTestApp(t *testing.T) (*fiber.App, *session.Store) {
+	t.Helper()
+	engine := html.New("../views", ".html")
+	engine.AddFunc("formatPrice", models.FormatMoney)
+	app := fiber.New(fiber.Config{
+		Views:             engine,
+		ViewsLayout:       "layout",
+		PassLocalsToViews: true,
+	})
+	sessions := session.New()
+	app.Use(CurrencyMiddleware(sessions))
+	return app, sessions
+}
+
+// send makes the request to the app and returns the response and its body.
+func send(t *testing.T, app *fiber.App, req *http.Request) (*http.Response, string) {
+	t.Helper()
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+// get makes a GET request to the app, sending the cookies if any.
+func get(t *testing.T, app *fiber.App, target string, cookies ...*http.Cookie) (*http.Response, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	return send(t, app, req)
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+
+	"ecommerce-app/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxPageSize caps the ?limit= a listing request may ask for
+const maxPageSize = 48
+
+// productListing is one page of a product listing with its facet counts.
+type productListing struct {
+	Request models.ProductPageRequest
+	Page    models.ProductPage
+	Facets  models.ProductFacets
+}
+
+// loadListing reads the listing query parameters (sort, after, limit, tag,
+// price and in_stock) and loads the matching page and facets. When category
+// is set, the listing is limited to it and its subcategories.
+func loadListing(c *fiber.Ctx, products models.ProductRepository, all []models.Category, category models.Category) (*productListing, error) {
+	req := models.ProductPageRequest{
+		Filter: models.ProductFilter{
+			Tag:      c.Query("tag"),
+			Currency: CurrentCurrency(c),
+			InStock:  c.QueryBool("in_stock"),
+		},
+		Sort:  models.ParseProductSort(c.Query("sort")),
+		After: c.Query("after"),
+		Limit: c.QueryInt("limit", models.DefaultPageSize),
+	}
+	if req.Limit < 1 || req.Limit > maxPageSize {
+		req.Limit = models.DefaultPageSize
+	}
+	if category.ID != "" {
+		req.Filter.CategoryIDs = models.CategoryAndDescendantIDs(all, category.ID)
+	}
+	if pr, ok := models.ParsePriceRange(c.Query("price")); ok {
+		req.Filter.Price = pr
+	}
+
+	page, err := products.ListPage(c.UserContext(), req)
+	if err != nil {
+		return nil, err
+	}
+	facets, err := products.Facets(c.UserContext(), req.Filter, all)
+	if err != nil {
+		return nil, err
+	}
+	return &productListing{Request: req, Page: page, Facets: facets}, nil
+}
+
+// renderListing renders the products view for a listing at path, adding the
+// sort options, facet links and pagination links to values.
+func renderListing(c *fiber.Ctx, products models.ProductRepository, all []models.Category, category models.Category, path string, values fiber.Map) error {
+	listing, err := loadListing(c, products, all, category)
+	if errors.Is(err, models.ErrInvalidCursor) {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid page cursor")
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to load products")
+	}
+
+	query, _ := url.ParseQuery(string(c.Request().URI().QueryString()))
+	locale, _ := c.Locals("Locale").(string)
+	currency := CurrentCurrency(c)
+
+	var sorts []facetLink
+	for _, s := range models.ProductSorts {
+		sorts = append(sorts, facetLink{
+			Label:    s.Label(),
+			URL:      listingURL(path, query, "sort", string(s)),
+			Selected: s == listing.Request.Sort,
+		})
+	}
+
+	var prices []facetLink
+	for _, pr := range listing.Facets.PriceRanges {
+		selected := pr.PriceRange == listing.Request.Filter.Price
+		link := facetLink{
+			Label:    priceRangeLabel(pr.PriceRange, currency, locale),
+			Count:    pr.Count,
+			URL:      listingURL(path, query, "price", pr.Key()),
+			Selected: selected,
+		}
+		if selected {
+			link.URL = listingURL(path, query, "price", "")
+		}
+		prices = append(prices, link)
+	}
+
+	inStock := facetLink{
+		Label:    "In stock only",
+		Count:    listing.Facets.InStock,
+		URL:      listingURL(path, query, "in_stock", "true"),
+		Selected: listing.Request.Filter.InStock,
+	}
+	if inStock.Selected {
+		inStock.URL = listingURL(path, query, "in_stock", "")
+	}
+
+	values["Products"] = models.LocalizeProducts(listing.Page.Products, currency)
+	values["Category"] = category
+	values["Tag"] = listing.Request.Filter.Tag
+	values["Total"] = listing.Facets.Total
+	values["Sorts"] = sorts
+	values["PriceFacets"] = prices
+	values["InStockFacet"] = inStock
+	values["AllProductsURL"] = listingURL("/products", query, "category", "")
+	values["CategoryTree"] = buildCategoryTree(all, "", listing.Facets.Categories, query)
+	if listing.Page.NextCursor != "" {
+		values["NextURL"] = listingURL(path, query, "after", listing.Page.NextCursor)
+	}
+	if listing.Request.After != "" {
+		values["FirstURL"] = listingURL(path, query, "after", "")
+	}
+	return c.Render("products", values)
+}
+
+// listingJSON writes a listing page with its facets as JSON.
+func listingJSON(c *fiber.Ctx, products models.ProductRepository, all []models.Category, category models.Category) error {
+	listing, err := loadListing(c, products, all, category)
+	if errors.Is(err, models.ErrInvalidCursor) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid page cursor"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to load products"})
+	}
+
+	locale, _ := c.Locals("Locale").(string)
+	currency := CurrentCurrency(c)
+
+	type categoryCount struct {
+		ID    string `json:"id"`
+		Slug  string `json:"slug"`
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	categories := make([]categoryCount, 0, len(all))
+	for _, cat := range all {
+		categories = append(categories, categoryCount{ID: cat.ID, Slug: cat.Slug, Name: cat.Name, Count: listing.Facets.Categories[cat.ID]})
+	}
+
+	type priceCount struct {
+		Key   string `json:"key"`
+		Label string `json:"label"`
+		models.PriceRangeCount
+	}
+	prices := make([]priceCount, 0, len(listing.Facets.PriceRanges))
+	for _, pr := range listing.Facets.PriceRanges {
+		prices = append(prices, priceCount{Key: pr.Key(), Label: priceRangeLabel(pr.PriceRange, currency, locale), PriceRangeCount: pr})
+	}
+
+	return c.JSON(fiber.Map{
+		"products":    models.LocalizeProducts(listing.Page.Products, currency),
+		"next_cursor": listing.Page.NextCursor,
+		"sort":        listing.Request.Sort,
+		"facets": fiber.Map{
+			"total":        listing.Facets.Total,
+			"categories":   categories,
+			"price_ranges": prices,
+			"in_stock":     listing.Facets.InStock,
+		},
+	})
+}
+
+// facetLink is a sort option or facet value in the listing sidebar.
+type facetLink struct {
+	Label    string
+	Count    int
+	URL      string // applies the value, or removes it when Selected
+	Selected bool
+}
+
+// categoryNode is a category in the listing sidebar with its product count
+// and subcategories.
+type categoryNode struct {
+	models.Category
+	Count    int
+	Link     string // the category's page, keeping the other listing parameters
+	Children []categoryNode
+}
+
+// buildCategoryTree returns the categories below parentID with their counts,
+// linking each to its page with the current sort and facets applied.
+func buildCategoryTree(all []models.Category, parentID string, counts map[string]int, query url.Values) []categoryNode {
+	var tree []categoryNode
+	for _, cat := range models.ChildCategories(all, parentID) {
+		tree = append(tree, categoryNode{
+			Category: cat,
+			Count:    counts[cat.ID],
+			Link:     listingURL(cat.URL(), query, "category", ""),
+			Children: buildCategoryTree(all, cat.ID, counts, query),
+		})
+	}
+	return tree
+}
+
+// listingURL returns path with the current query, key set to value (or
+// removed if value is empty), starting again from the first page.
+func listingURL(path string, query url.Values, key, value string) string {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	if key != "after" {
+		q.Del("after")
+	}
+	if value == "" {
+		q.Del(key)
+	} else {
+		q.Set(key, value)
+	}
+	if len(q) == 0 {
+		return path
+	}
+	return path + "?" + q.Encode()
+}
+
+// priceRangeLabel describes a price range in the shopper's currency and locale.
+func priceRangeLabel(pr models.PriceRange, currency, locale string) string {
+	min := models.FormatMoney(models.NewMoney(pr.Min, currency), locale)
+	max := models.FormatMoney(models.NewMoney(pr.Max, currency), locale)
+	switch {
+	case pr.Min == 0:
+		return "Under " + max
+	case pr.Max == 0:
+		return min + " and over"
+	default:
+		return min + " – " + max
+	}
+}
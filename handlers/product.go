@@ -6,34 +6,99 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
-// RegisterProductRoutes registers all product-related routes
-func RegisterProductRoutes(app *fiber.App) {
-	app.Get("/products", ListProducts)
-	app.Get("/products/:id", GetProduct)
+// ProductHandler serves the product catalog pages.
+type ProductHandler struct {
+	products   models.ProductRepository
+	categories models.CategoryRepository
 }
 
-// ListProducts renders the product listing page
-func ListProducts(c *fiber.Ctx) error {
-	products, err := models.GetProducts()
+// NewProductHandler returns a ProductHandler reading from the given repositories.
+func NewProductHandler(products models.ProductRepository, categories models.CategoryRepository) *ProductHandler {
+	return &ProductHandler{products: products, categories: categories}
+}
+
+// RegisterRoutes registers all product-related routes
+func (h *ProductHandler) RegisterRoutes(app *fiber.App) {
+	app.Get("/products", h.ListProducts)
+	app.Get("/products/:id", h.GetProduct)
+	app.Get("/api/products", h.ListProductsJSON)
+}
+
+// ListProducts renders the product listing page, optionally filtered by
+// category (?category=slug, including its subcategories), tag (?tag=name) and
+// the listing facets; see loadListing for the other parameters.
+func (h *ProductHandler) ListProducts(c *fiber.Ctx) error {
+	all, category, err := h.listingCategory(c)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("Failed to load products")
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to load categories")
 	}
-	return c.Render("products", fiber.Map{
-		"Title":    "All Products",
-		"Products": products,
-	})
+
+	title := "All Products"
+	if category.ID != "" {
+		title = category.Name
+	}
+	if tag := c.Query("tag"); tag != "" {
+		title += " tagged \"" + tag + "\""
+	}
+	return renderListing(c, h.products, all, category, "/products", fiber.Map{"Title": title})
+}
+
+// ListProductsJSON returns a page of the product listing with its facets as
+// JSON, taking the same parameters as ListProducts.
+func (h *ProductHandler) ListProductsJSON(c *fiber.Ctx) error {
+	all, category, err := h.listingCategory(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to load categories"})
+	}
+	return listingJSON(c, h.products, all, category)
+}
+
+// listingCategory loads every category and the one named by ?category=, if
+// any. An unknown slug lists all products.
+func (h *ProductHandler) listingCategory(c *fiber.Ctx) ([]models.Category, models.Category, error) {
+	all, err := h.categories.List(c.UserContext())
+	if err != nil {
+		return nil, models.Category{}, err
+	}
+	slug := c.Query("category")
+	for _, cat := range all {
+		if slug != "" && cat.Slug == slug {
+			return all, cat, nil
+		}
+	}
+	return all, models.Category{}, nil
 }
 
 // GetProduct renders the product detail page
-func GetProduct(c *fiber.Ctx) error {
+func (h *ProductHandler) GetProduct(c *fiber.Ctx) error {
 	id := c.Params("id")
-	product, err := models.GetProductByID(id)
-	if err != nil {
+	product, err := h.products.GetByID(c.UserContext(), id)
+	if err != nil || product.Archived {
 		return c.Status(fiber.StatusNotFound).Redirect("/products")
 	}
 
+	// A product without a price in the shopper's currency is shown but cannot be bought
+	localized, available := product.InCurrency(CurrentCurrency(c))
+
+	// Breadcrumbs follow the first category the product is filed under
+	var breadcrumbs []models.Category
+	if len(product.CategoryIDs) > 0 {
+		all, err := h.categories.List(c.UserContext())
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Failed to load categories")
+		}
+		breadcrumbs = models.CategoryPath(all, product.CategoryIDs[0])
+	}
+
 	return c.Render("product", fiber.Map{
-		"Title":   product.Name,
-		"Product": product,
+		"Title":         product.Name,
+		"Product":       &localized,
+		"Breadcrumbs":   breadcrumbs,
+		"Available":     available,
+		"InStock":       localized.InStock(),
+		"OutOfStock":    c.Query("error") == "out_of_stock",
+		"ChooseVariant": c.Query("error") == "choose_variant",
+		"TooMany":       c.Query("error") == "invalid_quantity",
+		"MaxQuantity":   models.MaxItemQuantity,
 	})
 }
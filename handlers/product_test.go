@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"ecommerce-app/models"
+)
+
+// TestProductHandlerRepositories checks that the product pages read from the
+// repositories the handler is given rather than from a global database.
+func TestProductHandlerRepositories(t *testing.T) {
+	ctx := context.Background()
+	store := models.NewMemoryStore()
+	for _, p := range []models.Product{
+		{ID: "tee", Name: "Organic Tee", Price: models.Money{Amount: 2000, Currency: "USD"}},
+		{ID: "mug", Name: "Camping Mug", Price: models.Money{Amount: 1500, Currency: "USD"}},
+		{ID: "cap", Name: "Old Cap", Price: models.Money{Amount: 1200, Currency: "USD"}, Archived: true},
+	} {
+		if err := store.Products.Create(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app, _ := newTestApp(t)
+	NewProductHandler(store.Products, store.Categories).RegisterRoutes(app)
+
+	resp, body := get(t, app, "/api/products")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/products: %s: %s", resp.Status, body)
+	}
+	var listing struct {
+		Products []models.Product `json:"products"`
+	}
+	if err := json.Unmarshal([]byte(body), &listing); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range listing.Products {
+		names = append(names, p.Name)
+	}
+	if strings.Join(names, ", ") != "Organic Tee, Camping Mug" {
+		t.Errorf("listed %v, want the products for sale", names)
+	}
+
+	resp, body = get(t, app, "/products/tee")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "Organic Tee") {
+		t.Errorf("GET /products/tee: %s, want the tee's page", resp.Status)
+	}
+	for _, id := range []string{"cap", "missing"} {
+		if resp, _ := get(t, app, "/products/"+id); resp.Header.Get("Location") != "/products" {
+			t.Errorf("GET /products/%s: %s to %q, want a redirect to /products", id, resp.Status, resp.Header.Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"strings"
+
+	"ecommerce-app/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// searchPageLimit is the number of results shown on the search page
+	searchPageLimit = 50
+	// searchAPILimit is the default number of results from the JSON endpoint
+	searchAPILimit = 8
+)
+
+// SearchHandler serves product search as a page and as JSON for typeahead.
+type SearchHandler struct {
+	search models.SearchRepository
+}
+
+// NewSearchHandler returns a SearchHandler querying the given repository.
+func NewSearchHandler(search models.SearchRepository) *SearchHandler {
+	return &SearchHandler{search: search}
+}
+
+// RegisterRoutes registers all search-related routes
+func (h *SearchHandler) RegisterRoutes(app *fiber.App) {
+	app.Get("/search", h.SearchPage)
+	app.Get("/api/search", h.SearchJSON)
+}
+
+// SearchPage renders the results for ?q=
+func (h *SearchHandler) SearchPage(c *fiber.Ctx) error {
+	query := strings.TrimSpace(c.Query("q"))
+	hits, err := h.search.Search(c.UserContext(), query, searchPageLimit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Search failed")
+	}
+
+	return c.Render("search", fiber.Map{
+		"Title": "Search",
+		"Query": query,
+		"Hits":  localizeHits(hits, CurrentCurrency(c)),
+	})
+}
+
+// searchResult is one product in the JSON search response.
+type searchResult struct {
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	NameHTML    string       `json:"name_html"`    // name with matched terms in <mark>
+	SnippetHTML string       `json:"snippet_html"` // description excerpt with matched terms in <mark>
+	Price       models.Money `json:"price"`
+	PriceText   string       `json:"price_text"` // price formatted for the shopper's locale
+	URL         string       `json:"url"`
+	Rank        float64      `json:"rank"`
+}
+
+// SearchJSON returns the results for ?q= (and an optional ?limit=) as JSON.
+// Terms match word prefixes, so it can be called on every keystroke.
+func (h *SearchHandler) SearchJSON(c *fiber.Ctx) error {
+	query := strings.TrimSpace(c.Query("q"))
+	limit := c.QueryInt("limit", searchAPILimit)
+	if limit < 1 || limit > searchPageLimit {
+		limit = searchAPILimit
+	}
+
+	hits, err := h.search.Search(c.UserContext(), query, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "search failed"})
+	}
+
+	locale, _ := c.Locals("Locale").(string)
+	results := make([]searchResult, 0, len(hits))
+	for _, hit := range localizeHits(hits, CurrentCurrency(c)) {
+		results = append(results, searchResult{
+			ID:          hit.Product.ID,
+			Name:        hit.Product.Name,
+			NameHTML:    string(hit.Name),
+			SnippetHTML: string(hit.Snippet),
+			Price:       hit.Product.Price,
+			PriceText:   models.FormatMoney(hit.Product.Price, locale),
+			URL:         "/products/" + hit.Product.ID,
+			Rank:        hit.Rank,
+		})
+	}
+	return c.JSON(fiber.Map{"query": query, "results": results})
+}
+
+// localizeHits prices each hit's product in the given currency, dropping
+// those that cannot be bought in it.
+func localizeHits(hits []models.SearchHit, currency string) []models.SearchHit {
+	localized := make([]models.SearchHit, 0, len(hits))
+	for _, hit := range hits {
+		if p, ok := hit.Product.InCurrency(currency); ok {
+			hit.Product = p
+			localized = append(localized, hit)
+		}
+	}
+	return localized
+}
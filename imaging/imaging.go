@@ -0,0 +1,127 @@
+// Package imaging resizes uploaded images into the renditions the storefront
+// serves, using only pure Go decoders and encoders.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	// Register decoders for image.Decode
+	_ "golang.org/x/image/webp"
+	_ "image/gif"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// ErrUnsupportedImage is returned for files that are not JPEG, PNG, GIF or
+// WebP images, or are too large to process.
+var ErrUnsupportedImage = errors.New("unsupported image")
+
+// MaxPixels limits the size of the images accepted, so that a small file
+// cannot decode into a huge bitmap.
+const MaxPixels = 40_000_000
+
+// Size is a rendition: its name and its largest width in pixels.
+type Size struct {
+	Name  string
+	Width int
+}
+
+// Sizes are the renditions made of every image, smallest first.
+var Sizes = []Size{
+	{Name: "thumb", Width: 160},
+	{Name: "medium", Width: 480},
+	{Name: "large", Width: 1200},
+}
+
+// Rendition is an image resized to one of the Sizes.
+type Rendition struct {
+	Size
+	Width  int // actual width; images are never enlarged
+	Height int
+	Data   []byte
+}
+
+// Result holds the renditions of an image. They are PNG if the original has
+// transparency, JPEG otherwise.
+type Result struct {
+	Width       int // of the original
+	Height      int
+	ContentType string
+	Ext         string // file extension of the renditions, e.g. ".jpg"
+	Renditions  []Rendition
+}
+
+// Process decodes an image and makes a rendition for each of the Sizes.
+func Process(r io.Reader) (*Result, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d pixels is too large", ErrUnsupportedImage, cfg.Width, cfg.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+
+	bounds := src.Bounds()
+	result := &Result{Width: bounds.Dx(), Height: bounds.Dy(), ContentType: "image/jpeg", Ext: ".jpg"}
+	transparent := !isOpaque(src)
+	if transparent {
+		result.ContentType, result.Ext = "image/png", ".png"
+	}
+
+	for _, size := range Sizes {
+		width, height := fit(bounds.Dx(), bounds.Dy(), size.Width)
+		dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+		if width == bounds.Dx() && height == bounds.Dy() {
+			draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+		} else {
+			xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+		}
+
+		var buf bytes.Buffer
+		if transparent {
+			err = png.Encode(&buf, dst)
+		} else {
+			err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error encoding %s rendition: %w", size.Name, err)
+		}
+		result.Renditions = append(result.Renditions, Rendition{Size: size, Width: width, Height: height, Data: buf.Bytes()})
+	}
+	return result, nil
+}
+
+// fit scales width x height down to at most maxWidth wide, keeping the aspect ratio.
+func fit(width, height, maxWidth int) (int, int) {
+	if width <= maxWidth {
+		return width, height
+	}
+	h := (height*maxWidth + width/2) / width
+	if h < 1 {
+		h = 1
+	}
+	return maxWidth, h
+}
+
+// isOpaque reports whether the image has no transparent pixels.
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return true
+}
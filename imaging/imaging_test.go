@@ -0,0 +1,150 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// encodePNG returns img as a PNG file.
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// filled returns a width x height image of one colour.
+func filled(width, height int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		width, height, maxWidth int
+		wantWidth, wantHeight   int
+	}{
+		{100, 50, 160, 100, 50},     // narrower: never enlarged
+		{160, 90, 160, 160, 90},     // exactly as wide
+		{1000, 500, 160, 160, 80},   // exact
+		{1000, 337, 160, 160, 54},   // 53.92 rounds up
+		{1000, 333, 160, 160, 53},   // 53.28 rounds down
+		{1000, 3, 480, 480, 1},      // 1.44
+		{100000, 1, 160, 160, 1},    // would round to 0
+		{300, 2000, 160, 160, 1067}, // tall images keep their ratio too
+		{1201, 1201, 1200, 1200, 1200},
+	}
+	for _, tt := range tests {
+		width, height := fit(tt.width, tt.height, tt.maxWidth)
+		if width != tt.wantWidth || height != tt.wantHeight {
+			t.Errorf("fit(%d, %d, %d) = %dx%d, want %dx%d", tt.width, tt.height, tt.maxWidth, width, height, tt.wantWidth, tt.wantHeight)
+		}
+	}
+}
+
+func TestProcess(t *testing.T) {
+	opaque := color.NRGBA{R: 200, G: 100, B: 50, A: 255}
+	tests := []struct {
+		name            string
+		img             image.Image
+		wantContentType string
+		wantExt         string
+		wantSizes       []image.Point // of each rendition, smallest first
+	}{
+		{
+			name:            "opaque",
+			img:             filled(1000, 500, opaque),
+			wantContentType: "image/jpeg",
+			wantExt:         ".jpg",
+			wantSizes:       []image.Point{{160, 80}, {480, 240}, {1000, 500}},
+		},
+		{
+			name:            "smaller than every size",
+			img:             filled(100, 50, opaque),
+			wantContentType: "image/jpeg",
+			wantExt:         ".jpg",
+			wantSizes:       []image.Point{{100, 50}, {100, 50}, {100, 50}},
+		},
+		{
+			name:            "transparent",
+			img:             filled(600, 300, color.NRGBA{R: 200, A: 128}),
+			wantContentType: "image/png",
+			wantExt:         ".png",
+			wantSizes:       []image.Point{{160, 80}, {480, 240}, {600, 300}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Process(bytes.NewReader(encodePNG(t, tt.img)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			bounds := tt.img.Bounds()
+			if result.Width != bounds.Dx() || result.Height != bounds.Dy() {
+				t.Errorf("original is %dx%d, want %dx%d", result.Width, result.Height, bounds.Dx(), bounds.Dy())
+			}
+			if result.ContentType != tt.wantContentType || result.Ext != tt.wantExt {
+				t.Errorf("renditions are %s (%s), want %s", result.ContentType, result.Ext, tt.wantContentType)
+			}
+			if len(result.Renditions) != len(Sizes) {
+				t.Fatalf("%d renditions, want %d", len(result.Renditions), len(Sizes))
+			}
+			for i, r := range result.Renditions {
+				if r.Size != Sizes[i] {
+					t.Errorf("rendition %d is %s, want %s", i, r.Name, Sizes[i].Name)
+				}
+				if (image.Point{r.Width, r.Height}) != tt.wantSizes[i] {
+					t.Errorf("%s is %dx%d, want %v", r.Name, r.Width, r.Height, tt.wantSizes[i])
+				}
+				// The data is what the rendition says it is
+				cfg, format, err := image.DecodeConfig(bytes.NewReader(r.Data))
+				if err != nil {
+					t.Fatalf("%s: %v", r.Name, err)
+				}
+				if "image/"+format != tt.wantContentType || cfg.Width != r.Width || cfg.Height != r.Height {
+					t.Errorf("%s decodes as a %dx%d %s", r.Name, cfg.Width, cfg.Height, format)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	// A PNG whose header claims more than MaxPixels, and a valid checksum:
+	// only the header is read, so the pixels need not be there
+	huge := encodePNG(t, filled(1, 1, color.NRGBA{A: 255}))
+	ihdr := huge[8+8 : 8+8+13] // after the signature, and the chunk's length and type
+	binary.BigEndian.PutUint32(ihdr[0:], 10_000)
+	binary.BigEndian.PutUint32(ihdr[4:], MaxPixels/10_000+1)
+	binary.BigEndian.PutUint32(huge[8+8+13:], crc32.ChecksumIEEE(huge[8+4:8+8+13]))
+	if cfg, err := png.DecodeConfig(bytes.NewReader(huge)); err != nil || cfg.Width*cfg.Height <= MaxPixels {
+		t.Fatalf("huge PNG header decodes as %dx%d: %v", cfg.Width, cfg.Height, err)
+	}
+
+	tests := map[string][]byte{
+		"not an image":        []byte("%PDF-1.7 not an image"),
+		"empty":               nil,
+		"truncated":           encodePNG(t, filled(10, 10, color.NRGBA{A: 255}))[:40],
+		"more than MaxPixels": huge,
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Process(bytes.NewReader(data)); !errors.Is(err, ErrUnsupportedImage) {
+				t.Errorf("Process = %v, want ErrUnsupportedImage", err)
+			}
+		})
+	}
+}
@@ -1,13 +1,13 @@
 package main
 
 import (
+	"context"
 	"ecommerce-app/db"
 	"ecommerce-app/handlers"
 	"ecommerce-app/models"
-	"encoding/gob"
+	"ecommerce-app/storage"
 	"log"
 	"os"
-	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
@@ -22,42 +22,89 @@ func init() {
 	if err != nil {
 		log.Println("No .env file found or error loading .env file")
 	}
-	// Register types for session encoding/decoding
-	gob.Register(&models.Order{})
 }
 
 func main() {
-	// Initialize Stripe
-	models.InitStripe()
-
 	// Initialize Database
-	db.InitDB()
+	db.InitDB(db.ConfigFromEnv())
+
+	// Schema management commands run instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrateCommand(os.Args[2:])
+		return
+	}
+
+	// Make sure the schema is current before serving any requests
+	prepareSchema()
+
+	// Repositories backed by the database
+	store := models.NewSQLStore(db.DB, db.Dialect)
 
-	// Seed Products into the database
-	err := models.SeedProducts()
+	// Seed Categories into the database
+	err := models.SeedCategories(context.Background(), store.Categories)
 	if err != nil {
-		log.Fatalf("Error seeding products: %v", err)
+		log.Fatalf("Error seeding categories: %v", err)
 	}
 
+	// Catalog import/export commands run instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "catalog" {
+		runCatalogCommand(store, os.Args[2:])
+		return
+	}
+
+	// Payment provider: Stripe, or the fake provider for offline development
+	payments, err := models.NewPaymentProvider(models.PaymentConfigFromEnv())
+	if err != nil {
+		log.Fatalf("Error configuring payments: %v", err)
+	}
+
+	// Webhook event commands run instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "webhooks" {
+		runWebhooksCommand(store, payments, os.Args[2:])
+		return
+	}
+
+	// The payment reconciliation command runs instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		runReconcileCommand(store, payments, os.Args[2:])
+		return
+	}
+
+	// Load the sample catalog into an empty store
+	seedCatalog(store)
+
 	// Initialize HTML Templates
 	engine := html.New("./views", ".html")
+	engine.AddFunc("formatPrice", models.FormatMoney)
 
 	// Set up the Fiber app with the template engine
 	app := fiber.New(fiber.Config{
 		Views:       engine,
 		ViewsLayout: "layout", // Use layout.html as the base template
+		// Values set with c.Locals (currency, locale) are available in every view
+		PassLocalsToViews: true,
+		// Fiber's default of 4 MB would reject product images the admin accepts
+		BodyLimit: handlers.MaxBodySize,
 	})
 
+	// Carts are stored in the database under the session ID, so the session
+	// cookie lasts as long as a cart is kept
+	cartTTL := cartTTLFromEnv()
+	go pruneCarts(store, cartTTL)
+
+	// Orders whose payment webhooks were missed are caught up with the provider
+	reconcileInterval, reconcileAfter := reconcileConfigFromEnv()
+	if reconcileInterval > 0 {
+		go reconcilePayments(store, payments, reconcileInterval, reconcileAfter)
+	}
+
 	// Initialize Session Store
-	store := session.New(session.Config{
-		Expiration: time.Hour * 24,
+	sessions := session.New(session.Config{
+		Expiration: cartTTL,
 		CookiePath: "/",
 		// You can configure other options like KeyGenerator, Storage, etc.
 	})
 
-	// Pass the session store to handlers that need it (like checkout)
-	handlers.InitSessionStore(store)
-
 	// Middleware
 	app.Use(logger.New())
 	app.Use(recover.New())
@@ -65,8 +112,11 @@ func main() {
 	// Static files
 	app.Static("/static", "./static")
 
+	// Resolve the shopper's currency and locale for every page
+	app.Use(handlers.CurrencyMiddleware(sessions))
+
 	// Setup routes
-	setupRoutes(app)
+	setupRoutes(app, store, sessions, payments)
 
 	// Get port from environment variables or use default
 	port := os.Getenv("PORT")
@@ -79,10 +129,10 @@ func main() {
 	log.Fatal(app.Listen(":" + port))
 }
 
-func setupRoutes(app *fiber.App) {
+func setupRoutes(app *fiber.App, store *models.Store, sessions *session.Store, payments models.PaymentProvider) {
 	// Home page
 	app.Get("/", func(c *fiber.Ctx) error {
-		products, err := models.GetProducts()
+		products, err := store.Products.List(c.UserContext(), models.ProductFilter{})
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).SendString("Error fetching products")
 		}
@@ -93,13 +143,46 @@ func setupRoutes(app *fiber.App) {
 		}
 		return c.Render("index", fiber.Map{
 			"Title":    "Welcome",
-			"Products": displayProducts,
+			"Products": models.LocalizeProducts(displayProducts, handlers.CurrentCurrency(c)),
 		})
 	})
 
 	// Register product routes (listing, details)
-	handlers.RegisterProductRoutes(app)
+	handlers.NewProductHandler(store.Products, store.Categories).RegisterRoutes(app)
+
+	// Register category routes (category pages)
+	handlers.NewCategoryHandler(store.Products, store.Categories).RegisterRoutes(app)
+
+	// Register search routes (search page, typeahead JSON)
+	handlers.NewSearchHandler(store.Search).RegisterRoutes(app)
+
+	// Tax rates by destination, from the rules file
+	taxes, err := models.NewTaxCalculator(models.TaxConfigFromEnv())
+	if err != nil {
+		log.Fatalf("Error configuring tax: %v", err)
+	}
+
+	// Shipping zones and rates, from the rates file
+	shipping, err := models.NewShippingTable(models.ShippingConfigFromEnv())
+	if err != nil {
+		log.Fatalf("Error configuring shipping: %v", err)
+	}
 
 	// Register checkout routes (cart, checkout, payment)
-	handlers.RegisterCheckoutRoutes(app)
+	// The session store is passed to handlers that need it (like checkout)
+	handlers.NewCheckoutHandler(store, sessions, taxes, shipping, payments).RegisterRoutes(app)
+
+	// The fake provider's checkout pages are served by the app itself
+	if fake, ok := payments.(*models.FakePaymentProvider); ok {
+		handlers.NewFakeCheckoutHandler(fake).RegisterRoutes(app)
+	}
+
+	// Blob storage for uploaded product images (local directory or S3)
+	blobs, err := storage.New(storage.ConfigFromEnv())
+	if err != nil {
+		log.Fatalf("Error configuring image storage: %v", err)
+	}
+
+	// Register admin routes (product management), enabled by ADMIN_PASSWORD
+	handlers.NewAdminHandler(store, blobs, payments, os.Getenv("ADMIN_USERNAME"), os.Getenv("ADMIN_PASSWORD")).RegisterRoutes(app)
 }
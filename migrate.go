@@ -0,0 +1,75 @@
+package main
+
+import (
+	"ecommerce-app/db"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+)
+
+// runMigrateCommand handles `migrate up|down [steps]|status`.
+func runMigrateCommand(args []string) {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case "up":
+		count, err := db.MigrateUp(db.DB, db.Dialect)
+		if err != nil {
+			log.Fatalf("Migration failed after applying %d migration(s): %v", count, err)
+		}
+		log.Printf("Applied %d migration(s).", count)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatalf("Invalid number of steps: %s", args[1])
+			}
+		}
+		count, err := db.MigrateDown(db.DB, db.Dialect, steps)
+		if err != nil {
+			log.Fatalf("Rollback failed after reverting %d migration(s): %v", count, err)
+		}
+		log.Printf("Reverted %d migration(s).", count)
+
+	case "status":
+		states, err := db.MigrationStatus(db.DB, db.Dialect)
+		if err != nil {
+			log.Fatalf("Error reading migration status: %v", err)
+		}
+		for _, s := range states {
+			status := "pending"
+			if s.Applied {
+				status = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if s.Modified {
+				status += " (MODIFIED)"
+			}
+			fmt.Printf("%04d_%-40s %s\n", s.Version, s.Name, status)
+		}
+
+	default:
+		fmt.Fprintln(os.Stderr, "usage: migrate [up | down [steps] | status]")
+		os.Exit(2)
+	}
+}
+
+// prepareSchema optionally applies pending migrations and then refuses to
+// continue if the schema is still behind or has been tampered with.
+func prepareSchema() {
+	if os.Getenv("DB_AUTO_MIGRATE") != "false" {
+		if _, err := db.MigrateUp(db.DB, db.Dialect); err != nil {
+			log.Fatalf("Error applying migrations: %v", err)
+		}
+	}
+
+	if err := db.CheckSchema(db.DB, db.Dialect); err != nil {
+		log.Fatalf("Refusing to start: %v (run `go run . migrate up`)", err)
+	}
+}
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"slices"
+	"strings"
+)
+
+// Country is a country the store delivers to.
+type Country struct {
+	Code string // ISO 3166-1 alpha-2, e.g. "US"
+	Name string
+}
+
+// Countries lists the countries customers can choose at checkout, by name.
+var Countries = []Country{
+	{"AU", "Australia"},
+	{"AT", "Austria"},
+	{"BE", "Belgium"},
+	{"CA", "Canada"},
+	{"DK", "Denmark"},
+	{"FI", "Finland"},
+	{"FR", "France"},
+	{"DE", "Germany"},
+	{"IE", "Ireland"},
+	{"IT", "Italy"},
+	{"JP", "Japan"},
+	{"NL", "Netherlands"},
+	{"NZ", "New Zealand"},
+	{"NO", "Norway"},
+	{"PT", "Portugal"},
+	{"ES", "Spain"},
+	{"SE", "Sweden"},
+	{"CH", "Switzerland"},
+	{"GB", "United Kingdom"},
+	{"US", "United States"},
+}
+
+// IsSupportedCountry reports whether customers can choose the country at checkout.
+func IsSupportedCountry(code string) bool {
+	return slices.ContainsFunc(Countries, func(c Country) bool { return c.Code == code })
+}
+
+// Address is where an order is delivered. Tax and shipping are worked out
+// from its country, state and postal code.
+type Address struct {
+	Name       string `json:"name"` // the recipient
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `json:"city"`
+	State      string `json:"state"`       // state, province or region code, e.g. "CA"
+	PostalCode string `json:"postal_code"` // ZIP or postcode
+	Country    string `json:"country"`     // ISO 3166-1 alpha-2, e.g. "US"
+}
+
+// IsZero reports whether no address has been given.
+func (a Address) IsZero() bool {
+	return a.Country == ""
+}
+
+// IsComplete reports whether the address has everything needed to deliver to it.
+func (a Address) IsComplete() bool {
+	return a.Validate() == nil
+}
+
+// CountryName returns the name of the address's country, or its code if it
+// is not one of Countries.
+func (a Address) CountryName() string {
+	for _, c := range Countries {
+		if c.Code == a.Country {
+			return c.Name
+		}
+	}
+	return a.Country
+}
+
+// Lines returns the address as it is written on a parcel, skipping empty lines.
+func (a Address) Lines() []string {
+	var lines []string
+	for _, line := range []string{a.Name, a.Line1, a.Line2, strings.TrimSpace(a.City + " " + a.State + " " + a.PostalCode), a.CountryName()} {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Normalize trims the address and upper-cases its codes, so that it matches
+// tax and shipping rules however it was typed.
+func (a *Address) Normalize() {
+	a.Name = strings.TrimSpace(a.Name)
+	a.Line1 = strings.TrimSpace(a.Line1)
+	a.Line2 = strings.TrimSpace(a.Line2)
+	a.City = strings.TrimSpace(a.City)
+	a.Country = strings.ToUpper(strings.TrimSpace(a.Country))
+	a.State = strings.ToUpper(strings.TrimSpace(a.State))
+	a.PostalCode = strings.ToUpper(strings.TrimSpace(a.PostalCode))
+}
+
+// Validate normalizes the address and checks it, returning nil if it is valid.
+func (a *Address) Validate() ValidationErrors {
+	a.Normalize()
+	errs := ValidationErrors{}
+	if a.Name == "" {
+		errs["name"] = "Enter the recipient's name"
+	}
+	if a.Line1 == "" {
+		errs["line1"] = "Enter the street address"
+	}
+	if a.City == "" {
+		errs["city"] = "Enter the town or city"
+	}
+	for field, value := range map[string]string{"name": a.Name, "line1": a.Line1, "line2": a.Line2, "city": a.City} {
+		if len(value) > 200 {
+			errs[field] = "Must be at most 200 characters"
+		}
+	}
+	if !IsSupportedCountry(a.Country) {
+		errs["country"] = "Choose a country"
+	}
+	if len(a.State) > 64 {
+		errs["state"] = "State must be at most 64 characters"
+	}
+	if len(a.PostalCode) > 16 {
+		errs["postal_code"] = "Postal code must be at most 16 characters"
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
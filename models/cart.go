@@ -0,0 +1,437 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxItemQuantity is the most units of one product or variant a cart can hold.
+const MaxItemQuantity = 99
+
+// CartKey identifies the owner of a cart: a signed-in customer by customer
+// ID, otherwise an anonymous visitor by session ID.
+type CartKey struct {
+	SessionID  string
+	CustomerID string
+}
+
+// String describes the owner for log messages.
+func (k CartKey) String() string {
+	if k.CustomerID != "" {
+		return "customer " + k.CustomerID
+	}
+	return "session " + k.SessionID
+}
+
+// CartItem is a product (or one variant of it) in a cart, priced when it was added.
+type CartItem struct {
+	ProductID    string `json:"product_id"`
+	ProductName  string `json:"product_name"`
+	VariantID    string `json:"variant_id,omitempty"`    // empty for products without variants
+	SKU          string `json:"sku,omitempty"`           // SKU of the variant
+	VariantTitle string `json:"variant_title,omitempty"` // e.g. "M / Black"
+	ImageURL     string `json:"image_url,omitempty"`     // thumbnail shown in the cart
+	TaxClass     string `json:"tax_class"`
+	Weight       int    `json:"weight"` // grams per unit
+	Quantity     int    `json:"quantity"`
+	UnitPrice    Money  `json:"unit_price"`
+}
+
+// Key identifies the line within the cart: the variant ID when the item is a
+// variant, otherwise the product ID.
+func (i CartItem) Key() string {
+	if i.VariantID != "" {
+		return i.VariantID
+	}
+	return i.ProductID
+}
+
+// DisplayName is the product name followed by the variant title, if any.
+func (i CartItem) DisplayName() string {
+	if i.VariantTitle != "" {
+		return i.ProductName + " (" + i.VariantTitle + ")"
+	}
+	return i.ProductName
+}
+
+// LineTotal is the unit price times the quantity.
+func (i CartItem) LineTotal() Money {
+	return i.UnitPrice.Mul(i.Quantity)
+}
+
+// Cart is what a shopper intends to buy. It becomes an Order only at
+// checkout, through ToOrder.
+type Cart struct {
+	ID          string     `json:"id"`
+	Currency    string     `json:"currency"` // ISO code the items are priced in
+	Items       []CartItem `json:"items"`
+	CouponCodes []string   `json:"coupon_codes"` // coupons applied by the shopper
+	// ShippingAddress is where the order will go, once the shopper has said
+	ShippingAddress Address `json:"shipping_address"`
+	// ShippingMethod is the ID of the shipping method the shopper chose, if any
+	ShippingMethod string `json:"shipping_method,omitempty"`
+	// ShippingRates are the methods that can deliver to the address, and
+	// Shipping the chosen one, worked out by ApplyShipping; they are not stored
+	ShippingRates []ShippingRate `json:"shipping_rates,omitempty"`
+	Shipping      *ShippingRate  `json:"shipping,omitempty"`
+	// Discounts are what the coupons take off, worked out by ApplyCoupons; they are not stored
+	Discounts []Discount `json:"discounts,omitempty"`
+	// Tax is the tax on each item, worked out by ApplyTax; nil until the
+	// shipping address is known. It is not stored.
+	Tax       *TaxBreakdown `json:"tax,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// CartTotals are a cart's estimated totals. The amounts charged are worked
+// out again when the cart is turned into an order.
+type CartTotals struct {
+	Subtotal Money // sum of the line totals
+	Discount Money // taken off by the applied coupons
+	Shipping Money // of the chosen shipping method
+	Tax      Money // added on top of the prices; included tax is part of the subtotal
+	// IncludedTax is the tax already in the prices, shown for information
+	IncludedTax Money
+	Total       Money
+}
+
+// NewCart returns an empty cart priced in the given currency.
+func NewCart(currency string) *Cart {
+	now := time.Now()
+	return &Cart{
+		ID:        uuid.New().String(),
+		Currency:  currency,
+		Items:     []CartItem{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// IsEmpty reports whether the cart has no items.
+func (c *Cart) IsEmpty() bool {
+	return len(c.Items) == 0
+}
+
+// Subtotal is the sum of the line totals.
+func (c *Cart) Subtotal() Money {
+	total := Money{Currency: c.Currency}
+	for _, item := range c.Items {
+		total = total.Add(item.LineTotal())
+	}
+	return total
+}
+
+// Totals estimates what the cart will cost, with the discounts, shipping and
+// tax last worked out by ApplyCoupons, ApplyShipping and ApplyTax.
+func (c *Cart) Totals() CartTotals {
+	zero := Money{Currency: c.Currency}
+	totals := CartTotals{Subtotal: c.Subtotal(), Shipping: zero, Tax: zero, IncludedTax: zero}
+	totals.Discount = TotalDiscount(c.Discounts, c.Currency)
+	if c.Shipping != nil {
+		totals.Shipping = c.Shipping.Amount
+	}
+	if c.Tax != nil && c.Tax.Inclusive {
+		totals.IncludedTax = c.Tax.Total(c.Currency)
+	} else if c.Tax != nil {
+		totals.Tax = c.Tax.Total(c.Currency)
+	}
+	totals.Total = totals.Subtotal
+	totals.Total.Amount += totals.Shipping.Amount + totals.Tax.Amount - totals.Discount.Amount
+	return totals
+}
+
+// Weight returns the total weight of the items in grams.
+func (c *Cart) Weight() int {
+	weight := 0
+	for _, item := range c.Items {
+		weight += item.Weight * item.Quantity
+	}
+	return weight
+}
+
+// TaxLines adds up the cart's tax by name and rate, for showing one line per tax.
+func (c *Cart) TaxLines() []TaxLine {
+	if c.Tax == nil {
+		return nil
+	}
+	return sumTaxLines(c.Tax.Items)
+}
+
+// Quantity returns the quantity of the line with the given key, or 0.
+func (c *Cart) Quantity(key string) int {
+	for _, item := range c.Items {
+		if item.Key() == key {
+			return item.Quantity
+		}
+	}
+	return 0
+}
+
+// AddItem adds a product to the cart, priced in the cart's currency, or adds
+// to the quantity of its line. A product with variants must be added as one of them.
+func (c *Cart) AddItem(product Product, variantID string, quantity int) error {
+	variant, err := product.resolveVariant(variantID)
+	if err != nil {
+		return err
+	}
+
+	price, ok := product.PriceIn(c.Currency)
+	if variant != nil {
+		price, ok = product.VariantPriceIn(*variant, c.Currency)
+	}
+	if !ok {
+		return fmt.Errorf("product %s has no price in %s", product.ID, c.Currency)
+	}
+
+	// Check if product already exists in cart
+	for i, item := range c.Items {
+		if item.ProductID == product.ID && item.VariantID == variantID {
+			c.Items[i].Quantity += quantity
+			return nil
+		}
+	}
+
+	item := CartItem{
+		ProductID:   product.ID,
+		ProductName: product.Name,
+		Quantity:    quantity,
+		UnitPrice:   price,
+		ImageURL:    product.Image().ThumbURL,
+		TaxClass:    product.EffectiveTaxClass(),
+		Weight:      product.Weight,
+	}
+	if variant != nil {
+		item.VariantID = variant.ID
+		item.SKU = variant.SKU
+		item.VariantTitle = product.VariantTitle(*variant)
+		if variant.ImageURL != "" {
+			item.ImageURL = variant.ImageURL
+		}
+	}
+	c.Items = append(c.Items, item)
+	return nil
+}
+
+// SetItemQuantity changes the quantity of the line with the given key,
+// removing the line when quantity is zero. It returns an error wrapping
+// ErrNotFound if there is no such line.
+func (c *Cart) SetItemQuantity(key string, quantity int) error {
+	for i, item := range c.Items {
+		if item.Key() != key {
+			continue
+		}
+		if quantity <= 0 {
+			c.Items = append(c.Items[:i], c.Items[i+1:]...)
+		} else {
+			c.Items[i].Quantity = quantity
+		}
+		return nil
+	}
+	return fmt.Errorf("cart line %s: %w", key, ErrNotFound)
+}
+
+// MergeCarts moves the cart owned by from, e.g. an anonymous visitor's, into
+// the cart owned by to, e.g. the customer they signed in as, and deletes it.
+// Quantities of lines both carts hold are added up, then cut down to the
+// stock available and MaxItemQuantity; lines whose product is gone or has no
+// price in the cart's currency are dropped. The merged cart keeps to's
+// currency, shipping address and method if it has them, and gets the coupons
+// of both. It returns the merged cart, or nil if from has no cart.
+func MergeCarts(ctx context.Context, products ProductRepository, carts CartRepository, from, to CartKey) (*Cart, error) {
+	guest, err := carts.Get(ctx, from)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil // nothing to merge
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cart, err := carts.Get(ctx, to)
+	if errors.Is(err, ErrNotFound) {
+		cart = NewCart(guest.Currency)
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, item := range guest.Items {
+		product, err := products.GetByID(ctx, item.ProductID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("error merging cart line %s: %w", item.Key(), err)
+		}
+		if err != nil || product.Archived {
+			continue // no longer sold
+		}
+		variant, err := product.resolveVariant(item.VariantID)
+		if err != nil {
+			continue
+		}
+
+		inCart := cart.Quantity(item.Key())
+		quantity := min(inCart+item.Quantity, MaxItemQuantity)
+		stock, tracked := product.Stock, product.TrackInventory
+		if variant != nil {
+			stock, tracked = variant.Stock, variant.TrackInventory
+		}
+		if tracked {
+			quantity = min(quantity, max(stock, 0))
+		}
+		if quantity <= inCart {
+			continue // the customer's cart holds as many as can be bought already
+		}
+		if inCart > 0 {
+			err = cart.SetItemQuantity(item.Key(), quantity)
+		} else {
+			err = cart.AddItem(product, item.VariantID, quantity)
+		}
+		if err != nil {
+			log.Printf("Not merging cart line %s into the cart of %s: %v", item.Key(), to, err)
+		}
+	}
+	if cart.ShippingAddress.IsZero() {
+		cart.ShippingAddress = guest.ShippingAddress
+		cart.ShippingMethod = guest.ShippingMethod
+	}
+	for _, code := range guest.CouponCodes {
+		if err := cart.AddCoupon(code); err != nil {
+			break // the cart holds as many coupons as it can
+		}
+	}
+
+	if err := carts.Save(ctx, to, cart); err != nil {
+		return nil, err
+	}
+	if err := carts.Delete(ctx, from); err != nil {
+		return nil, err
+	}
+	return cart, nil
+}
+
+// ToOrder turns the cart into a pending order for the customer, charged in
+// the cart's currency. The cart should have been checked with ValidateCart,
+// and its discounts, shipping and tax worked out with ApplyCoupons,
+// ApplyShipping and ApplyTax, first.
+func (c *Cart) ToOrder(customerEmail string) *Order {
+	order := NewOrder(customerEmail, c.Currency)
+	order.Discounts = append(order.Discounts, c.Discounts...)
+	order.TaxInclusive = c.Tax != nil && c.Tax.Inclusive
+	order.ShippingAddress = c.ShippingAddress
+	if c.Shipping != nil {
+		order.Shipping = *c.Shipping
+	}
+	for i, item := range c.Items {
+		line := OrderItem{
+			ProductID:    item.ProductID,
+			ProductName:  item.ProductName,
+			VariantID:    item.VariantID,
+			SKU:          item.SKU,
+			VariantTitle: item.VariantTitle,
+			TaxClass:     item.TaxClass,
+			Quantity:     item.Quantity,
+			UnitPrice:    item.UnitPrice,
+		}
+		if c.Tax != nil {
+			line.Taxes = append(line.Taxes, c.Tax.Items[i]...)
+		}
+		order.Items = append(order.Items, line)
+	}
+	order.CalculateTotal()
+	return order
+}
+
+// ValidateQuantity checks a quantity asked for a cart line.
+func ValidateQuantity(quantity int) error {
+	if quantity < 1 || quantity > MaxItemQuantity {
+		return fmt.Errorf("quantity must be between 1 and %d", MaxItemQuantity)
+	}
+	return nil
+}
+
+// CartIssue says why validation changed a cart line.
+type CartIssue string
+
+const (
+	// CartIssueUnavailable means the product or variant is no longer sold, or
+	// not in the cart's currency, and the line was removed
+	CartIssueUnavailable CartIssue = "unavailable"
+	// CartIssueOutOfStock means there was not enough stock and the quantity was
+	// reduced, or the line removed when none is left
+	CartIssueOutOfStock CartIssue = "out_of_stock"
+	// CartIssuePriceChanged means the price changed since the item was added
+	// and the line was re-priced
+	CartIssuePriceChanged CartIssue = "price_changed"
+)
+
+// CartAdjustment is a change made to a cart line by ValidateCart.
+type CartAdjustment struct {
+	Item     CartItem // the line as it was
+	Issue    CartIssue
+	Quantity int   // quantity left in the cart, 0 if the line was removed
+	NewPrice Money // the current unit price, for CartIssuePriceChanged
+}
+
+// Removed reports whether the line was taken out of the cart.
+func (a CartAdjustment) Removed() bool {
+	return a.Quantity == 0
+}
+
+// ValidateCart checks every line of the cart against the current catalog. Lines
+// whose product or variant is gone, archived or has no price in the cart's
+// currency are removed, quantities are cut down to the stock available, and
+// lines are re-priced at the current price. It returns the validated copy of
+// the cart with the adjustments made; an error means the catalog could not be read.
+func ValidateCart(ctx context.Context, products ProductRepository, cart *Cart) (*Cart, []CartAdjustment, error) {
+	validated := *cart
+	validated.Items = []CartItem{}
+	var adjustments []CartAdjustment
+
+	for _, item := range cart.Items {
+		product, err := products.GetByID(ctx, item.ProductID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, nil, fmt.Errorf("error validating cart line %s: %w", item.Key(), err)
+		}
+		var variant *Variant
+		if err == nil && !product.Archived {
+			variant, err = product.resolveVariant(item.VariantID)
+		}
+		price, ok := product.PriceIn(cart.Currency)
+		if variant != nil {
+			price, ok = product.VariantPriceIn(*variant, cart.Currency)
+		}
+		if err != nil || product.Archived || !ok {
+			adjustments = append(adjustments, CartAdjustment{Item: item, Issue: CartIssueUnavailable})
+			continue
+		}
+
+		line := item
+		line.ProductName = product.Name
+		line.TaxClass = product.EffectiveTaxClass()
+		line.Weight = product.Weight
+		if variant != nil {
+			line.SKU = variant.SKU
+			line.VariantTitle = product.VariantTitle(*variant)
+		}
+		stock, tracked := product.Stock, product.TrackInventory
+		if variant != nil {
+			stock, tracked = variant.Stock, variant.TrackInventory
+		}
+		if tracked && line.Quantity > stock {
+			line.Quantity = max(stock, 0)
+			adjustments = append(adjustments, CartAdjustment{Item: item, Issue: CartIssueOutOfStock, Quantity: line.Quantity})
+			if line.Quantity == 0 {
+				continue
+			}
+		}
+		if price != item.UnitPrice {
+			line.UnitPrice = price
+			adjustments = append(adjustments, CartAdjustment{Item: item, Issue: CartIssuePriceChanged, Quantity: line.Quantity, NewPrice: price})
+		}
+		validated.Items = append(validated.Items, line)
+	}
+
+	return &validated, adjustments, nil
+}
@@ -0,0 +1,204 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SQLCartRepository is a CartRepository backed by the carts and cart_items tables.
+type SQLCartRepository struct {
+	sqlRepository
+}
+
+// ownerColumn returns the carts column and value that identify the key's cart.
+func ownerColumn(key CartKey) (string, string) {
+	if key.CustomerID != "" {
+		return "customer_id", key.CustomerID
+	}
+	return "session_id", key.SessionID
+}
+
+// Get returns the cart owned by key.
+func (r *SQLCartRepository) Get(ctx context.Context, key CartKey) (*Cart, error) {
+	column, owner := ownerColumn(key)
+	cart := &Cart{}
+	addr := &cart.ShippingAddress
+	err := r.conn.QueryRowContext(ctx, r.q("SELECT id, currency, name, line1, line2, city, state, postal_code, country, shipping_method, created_at, updated_at FROM carts WHERE "+column+" = ?"), owner).
+		Scan(&cart.ID, &cart.Currency, &addr.Name, &addr.Line1, &addr.Line2, &addr.City, &addr.State, &addr.PostalCode, &addr.Country, &cart.ShippingMethod, &cart.CreatedAt, &cart.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("cart of %s: %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching cart of %s: %w", key, err)
+	}
+
+	rows, err := r.conn.QueryContext(ctx, r.q("SELECT product_id, product_name, variant_id, sku, variant_title, image_url, tax_class, weight, quantity, unit_price FROM cart_items WHERE cart_id = ? ORDER BY position"), cart.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching items of cart %s: %w", cart.ID, err)
+	}
+	defer rows.Close()
+
+	cart.Items = []CartItem{}
+	for rows.Next() {
+		var item CartItem
+		if err := rows.Scan(&item.ProductID, &item.ProductName, &item.VariantID, &item.SKU, &item.VariantTitle, &item.ImageURL, &item.TaxClass, &item.Weight, &item.Quantity, &item.UnitPrice.Amount); err != nil {
+			return nil, fmt.Errorf("error scanning item row of cart %s: %w", cart.ID, err)
+		}
+		// Items are priced in the cart's currency
+		item.UnitPrice.Currency = cart.Currency
+		cart.Items = append(cart.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating through item rows of cart %s: %w", cart.ID, err)
+	}
+
+	rows, err = r.conn.QueryContext(ctx, r.q("SELECT code FROM cart_coupons WHERE cart_id = ? ORDER BY position"), cart.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching coupons of cart %s: %w", cart.ID, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, fmt.Errorf("error scanning coupon row of cart %s: %w", cart.ID, err)
+		}
+		cart.CouponCodes = append(cart.CouponCodes, code)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating through coupon rows of cart %s: %w", cart.ID, err)
+	}
+
+	return cart, nil
+}
+
+// Save replaces the cart owned by key, with its items and coupons, with cart.
+func (r *SQLCartRepository) Save(ctx context.Context, key CartKey, cart *Cart) error {
+	column, owner := ownerColumn(key)
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if commit fails
+
+	// The owner may have had a different cart, e.g. before a currency change
+	if err := r.deleteCart(ctx, tx, column, owner); err != nil {
+		return err
+	}
+	if err := r.deleteLines(ctx, tx, cart.ID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	addr := cart.ShippingAddress
+	_, err = tx.ExecContext(ctx,
+		r.q("INSERT INTO carts (id, "+column+", currency, name, line1, line2, city, state, postal_code, country, shipping_method, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) "+
+			"ON CONFLICT(id) DO UPDATE SET session_id = excluded.session_id, customer_id = excluded.customer_id, currency = excluded.currency, name = excluded.name, line1 = excluded.line1, line2 = excluded.line2, city = excluded.city, "+
+			"state = excluded.state, postal_code = excluded.postal_code, country = excluded.country, shipping_method = excluded.shipping_method, updated_at = excluded.updated_at"),
+		cart.ID, owner, cart.Currency, addr.Name, addr.Line1, addr.Line2, addr.City, addr.State, addr.PostalCode, addr.Country, cart.ShippingMethod, cart.CreatedAt, now,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving cart of %s: %w", key, err)
+	}
+	for i, item := range cart.Items {
+		_, err := tx.ExecContext(ctx,
+			r.q("INSERT INTO cart_items (cart_id, position, product_id, product_name, variant_id, sku, variant_title, image_url, tax_class, weight, quantity, unit_price) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+			cart.ID, i, item.ProductID, item.ProductName, item.VariantID, item.SKU, item.VariantTitle, item.ImageURL, item.TaxClass, item.Weight, item.Quantity, item.UnitPrice.Amount,
+		)
+		if err != nil {
+			return fmt.Errorf("error saving item of cart %s: %w", cart.ID, err)
+		}
+	}
+	for i, code := range cart.CouponCodes {
+		_, err := tx.ExecContext(ctx, r.q("INSERT INTO cart_coupons (cart_id, position, code) VALUES (?, ?, ?)"), cart.ID, i, code)
+		if err != nil {
+			return fmt.Errorf("error saving coupon of cart %s: %w", cart.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	cart.UpdatedAt = now
+	return nil
+}
+
+// Delete removes the cart owned by key, if any.
+func (r *SQLCartRepository) Delete(ctx context.Context, key CartKey) error {
+	column, owner := ownerColumn(key)
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if commit fails
+
+	if err := r.deleteCart(ctx, tx, column, owner); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// Prune deletes the carts last saved before the cutoff and returns how many
+// were deleted.
+func (r *SQLCartRepository) Prune(ctx context.Context, before time.Time) (int, error) {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if commit fails
+
+	for _, table := range []string{"cart_items", "cart_coupons"} {
+		_, err = tx.ExecContext(ctx, r.q("DELETE FROM "+table+" WHERE cart_id IN (SELECT id FROM carts WHERE updated_at < ?)"), before)
+		if err != nil {
+			return 0, fmt.Errorf("error deleting %s of abandoned carts: %w", table, err)
+		}
+	}
+	res, err := tx.ExecContext(ctx, r.q("DELETE FROM carts WHERE updated_at < ?"), before)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting abandoned carts: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error counting deleted carts: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return int(n), nil
+}
+
+// deleteCart deletes the cart, with its items and coupons, whose owner
+// column has the given value.
+func (r *SQLCartRepository) deleteCart(ctx context.Context, tx *sql.Tx, column, owner string) error {
+	var id string
+	err := tx.QueryRowContext(ctx, r.q("SELECT id FROM carts WHERE "+column+" = ?"), owner).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error fetching cart: %w", err)
+	}
+	if err := r.deleteLines(ctx, tx, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, r.q("DELETE FROM carts WHERE id = ?"), id); err != nil {
+		return fmt.Errorf("error deleting cart %s: %w", id, err)
+	}
+	return nil
+}
+
+// deleteLines deletes the items and coupons of a cart.
+func (r *SQLCartRepository) deleteLines(ctx context.Context, tx *sql.Tx, cartID string) error {
+	if _, err := tx.ExecContext(ctx, r.q("DELETE FROM cart_items WHERE cart_id = ?"), cartID); err != nil {
+		return fmt.Errorf("error deleting items of cart %s: %w", cartID, err)
+	}
+	if _, err := tx.ExecContext(ctx, r.q("DELETE FROM cart_coupons WHERE cart_id = ?"), cartID); err != nil {
+		return fmt.Errorf("error deleting coupons of cart %s: %w", cartID, err)
+	}
+	return nil
+}
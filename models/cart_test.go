@@ -0,0 +1,337 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMergeCarts(t *testing.T) {
+	ctx := context.Background()
+	guestKey := CartKey{SessionID: "sess-1"}
+	customerKey := CartKey{SessionID: "sess-1", CustomerID: "cust-1"}
+	products := []Product{
+		{ID: "tee", Name: "Tee", Price: Money{1000, "USD"}, Stock: 5, TrackInventory: true},
+		{ID: "mug", Name: "Mug", Price: Money{1500, "USD"}},
+		{ID: "cap", Name: "Cap", Price: Money{800, "USD"}, Stock: 10, TrackInventory: true},
+		{ID: "old", Name: "Old", Price: Money{500, "USD"}, Archived: true},
+	}
+	tests := []struct {
+		name     string
+		customer map[string]int // the customer's saved cart, nil for none
+		guest    map[string]int
+		want     map[string]int
+	}{
+		{
+			name:     "quantities added up",
+			customer: map[string]int{"tee": 1, "mug": 2},
+			guest:    map[string]int{"tee": 2, "cap": 1},
+			want:     map[string]int{"tee": 3, "mug": 2, "cap": 1},
+		},
+		{
+			name:     "cut down to the stock",
+			customer: map[string]int{"tee": 4},
+			guest:    map[string]int{"tee": 3},
+			want:     map[string]int{"tee": 5},
+		},
+		{
+			name:     "cut down to the quantity limit",
+			customer: map[string]int{"mug": 60},
+			guest:    map[string]int{"mug": 60},
+			want:     map[string]int{"mug": MaxItemQuantity},
+		},
+		{
+			name:     "products no longer sold dropped",
+			customer: map[string]int{"mug": 1},
+			guest:    map[string]int{"old": 1, "tee": 1},
+			want:     map[string]int{"mug": 1, "tee": 1},
+		},
+		{
+			name:  "no saved cart",
+			guest: map[string]int{"tee": 2, "mug": 1},
+			want:  map[string]int{"tee": 2, "mug": 1},
+		},
+	}
+	for _, tt := range tests {
+		for name, store := range testStores(t, withCatalog(nil, products)) {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				saveCart := func(key CartKey, quantities map[string]int, coupon string) {
+					t.Helper()
+					cart := NewCart("USD")
+					for _, p := range products {
+						if quantities[p.ID] > 0 {
+							if err := cart.AddItem(p, "", quantities[p.ID]); err != nil {
+								t.Fatal(err)
+							}
+						}
+					}
+					cart.CouponCodes = []string{coupon}
+					if err := store.Carts.Save(ctx, key, cart); err != nil {
+						t.Fatal(err)
+					}
+				}
+				wantCoupons := []string{"GUEST"}
+				if tt.customer != nil {
+					saveCart(customerKey, tt.customer, "SAVED")
+					wantCoupons = []string{"SAVED", "GUEST"}
+				}
+				saveCart(guestKey, tt.guest, "GUEST")
+
+				if _, err := MergeCarts(ctx, store.Products, store.Carts, guestKey, customerKey); err != nil {
+					t.Fatal(err)
+				}
+
+				merged, err := store.Carts.Get(ctx, customerKey)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := map[string]int{}
+				for _, item := range merged.Items {
+					got[item.Key()] = item.Quantity
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("merged cart = %v, want %v", got, tt.want)
+				}
+				if !reflect.DeepEqual(merged.CouponCodes, wantCoupons) {
+					t.Errorf("coupons = %v, want %v", merged.CouponCodes, wantCoupons)
+				}
+				if _, err := store.Carts.Get(ctx, guestKey); !errors.Is(err, ErrNotFound) {
+					t.Errorf("anonymous cart still there: %v", err)
+				}
+
+				// Merging again finds nothing to merge
+				again, err := MergeCarts(ctx, store.Products, store.Carts, guestKey, customerKey)
+				if err != nil || again != nil {
+					t.Errorf("merging again = %v, %v; want nothing merged", again, err)
+				}
+			})
+		}
+	}
+}
+
+func TestValidateCart(t *testing.T) {
+	ctx := context.Background()
+	// What the catalog says now; the cart was filled before these changes
+	catalog := withCatalog(nil, []Product{
+		{ID: "tee", Name: "Tee", Price: Money{1200, "USD"}},
+		{ID: "mug", Name: "Mug", Price: Money{1500, "USD"}, Stock: 1, TrackInventory: true},
+		{ID: "cap", Name: "Cap", Price: Money{800, "USD"}, Archived: true},
+		{ID: "scarf", Name: "Scarf", Price: Money{2000, "EUR"}},
+		{ID: "hoodie", Name: "Hoodie", Price: Money{4000, "USD"}, Options: []string{"Size"},
+			Variants: []Variant{{ID: "hood-m", SKU: "HOOD-M2", Options: map[string]string{"Size": "M"}, Stock: 0, TrackInventory: true},
+				{ID: "hood-l", SKU: "HOOD-L", Options: map[string]string{"Size": "L"}, Prices: map[string]Money{"USD": {4500, "USD"}}}}},
+		{ID: "pen", Name: "Pen", Price: Money{300, "USD"}},
+	})
+	cart := NewCart("USD")
+	cart.Items = []CartItem{
+		{ProductID: "tee", ProductName: "Tee", Quantity: 2, UnitPrice: Money{1000, "USD"}},
+		{ProductID: "mug", ProductName: "Mug", Quantity: 3, UnitPrice: Money{1500, "USD"}},
+		{ProductID: "cap", ProductName: "Cap", Quantity: 1, UnitPrice: Money{800, "USD"}},
+		{ProductID: "sock", ProductName: "Sock", Quantity: 1, UnitPrice: Money{500, "USD"}}, // deleted since
+		{ProductID: "scarf", ProductName: "Scarf", Quantity: 1, UnitPrice: Money{2000, "USD"}},
+		{ProductID: "hoodie", ProductName: "Hoodie", VariantID: "hood-m", SKU: "HOOD-M", Quantity: 1, UnitPrice: Money{4000, "USD"}},
+		{ProductID: "hoodie", ProductName: "Hoodie", VariantID: "hood-l", SKU: "HOOD-L", Quantity: 1, UnitPrice: Money{4000, "USD"}},
+		{ProductID: "pen", ProductName: "Pen", Quantity: 4, UnitPrice: Money{300, "USD"}},
+	}
+	wantAdjustments := map[string]CartAdjustment{ // by line key, without the line
+		"tee":    {Issue: CartIssuePriceChanged, Quantity: 2, NewPrice: Money{1200, "USD"}},
+		"mug":    {Issue: CartIssueOutOfStock, Quantity: 1},
+		"cap":    {Issue: CartIssueUnavailable},
+		"sock":   {Issue: CartIssueUnavailable},
+		"scarf":  {Issue: CartIssueUnavailable}, // no price in dollars
+		"hood-m": {Issue: CartIssueOutOfStock},
+		"hood-l": {Issue: CartIssuePriceChanged, Quantity: 1, NewPrice: Money{4500, "USD"}},
+	}
+	wantLines := map[string]CartItem{
+		"tee":    {ProductID: "tee", ProductName: "Tee", Quantity: 2, UnitPrice: Money{1200, "USD"}, TaxClass: TaxClassStandard},
+		"mug":    {ProductID: "mug", ProductName: "Mug", Quantity: 1, UnitPrice: Money{1500, "USD"}, TaxClass: TaxClassStandard},
+		"hood-l": {ProductID: "hoodie", ProductName: "Hoodie", VariantID: "hood-l", SKU: "HOOD-L", VariantTitle: "L", Quantity: 1, UnitPrice: Money{4500, "USD"}, TaxClass: TaxClassStandard},
+		"pen":    {ProductID: "pen", ProductName: "Pen", Quantity: 4, UnitPrice: Money{300, "USD"}, TaxClass: TaxClassStandard},
+	}
+
+	for name, store := range testStores(t, catalog) {
+		t.Run(name, func(t *testing.T) {
+			validated, adjustments, err := ValidateCart(ctx, store.Products, cart)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := make(map[string]CartItem)
+			for _, item := range validated.Items {
+				lines[item.Key()] = item
+			}
+			if !reflect.DeepEqual(lines, wantLines) {
+				t.Errorf("validated lines = %+v, want %+v", lines, wantLines)
+			}
+			got := make(map[string]CartAdjustment)
+			for _, a := range adjustments {
+				key := a.Item.Key()
+				a.Item = CartItem{}
+				got[key] = a
+			}
+			if !reflect.DeepEqual(got, wantAdjustments) {
+				t.Errorf("adjustments = %+v, want %+v", got, wantAdjustments)
+			}
+			if len(cart.Items) != 8 || cart.Items[0].UnitPrice.Amount != 1000 {
+				t.Error("ValidateCart changed the cart it was given")
+			}
+		})
+	}
+}
+
+func TestCartToOrder(t *testing.T) {
+	for _, inclusive := range []bool{false, true} {
+		cart := NewCart("EUR")
+		cart.Items = []CartItem{
+			{ProductID: "tee", ProductName: "Tee", TaxClass: TaxClassStandard, Quantity: 2, UnitPrice: Money{1000, "EUR"}},
+			{ProductID: "hoodie", ProductName: "Hoodie", VariantID: "hood-m", SKU: "HOOD-M", VariantTitle: "M", TaxClass: TaxClassStandard,
+				Quantity: 1, UnitPrice: Money{4000, "EUR"}},
+		}
+		cart.Discounts = []Discount{{Code: "TEN", Amount: Money{1000, "EUR"}}}
+		cart.ShippingAddress = Address{Name: "Ann", Line1: "1 Hauptstr.", City: "Berlin", PostalCode: "10115", Country: "DE"}
+		cart.Shipping = &ShippingRate{Method: "standard", Name: "Standard", Amount: Money{500, "EUR"}}
+		vat := func(amount int64) []TaxLine {
+			return []TaxLine{{Name: "VAT", Rate: 19000, Amount: Money{amount, "EUR"}}}
+		}
+		cart.Tax = &TaxBreakdown{Inclusive: inclusive, Items: [][]TaxLine{vat(380), vat(760)}}
+
+		order := cart.ToOrder("ann@example.com")
+		if order.Status != OrderStatusPending || order.CustomerEmail != "ann@example.com" || order.Currency() != "EUR" {
+			t.Errorf("order is %s for %q in %s, want pending for ann in EUR", order.Status, order.CustomerEmail, order.Currency())
+		}
+		if order.ShippingAddress != cart.ShippingAddress || order.Shipping != *cart.Shipping || order.TaxInclusive != inclusive {
+			t.Errorf("order ships %+v by %+v, tax inclusive %v", order.ShippingAddress, order.Shipping, order.TaxInclusive)
+		}
+		want := []OrderItem{
+			{ProductID: "tee", ProductName: "Tee", TaxClass: TaxClassStandard, Quantity: 2, UnitPrice: Money{1000, "EUR"}, Taxes: vat(380)},
+			{ProductID: "hoodie", ProductName: "Hoodie", VariantID: "hood-m", SKU: "HOOD-M", VariantTitle: "M", TaxClass: TaxClassStandard,
+				Quantity: 1, UnitPrice: Money{4000, "EUR"}, Taxes: vat(760)},
+		}
+		if !reflect.DeepEqual(order.Items, want) {
+			t.Errorf("order items = %+v, want %+v", order.Items, want)
+		}
+		if !reflect.DeepEqual(order.Discounts, cart.Discounts) {
+			t.Errorf("order discounts = %+v, want the cart's", order.Discounts)
+		}
+		// The order charges what the cart showed: 60.00 - 10.00 + 5.00, plus
+		// 11.40 of tax unless the prices include it
+		wantTotal := int64(5500)
+		if !inclusive {
+			wantTotal += 1140
+		}
+		if order.TotalAmount != (Money{wantTotal, "EUR"}) || cart.Totals().Total != order.TotalAmount {
+			t.Errorf("inclusive %v: order total %s, cart total %s; want %d", inclusive, order.TotalAmount, cart.Totals().Total, wantTotal)
+		}
+	}
+}
+
+func TestValidateQuantity(t *testing.T) {
+	for quantity, valid := range map[int]bool{-1: false, 0: false, 1: true, MaxItemQuantity: true, MaxItemQuantity + 1: false} {
+		if err := ValidateQuantity(quantity); (err == nil) != valid {
+			t.Errorf("ValidateQuantity(%d) = %v, want valid: %v", quantity, err, valid)
+		}
+	}
+}
+
+func TestCartRepository(t *testing.T) {
+	ctx := context.Background()
+	guestKey := CartKey{SessionID: "sess-1"}
+	customerKey := CartKey{SessionID: "sess-1", CustomerID: "cust-1"}
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			tee := Product{ID: "tee", Name: "Tee", Price: Money{1000, "USD"}, Options: []string{"Size"},
+				Variants: []Variant{{ID: "tee-m", SKU: "TEE-M", Options: map[string]string{"Size": "M"}}}}
+			mug := Product{ID: "mug", Name: "Mug", Price: Money{1500, "USD"}, Weight: 350}
+			cart := NewCart("USD")
+			if err := cart.AddItem(tee, "tee-m", 2); err != nil {
+				t.Fatal(err)
+			}
+			if err := cart.AddItem(mug, "", 1); err != nil {
+				t.Fatal(err)
+			}
+			cart.CouponCodes = []string{"TEN"}
+			cart.ShippingAddress = Address{Name: "Ann", Line1: "1 Main St", City: "Springfield", PostalCode: "12345", Country: "US"}
+			cart.ShippingMethod = "standard"
+			if err := store.Carts.Save(ctx, guestKey, cart); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := store.Carts.Get(ctx, guestKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != cart.ID || got.Currency != "USD" || !reflect.DeepEqual(got.Items, cart.Items) ||
+				!reflect.DeepEqual(got.CouponCodes, cart.CouponCodes) || got.ShippingAddress != cart.ShippingAddress || got.ShippingMethod != "standard" {
+				t.Errorf("saved cart came back as %+v, want %+v", got, cart)
+			}
+
+			// Changing a quantity, as /cart/update/:id does
+			if err := got.SetItemQuantity("tee-m", 5); err != nil {
+				t.Fatal(err)
+			}
+			if err := got.SetItemQuantity("mug", 0); err != nil {
+				t.Fatal(err)
+			}
+			if err := got.SetItemQuantity("cap", 1); !errors.Is(err, ErrNotFound) {
+				t.Errorf("SetItemQuantity of a line not in the cart = %v, want ErrNotFound", err)
+			}
+			if err := store.Carts.Save(ctx, guestKey, got); err != nil {
+				t.Fatal(err)
+			}
+			got, err = store.Carts.Get(ctx, guestKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Items) != 1 || got.Items[0].Key() != "tee-m" || got.Items[0].Quantity != 5 {
+				t.Errorf("items = %+v, want 5 of tee-m", got.Items)
+			}
+
+			// Saving the cart for the customer takes it from the session
+			if err := store.Carts.Save(ctx, customerKey, got); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Carts.Get(ctx, guestKey); !errors.Is(err, ErrNotFound) {
+				t.Errorf("session still has the cart: %v", err)
+			}
+			if err := store.Carts.Delete(ctx, customerKey); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Carts.Get(ctx, customerKey); !errors.Is(err, ErrNotFound) {
+				t.Errorf("deleted cart still there: %v", err)
+			}
+		})
+	}
+}
+
+func TestCartPrune(t *testing.T) {
+	ctx := context.Background()
+	oldKey, newKey := CartKey{SessionID: "sess-old"}, CartKey{SessionID: "sess-new"}
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := store.Carts.Save(ctx, oldKey, NewCart("USD")); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+			cutoff := time.Now()
+			time.Sleep(10 * time.Millisecond)
+			if err := store.Carts.Save(ctx, newKey, NewCart("USD")); err != nil {
+				t.Fatal(err)
+			}
+
+			pruned, err := store.Carts.Prune(ctx, cutoff)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pruned != 1 {
+				t.Errorf("pruned %d carts, want 1", pruned)
+			}
+			if _, err := store.Carts.Get(ctx, oldKey); !errors.Is(err, ErrNotFound) {
+				t.Errorf("cart saved before the cutoff still there: %v", err)
+			}
+			if _, err := store.Carts.Get(ctx, newKey); err != nil {
+				t.Errorf("cart saved after the cutoff: %v", err)
+			}
+		})
+	}
+}
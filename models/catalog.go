@@ -0,0 +1,468 @@
+package models
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CatalogFormat is a file format the catalog is imported from and exported to.
+type CatalogFormat string
+
+const (
+	// CatalogCSV has one row per variant, or per product without variants,
+	// with the product's columns repeated on each of its rows
+	CatalogCSV CatalogFormat = "csv"
+	// CatalogJSON is an array of products with their variants nested
+	CatalogJSON CatalogFormat = "json"
+)
+
+// ParseCatalogFormat returns the format named s ("csv" or "json"), or the
+// format given by the extension if s is a file name.
+func ParseCatalogFormat(s string) (CatalogFormat, error) {
+	name := strings.ToLower(strings.TrimPrefix(filepath.Ext(s), "."))
+	if name == "" {
+		name = strings.ToLower(s)
+	}
+	switch format := CatalogFormat(name); format {
+	case CatalogCSV, CatalogJSON:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown catalog format %q (use csv or json)", s)
+}
+
+// ContentType is the MIME type of files in the format.
+func (f CatalogFormat) ContentType() string {
+	if f == CatalogJSON {
+		return "application/json"
+	}
+	return "text/csv"
+}
+
+// catalogListSeparator separates the categories, tags and variant options
+// packed into a single CSV cell.
+const catalogListSeparator = "|"
+
+// catalogRecord is a product as it appears in catalog files. Prices are
+// decimal strings such as "29.99" and categories are referred to by slug, so
+// that the files are easy to edit in a spreadsheet.
+type catalogRecord struct {
+	ID             string            `json:"id"`
+	Name           string            `json:"name"`
+	Description    string            `json:"description,omitempty"`
+	ImageURL       string            `json:"image_url,omitempty"`
+	Prices         map[string]string `json:"prices"`
+	Stock          int               `json:"stock,omitempty"`
+	TrackInventory bool              `json:"track_inventory,omitempty"`
+	TaxClass       string            `json:"tax_class,omitempty"`
+	Weight         int               `json:"weight,omitempty"` // grams
+	Categories     []string          `json:"categories,omitempty"`
+	Tags           []string          `json:"tags,omitempty"`
+	Archived       bool              `json:"archived,omitempty"`
+	Options        []string          `json:"options,omitempty"`
+	Variants       []catalogVariant  `json:"variants,omitempty"`
+
+	row     int             // where the record starts in the file, for error messages
+	columns map[string]bool // the CSV columns present; nil if every field is
+}
+
+// has reports whether the record sets the field in the named CSV column.
+// Fields whose column is missing from a CSV file keep their current values.
+func (rec catalogRecord) has(column string) bool {
+	return rec.columns == nil || rec.columns[column]
+}
+
+// hasVariants reports whether the record lists the product's variants.
+func (rec catalogRecord) hasVariants() bool {
+	return rec.has("sku") || rec.has("variant_id") || rec.has("options")
+}
+
+// catalogVariant is a variant as it appears in catalog files.
+type catalogVariant struct {
+	ID             string            `json:"id,omitempty"`
+	SKU            string            `json:"sku"`
+	Options        map[string]string `json:"options,omitempty"`
+	Prices         map[string]string `json:"prices,omitempty"`
+	ImageURL       string            `json:"image_url,omitempty"`
+	Stock          int               `json:"stock,omitempty"`
+	TrackInventory bool              `json:"track_inventory,omitempty"`
+
+	row int
+}
+
+// newCatalogRecord converts a product to its catalog file form. slugs maps
+// category IDs to slugs.
+func newCatalogRecord(p Product, slugs map[string]string) catalogRecord {
+	rec := catalogRecord{
+		ID:             p.ID,
+		Name:           p.Name,
+		Description:    p.Description,
+		ImageURL:       p.ImageURL,
+		Prices:         make(map[string]string),
+		Stock:          p.Stock,
+		TrackInventory: p.TrackInventory,
+		TaxClass:       p.EffectiveTaxClass(),
+		Weight:         p.Weight,
+		Archived:       p.Archived,
+	}
+	if len(p.Tags) > 0 {
+		rec.Tags = p.Tags
+	}
+	if len(p.Options) > 0 {
+		rec.Options = p.Options
+	}
+	if p.Price.Currency != "" && p.Price.Amount > 0 {
+		rec.Prices[p.Price.Currency] = p.Price.Decimal()
+	}
+	for currency, price := range p.Prices {
+		rec.Prices[currency] = price.Decimal()
+	}
+	for _, id := range p.CategoryIDs {
+		if slug, ok := slugs[id]; ok {
+			rec.Categories = append(rec.Categories, slug)
+		}
+	}
+	for _, v := range p.Variants {
+		cv := catalogVariant{
+			ID:             v.ID,
+			SKU:            v.SKU,
+			Options:        v.Options,
+			ImageURL:       v.ImageURL,
+			Stock:          v.Stock,
+			TrackInventory: v.TrackInventory,
+		}
+		if len(v.Prices) > 0 {
+			cv.Prices = make(map[string]string)
+			for currency, price := range v.Prices {
+				cv.Prices[currency] = price.Decimal()
+			}
+		}
+		rec.Variants = append(rec.Variants, cv)
+	}
+	return rec
+}
+
+// ExportCatalog writes every product, archived ones included, in
+// merchandising order. The output can be edited and imported again.
+func ExportCatalog(ctx context.Context, products ProductRepository, categories CategoryRepository, w io.Writer, format CatalogFormat) error {
+	all, err := products.List(ctx, ProductFilter{IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+	cats, err := categories.List(ctx)
+	if err != nil {
+		return err
+	}
+	slugs := make(map[string]string, len(cats))
+	for _, c := range cats {
+		slugs[c.ID] = c.Slug
+	}
+
+	records := make([]catalogRecord, len(all))
+	for i, p := range all {
+		records[i] = newCatalogRecord(p, slugs)
+	}
+	if format == CatalogJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	}
+	return writeCatalogCSV(w, records)
+}
+
+// catalogColumns returns the CSV header: the product columns, then the
+// variant columns, with a price column for every supported currency.
+func catalogColumns() []string {
+	cols := []string{"id", "name", "description", "image_url", "categories", "tags", "archived", "track_inventory", "stock", "tax_class", "weight"}
+	for _, currency := range SupportedCurrencies {
+		cols = append(cols, "price_"+currency)
+	}
+	cols = append(cols, "variant_id", "sku", "options", "variant_image_url", "variant_track_inventory", "variant_stock")
+	for _, currency := range SupportedCurrencies {
+		cols = append(cols, "variant_price_"+currency)
+	}
+	return cols
+}
+
+// writeCatalogCSV writes records as CSV rows.
+func writeCatalogCSV(w io.Writer, records []catalogRecord) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(catalogColumns()); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		product := []string{
+			rec.ID,
+			rec.Name,
+			rec.Description,
+			rec.ImageURL,
+			strings.Join(rec.Categories, catalogListSeparator),
+			strings.Join(rec.Tags, catalogListSeparator),
+			strconv.FormatBool(rec.Archived),
+			strconv.FormatBool(rec.TrackInventory),
+			strconv.Itoa(rec.Stock),
+			rec.TaxClass,
+			strconv.Itoa(rec.Weight),
+		}
+		for _, currency := range SupportedCurrencies {
+			product = append(product, rec.Prices[currency])
+		}
+
+		if len(rec.Variants) == 0 {
+			row := append(product, make([]string, 6+len(SupportedCurrencies))...)
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, v := range rec.Variants {
+			row := append(append([]string(nil), product...),
+				v.ID,
+				v.SKU,
+				formatVariantOptions(rec.Options, v.Options),
+				v.ImageURL,
+				strconv.FormatBool(v.TrackInventory),
+				strconv.Itoa(v.Stock),
+			)
+			for _, currency := range SupportedCurrencies {
+				row = append(row, v.Prices[currency])
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatVariantOptions packs a variant's options into one cell, e.g.
+// "Size=M|Color=Black", in the product's option order.
+func formatVariantOptions(names []string, options map[string]string) string {
+	var extra []string
+	for name := range options {
+		if !containsString(names, name) {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+
+	var pairs []string
+	for _, name := range append(append([]string(nil), names...), extra...) {
+		if value, ok := options[name]; ok {
+			pairs = append(pairs, name+"="+value)
+		}
+	}
+	return strings.Join(pairs, catalogListSeparator)
+}
+
+// readCatalog parses a catalog file into records. Problems with individual
+// rows are returned as row errors; an error is returned only if the file
+// cannot be read at all. owners maps SKUs to the IDs of the products that
+// have them, so that CSV rows without an ID are matched to their product.
+func readCatalog(r io.Reader, format CatalogFormat, owners map[string]string) ([]catalogRecord, []ImportRowError, error) {
+	if format == CatalogJSON {
+		var records []catalogRecord
+		if err := json.NewDecoder(r).Decode(&records); err != nil {
+			return nil, nil, fmt.Errorf("error reading JSON catalog: %w", err)
+		}
+		for i := range records {
+			records[i].row = i + 1
+			for j := range records[i].Variants {
+				records[i].Variants[j].row = i + 1
+			}
+		}
+		return records, nil, nil
+	}
+	return readCatalogCSV(r, owners)
+}
+
+// readCatalogCSV parses CSV rows into records, grouping the rows of each
+// product. A product's own columns are taken from its first row.
+func readCatalogCSV(r io.Reader, owners map[string]string) ([]catalogRecord, []ImportRowError, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil, errors.New("the catalog file is empty")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading CSV header: %w", err)
+	}
+
+	// Columns may come in any order, and any but id or sku may be left out
+	index := make(map[string]int, len(header))
+	columns := make(map[string]bool, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		col := ""
+		for _, known := range catalogColumns() {
+			if strings.EqualFold(known, name) {
+				col = known
+			}
+		}
+		if col == "" {
+			return nil, nil, fmt.Errorf("unknown column %q in CSV header", name)
+		}
+		index[col] = i
+		columns[col] = true
+	}
+	if !columns["id"] && !columns["sku"] {
+		return nil, nil, errors.New(`the CSV header needs an "id" or "sku" column`)
+	}
+
+	var records []catalogRecord
+	byKey := make(map[string]int) // product key to index in records
+	var rowErrs []ImportRowError
+	for {
+		fields, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading CSV: %w", err)
+		}
+		row, _ := cr.FieldPos(0)
+		get := func(col string) string {
+			if i, ok := index[col]; ok {
+				return strings.TrimSpace(fields[i])
+			}
+			return ""
+		}
+		if strings.Join(fields, "") == "" {
+			continue // blank line
+		}
+
+		id, sku := get("id"), get("sku")
+		fail := func(col, msg string) {
+			rowErrs = append(rowErrs, ImportRowError{Row: row, ProductID: id, Field: col, Message: msg})
+		}
+
+		// Rows without an ID belong to the product that has their SKU, if any
+		key := id
+		if key == "" {
+			key = owners[sku]
+		}
+		if key == "" {
+			key = "row:" + strconv.Itoa(row)
+		}
+
+		i, seen := byKey[key]
+		if !seen {
+			rec := catalogRecord{
+				ID:          id,
+				Name:        get("name"),
+				Description: get("description"),
+				ImageURL:    get("image_url"),
+				Prices:      make(map[string]string),
+				Categories:  splitCatalogList(get("categories")),
+				Tags:        splitCatalogList(get("tags")),
+				TaxClass:    strings.ToLower(get("tax_class")),
+				row:         row,
+				columns:     columns,
+			}
+			if !strings.HasPrefix(key, "row:") {
+				rec.ID = key
+			}
+			for _, currency := range SupportedCurrencies {
+				if price := get("price_" + currency); price != "" {
+					rec.Prices[currency] = price
+				}
+			}
+			if rec.Archived, err = parseCatalogBool(get("archived")); err != nil {
+				fail("archived", err.Error())
+			}
+			if rec.TrackInventory, err = parseCatalogBool(get("track_inventory")); err != nil {
+				fail("track_inventory", err.Error())
+			}
+			if rec.Stock, err = parseCatalogInt(get("stock")); err != nil {
+				fail("stock", err.Error())
+			}
+			if rec.Weight, err = parseCatalogInt(get("weight")); err != nil {
+				fail("weight", err.Error())
+			}
+			records = append(records, rec)
+			i = len(records) - 1
+			byKey[key] = i
+		}
+
+		if sku == "" && get("variant_id") == "" && get("options") == "" {
+			continue // a product without variants
+		}
+		v := catalogVariant{
+			ID:       get("variant_id"),
+			SKU:      sku,
+			Options:  make(map[string]string),
+			ImageURL: get("variant_image_url"),
+			row:      row,
+		}
+		for _, pair := range splitCatalogList(get("options")) {
+			name, value, ok := strings.Cut(pair, "=")
+			name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+			if !ok || name == "" || value == "" {
+				fail("options", fmt.Sprintf("%q is not of the form Name=Value", pair))
+				continue
+			}
+			v.Options[name] = value
+			if !containsString(records[i].Options, name) {
+				records[i].Options = append(records[i].Options, name)
+			}
+		}
+		for _, currency := range SupportedCurrencies {
+			if price := get("variant_price_" + currency); price != "" {
+				if v.Prices == nil {
+					v.Prices = make(map[string]string)
+				}
+				v.Prices[currency] = price
+			}
+		}
+		if v.TrackInventory, err = parseCatalogBool(get("variant_track_inventory")); err != nil {
+			fail("variant_track_inventory", err.Error())
+		}
+		if v.Stock, err = parseCatalogInt(get("variant_stock")); err != nil {
+			fail("variant_stock", err.Error())
+		}
+		records[i].Variants = append(records[i].Variants, v)
+	}
+	return records, rowErrs, nil
+}
+
+// splitCatalogList splits a cell holding a "|"-separated list.
+func splitCatalogList(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, catalogListSeparator) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// parseCatalogBool parses a yes/no cell; an empty cell is false.
+func parseCatalogBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "", "0", "false", "no", "n":
+		return false, nil
+	case "1", "true", "yes", "y", "x":
+		return true, nil
+	}
+	return false, fmt.Errorf("%q is not yes or no", s)
+}
+
+// parseCatalogInt parses a whole-number cell; an empty cell is zero.
+func parseCatalogInt(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a whole number", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,420 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ImportOptions controls how a catalog file is imported.
+type ImportOptions struct {
+	// DryRun works out the changes without saving any of them
+	DryRun bool
+	// Prune archives live products that are missing from the file, and
+	// deletes variants missing from their product's rows, for files holding
+	// the whole catalog. Without it such products and variants are left alone.
+	Prune bool
+}
+
+// ImportAction is what an import does to a product.
+type ImportAction string
+
+const (
+	ImportCreate ImportAction = "create"
+	ImportUpdate ImportAction = "update"
+	ImportDelete ImportAction = "delete" // products are archived rather than deleted
+)
+
+// ImportChange is one product an import creates, updates or deletes.
+type ImportChange struct {
+	Action    ImportAction `json:"action"`
+	ProductID string       `json:"product_id"`
+	Name      string       `json:"name"`
+	Fields    []string     `json:"fields,omitempty"` // the changed fields of an update
+}
+
+// ImportRowError is a problem with one row (CSV line, or JSON array element)
+// of a catalog file.
+type ImportRowError struct {
+	Row       int    `json:"row"`
+	ProductID string `json:"product_id,omitempty"`
+	Field     string `json:"field,omitempty"`
+	Message   string `json:"message"`
+}
+
+func (e ImportRowError) Error() string {
+	msg := "row " + strconv.Itoa(e.Row)
+	if e.ProductID != "" {
+		msg += " (" + e.ProductID + ")"
+	}
+	if e.Field != "" {
+		msg += ": " + e.Field
+	}
+	return msg + ": " + e.Message
+}
+
+// ImportResult describes what an import changed, or would change in a dry run.
+type ImportResult struct {
+	Creates   []ImportChange   `json:"creates"`
+	Updates   []ImportChange   `json:"updates"`
+	Deletes   []ImportChange   `json:"deletes"`
+	Unchanged int              `json:"unchanged"`
+	Errors    []ImportRowError `json:"errors"`
+	// Applied is set once the changes have been saved. Nothing is saved in a
+	// dry run or if any row is invalid.
+	Applied bool `json:"applied"`
+}
+
+// HasChanges reports whether the import creates, updates or deletes anything.
+func (r *ImportResult) HasChanges() bool {
+	return len(r.Creates)+len(r.Updates)+len(r.Deletes) > 0
+}
+
+// ImportCatalog reads products from a catalog file and upserts them: rows are
+// matched to existing products by ID, or by variant SKU when the ID is left
+// blank, and to existing variants by variant ID or SKU. Every row is checked
+// before anything is saved, so a file with any invalid row changes nothing.
+// The changes are then saved in file order in a single transaction, so that
+// if one fails, none are saved. Stock is changed by as much as the file
+// changes it, so that units reserved or sold meanwhile are kept.
+func ImportCatalog(ctx context.Context, products ProductRepository, categories CategoryRepository, r io.Reader, format CatalogFormat, opts ImportOptions) (*ImportResult, error) {
+	existing, err := products.List(ctx, ProductFilter{IncludeArchived: true})
+	if err != nil {
+		return nil, err
+	}
+	cats, err := categories.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]Product, len(existing))
+	owners := make(map[string]string) // SKU to product ID
+	for _, p := range existing {
+		byID[p.ID] = p
+		for _, v := range p.Variants {
+			owners[v.SKU] = p.ID
+		}
+	}
+	slugs := make(map[string]string, len(cats))
+	bySlug := make(map[string]string, len(cats))
+	for _, c := range cats {
+		slugs[c.ID] = c.Slug
+		bySlug[c.Slug] = c.ID
+	}
+
+	records, rowErrs, err := readCatalog(r, format, owners)
+	if err != nil {
+		return nil, err
+	}
+	result := &ImportResult{Errors: rowErrs}
+
+	var changes []ProductChange
+	imported := make(map[string]bool)
+	skuRows := make(map[string]string) // SKU to the imported product that has it
+	for _, rec := range records {
+		if rec.ID == "" {
+			rec.ID = NewProductID()
+		}
+		if imported[rec.ID] {
+			result.Errors = append(result.Errors, ImportRowError{Row: rec.row, ProductID: rec.ID, Field: "id", Message: "the product appears more than once"})
+			continue
+		}
+		imported[rec.ID] = true
+
+		old, exists := byID[rec.ID]
+		p, errs := rec.product(old, exists, bySlug, opts.Prune)
+		result.Errors = append(result.Errors, errs...)
+		for i, v := range p.Variants {
+			if other, ok := skuRows[v.SKU]; ok && v.SKU != "" {
+				result.Errors = append(result.Errors, ImportRowError{Row: rec.Variants[i].row, ProductID: rec.ID, Field: "sku", Message: fmt.Sprintf("SKU %s is also used by product %s", v.SKU, other)})
+			}
+			skuRows[v.SKU] = rec.ID
+		}
+
+		switch {
+		case !exists:
+			changes = append(changes, ProductChange{Action: ImportCreate, Product: p})
+			result.Creates = append(result.Creates, ImportChange{Action: ImportCreate, ProductID: p.ID, Name: p.Name})
+		default:
+			fields := changedFields(old, p, slugs)
+			if len(fields) == 0 {
+				result.Unchanged++
+				continue
+			}
+			changes = append(changes, ProductChange{Action: ImportUpdate, Product: p, Stock: StockChangesBetween(old, p)})
+			result.Updates = append(result.Updates, ImportChange{Action: ImportUpdate, ProductID: p.ID, Name: p.Name, Fields: fields})
+		}
+	}
+
+	// SKUs must stay unique across the products the file leaves alone too
+	for sku, id := range owners {
+		if imported[id] {
+			continue
+		}
+		if other, ok := skuRows[sku]; ok {
+			result.Errors = append(result.Errors, ImportRowError{ProductID: other, Field: "sku", Message: fmt.Sprintf("SKU %s is already used by product %s", sku, id)})
+		}
+	}
+
+	if opts.Prune {
+		for _, p := range existing {
+			if !imported[p.ID] && !p.Archived {
+				result.Deletes = append(result.Deletes, ImportChange{Action: ImportDelete, ProductID: p.ID, Name: p.Name})
+			}
+		}
+	}
+
+	sort.SliceStable(result.Errors, func(i, j int) bool { return result.Errors[i].Row < result.Errors[j].Row })
+	if opts.DryRun || len(result.Errors) > 0 {
+		return result, nil
+	}
+
+	for _, change := range result.Deletes {
+		changes = append(changes, ProductChange{Action: ImportDelete, Product: Product{ID: change.ProductID}})
+	}
+	if err := products.SaveAll(ctx, changes); err != nil {
+		return result, fmt.Errorf("error importing catalog, nothing was saved: %w", err)
+	}
+	result.Applied = true
+	return result, nil
+}
+
+// product builds the product the record describes on top of the existing
+// product (if exists is set), and validates it. Existing variants missing
+// from the record are kept unless prune is set.
+func (rec catalogRecord) product(old Product, exists bool, bySlug map[string]string, prune bool) (Product, []ImportRowError) {
+	var errs []ImportRowError
+	fail := func(row int, field, msg string) {
+		errs = append(errs, ImportRowError{Row: row, ProductID: rec.ID, Field: field, Message: msg})
+	}
+
+	p := Product{ID: rec.ID, Price: Money{Currency: DefaultCurrency}, Prices: make(map[string]Money)}
+	if exists {
+		p = copyProduct(old)
+		if p.Prices == nil {
+			p.Prices = make(map[string]Money)
+		}
+	}
+	if rec.has("name") {
+		p.Name = rec.Name
+	}
+	if rec.has("description") {
+		p.Description = rec.Description
+	}
+	if rec.has("image_url") {
+		p.ImageURL = rec.ImageURL
+	}
+	if rec.has("stock") {
+		p.Stock = rec.Stock
+	}
+	if rec.has("track_inventory") {
+		p.TrackInventory = rec.TrackInventory
+	}
+	if rec.has("tax_class") {
+		p.TaxClass = rec.TaxClass
+	}
+	if rec.has("weight") {
+		p.Weight = rec.Weight
+	}
+	if rec.has("archived") {
+		p.Archived = rec.Archived
+	}
+	if rec.has("tags") {
+		p.Tags = rec.Tags
+	}
+	if rec.has("categories") {
+		p.CategoryIDs = nil
+		for _, slug := range rec.Categories {
+			id, ok := bySlug[slug]
+			if !ok {
+				fail(rec.row, "categories", fmt.Sprintf("no category has the slug %q", slug))
+				continue
+			}
+			p.CategoryIDs = append(p.CategoryIDs, id)
+		}
+	}
+
+	prices := parseCatalogPrices(rec.Prices, func(currency, msg string) {
+		fail(rec.row, "price_"+currency, msg)
+	})
+	for _, currency := range SupportedCurrencies {
+		if !rec.has("price_" + currency) {
+			continue
+		}
+		price, ok := prices[currency]
+		if ok {
+			p.Prices[currency] = price
+		} else {
+			delete(p.Prices, currency)
+		}
+		if currency == DefaultCurrency {
+			p.Price = Money{Currency: DefaultCurrency}
+			if ok {
+				p.Price = price
+			}
+		}
+	}
+
+	if rec.hasVariants() {
+		if rec.has("options") {
+			p.Options = rec.Options
+		}
+		p.Variants = nil
+		for _, cv := range rec.Variants {
+			p.Variants = append(p.Variants, rec.variant(cv, old, p.ID, func(field, msg string) {
+				fail(cv.row, field, msg)
+			}))
+		}
+		if !prune {
+			for _, v := range copyProduct(old).Variants {
+				if _, listed := p.FindVariant(v.ID); !listed {
+					p.Variants = append(p.Variants, v)
+				}
+			}
+		}
+	}
+
+	for field, msg := range p.Validate() {
+		row := rec.row
+		if rest, ok := strings.CutPrefix(field, "variants."); ok {
+			n, sub, _ := strings.Cut(rest, ".")
+			if i, err := strconv.Atoi(n); err == nil && i < len(rec.Variants) {
+				row, field = rec.Variants[i].row, sub
+			}
+		}
+		fail(row, field, msg)
+	}
+	return p, errs
+}
+
+// variant builds a variant of the record on top of the existing variant with
+// the same ID or SKU, if old has one.
+func (rec catalogRecord) variant(cv catalogVariant, old Product, productID string, fail func(field, msg string)) Variant {
+	v := Variant{ID: cv.ID, ProductID: productID}
+	for _, existing := range copyProduct(old).Variants {
+		if cv.ID != "" && existing.ID == cv.ID || cv.ID == "" && cv.SKU != "" && existing.SKU == cv.SKU {
+			v = existing
+		}
+	}
+	if rec.has("sku") {
+		v.SKU = strings.TrimSpace(cv.SKU)
+	}
+	if v.ID == "" && v.SKU != "" {
+		v.ID = "var_" + strings.ToLower(strings.NewReplacer("-", "_", " ", "_").Replace(v.SKU))
+	}
+	if rec.has("options") {
+		v.Options = cv.Options
+	}
+	if rec.has("variant_image_url") {
+		v.ImageURL = cv.ImageURL
+	}
+	if rec.has("variant_stock") {
+		v.Stock = cv.Stock
+	}
+	if rec.has("variant_track_inventory") {
+		v.TrackInventory = cv.TrackInventory
+	}
+
+	prices := parseCatalogPrices(cv.Prices, func(currency, msg string) {
+		fail("variant_price_"+currency, msg)
+	})
+	for _, currency := range SupportedCurrencies {
+		if !rec.has("variant_price_" + currency) {
+			continue
+		}
+		if price, ok := prices[currency]; ok {
+			if v.Prices == nil {
+				v.Prices = make(map[string]Money)
+			}
+			v.Prices[currency] = price
+		} else {
+			delete(v.Prices, currency)
+		}
+	}
+	if len(v.Prices) == 0 {
+		v.Prices = nil
+	}
+	return v
+}
+
+// parseCatalogPrices parses decimal prices by currency, reporting the ones
+// that cannot be parsed to fail.
+func parseCatalogPrices(prices map[string]string, fail func(currency, msg string)) map[string]Money {
+	parsed := make(map[string]Money, len(prices))
+	for currency, s := range prices {
+		currency = strings.ToUpper(currency)
+		if !IsSupportedCurrency(currency) {
+			fail(currency, "unsupported currency")
+			continue
+		}
+		price, err := ParseMoney(s, currency)
+		if err != nil {
+			fail(currency, fmt.Sprintf("%q is not an amount such as 19.99", s))
+			continue
+		}
+		parsed[currency] = price
+	}
+	return parsed
+}
+
+// changedFields lists the catalog fields that differ between two versions of
+// a product. Categories and tags are compared as sets and variants by ID.
+func changedFields(old, updated Product, slugs map[string]string) []string {
+	a, b := newCatalogRecord(old, slugs), newCatalogRecord(updated, slugs)
+	var fields []string
+	add := func(changed bool, field string) {
+		if changed {
+			fields = append(fields, field)
+		}
+	}
+	add(a.Name != b.Name, "name")
+	add(a.Description != b.Description, "description")
+	add(a.ImageURL != b.ImageURL, "image_url")
+	add(!sameJSON(a.Prices, b.Prices), "prices")
+	add(a.Stock != b.Stock, "stock")
+	add(a.TrackInventory != b.TrackInventory, "track_inventory")
+	add(a.TaxClass != b.TaxClass, "tax_class")
+	add(a.Weight != b.Weight, "weight")
+	add(!sameSet(a.Categories, b.Categories), "categories")
+	add(!sameSet(a.Tags, b.Tags), "tags")
+	add(a.Archived != b.Archived, "archived")
+	add(!sameJSON(a.Options, b.Options), "options")
+	add(!sameJSON(variantsByID(a.Variants), variantsByID(b.Variants)), "variants")
+	return fields
+}
+
+// variantsByID keys catalog variants by ID so that their order does not matter.
+func variantsByID(variants []catalogVariant) map[string]catalogVariant {
+	byID := make(map[string]catalogVariant, len(variants))
+	for _, v := range variants {
+		if len(v.Options) == 0 {
+			v.Options = nil
+		}
+		byID[v.ID] = v
+	}
+	return byID
+}
+
+// sameJSON reports whether a and b encode to the same JSON.
+func sameJSON(a, b any) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return string(ja) == string(jb)
+}
+
+// sameSet reports whether a and b hold the same strings, ignoring order.
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, s := range a {
+		if !containsString(b, s) {
+			return false
+		}
+	}
+	return true
+}
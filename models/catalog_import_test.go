@@ -0,0 +1,228 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// importCatalog is a setup for testStores adding a mug, a hoodie in size M
+// and the "tops" category to each store.
+var importCatalog = withCatalog(
+	[]Category{{ID: "cat_tops", Slug: "tops", Name: "Tops"}},
+	[]Product{
+		{ID: "mug", Name: "Mug", Price: Money{1200, "USD"}, Stock: 5, TrackInventory: true},
+		{ID: "hoodie", Name: "Hoodie", Price: Money{4000, "USD"}, Options: []string{"Size"}, CategoryIDs: []string{"cat_tops"},
+			Variants: []Variant{{ID: "var_hood_m", SKU: "HOOD-M", Options: map[string]string{"Size": "M"}, Stock: 5, TrackInventory: true}}},
+	},
+)
+
+func TestImportCatalogErrors(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		format CatalogFormat
+		file   string
+		want   ImportRowError // without the message
+	}{
+		{
+			name: "price not an amount", format: CatalogCSV,
+			file: "id,name,price_USD\ncap,Cap,abc\n",
+			want: ImportRowError{Row: 2, ProductID: "cap", Field: "price_USD"},
+		},
+		{
+			name: "name missing", format: CatalogCSV,
+			file: "id,name,price_USD\ncap,,8.00\n",
+			want: ImportRowError{Row: 2, ProductID: "cap", Field: "name"},
+		},
+		{
+			name: "unknown category", format: CatalogCSV,
+			file: "id,name,price_USD,categories\ncap,Cap,8.00,hats\n",
+			want: ImportRowError{Row: 2, ProductID: "cap", Field: "categories"},
+		},
+		{
+			name: "stock not a number", format: CatalogCSV,
+			file: "id,name,price_USD,stock\ncap,Cap,8.00,lots\n",
+			want: ImportRowError{Row: 2, ProductID: "cap", Field: "stock"},
+		},
+		{
+			name: "variant without a SKU", format: CatalogCSV,
+			file: "id,name,price_USD,sku,options\ncap,Cap,8.00,CAP-S,Size=S\ncap,Cap,8.00,,Size=L\n",
+			want: ImportRowError{Row: 3, ProductID: "cap", Field: "sku"},
+		},
+		{
+			name: "SKU of another product", format: CatalogCSV,
+			file: "id,name,price_USD,sku,options\ncap,Cap,8.00,HOOD-M,Size=M\n",
+			want: ImportRowError{ProductID: "cap", Field: "sku"},
+		},
+		{
+			name: "product twice", format: CatalogJSON,
+			file: `[{"id": "cap", "name": "Cap", "prices": {"USD": "8.00"}}, {"id": "cap", "name": "Hat", "prices": {"USD": "9.00"}}]`,
+			want: ImportRowError{Row: 2, ProductID: "cap", Field: "id"},
+		},
+	}
+	for _, tt := range tests {
+		for name, store := range testStores(t, importCatalog) {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				result, err := ImportCatalog(ctx, store.Products, store.Categories, strings.NewReader(tt.file), tt.format, ImportOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Applied {
+					t.Error("import applied despite errors")
+				}
+				found := false
+				for _, e := range result.Errors {
+					e.Message = ""
+					found = found || e == tt.want
+				}
+				if !found {
+					t.Errorf("errors = %v, want one for %+v", result.Errors, tt.want)
+				}
+				if _, err := store.Products.GetByID(ctx, "cap"); !errors.Is(err, ErrNotFound) {
+					t.Errorf("cap was saved: %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestImportCatalogDryRun(t *testing.T) {
+	ctx := context.Background()
+	file := "id,name,price_USD,stock,track_inventory\nmug,Big Mug,12.00,8,yes\ncap,Cap,8.00,3,yes\n"
+	for name, store := range testStores(t, importCatalog) {
+		t.Run(name, func(t *testing.T) {
+			opts := ImportOptions{DryRun: true, Prune: true}
+			result, err := ImportCatalog(ctx, store.Products, store.Categories, strings.NewReader(file), CatalogCSV, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := &ImportResult{
+				Creates: []ImportChange{{Action: ImportCreate, ProductID: "cap", Name: "Cap"}},
+				Updates: []ImportChange{{Action: ImportUpdate, ProductID: "mug", Name: "Big Mug", Fields: []string{"name", "stock"}}},
+				Deletes: []ImportChange{{Action: ImportDelete, ProductID: "hoodie", Name: "Hoodie"}},
+			}
+			if !reflect.DeepEqual(result, want) {
+				t.Errorf("dry run = %+v, want %+v", result, want)
+			}
+			mug, err := store.Products.GetByID(ctx, "mug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mug.Name != "Mug" || mug.Stock != 5 {
+				t.Errorf("dry run saved the mug: %q with %d in stock", mug.Name, mug.Stock)
+			}
+
+			opts.DryRun = false
+			result, err = ImportCatalog(ctx, store.Products, store.Categories, strings.NewReader(file), CatalogCSV, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Applied {
+				t.Fatalf("import not applied: %v", result.Errors)
+			}
+			mug, err = store.Products.GetByID(ctx, "mug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mug.Name != "Big Mug" || mug.Stock != 8 {
+				t.Errorf("mug = %q with %d in stock, want %q with 8", mug.Name, mug.Stock, "Big Mug")
+			}
+			if _, err := store.Products.GetByID(ctx, "cap"); err != nil {
+				t.Errorf("cap not created: %v", err)
+			}
+			if hoodie, err := store.Products.GetByID(ctx, "hoodie"); err != nil || !hoodie.Archived {
+				t.Errorf("hoodie not archived: %v", err)
+			}
+		})
+	}
+}
+
+func TestImportCatalogVariants(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name       string
+		file       string
+		prune      bool
+		wantFields []string
+		want       map[string]int // stock by SKU
+	}{
+		{
+			name:       "stock by SKU",
+			file:       "sku,variant_stock\nHOOD-M,9\n",
+			wantFields: []string{"variants"},
+			want:       map[string]int{"HOOD-M": 9},
+		},
+		{
+			name:       "variant added",
+			file:       "id,sku,options,variant_stock,variant_track_inventory\nhoodie,HOOD-L,Size=L,3,yes\n",
+			wantFields: []string{"variants"},
+			want:       map[string]int{"HOOD-M": 5, "HOOD-L": 3},
+		},
+		{
+			name:       "variant pruned",
+			file:       "id,sku,options,variant_stock,variant_track_inventory\nhoodie,HOOD-L,Size=L,3,yes\n",
+			prune:      true,
+			wantFields: []string{"variants"},
+			want:       map[string]int{"HOOD-L": 3},
+		},
+	}
+	for _, tt := range tests {
+		for name, store := range testStores(t, importCatalog) {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				result, err := ImportCatalog(ctx, store.Products, store.Categories, strings.NewReader(tt.file), CatalogCSV, ImportOptions{Prune: tt.prune})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !result.Applied || len(result.Updates) != 1 {
+					t.Fatalf("result = %+v, want the hoodie updated", result)
+				}
+				if got := result.Updates[0]; got.ProductID != "hoodie" || !reflect.DeepEqual(got.Fields, tt.wantFields) {
+					t.Errorf("update = %+v, want hoodie with fields %v", got, tt.wantFields)
+				}
+
+				hoodie, err := store.Products.GetByID(ctx, "hoodie")
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := make(map[string]int)
+				for _, v := range hoodie.Variants {
+					got[v.SKU] = v.Stock
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("variant stock = %v, want %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestProductSaveAll(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range testStores(t, importCatalog) {
+		t.Run(name, func(t *testing.T) {
+			err := store.Products.SaveAll(ctx, []ProductChange{
+				{Action: ImportCreate, Product: Product{ID: "cap", Name: "Cap", Price: Money{800, "USD"}}},
+				{Action: ImportUpdate, Product: Product{ID: "mug", Name: "Big Mug", Price: Money{1200, "USD"}}},
+				{Action: ImportUpdate, Product: Product{ID: "missing", Name: "Missing", Price: Money{100, "USD"}}},
+			})
+			if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("err = %v, want ErrNotFound", err)
+			}
+
+			// Nothing before the failed change was saved either
+			if _, err := store.Products.GetByID(ctx, "cap"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("cap was created: %v", err)
+			}
+			mug, err := store.Products.GetByID(ctx, "mug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mug.Name != "Mug" {
+				t.Errorf("mug renamed to %q", mug.Name)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"context"
+	"sort"
+)
+
+// Category groups products for browsing. Categories form a tree through ParentID.
+type Category struct {
+	ID          string `json:"id"`
+	ParentID    string `json:"parent_id,omitempty"` // empty for top-level categories
+	Slug        string `json:"slug"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Position    int    `json:"position"`
+}
+
+// URL returns the path of the category's listing page.
+func (c Category) URL() string {
+	return "/categories/" + c.Slug
+}
+
+// CategoryPath returns the chain of categories from the root down to the
+// category with the given ID, for use as breadcrumbs.
+func CategoryPath(all []Category, id string) []Category {
+	byID := make(map[string]Category, len(all))
+	for _, c := range all {
+		byID[c.ID] = c
+	}
+
+	var path []Category
+	seen := make(map[string]bool)
+	for id != "" && !seen[id] {
+		c, ok := byID[id]
+		if !ok {
+			break
+		}
+		seen[id] = true
+		path = append([]Category{c}, path...)
+		id = c.ParentID
+	}
+	return path
+}
+
+// ChildCategories returns the direct children of parentID ("" for top-level
+// categories), ordered by position and name.
+func ChildCategories(all []Category, parentID string) []Category {
+	var children []Category
+	for _, c := range all {
+		if c.ParentID == parentID {
+			children = append(children, c)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		if children[i].Position != children[j].Position {
+			return children[i].Position < children[j].Position
+		}
+		return children[i].Name < children[j].Name
+	})
+	return children
+}
+
+// CategoryAndDescendantIDs returns id plus the IDs of every category below it,
+// so that a parent category's page also lists the products of its children.
+// Like CategoryPath, it stops at a category already seen if the parents loop.
+func CategoryAndDescendantIDs(all []Category, id string) []string {
+	ids := []string{id}
+	seen := map[string]bool{id: true}
+	for i := 0; i < len(ids); i++ {
+		for _, c := range all {
+			if c.ParentID == ids[i] && !seen[c.ID] {
+				seen[c.ID] = true
+				ids = append(ids, c.ID)
+			}
+		}
+	}
+	return ids
+}
+
+// SeedCategories inserts the initial category tree into the repository.
+func SeedCategories(ctx context.Context, categories CategoryRepository) error {
+	seed := []Category{
+		{ID: "cat_clothing", Slug: "clothing", Name: "Clothing", Description: "Everyday clothing", Position: 0},
+		{ID: "cat_tops", ParentID: "cat_clothing", Slug: "tops", Name: "Tops", Description: "T-shirts and more", Position: 0},
+		{ID: "cat_bottoms", ParentID: "cat_clothing", Slug: "bottoms", Name: "Bottoms", Description: "Jeans and trousers", Position: 1},
+		{ID: "cat_footwear", Slug: "footwear", Name: "Footwear", Description: "Shoes for every occasion", Position: 1},
+	}
+
+	for _, c := range seed {
+		if err := categories.Insert(ctx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
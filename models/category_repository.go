@@ -0,0 +1,68 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// SQLCategoryRepository is a CategoryRepository backed by the categories table.
+type SQLCategoryRepository struct {
+	sqlRepository
+}
+
+// List returns every category ordered by position and name.
+func (r *SQLCategoryRepository) List(ctx context.Context) ([]Category, error) {
+	rows, err := r.conn.QueryContext(ctx, "SELECT id, COALESCE(parent_id, ''), slug, name, description, position FROM categories ORDER BY position, name")
+	if err != nil {
+		return nil, fmt.Errorf("error fetching categories: %w", err)
+	}
+	defer rows.Close()
+
+	var categories []Category
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.ID, &c.ParentID, &c.Slug, &c.Name, &c.Description, &c.Position); err != nil {
+			return nil, fmt.Errorf("error scanning category row: %w", err)
+		}
+		categories = append(categories, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating through category rows: %w", err)
+	}
+
+	return categories, nil
+}
+
+// GetBySlug returns the category with the given slug.
+func (r *SQLCategoryRepository) GetBySlug(ctx context.Context, slug string) (Category, error) {
+	row := r.conn.QueryRowContext(ctx, r.q("SELECT id, COALESCE(parent_id, ''), slug, name, description, position FROM categories WHERE slug = ?"), slug)
+
+	var c Category
+	err := row.Scan(&c.ID, &c.ParentID, &c.Slug, &c.Name, &c.Description, &c.Position)
+	if err == sql.ErrNoRows {
+		return Category{}, fmt.Errorf("category with slug %s: %w", slug, ErrNotFound)
+	}
+	if err != nil {
+		return Category{}, fmt.Errorf("error fetching category by slug %s: %w", slug, err)
+	}
+
+	return c, nil
+}
+
+// Insert adds the category unless a category with the same ID already exists.
+func (r *SQLCategoryRepository) Insert(ctx context.Context, c Category) error {
+	var parentID any
+	if c.ParentID != "" {
+		parentID = c.ParentID
+	}
+	_, err := r.conn.ExecContext(ctx,
+		r.q("INSERT INTO categories (id, parent_id, slug, name, description, position) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT(id) DO NOTHING"),
+		c.ID, parentID, c.Slug, c.Name, c.Description, c.Position,
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting category %s: %w", c.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// categoryTree is clothing > tops > tees, clothing > bottoms, and footwear,
+// listed out of order.
+var categoryTree = []Category{
+	{ID: "tees", ParentID: "tops", Slug: "tees", Name: "Tees"},
+	{ID: "bottoms", ParentID: "clothing", Slug: "bottoms", Name: "Bottoms", Position: 1},
+	{ID: "footwear", Slug: "footwear", Name: "Footwear", Position: 1},
+	{ID: "tops", ParentID: "clothing", Slug: "tops", Name: "Tops", Position: 1},
+	{ID: "clothing", Slug: "clothing", Name: "Clothing"},
+}
+
+// categoryIDs returns the IDs of the categories, in order.
+func categoryIDs(categories []Category) []string {
+	var ids []string
+	for _, c := range categories {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+func TestCategoryPath(t *testing.T) {
+	loop := []Category{{ID: "a", ParentID: "b"}, {ID: "b", ParentID: "a"}}
+	tests := []struct {
+		all  []Category
+		id   string
+		want []string
+	}{
+		{categoryTree, "tees", []string{"clothing", "tops", "tees"}},
+		{categoryTree, "footwear", []string{"footwear"}},
+		{categoryTree, "missing", nil},
+		{categoryTree, "", nil},
+		{[]Category{{ID: "orphan", ParentID: "deleted"}}, "orphan", []string{"orphan"}},
+		{loop, "a", []string{"b", "a"}},
+	}
+	for _, tt := range tests {
+		if got := categoryIDs(CategoryPath(tt.all, tt.id)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("CategoryPath(%s) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestChildCategories(t *testing.T) {
+	tests := []struct {
+		parentID string
+		want     []string
+	}{
+		{"", []string{"clothing", "footwear"}},
+		{"clothing", []string{"bottoms", "tops"}}, // same position: by name
+		{"tees", nil},
+	}
+	for _, tt := range tests {
+		if got := categoryIDs(ChildCategories(categoryTree, tt.parentID)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ChildCategories(%q) = %v, want %v", tt.parentID, got, tt.want)
+		}
+	}
+}
+
+func TestCategoryAndDescendantIDs(t *testing.T) {
+	tests := []struct {
+		all  []Category
+		id   string
+		want []string
+	}{
+		{categoryTree, "clothing", []string{"clothing", "bottoms", "tops", "tees"}},
+		{categoryTree, "tops", []string{"tops", "tees"}},
+		{categoryTree, "tees", []string{"tees"}},
+		{[]Category{{ID: "a", ParentID: "b"}, {ID: "b", ParentID: "a"}}, "a", []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		if got := CategoryAndDescendantIDs(tt.all, tt.id); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("CategoryAndDescendantIDs(%s) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestSeedCategories(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// Seeding runs on every start, so the second run changes nothing
+			for range 2 {
+				if err := SeedCategories(ctx, store.Categories); err != nil {
+					t.Fatal(err)
+				}
+			}
+			all, err := store.Categories.List(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := categoryIDs(CategoryPath(all, "cat_tops")); !reflect.DeepEqual(got, []string{"cat_clothing", "cat_tops"}) {
+				t.Errorf("path to tops = %v, want clothing, tops", got)
+			}
+			tops, err := store.Categories.GetBySlug(ctx, "tops")
+			if err != nil || tops.ParentID != "cat_clothing" {
+				t.Errorf("GetBySlug(tops) = %+v, %v", tops, err)
+			}
+			if len(all) != 4 {
+				t.Errorf("%d categories, want the 4 seeded once", len(all))
+			}
+		})
+	}
+}
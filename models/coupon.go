@@ -0,0 +1,511 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxCartCoupons is the most coupon codes a cart can hold at once.
+const MaxCartCoupons = 5
+
+// CouponKind is what a coupon takes off an order.
+type CouponKind string
+
+const (
+	// CouponPercentOff takes a percentage off the eligible items
+	CouponPercentOff CouponKind = "percent_off"
+	// CouponAmountOff takes a fixed amount off the eligible items
+	CouponAmountOff CouponKind = "amount_off"
+	// CouponFreeShipping makes shipping free
+	CouponFreeShipping CouponKind = "free_shipping"
+	// CouponBuyXGetY gives GetQuantity eligible units free for every
+	// BuyQuantity bought, the cheapest units being the free ones
+	CouponBuyXGetY CouponKind = "buy_x_get_y"
+)
+
+// CouponKinds lists the kinds of coupon in the order the admin form offers them.
+var CouponKinds = []CouponKind{CouponPercentOff, CouponAmountOff, CouponFreeShipping, CouponBuyXGetY}
+
+// Coupon is a discount code customers can apply to their cart.
+type Coupon struct {
+	Code        string     `json:"code"` // upper case, see NormalizeCouponCode
+	Description string     `json:"description"`
+	Kind        CouponKind `json:"kind"`
+	PercentOff  int        `json:"percent_off,omitempty"`  // 1 to 100, for CouponPercentOff
+	AmountOff   Money      `json:"amount_off"`             // for CouponAmountOff
+	BuyQuantity int        `json:"buy_quantity,omitempty"` // for CouponBuyXGetY
+	GetQuantity int        `json:"get_quantity,omitempty"` // for CouponBuyXGetY
+	// MinSubtotal is the cart subtotal needed for the coupon to apply; zero
+	// for no minimum. Like AmountOff, it only works for carts in its currency.
+	MinSubtotal Money     `json:"min_subtotal"`
+	StartsAt    time.Time `json:"starts_at"` // zero if valid from creation
+	EndsAt      time.Time `json:"ends_at"`   // zero if it never expires
+	MaxUses     int       `json:"max_uses"`  // 0 for unlimited
+	// MaxUsesPerCustomer limits the orders one customer email may use it on; 0 for unlimited
+	MaxUsesPerCustomer int `json:"max_uses_per_customer"`
+	// ProductIDs and CategoryIDs limit the coupon to those products and the
+	// products in those categories or their subcategories. When both are
+	// empty the coupon applies to the whole cart.
+	ProductIDs  []string  `json:"product_ids"`
+	CategoryIDs []string  `json:"category_ids"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Currency returns the currency of the coupon's amounts, or "" if it has none.
+func (c *Coupon) Currency() string {
+	if c.AmountOff.Currency != "" {
+		return c.AmountOff.Currency
+	}
+	return c.MinSubtotal.Currency
+}
+
+// Scoped reports whether the coupon only applies to some products.
+func (c *Coupon) Scoped() bool {
+	return len(c.ProductIDs) > 0 || len(c.CategoryIDs) > 0
+}
+
+// Summary describes what the coupon gives, e.g. "20% off" or "Buy 2, get 1 free".
+func (c *Coupon) Summary() string {
+	switch c.Kind {
+	case CouponPercentOff:
+		return fmt.Sprintf("%d%% off", c.PercentOff)
+	case CouponAmountOff:
+		return c.AmountOff.String() + " off"
+	case CouponFreeShipping:
+		return "Free shipping"
+	case CouponBuyXGetY:
+		return fmt.Sprintf("Buy %d, get %d free", c.BuyQuantity, c.GetQuantity)
+	}
+	return string(c.Kind)
+}
+
+// validCouponCode matches normalized coupon codes.
+var validCouponCode = regexp.MustCompile(`^[A-Z0-9][A-Z0-9_-]{2,31}$`)
+
+// NormalizeCouponCode returns the code as stored: trimmed and upper case, so
+// that customers can type it in any case.
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Validate checks the coupon before it is saved and normalizes its code. It
+// returns nil if the coupon is valid.
+func (c *Coupon) Validate() ValidationErrors {
+	errs := ValidationErrors{}
+
+	c.Code = NormalizeCouponCode(c.Code)
+	if !validCouponCode.MatchString(c.Code) {
+		errs["code"] = "Use 3 to 32 letters, digits, '-' and '_'"
+	}
+	c.Description = strings.TrimSpace(c.Description)
+	if len(c.Description) > 200 {
+		errs["description"] = "Description must be at most 200 characters"
+	}
+
+	switch c.Kind {
+	case CouponPercentOff:
+		if c.PercentOff < 1 || c.PercentOff > 100 {
+			errs["percent_off"] = "Enter a percentage between 1 and 100"
+		}
+	case CouponAmountOff:
+		if c.AmountOff.Amount <= 0 {
+			errs["amount_off"] = "Amount must be greater than zero"
+		}
+	case CouponFreeShipping:
+	case CouponBuyXGetY:
+		if c.BuyQuantity < 1 || c.BuyQuantity > MaxItemQuantity {
+			errs["buy_quantity"] = fmt.Sprintf("Enter a quantity between 1 and %d", MaxItemQuantity)
+		}
+		if c.GetQuantity < 1 || c.GetQuantity > MaxItemQuantity {
+			errs["get_quantity"] = fmt.Sprintf("Enter a quantity between 1 and %d", MaxItemQuantity)
+		}
+	default:
+		errs["kind"] = "Choose the kind of discount"
+	}
+
+	if c.MinSubtotal.Amount < 0 {
+		errs["min_subtotal"] = "Minimum order cannot be negative"
+	}
+	if c.Kind == CouponAmountOff && c.MinSubtotal.Amount > 0 && c.MinSubtotal.Currency != c.AmountOff.Currency {
+		errs["min_subtotal"] = "Minimum order must be in the same currency as the amount off"
+	}
+	if !c.StartsAt.IsZero() && !c.EndsAt.IsZero() && !c.EndsAt.After(c.StartsAt) {
+		errs["ends_at"] = "End must be after the start"
+	}
+	if c.MaxUses < 0 {
+		errs["max_uses"] = "Enter 0 for unlimited uses"
+	}
+	if c.MaxUsesPerCustomer < 0 {
+		errs["max_uses_per_customer"] = "Enter 0 for unlimited uses"
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// CreateCoupon validates and stores a new coupon.
+func CreateCoupon(ctx context.Context, coupons CouponRepository, c Coupon) error {
+	if errs := c.Validate(); errs != nil {
+		return errs
+	}
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now()
+	}
+	if err := coupons.Create(ctx, c); err != nil {
+		if errors.Is(err, ErrConflict) {
+			return ValidationErrors{"code": "A coupon with this code already exists"}
+		}
+		return err
+	}
+	return nil
+}
+
+// UpdateCoupon validates and saves changes to an existing coupon.
+func UpdateCoupon(ctx context.Context, coupons CouponRepository, c Coupon) error {
+	if errs := c.Validate(); errs != nil {
+		return errs
+	}
+	return coupons.Update(ctx, c)
+}
+
+// CouponProblem says why a coupon cannot be used on a cart.
+type CouponProblem string
+
+const (
+	// CouponUnknown means there is no active coupon with the code
+	CouponUnknown CouponProblem = "unknown"
+	// CouponNotStarted means the coupon cannot be used yet
+	CouponNotStarted CouponProblem = "not_started"
+	// CouponExpired means the coupon has ended
+	CouponExpired CouponProblem = "expired"
+	// CouponUsedUp means the coupon has been used as many times as allowed
+	CouponUsedUp CouponProblem = "used_up"
+	// CouponUsedByCustomer means the customer has used the coupon as many times as allowed
+	CouponUsedByCustomer CouponProblem = "used_by_customer"
+	// CouponBelowMinimum means the cart subtotal is below the coupon's minimum
+	CouponBelowMinimum CouponProblem = "below_minimum"
+	// CouponNoEligibleItems means nothing in the cart is discounted by the coupon
+	CouponNoEligibleItems CouponProblem = "no_eligible_items"
+	// CouponWrongCurrency means the coupon's amounts are in another currency than the cart
+	CouponWrongCurrency CouponProblem = "wrong_currency"
+	// CouponTooMany means the cart already holds MaxCartCoupons codes
+	CouponTooMany CouponProblem = "too_many"
+)
+
+// couponMessages explain each problem to the customer.
+var couponMessages = map[CouponProblem]string{
+	CouponUnknown:         "This code is not valid.",
+	CouponNotStarted:      "This code cannot be used yet.",
+	CouponExpired:         "This code has expired.",
+	CouponUsedUp:          "This code has already been used as many times as allowed.",
+	CouponUsedByCustomer:  "You have already used this code as many times as allowed.",
+	CouponBelowMinimum:    "Your order does not reach the minimum for this code yet.",
+	CouponNoEligibleItems: "This code does not apply to anything in your cart.",
+	CouponWrongCurrency:   "This code cannot be used when paying in this currency.",
+	CouponTooMany:         fmt.Sprintf("At most %d codes can be used on one order.", MaxCartCoupons),
+}
+
+// Message explains the problem to the customer, or is empty for an unknown problem.
+func (p CouponProblem) Message() string {
+	return couponMessages[p]
+}
+
+// CouponError is returned when a coupon code cannot be used.
+type CouponError struct {
+	Code    string
+	Problem CouponProblem
+}
+
+// Error describes the problem with the code.
+func (e *CouponError) Error() string {
+	return fmt.Sprintf("coupon %s: %s", e.Code, e.Problem)
+}
+
+// Message explains the problem to the customer.
+func (e *CouponError) Message() string {
+	return e.Problem.Message()
+}
+
+// Discount is what one coupon takes off a cart or order.
+type Discount struct {
+	Code         string `json:"code"`
+	Description  string `json:"description"`   // what the customer sees, e.g. "SUMMER: 20% off"
+	Amount       Money  `json:"amount"`        // taken off the items
+	FreeShipping bool   `json:"free_shipping"` // shipping is not charged
+	// ItemKeys are the keys of the lines the coupon applies to, and Amount is
+	// taken off; empty when it applies to every line
+	ItemKeys []string `json:"item_keys,omitempty"`
+}
+
+// appliesTo reports whether the discount is taken off the line with the given key.
+func (d Discount) appliesTo(key string) bool {
+	return len(d.ItemKeys) == 0 || slices.Contains(d.ItemKeys, key)
+}
+
+// TotalDiscount adds up the amounts of the discounts.
+func TotalDiscount(discounts []Discount, currency string) Money {
+	total := Money{Currency: currency}
+	for _, d := range discounts {
+		total = total.Add(d.Amount)
+	}
+	return total
+}
+
+// shareDiscounts works out what the discounts take off each line, given the
+// lines' keys and totals. Each discount is shared out over the lines it
+// applies to in proportion to what is left of them after the discounts before
+// it, rounding so that the shares add up to the discount and none is more
+// than what is left of its line.
+func shareDiscounts(keys []string, totals []int64, discounts []Discount) []int64 {
+	off := make([]int64, len(totals))
+	for _, d := range discounts {
+		var lines []int
+		var base int64
+		for i, key := range keys {
+			if d.appliesTo(key) && totals[i] > off[i] {
+				lines = append(lines, i)
+				base += totals[i] - off[i]
+			}
+		}
+		amount := min(d.Amount.Amount, base)
+		var before, shared int64 // what is left of the lines so far, and their shares
+		for _, i := range lines {
+			before += totals[i] - off[i]
+			share := amount*before/base - shared
+			shared += share
+			off[i] += share
+		}
+	}
+	return off
+}
+
+// AddCoupon adds a normalized code to the cart's coupons, if it is not already there.
+func (c *Cart) AddCoupon(code string) error {
+	code = NormalizeCouponCode(code)
+	if slices.Contains(c.CouponCodes, code) {
+		return nil
+	}
+	if len(c.CouponCodes) >= MaxCartCoupons {
+		return &CouponError{Code: code, Problem: CouponTooMany}
+	}
+	c.CouponCodes = append(c.CouponCodes, code)
+	return nil
+}
+
+// RemoveCoupon takes a code off the cart, reporting whether it was there.
+func (c *Cart) RemoveCoupon(code string) bool {
+	code = NormalizeCouponCode(code)
+	i := slices.Index(c.CouponCodes, code)
+	if i < 0 {
+		return false
+	}
+	c.CouponCodes = slices.Delete(c.CouponCodes, i, i+1)
+	return true
+}
+
+// ApplyCoupons works out what each of the cart's coupons takes off and sets
+// cart.Discounts. Codes are applied in the order they were added, and none
+// takes off more than is left of the lines it applies to. Codes that cannot be used are
+// returned with the reason and stay on the cart, so they apply once the cart
+// qualifies. The per-customer limits are only checked when customerEmail is
+// known. An error means the coupons could not be read.
+func ApplyCoupons(ctx context.Context, store *Store, cart *Cart, customerEmail string) ([]*CouponError, error) {
+	cart.Discounts = nil
+	var problems []*CouponError
+	if len(cart.CouponCodes) == 0 {
+		return nil, nil
+	}
+
+	scope := couponScope{store: store}
+	keys := make([]string, len(cart.Items))
+	totals := make([]int64, len(cart.Items))
+	for i, item := range cart.Items {
+		keys[i], totals[i] = item.Key(), item.LineTotal().Amount
+	}
+	now := time.Now()
+	for _, code := range cart.CouponCodes {
+		coupon, err := store.Coupons.GetByCode(ctx, code)
+		if errors.Is(err, ErrNotFound) {
+			problems = append(problems, &CouponError{Code: code, Problem: CouponUnknown})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error loading coupon %s: %w", code, err)
+		}
+
+		discount, problem, err := evaluateCoupon(ctx, store, &scope, coupon, cart, customerEmail, now)
+		if err != nil {
+			return nil, err
+		}
+		if problem != "" {
+			problems = append(problems, &CouponError{Code: code, Problem: problem})
+			continue
+		}
+		off := shareDiscounts(keys, totals, cart.Discounts)
+		left := int64(0)
+		for i, key := range keys {
+			if discount.appliesTo(key) {
+				left += totals[i] - off[i]
+			}
+		}
+		discount.Amount.Amount = min(discount.Amount.Amount, left)
+		cart.Discounts = append(cart.Discounts, discount)
+	}
+	return problems, nil
+}
+
+// evaluateCoupon checks that the coupon can be used on the cart and works out
+// its discount, or returns the problem that stops it.
+func evaluateCoupon(ctx context.Context, store *Store, scope *couponScope, coupon Coupon, cart *Cart, customerEmail string, now time.Time) (Discount, CouponProblem, error) {
+	switch {
+	case !coupon.Active:
+		return Discount{}, CouponUnknown, nil
+	case !coupon.StartsAt.IsZero() && now.Before(coupon.StartsAt):
+		return Discount{}, CouponNotStarted, nil
+	case !coupon.EndsAt.IsZero() && !now.Before(coupon.EndsAt):
+		return Discount{}, CouponExpired, nil
+	}
+	if coupon.Kind == CouponAmountOff && coupon.AmountOff.Currency != cart.Currency {
+		return Discount{}, CouponWrongCurrency, nil
+	}
+	if coupon.MinSubtotal.Amount > 0 {
+		if coupon.MinSubtotal.Currency != cart.Currency {
+			return Discount{}, CouponWrongCurrency, nil
+		}
+		if cart.Subtotal().Amount < coupon.MinSubtotal.Amount {
+			return Discount{}, CouponBelowMinimum, nil
+		}
+	}
+
+	if coupon.MaxUses > 0 || (coupon.MaxUsesPerCustomer > 0 && customerEmail != "") {
+		total, byCustomer, err := store.Coupons.Uses(ctx, coupon.Code, customerEmail)
+		if err != nil {
+			return Discount{}, "", fmt.Errorf("error counting uses of coupon %s: %w", coupon.Code, err)
+		}
+		if coupon.MaxUses > 0 && total >= coupon.MaxUses {
+			return Discount{}, CouponUsedUp, nil
+		}
+		if coupon.MaxUsesPerCustomer > 0 && customerEmail != "" && byCustomer >= coupon.MaxUsesPerCustomer {
+			return Discount{}, CouponUsedByCustomer, nil
+		}
+	}
+
+	var eligible []CartItem
+	for _, item := range cart.Items {
+		ok, err := scope.includes(ctx, coupon, item.ProductID)
+		if err != nil {
+			return Discount{}, "", err
+		}
+		if ok {
+			eligible = append(eligible, item)
+		}
+	}
+	if len(eligible) == 0 {
+		return Discount{}, CouponNoEligibleItems, nil
+	}
+
+	discount := Discount{
+		Code:        coupon.Code,
+		Description: coupon.Code + ": " + coupon.Summary(),
+		Amount:      Money{Currency: cart.Currency},
+	}
+	var eligibleTotal Money
+	for _, item := range eligible {
+		eligibleTotal = eligibleTotal.Add(item.LineTotal())
+		if coupon.Scoped() {
+			discount.ItemKeys = append(discount.ItemKeys, item.Key())
+		}
+	}
+	switch coupon.Kind {
+	case CouponPercentOff:
+		// Rounded to the nearest minor unit
+		discount.Amount.Amount = (eligibleTotal.Amount*int64(coupon.PercentOff) + 50) / 100
+	case CouponAmountOff:
+		discount.Amount.Amount = min(coupon.AmountOff.Amount, eligibleTotal.Amount)
+	case CouponFreeShipping:
+		discount.FreeShipping = true
+	case CouponBuyXGetY:
+		discount.Amount.Amount = buyXGetYDiscount(eligible, coupon.BuyQuantity, coupon.GetQuantity)
+		if discount.Amount.Amount == 0 {
+			return Discount{}, CouponNoEligibleItems, nil
+		}
+	}
+	return discount, "", nil
+}
+
+// buyXGetYDiscount returns the price of the units given away: the units are
+// ranked from the most to the least expensive and, in every full group of
+// buy+get units, the last get units are free.
+func buyXGetYDiscount(items []CartItem, buy, get int) int64 {
+	var prices []int64
+	for _, item := range items {
+		for range item.Quantity {
+			prices = append(prices, item.UnitPrice.Amount)
+		}
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i] > prices[j] })
+
+	var free int64
+	group := buy + get
+	for start := 0; start+group <= len(prices); start += group {
+		for _, price := range prices[start+buy : start+group] {
+			free += price
+		}
+	}
+	return free
+}
+
+// couponScope decides which products a coupon applies to, loading products
+// and the category tree only when a coupon is limited to categories.
+type couponScope struct {
+	store      *Store
+	categories []Category
+	loaded     bool
+	products   map[string][]string // category IDs by product ID
+}
+
+// includes reports whether the coupon applies to the product.
+func (s *couponScope) includes(ctx context.Context, coupon Coupon, productID string) (bool, error) {
+	if !coupon.Scoped() || slices.Contains(coupon.ProductIDs, productID) {
+		return true, nil
+	}
+	if len(coupon.CategoryIDs) == 0 {
+		return false, nil
+	}
+
+	if !s.loaded {
+		all, err := s.store.Categories.List(ctx)
+		if err != nil {
+			return false, fmt.Errorf("error loading categories: %w", err)
+		}
+		s.categories, s.loaded = all, true
+		s.products = make(map[string][]string)
+	}
+	productCategories, ok := s.products[productID]
+	if !ok {
+		product, err := s.store.Products.GetByID(ctx, productID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return false, fmt.Errorf("error loading product %s: %w", productID, err)
+		}
+		productCategories = product.CategoryIDs
+		s.products[productID] = productCategories
+	}
+
+	for _, id := range coupon.CategoryIDs {
+		for _, categoryID := range CategoryAndDescendantIDs(s.categories, id) {
+			if slices.Contains(productCategories, categoryID) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
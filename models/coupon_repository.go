@@ -0,0 +1,236 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SQLCouponRepository is a CouponRepository backed by the coupons table, with
+// uses counted from order_discounts.
+type SQLCouponRepository struct {
+	sqlRepository
+}
+
+// couponColumns are the coupons columns read by scanCoupon, in order.
+const couponColumns = "code, description, kind, percent_off, amount_off, buy_quantity, get_quantity, min_subtotal, currency, starts_at, ends_at, max_uses, max_uses_per_customer, active, created_at"
+
+// scanCoupon reads a coupons row selected with couponColumns.
+func scanCoupon(row interface{ Scan(...any) error }) (Coupon, error) {
+	var c Coupon
+	var kind, currency string
+	var startsAt, endsAt sql.NullTime
+	err := row.Scan(&c.Code, &c.Description, &kind, &c.PercentOff, &c.AmountOff.Amount, &c.BuyQuantity, &c.GetQuantity,
+		&c.MinSubtotal.Amount, &currency, &startsAt, &endsAt, &c.MaxUses, &c.MaxUsesPerCustomer, &c.Active, &c.CreatedAt)
+	if err != nil {
+		return Coupon{}, err
+	}
+	c.Kind = CouponKind(kind)
+	c.StartsAt, c.EndsAt = startsAt.Time, endsAt.Time
+	if c.AmountOff.Amount > 0 {
+		c.AmountOff.Currency = currency
+	}
+	if c.MinSubtotal.Amount > 0 {
+		c.MinSubtotal.Currency = currency
+	}
+	return c, nil
+}
+
+// List returns every coupon, newest first.
+func (r *SQLCouponRepository) List(ctx context.Context) ([]Coupon, error) {
+	rows, err := r.conn.QueryContext(ctx, "SELECT "+couponColumns+" FROM coupons ORDER BY created_at DESC, code")
+	if err != nil {
+		return nil, fmt.Errorf("error querying coupons: %w", err)
+	}
+	defer rows.Close()
+
+	var coupons []Coupon
+	for rows.Next() {
+		c, err := scanCoupon(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning coupon row: %w", err)
+		}
+		coupons = append(coupons, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating through coupon rows: %w", err)
+	}
+
+	for i := range coupons {
+		if err := r.loadScope(ctx, &coupons[i]); err != nil {
+			return nil, err
+		}
+	}
+	return coupons, nil
+}
+
+// GetByCode returns the coupon with the given code, in any case.
+func (r *SQLCouponRepository) GetByCode(ctx context.Context, code string) (Coupon, error) {
+	code = NormalizeCouponCode(code)
+	c, err := scanCoupon(r.conn.QueryRowContext(ctx, r.q("SELECT "+couponColumns+" FROM coupons WHERE code = ?"), code))
+	if err == sql.ErrNoRows {
+		return Coupon{}, fmt.Errorf("coupon %s: %w", code, ErrNotFound)
+	}
+	if err != nil {
+		return Coupon{}, fmt.Errorf("error fetching coupon %s: %w", code, err)
+	}
+	if err := r.loadScope(ctx, &c); err != nil {
+		return Coupon{}, err
+	}
+	return c, nil
+}
+
+// loadScope reads the products and categories the coupon is limited to.
+func (r *SQLCouponRepository) loadScope(ctx context.Context, c *Coupon) error {
+	var err error
+	c.ProductIDs, err = r.strings(ctx, "SELECT product_id FROM coupon_products WHERE code = ? ORDER BY product_id", c.Code)
+	if err != nil {
+		return fmt.Errorf("error fetching products of coupon %s: %w", c.Code, err)
+	}
+	c.CategoryIDs, err = r.strings(ctx, "SELECT category_id FROM coupon_categories WHERE code = ? ORDER BY category_id", c.Code)
+	if err != nil {
+		return fmt.Errorf("error fetching categories of coupon %s: %w", c.Code, err)
+	}
+	return nil
+}
+
+// strings runs a query returning one text column.
+func (r *SQLCouponRepository) strings(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := r.conn.QueryContext(ctx, r.q(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// Create inserts a new coupon, or returns an error wrapping ErrConflict if the code is taken.
+func (r *SQLCouponRepository) Create(ctx context.Context, c Coupon) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if commit fails
+
+	var exists int
+	err = tx.QueryRowContext(ctx, r.q("SELECT COUNT(*) FROM coupons WHERE code = ?"), c.Code).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("error checking coupon %s: %w", c.Code, err)
+	}
+	if exists > 0 {
+		return fmt.Errorf("coupon %s: %w", c.Code, ErrConflict)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		r.q("INSERT INTO coupons ("+couponColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		c.Code, c.Description, string(c.Kind), c.PercentOff, c.AmountOff.Amount, c.BuyQuantity, c.GetQuantity,
+		c.MinSubtotal.Amount, c.Currency(), nullTime(c.StartsAt), nullTime(c.EndsAt), c.MaxUses, c.MaxUsesPerCustomer, c.Active, c.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting coupon %s: %w", c.Code, err)
+	}
+	if err := r.saveScope(ctx, tx, c); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// Update replaces the stored coupon with the same code.
+func (r *SQLCouponRepository) Update(ctx context.Context, c Coupon) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if commit fails
+
+	res, err := tx.ExecContext(ctx,
+		r.q("UPDATE coupons SET description = ?, kind = ?, percent_off = ?, amount_off = ?, buy_quantity = ?, get_quantity = ?, min_subtotal = ?, currency = ?, starts_at = ?, ends_at = ?, max_uses = ?, max_uses_per_customer = ?, active = ? WHERE code = ?"),
+		c.Description, string(c.Kind), c.PercentOff, c.AmountOff.Amount, c.BuyQuantity, c.GetQuantity,
+		c.MinSubtotal.Amount, c.Currency(), nullTime(c.StartsAt), nullTime(c.EndsAt), c.MaxUses, c.MaxUsesPerCustomer, c.Active, c.Code,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating coupon %s: %w", c.Code, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("coupon %s: %w", c.Code, ErrNotFound)
+	}
+	for _, table := range []string{"coupon_products", "coupon_categories"} {
+		if _, err := tx.ExecContext(ctx, r.q("DELETE FROM "+table+" WHERE code = ?"), c.Code); err != nil {
+			return fmt.Errorf("error clearing %s of coupon %s: %w", table, c.Code, err)
+		}
+	}
+	if err := r.saveScope(ctx, tx, c); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// saveScope inserts the products and categories the coupon is limited to.
+func (r *SQLCouponRepository) saveScope(ctx context.Context, tx *sql.Tx, c Coupon) error {
+	for _, id := range uniqueStrings(c.ProductIDs) {
+		if _, err := tx.ExecContext(ctx, r.q("INSERT INTO coupon_products (code, product_id) VALUES (?, ?)"), c.Code, id); err != nil {
+			return fmt.Errorf("error saving product %s of coupon %s: %w", id, c.Code, err)
+		}
+	}
+	for _, id := range uniqueStrings(c.CategoryIDs) {
+		if _, err := tx.ExecContext(ctx, r.q("INSERT INTO coupon_categories (code, category_id) VALUES (?, ?)"), c.Code, id); err != nil {
+			return fmt.Errorf("error saving category %s of coupon %s: %w", id, c.Code, err)
+		}
+	}
+	return nil
+}
+
+// Uses counts the orders that used the coupon, leaving out cancelled and expired ones, in
+// total and by the customer with the given email (compared case-insensitively).
+func (r *SQLCouponRepository) Uses(ctx context.Context, code, customerEmail string) (int, int, error) {
+	var total, byCustomer int
+	err := r.conn.QueryRowContext(ctx,
+		r.q(couponUsesQuery),
+		strings.ToLower(customerEmail), NormalizeCouponCode(code), string(OrderStatusCancelled), string(OrderStatusExpired),
+	).Scan(&total, &byCustomer)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error counting uses of coupon %s: %w", code, err)
+	}
+	return total, byCustomer, nil
+}
+
+// couponUsesQuery counts the orders that used a coupon and were not cancelled
+// or expired, in total and for a customer email. Its arguments are the
+// lowercased email, the coupon code and the two statuses.
+const couponUsesQuery = "SELECT COUNT(*), COALESCE(SUM(CASE WHEN LOWER(o.customer_email) = ? THEN 1 ELSE 0 END), 0) " +
+	"FROM order_discounts d JOIN orders o ON o.id = d.order_id WHERE d.code = ? AND o.status NOT IN (?, ?)"
+
+// nullTime stores a zero time as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// uniqueStrings returns the non-empty values, without repeats, in their first order.
+func uniqueStrings(values []string) []string {
+	var unique []string
+	for _, v := range values {
+		if v != "" && !containsString(unique, v) {
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
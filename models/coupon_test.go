@@ -0,0 +1,173 @@
+package models
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuyXGetYDiscount(t *testing.T) {
+	item := func(id string, quantity int, price int64) CartItem {
+		return CartItem{ProductID: id, Quantity: quantity, UnitPrice: Money{price, "USD"}}
+	}
+	tests := []struct {
+		name     string
+		items    []CartItem
+		buy, get int
+		wantFree int64
+	}{
+		{"one full group", []CartItem{item("a", 3, 1000)}, 2, 1, 1000},
+		{"cheapest unit is free", []CartItem{item("a", 1, 3000), item("b", 1, 1000), item("c", 1, 2000)}, 2, 1, 1000},
+		{"every full group", []CartItem{item("a", 1, 500), item("b", 1, 400), item("c", 1, 300), item("d", 1, 200), item("e", 2, 100)}, 2, 1, 400},
+		{"last group incomplete", []CartItem{item("a", 4, 1000)}, 2, 1, 1000},
+		{"no full group", []CartItem{item("a", 2, 1000)}, 2, 1, 0},
+		{"buy one get one", []CartItem{item("a", 1, 100), item("b", 1, 200), item("c", 1, 300), item("d", 1, 400)}, 1, 1, 400},
+		{"several free per group", []CartItem{item("a", 5, 100)}, 3, 2, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buyXGetYDiscount(tt.items, tt.buy, tt.get); got != tt.wantFree {
+				t.Errorf("buyXGetYDiscount = %d, want %d", got, tt.wantFree)
+			}
+		})
+	}
+}
+
+func TestEvaluateCoupon(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	for _, c := range []Category{{ID: "shirts", Slug: "shirts", Name: "Shirts"}, {ID: "tees", ParentID: "shirts", Slug: "tees", Name: "Tees"}} {
+		if err := store.Categories.Insert(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range []Product{{ID: "tee", Name: "Tee", CategoryIDs: []string{"tees"}}, {ID: "mug", Name: "Mug"}} {
+		if err := store.Products.Create(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Earlier orders using LIMITED: two by ann, one of them cancelled, and one by bob
+	for _, o := range []struct {
+		email  string
+		status OrderStatus
+	}{{"ann@example.com", OrderStatusPaid}, {"ann@example.com", OrderStatusCancelled}, {"bob@example.com", OrderStatusPending}} {
+		order := NewOrder(o.email, "USD")
+		order.Status = o.status
+		order.Discounts = []Discount{{Code: "LIMITED", Amount: Money{100, "USD"}}}
+		if err := store.Orders.Save(ctx, order); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newCart := func() *Cart {
+		cart := NewCart("USD")
+		cart.Items = []CartItem{
+			{ProductID: "tee", Quantity: 2, UnitPrice: Money{1000, "USD"}},
+			{ProductID: "mug", Quantity: 1, UnitPrice: Money{3000, "USD"}},
+		}
+		return cart
+	}
+	percent := func(code string, off int) Coupon {
+		return Coupon{Code: code, Kind: CouponPercentOff, PercentOff: off, Active: true}
+	}
+	with := func(c Coupon, change func(*Coupon)) Coupon {
+		change(&c)
+		return c
+	}
+
+	tests := []struct {
+		name         string
+		coupon       Coupon
+		cart         func(*Cart) // changes the cart, if set
+		email        string
+		wantProblem  CouponProblem
+		wantAmount   int64
+		wantShipping bool
+		wantItems    []string // the lines it is taken off, if not all
+	}{
+		{name: "percent off", coupon: percent("TEN", 10), wantAmount: 500},
+		{name: "percent off rounds to the nearest cent", coupon: percent("TEN", 10),
+			cart: func(c *Cart) { c.Items = c.Items[:1]; c.Items[0].UnitPrice.Amount = 995 }, wantAmount: 199},
+		{name: "inactive", coupon: with(percent("TEN", 10), func(c *Coupon) { c.Active = false }), wantProblem: CouponUnknown},
+		{name: "not started", coupon: with(percent("TEN", 10), func(c *Coupon) { c.StartsAt = now.Add(time.Minute) }), wantProblem: CouponNotStarted},
+		{name: "started", coupon: with(percent("TEN", 10), func(c *Coupon) { c.StartsAt = now }), wantAmount: 500},
+		{name: "ended", coupon: with(percent("TEN", 10), func(c *Coupon) { c.EndsAt = now }), wantProblem: CouponExpired},
+		{name: "amount off", coupon: Coupon{Code: "FIVE", Kind: CouponAmountOff, AmountOff: Money{500, "USD"}, Active: true}, wantAmount: 500},
+		{name: "amount off is capped at the eligible items", coupon: Coupon{Code: "BIG", Kind: CouponAmountOff, AmountOff: Money{9000, "USD"}, ProductIDs: []string{"mug"}, Active: true}, wantAmount: 3000, wantItems: []string{"mug"}},
+		{name: "amount off in another currency", coupon: Coupon{Code: "FIVE", Kind: CouponAmountOff, AmountOff: Money{500, "EUR"}, Active: true}, wantProblem: CouponWrongCurrency},
+		{name: "free shipping", coupon: Coupon{Code: "SHIP", Kind: CouponFreeShipping, Active: true}, wantShipping: true},
+		{name: "below the minimum", coupon: with(percent("TEN", 10), func(c *Coupon) { c.MinSubtotal = Money{5001, "USD"} }), wantProblem: CouponBelowMinimum},
+		{name: "at the minimum", coupon: with(percent("TEN", 10), func(c *Coupon) { c.MinSubtotal = Money{5000, "USD"} }), wantAmount: 500},
+		{name: "minimum in another currency", coupon: with(percent("TEN", 10), func(c *Coupon) { c.MinSubtotal = Money{100, "EUR"} }), wantProblem: CouponWrongCurrency},
+		{name: "limited to a product", coupon: with(percent("TEN", 10), func(c *Coupon) { c.ProductIDs = []string{"mug"} }), wantAmount: 300, wantItems: []string{"mug"}},
+		{name: "limited to a parent category", coupon: with(percent("TEN", 10), func(c *Coupon) { c.CategoryIDs = []string{"shirts"} }), wantAmount: 200, wantItems: []string{"tee"}},
+		{name: "nothing eligible", coupon: with(percent("TEN", 10), func(c *Coupon) { c.ProductIDs = []string{"hat"} }), wantProblem: CouponNoEligibleItems},
+		{name: "buy 2 get 1", coupon: Coupon{Code: "B2G1", Kind: CouponBuyXGetY, BuyQuantity: 2, GetQuantity: 1, Active: true}, wantAmount: 1000},
+		{name: "buy 2 get 1 without enough units", coupon: Coupon{Code: "B2G1", Kind: CouponBuyXGetY, BuyQuantity: 2, GetQuantity: 1, ProductIDs: []string{"tee"}, Active: true}, wantProblem: CouponNoEligibleItems},
+		{name: "uses left", coupon: with(percent("LIMITED", 10), func(c *Coupon) { c.MaxUses = 3 }), wantAmount: 500},
+		{name: "used up, not counting cancelled orders", coupon: with(percent("LIMITED", 10), func(c *Coupon) { c.MaxUses = 2 }), wantProblem: CouponUsedUp},
+		{name: "used by the customer", coupon: with(percent("LIMITED", 10), func(c *Coupon) { c.MaxUsesPerCustomer = 1 }), email: "ANN@example.com", wantProblem: CouponUsedByCustomer},
+		{name: "not used by another customer", coupon: with(percent("LIMITED", 10), func(c *Coupon) { c.MaxUsesPerCustomer = 1 }), email: "cy@example.com", wantAmount: 500},
+		{name: "per-customer limit without an email", coupon: with(percent("LIMITED", 10), func(c *Coupon) { c.MaxUsesPerCustomer = 1 }), wantAmount: 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cart := newCart()
+			if tt.cart != nil {
+				tt.cart(cart)
+			}
+			scope := couponScope{store: store}
+			discount, problem, err := evaluateCoupon(ctx, store, &scope, tt.coupon, cart, tt.email, now)
+			if err != nil {
+				t.Fatalf("evaluateCoupon: %v", err)
+			}
+			if problem != tt.wantProblem {
+				t.Fatalf("problem = %q, want %q", problem, tt.wantProblem)
+			}
+			if problem != "" {
+				return
+			}
+			want := Discount{
+				Code:         tt.coupon.Code,
+				Description:  tt.coupon.Code + ": " + tt.coupon.Summary(),
+				Amount:       Money{tt.wantAmount, "USD"},
+				FreeShipping: tt.wantShipping,
+				ItemKeys:     tt.wantItems,
+			}
+			if !reflect.DeepEqual(discount, want) {
+				t.Errorf("discount = %+v, want %+v", discount, want)
+			}
+		})
+	}
+}
+
+func TestShareDiscounts(t *testing.T) {
+	keys := []string{"a", "b", "c"}
+	discount := func(amount int64, items ...string) Discount {
+		return Discount{Amount: Money{amount, "USD"}, ItemKeys: items}
+	}
+	tests := []struct {
+		name      string
+		totals    []int64
+		discounts []Discount
+		want      []int64
+	}{
+		{"none", []int64{1000, 2000, 3000}, nil, []int64{0, 0, 0}},
+		{"every line", []int64{1000, 2000, 3000}, []Discount{discount(600)}, []int64{100, 200, 300}},
+		{"rounding adds up", []int64{1000, 1000, 1000}, []Discount{discount(100)}, []int64{33, 33, 34}},
+		{"no line gets more than it costs", []int64{1, 1, 1}, []Discount{discount(2)}, []int64{0, 1, 1}},
+		{"scoped", []int64{1000, 2000, 3000}, []Discount{discount(500, "b", "c")}, []int64{0, 200, 300}},
+		{"one line", []int64{1000, 2000, 3000}, []Discount{discount(500, "a")}, []int64{500, 0, 0}},
+		{"after an earlier discount", []int64{1000, 2000, 3000}, []Discount{discount(1000, "a"), discount(500)}, []int64{1000, 200, 300}},
+		{"unknown line", []int64{1000, 2000, 3000}, []Discount{discount(500, "z")}, []int64{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shareDiscounts(keys, tt.totals, tt.discounts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("shareDiscounts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// SQLImageRepository is an ImageRepository backed by the product_images table.
+type SQLImageRepository struct {
+	sqlRepository
+}
+
+// imageColumns are the product_images columns read by scanImage.
+const imageColumns = "id, product_id, position, alt, storage_key, ext, width, height, thumb_url, medium_url, large_url"
+
+// scanImage reads the imageColumns of a row.
+func scanImage(scanner interface{ Scan(...any) error }, img *ProductImage) error {
+	return scanner.Scan(&img.ID, &img.ProductID, &img.Position, &img.Alt, &img.StorageKey, &img.Ext,
+		&img.Width, &img.Height, &img.ThumbURL, &img.MediumURL, &img.LargeURL)
+}
+
+// List returns the product's images in display order.
+func (r *SQLImageRepository) List(ctx context.Context, productID string) ([]ProductImage, error) {
+	rows, err := r.conn.QueryContext(ctx, r.q("SELECT "+imageColumns+" FROM product_images WHERE product_id = ? ORDER BY position, id"), productID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching images of product %s: %w", productID, err)
+	}
+	defer rows.Close()
+
+	var images []ProductImage
+	for rows.Next() {
+		var img ProductImage
+		if err := scanImage(rows, &img); err != nil {
+			return nil, fmt.Errorf("error scanning product image row: %w", err)
+		}
+		images = append(images, img)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating through product image rows: %w", err)
+	}
+	return images, nil
+}
+
+// Add inserts the image after the product's other images.
+func (r *SQLImageRepository) Add(ctx context.Context, img ProductImage) error {
+	_, err := r.conn.ExecContext(ctx,
+		r.q("INSERT INTO product_images ("+imageColumns+") VALUES (?, ?, (SELECT COALESCE(MAX(position) + 1, 0) FROM product_images WHERE product_id = ?), ?, ?, ?, ?, ?, ?, ?, ?)"),
+		img.ID, img.ProductID, img.ProductID, img.Alt, img.StorageKey, img.Ext, img.Width, img.Height, img.ThumbURL, img.MediumURL, img.LargeURL,
+	)
+	if err != nil {
+		return fmt.Errorf("error adding image to product %s: %w", img.ProductID, err)
+	}
+	return nil
+}
+
+// Delete removes the image row and returns it.
+func (r *SQLImageRepository) Delete(ctx context.Context, productID, id string) (ProductImage, error) {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return ProductImage{}, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if commit fails
+
+	var img ProductImage
+	err = scanImage(tx.QueryRowContext(ctx, r.q("SELECT "+imageColumns+" FROM product_images WHERE product_id = ? AND id = ?"), productID, id), &img)
+	if err == sql.ErrNoRows {
+		return ProductImage{}, fmt.Errorf("image %s of product %s: %w", id, productID, ErrNotFound)
+	}
+	if err != nil {
+		return ProductImage{}, fmt.Errorf("error fetching image %s: %w", id, err)
+	}
+	if _, err := tx.ExecContext(ctx, r.q("DELETE FROM product_images WHERE id = ?"), id); err != nil {
+		return ProductImage{}, fmt.Errorf("error deleting image %s: %w", id, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ProductImage{}, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return img, nil
+}
+
+// Reorder numbers the product's images in the order of ids.
+func (r *SQLImageRepository) Reorder(ctx context.Context, productID string, ids []string) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if commit fails
+
+	for i, id := range ids {
+		if _, err := tx.ExecContext(ctx, r.q("UPDATE product_images SET position = ? WHERE product_id = ? AND id = ?"), i, productID, id); err != nil {
+			return fmt.Errorf("error moving image %s: %w", id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package models
+
+// ReservationStatus is the state of a stock reservation held by an order
+type ReservationStatus string
+
+const (
+	// ReservationReserved means the stock is held while the customer pays
+	ReservationReserved ReservationStatus = "reserved"
+	// ReservationCommitted means the order was paid and the stock is sold
+	ReservationCommitted ReservationStatus = "committed"
+	// ReservationReleased means the checkout ended without payment and the stock was returned
+	ReservationReleased ReservationStatus = "released"
+)
+
+// StockChanges are amounts to add to the stored stock of a product, under the
+// key "", and of its variants, by variant ID. Stock is changed by an amount
+// rather than set, so that units reserved, sold or restocked while the change
+// was being made are kept.
+type StockChanges map[string]int
+
+// StockChangesBetween returns the changes that take the stock of old to that
+// of updated, for the product and the variants both versions have.
+func StockChangesBetween(old, updated Product) StockChanges {
+	changes := StockChanges{}
+	if d := updated.Stock - old.Stock; d != 0 {
+		changes[""] = d
+	}
+	for _, v := range updated.Variants {
+		if ov, ok := old.FindVariant(v.ID); ok && v.Stock != ov.Stock {
+			changes[v.ID] = v.Stock - ov.Stock
+		}
+	}
+	return changes
+}
@@ -0,0 +1,191 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// SQLInventoryRepository is an InventoryRepository backed by the products,
+// product_variants and stock_reservations tables. Stock is decremented with a
+// conditional UPDATE, so concurrent checkouts can never take more units than exist.
+type SQLInventoryRepository struct {
+	sqlRepository
+}
+
+// stockTable returns the table and key holding the stock for an item: the
+// variant's row when the item is a variant, otherwise the product's.
+func stockTable(productID, variantID string) (table, id string) {
+	if variantID != "" {
+		return "product_variants", variantID
+	}
+	return "products", productID
+}
+
+// Reserve takes stock for every item of the order in a single transaction.
+func (r *SQLInventoryRepository) Reserve(ctx context.Context, orderID string, items []OrderItem) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if commit fails
+
+	now := time.Now()
+	for _, item := range items {
+		table, id := stockTable(item.ProductID, item.VariantID)
+		res, err := tx.ExecContext(ctx,
+			r.q("UPDATE "+table+" SET stock = stock - ? WHERE id = ? AND track_inventory = TRUE AND stock >= ?"),
+			item.Quantity, id, item.Quantity,
+		)
+		if err != nil {
+			return fmt.Errorf("error reserving stock for %s: %w", item.Key(), err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return fmt.Errorf("error reserving stock for %s: %w", item.Key(), err)
+		} else if n == 0 {
+			// Either the item is untracked (always available) or it is short
+			var tracked bool
+			err := tx.QueryRowContext(ctx, r.q("SELECT track_inventory FROM "+table+" WHERE id = ?"), id).Scan(&tracked)
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("%s %s: %w", table, id, ErrNotFound)
+			}
+			if err != nil {
+				return fmt.Errorf("error checking stock for %s: %w", item.Key(), err)
+			}
+			if tracked {
+				return fmt.Errorf("%s (%s): %w", item.DisplayName(), item.Key(), ErrOutOfStock)
+			}
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx,
+			r.q("INSERT INTO stock_reservations (order_id, product_id, variant_id, quantity, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)"),
+			orderID, item.ProductID, item.VariantID, item.Quantity, string(ReservationReserved), now, now,
+		)
+		if err != nil {
+			return fmt.Errorf("error recording reservation for %s: %w", item.Key(), err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	log.Printf("Stock reserved for order %s.", orderID)
+	return nil
+}
+
+// Release returns the order's outstanding reservations to stock. Releasing an
+// order with no outstanding reservations is a no-op.
+func (r *SQLInventoryRepository) Release(ctx context.Context, orderID string) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if commit fails
+
+	type reservation struct {
+		id        int64
+		productID string
+		variantID string
+		quantity  int
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		r.q("SELECT id, product_id, variant_id, quantity FROM stock_reservations WHERE order_id = ? AND status = ?"),
+		orderID, string(ReservationReserved),
+	)
+	if err != nil {
+		return fmt.Errorf("error fetching reservations for order %s: %w", orderID, err)
+	}
+	var reservations []reservation
+	for rows.Next() {
+		var res reservation
+		if err := rows.Scan(&res.id, &res.productID, &res.variantID, &res.quantity); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning reservation row for order %s: %w", orderID, err)
+		}
+		reservations = append(reservations, res)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error after iterating through reservation rows for order %s: %w", orderID, err)
+	}
+
+	now := time.Now()
+	released := 0
+	for _, res := range reservations {
+		// Only the release that moves the reservation out of reserved gives the
+		// stock back, so concurrent releases, or a commit, cannot add it twice
+		result, err := tx.ExecContext(ctx,
+			r.q("UPDATE stock_reservations SET status = ?, updated_at = ? WHERE id = ? AND status = ?"),
+			string(ReservationReleased), now, res.id, string(ReservationReserved),
+		)
+		if err != nil {
+			return fmt.Errorf("error releasing reservation for order %s: %w", orderID, err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("error releasing reservation for order %s: %w", orderID, err)
+		}
+		if n != 1 {
+			continue
+		}
+		table, id := stockTable(res.productID, res.variantID)
+		if _, err := tx.ExecContext(ctx, r.q("UPDATE "+table+" SET stock = stock + ? WHERE id = ?"), res.quantity, id); err != nil {
+			return fmt.Errorf("error returning stock for order %s: %w", orderID, err)
+		}
+		released++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	if released > 0 {
+		log.Printf("Stock reservations released for order %s.", orderID)
+	}
+	return nil
+}
+
+// Commit marks the order's outstanding reservations as sold. Only reservations
+// still reserved are changed, so a reservation Release has given back stays
+// released.
+func (r *SQLInventoryRepository) Commit(ctx context.Context, orderID string) error {
+	_, err := r.conn.ExecContext(ctx,
+		r.q("UPDATE stock_reservations SET status = ?, updated_at = ? WHERE order_id = ? AND status = ?"),
+		string(ReservationCommitted), time.Now(), orderID, string(ReservationReserved),
+	)
+	if err != nil {
+		return fmt.Errorf("error committing reservations for order %s: %w", orderID, err)
+	}
+	return nil
+}
+
+// Restock adds the items' quantities back to the stock of their products or
+// variants, in a single transaction.
+func (r *SQLInventoryRepository) Restock(ctx context.Context, items []OrderItem) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if commit fails
+
+	for _, item := range items {
+		table, id := stockTable(item.ProductID, item.VariantID)
+		_, err := tx.ExecContext(ctx,
+			r.q("UPDATE "+table+" SET stock = stock + ? WHERE id = ? AND track_inventory = TRUE"),
+			item.Quantity, id,
+		)
+		if err != nil {
+			return fmt.Errorf("error restocking %s: %w", item.Key(), err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
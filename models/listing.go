@@ -0,0 +1,200 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidCursor is returned when a page cursor cannot be decoded.
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// ProductSort is an order the product listing can be sorted in.
+type ProductSort string
+
+const (
+	// SortFeatured lists products in the order merchandisers arranged them
+	SortFeatured ProductSort = "featured"
+	// SortNewest lists the most recently added products first
+	SortNewest ProductSort = "newest"
+	// SortPriceAsc lists the cheapest products first
+	SortPriceAsc ProductSort = "price_asc"
+	// SortPriceDesc lists the most expensive products first
+	SortPriceDesc ProductSort = "price_desc"
+	// SortName lists products alphabetically
+	SortName ProductSort = "name"
+	// SortBestSelling lists the products with the most units sold first
+	SortBestSelling ProductSort = "best_selling"
+)
+
+// ProductSorts are the sort orders offered to shoppers, in display order.
+var ProductSorts = []ProductSort{SortFeatured, SortNewest, SortBestSelling, SortPriceAsc, SortPriceDesc, SortName}
+
+// Label is the name of the sort order shown to shoppers.
+func (s ProductSort) Label() string {
+	switch s {
+	case SortPriceAsc:
+		return "Price: low to high"
+	case SortPriceDesc:
+		return "Price: high to low"
+	case SortName:
+		return "Name"
+	case SortBestSelling:
+		return "Best selling"
+	case SortNewest:
+		return "Newest"
+	default:
+		return "Featured"
+	}
+}
+
+// ParseProductSort returns the sort order named s, or SortFeatured if s names none.
+func ParseProductSort(s string) ProductSort {
+	for _, sort := range ProductSorts {
+		if string(sort) == s {
+			return sort
+		}
+	}
+	return SortFeatured
+}
+
+// PriceRange bounds a price in minor units: Min inclusive, Max exclusive.
+// A zero bound is open, so the zero PriceRange matches every price.
+type PriceRange struct {
+	Min int64 `json:"min"`
+	Max int64 `json:"max"`
+}
+
+// PriceRanges are the price buckets offered as listing facets.
+var PriceRanges = []PriceRange{{0, 5000}, {5000, 10000}, {10000, 20000}, {20000, 0}}
+
+// IsZero reports whether the range has no bounds.
+func (r PriceRange) IsZero() bool {
+	return r.Min == 0 && r.Max == 0
+}
+
+// Contains reports whether amount falls within the range.
+func (r PriceRange) Contains(amount int64) bool {
+	return amount >= r.Min && (r.Max == 0 || amount < r.Max)
+}
+
+// Key encodes the range for use in URLs, e.g. "5000-10000" or "20000-".
+func (r PriceRange) Key() string {
+	if r.IsZero() {
+		return ""
+	}
+	key := strconv.FormatInt(r.Min, 10) + "-"
+	if r.Max > 0 {
+		key += strconv.FormatInt(r.Max, 10)
+	}
+	return key
+}
+
+// ParsePriceRange decodes a range encoded by Key.
+func ParsePriceRange(key string) (PriceRange, bool) {
+	minStr, maxStr, ok := strings.Cut(key, "-")
+	if !ok {
+		return PriceRange{}, false
+	}
+	var r PriceRange
+	var err error
+	if minStr != "" {
+		if r.Min, err = strconv.ParseInt(minStr, 10, 64); err != nil || r.Min < 0 {
+			return PriceRange{}, false
+		}
+	}
+	if maxStr != "" {
+		if r.Max, err = strconv.ParseInt(maxStr, 10, 64); err != nil || r.Max <= r.Min {
+			return PriceRange{}, false
+		}
+	}
+	return r, true
+}
+
+// ProductPageRequest asks for one page of the product listing.
+type ProductPageRequest struct {
+	Filter ProductFilter
+	Sort   ProductSort
+	After  string // cursor of the previous page's last product; empty for the first page
+	Limit  int
+}
+
+// ProductPage is one page of the product listing.
+type ProductPage struct {
+	Products   []Product `json:"products"`
+	NextCursor string    `json:"next_cursor,omitempty"` // empty on the last page
+}
+
+// PriceRangeCount is the number of products within a price range.
+type PriceRangeCount struct {
+	PriceRange
+	Count int `json:"count"`
+}
+
+// ProductFacets counts the products in each facet value. Each facet's counts
+// apply the rest of the filter but not that facet's own selection, so they
+// show how many products choosing the value would give.
+type ProductFacets struct {
+	Total       int               `json:"total"`      // products matching the whole filter
+	Categories  map[string]int    `json:"categories"` // by category ID, including subcategories' products
+	PriceRanges []PriceRangeCount `json:"price_ranges"`
+	InStock     int               `json:"in_stock"`
+}
+
+// productCursor is the position of a product within a sort order: its sort
+// key (numeric or text, depending on the order) and its ID as a tie-breaker.
+type productCursor struct {
+	ID  string `json:"id"`
+	Num int64  `json:"n,omitempty"`
+	Str string `json:"s,omitempty"`
+}
+
+// encode returns the cursor as an opaque URL-safe string.
+func (c productCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeProductCursor parses a cursor produced by encode.
+func decodeProductCursor(s string) (productCursor, error) {
+	var c productCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+	if err != nil || c.ID == "" {
+		return productCursor{}, fmt.Errorf("%w: %q", ErrInvalidCursor, s)
+	}
+	return c, nil
+}
+
+// sortDescending reports whether the sort order puts larger keys first.
+func sortDescending(sort ProductSort) bool {
+	return sort == SortNewest || sort == SortPriceDesc || sort == SortBestSelling
+}
+
+// countCategoryFacets counts, for every category, the distinct products filed
+// under it or any of its subcategories, given the product IDs assigned to each
+// category.
+func countCategoryFacets(all []Category, assigned map[string][]string) map[string]int {
+	products := make(map[string]map[string]bool)
+	for categoryID, productIDs := range assigned {
+		for _, ancestor := range CategoryPath(all, categoryID) {
+			if products[ancestor.ID] == nil {
+				products[ancestor.ID] = make(map[string]bool)
+			}
+			for _, id := range productIDs {
+				products[ancestor.ID][id] = true
+			}
+		}
+	}
+
+	counts := make(map[string]int, len(products))
+	for id, set := range products {
+		counts[id] = len(set)
+	}
+	return counts
+}
@@ -0,0 +1,271 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultPageSize is the number of products on a listing page when the
+// request does not say.
+const DefaultPageSize = 12
+
+// Facets whose own selection is left out when counting their values.
+const (
+	facetCategory = "category"
+	facetPrice    = "price"
+	facetInStock  = "in_stock"
+)
+
+// inStockCondition matches products with a unit available: an untracked or
+// stocked product without variants, or one with an untracked or stocked variant.
+const inStockCondition = `((NOT EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = p.id) AND (p.track_inventory = FALSE OR p.stock > 0))
+	OR EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = p.id AND (v.track_inventory = FALSE OR v.stock > 0)))`
+
+// ListPage returns one page of the products matching the request's filter.
+// Pages are keyset-paginated: each continues after the sort key and ID of the
+// previous page's last product, so deep pages cost no more than the first.
+func (r *SQLProductRepository) ListPage(ctx context.Context, req ProductPageRequest) (ProductPage, error) {
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	sort := ParseProductSort(string(req.Sort))
+	key, numeric := r.sortKey(sort, req.Filter)
+
+	from, args := r.listingFrom(req.Filter, sort == SortBestSelling)
+	where, whereArgs := r.listingWhere(req.Filter, "")
+	args = append(args, whereArgs...)
+
+	direction := " ASC"
+	if sortDescending(sort) {
+		direction = " DESC"
+	}
+
+	if req.After != "" {
+		cursor, err := decodeProductCursor(req.After)
+		if err != nil {
+			return ProductPage{}, err
+		}
+		op := ">"
+		if sortDescending(sort) {
+			op = "<"
+		}
+		var value any = cursor.Str
+		if numeric {
+			value = cursor.Num
+		}
+		where = append(where, "("+key+" "+op+" ? OR ("+key+" = ? AND p.id > ?))")
+		args = append(args, value, value, cursor.ID)
+	}
+
+	query := "SELECT " + productColumns + ", " + key + from + whereClause(where) +
+		" ORDER BY " + key + direction + ", p.id LIMIT ?"
+	args = append(args, limit+1) // one extra row tells whether there is a next page
+
+	rows, err := r.conn.QueryContext(ctx, r.q(query), args...)
+	if err != nil {
+		return ProductPage{}, fmt.Errorf("error fetching product page: %w", err)
+	}
+	defer rows.Close()
+
+	var products []Product
+	var cursors []productCursor
+	for rows.Next() {
+		var p Product
+		var cursor productCursor
+		sortValue := any(&cursor.Str)
+		if numeric {
+			sortValue = &cursor.Num
+		}
+		if err := scanProduct(rows, &p, sortValue); err != nil {
+			return ProductPage{}, fmt.Errorf("error scanning product row: %w", err)
+		}
+		cursor.ID = p.ID
+		products = append(products, p)
+		cursors = append(cursors, cursor)
+	}
+	if err := rows.Err(); err != nil {
+		return ProductPage{}, fmt.Errorf("error after iterating through product rows: %w", err)
+	}
+	rows.Close()
+
+	var page ProductPage
+	if len(products) > limit {
+		products = products[:limit]
+		page.NextCursor = cursors[limit-1].encode()
+	}
+	if err := r.loadDetails(ctx, products); err != nil {
+		return ProductPage{}, err
+	}
+	page.Products = products
+	return page, nil
+}
+
+// Facets counts the products matching filter by category, price range and
+// availability.
+func (r *SQLProductRepository) Facets(ctx context.Context, filter ProductFilter, categories []Category) (ProductFacets, error) {
+	facets := ProductFacets{Categories: make(map[string]int)}
+	from, fromArgs := r.listingFrom(filter, false)
+	price := r.priceExpr(filter)
+
+	// Products matching the whole filter
+	where, args := r.listingWhere(filter, "")
+	err := r.conn.QueryRowContext(ctx, r.q("SELECT COUNT(*)"+from+whereClause(where)), append(fromArgs, args...)...).Scan(&facets.Total)
+	if err != nil {
+		return ProductFacets{}, fmt.Errorf("error counting products: %w", err)
+	}
+
+	// Category assignments, rolled up into parent categories
+	where, args = r.listingWhere(filter, facetCategory)
+	rows, err := r.conn.QueryContext(ctx,
+		r.q("SELECT pc.category_id, p.id"+from+" JOIN product_categories pc ON pc.product_id = p.id"+whereClause(where)),
+		append(fromArgs, args...)...,
+	)
+	if err != nil {
+		return ProductFacets{}, fmt.Errorf("error counting products by category: %w", err)
+	}
+	defer rows.Close()
+	assigned := make(map[string][]string)
+	for rows.Next() {
+		var categoryID, productID string
+		if err := rows.Scan(&categoryID, &productID); err != nil {
+			return ProductFacets{}, fmt.Errorf("error scanning category facet row: %w", err)
+		}
+		assigned[categoryID] = append(assigned[categoryID], productID)
+	}
+	if err := rows.Err(); err != nil {
+		return ProductFacets{}, fmt.Errorf("error after iterating through category facet rows: %w", err)
+	}
+	rows.Close()
+	facets.Categories = countCategoryFacets(categories, assigned)
+
+	// Price ranges, counted in one pass
+	var sums []string
+	for _, pr := range PriceRanges {
+		cond := price + " >= " + strconv.FormatInt(pr.Min, 10)
+		if pr.Max > 0 {
+			cond += " AND " + price + " < " + strconv.FormatInt(pr.Max, 10)
+		}
+		sums = append(sums, "COALESCE(SUM(CASE WHEN "+cond+" THEN 1 ELSE 0 END), 0)")
+	}
+	where, args = r.listingWhere(filter, facetPrice)
+	counts := make([]int, len(PriceRanges))
+	dest := make([]any, len(counts))
+	for i := range counts {
+		dest[i] = &counts[i]
+	}
+	err = r.conn.QueryRowContext(ctx, r.q("SELECT "+strings.Join(sums, ", ")+from+whereClause(where)), append(fromArgs, args...)...).Scan(dest...)
+	if err != nil {
+		return ProductFacets{}, fmt.Errorf("error counting products by price range: %w", err)
+	}
+	for i, pr := range PriceRanges {
+		facets.PriceRanges = append(facets.PriceRanges, PriceRangeCount{PriceRange: pr, Count: counts[i]})
+	}
+
+	// Products with stock
+	where, args = r.listingWhere(filter, facetInStock)
+	where = append(where, inStockCondition)
+	err = r.conn.QueryRowContext(ctx, r.q("SELECT COUNT(*)"+from+whereClause(where)), append(fromArgs, args...)...).Scan(&facets.InStock)
+	if err != nil {
+		return ProductFacets{}, fmt.Errorf("error counting products in stock: %w", err)
+	}
+
+	return facets, nil
+}
+
+// listingFrom returns the FROM clause of a listing query over products p,
+// joining the price list for the filter's currency as pp and, if withSales
+// is set, the units sold as s.sold.
+func (r *SQLProductRepository) listingFrom(filter ProductFilter, withSales bool) (string, []any) {
+	from := " FROM products p"
+	var args []any
+	if filter.Currency != "" {
+		from += " JOIN product_prices pp ON pp.product_id = p.id AND pp.currency = ?"
+		args = append(args, filter.Currency)
+	}
+	if withSales {
+		from += " LEFT JOIN (SELECT oi.product_id, SUM(oi.quantity) AS sold FROM order_items oi JOIN orders o ON o.id = oi.order_id WHERE o.status IN (?, ?, ?, ?, ?) GROUP BY oi.product_id) s ON s.product_id = p.id"
+		// Refunded orders are not counted as sales
+		args = append(args, string(OrderStatusPaid), string(OrderStatusProcessing), string(OrderStatusShipped), string(OrderStatusDelivered), string(OrderStatusPartiallyRefunded))
+	}
+	return from, args
+}
+
+// listingWhere returns the conditions of the filter, leaving out the
+// selection of the facet named by skip (if any).
+func (r *SQLProductRepository) listingWhere(filter ProductFilter, skip string) ([]string, []any) {
+	var where []string
+	var args []any
+	if !filter.IncludeArchived {
+		where = append(where, "p.archived = FALSE")
+	}
+	if len(filter.CategoryIDs) > 0 && skip != facetCategory {
+		where = append(where, "EXISTS (SELECT 1 FROM product_categories pc WHERE pc.product_id = p.id AND pc.category_id IN ("+placeholders(len(filter.CategoryIDs))+"))")
+		for _, id := range filter.CategoryIDs {
+			args = append(args, id)
+		}
+	}
+	if filter.Tag != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM product_tags pt WHERE pt.product_id = p.id AND pt.tag = ?)")
+		args = append(args, filter.Tag)
+	}
+	if skip != facetPrice {
+		if filter.Price.Min > 0 {
+			where = append(where, r.priceExpr(filter)+" >= ?")
+			args = append(args, filter.Price.Min)
+		}
+		if filter.Price.Max > 0 {
+			where = append(where, r.priceExpr(filter)+" < ?")
+			args = append(args, filter.Price.Max)
+		}
+	}
+	if filter.InStock && skip != facetInStock {
+		where = append(where, inStockCondition)
+	}
+	return where, args
+}
+
+// priceExpr is the price a listing query filters and sorts by.
+func (r *SQLProductRepository) priceExpr(filter ProductFilter) string {
+	if filter.Currency != "" {
+		return "pp.amount"
+	}
+	return "p.price"
+}
+
+// sortKey returns the expression a listing is ordered by and whether it is
+// numeric (as opposed to text).
+func (r *SQLProductRepository) sortKey(sort ProductSort, filter ProductFilter) (string, bool) {
+	switch sort {
+	case SortPriceAsc, SortPriceDesc:
+		return r.priceExpr(filter), true
+	case SortName:
+		return "p.name", false
+	case SortBestSelling:
+		return "COALESCE(s.sold, 0)", true
+	case SortFeatured:
+		return "p.position", true
+	default:
+		// Compare creation times as Unix seconds so that cursors do not depend
+		// on how the driver formats timestamps.
+		if r.dialect == "postgres" {
+			return "CAST(EXTRACT(EPOCH FROM p.created_at) AS BIGINT)", true
+		}
+		return "CAST(strftime('%s', p.created_at) AS INTEGER)", true
+	}
+}
+
+// whereClause joins conditions into a WHERE clause, or returns "" if there are none.
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// placeholders returns n comma-separated "?" placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
@@ -0,0 +1,169 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// listingCatalog is a setup for testStores adding the same four products for
+// sale and an archived one to each store.
+var listingCatalog = withCatalog(
+	[]Category{
+		{ID: "tops", Slug: "tops", Name: "Tops"},
+		{ID: "tees", ParentID: "tops", Slug: "tees", Name: "Tees"},
+		{ID: "mugs", Slug: "mugs", Name: "Mugs"},
+	},
+	[]Product{ // in merchandising order
+		{ID: "p4", Name: "Bravo", Price: Money{1000, "USD"}, CategoryIDs: []string{"tees"}},
+		{ID: "p2", Name: "Alpha", Price: Money{6000, "USD"}, Stock: 3, TrackInventory: true, CategoryIDs: []string{"mugs"}},
+		{ID: "p3", Name: "Bravo", Price: Money{1000, "USD"}, Stock: 0, TrackInventory: true, CategoryIDs: []string{"tops"}},
+		{ID: "p1", Name: "Delta", Price: Money{15000, "USD"}, Options: []string{"Size"},
+			Variants: []Variant{{ID: "p1-s", SKU: "P1-S", Options: map[string]string{"Size": "S"}, TrackInventory: true}}},
+		{ID: "p5", Name: "Echo", Price: Money{25000, "USD"}, Archived: true},
+	},
+)
+
+// listingCreationTimes is a setup for testStores, after listingCatalog, that
+// makes p1, p3 and p4 added in the same second, a day before p2, so that with
+// p3 and p4 sharing a name and a price every sort has ties to break.
+func listingCreationTimes(t *testing.T, store *Store) {
+	t.Helper()
+	older := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	created := map[string]time.Time{"p1": older, "p2": older.Add(24 * time.Hour), "p3": older, "p4": older, "p5": older}
+	// Both stores set the creation time themselves
+	for id, at := range created {
+		switch repo := store.Products.(type) {
+		case *MemoryProductRepository:
+			p := repo.products[id]
+			p.CreatedAt = at
+			repo.products[id] = p
+		case *SQLProductRepository:
+			// As CURRENT_TIMESTAMP writes it
+			if _, err := repo.conn.Exec("UPDATE products SET created_at = ? WHERE id = ?", at.Format(time.DateTime), id); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			t.Fatalf("cannot set creation times in a %T", repo)
+		}
+	}
+}
+
+func TestProductListPage(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		sort ProductSort
+		want []string
+	}{
+		{SortFeatured, []string{"p4", "p2", "p3", "p1"}},
+		{SortNewest, []string{"p2", "p1", "p3", "p4"}},
+		{SortPriceAsc, []string{"p3", "p4", "p2", "p1"}},
+		{SortPriceDesc, []string{"p1", "p2", "p3", "p4"}},
+		{SortName, []string{"p2", "p3", "p4", "p1"}},
+		{SortBestSelling, []string{"p1", "p2", "p3", "p4"}}, // nothing sold: all tied
+	}
+	for name, store := range testStores(t, listingCatalog, listingCreationTimes) {
+		for _, tt := range tests {
+			// Every page size splits the listing, and its ties, differently
+			for limit := 1; limit <= len(tt.want); limit++ {
+				t.Run(fmt.Sprintf("%s/%d per page/%s", tt.sort, limit, name), func(t *testing.T) {
+					var got []string
+					req := ProductPageRequest{Sort: tt.sort, Limit: limit}
+					for pages := 0; ; pages++ {
+						if pages > len(tt.want) {
+							t.Fatalf("still paging after %v", got)
+						}
+						page, err := store.Products.ListPage(ctx, req)
+						if err != nil {
+							t.Fatal(err)
+						}
+						for _, p := range page.Products {
+							got = append(got, p.ID)
+						}
+						if page.NextCursor == "" {
+							break
+						}
+						req.After = page.NextCursor
+					}
+					if !reflect.DeepEqual(got, tt.want) {
+						t.Errorf("listed %v, want %v", got, tt.want)
+					}
+				})
+			}
+		}
+
+		t.Run("invalid cursor/"+name, func(t *testing.T) {
+			_, err := store.Products.ListPage(ctx, ProductPageRequest{After: "not a cursor"})
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("err = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
+
+func TestProductFacets(t *testing.T) {
+	ctx := context.Background()
+	priceCounts := func(counts ...int) []PriceRangeCount {
+		ranges := make([]PriceRangeCount, len(PriceRanges))
+		for i, pr := range PriceRanges {
+			ranges[i] = PriceRangeCount{PriceRange: pr, Count: counts[i]}
+		}
+		return ranges
+	}
+	tests := []struct {
+		name   string
+		filter ProductFilter
+		want   ProductFacets
+	}{
+		{
+			name: "everything",
+			want: ProductFacets{
+				Total:       4,
+				Categories:  map[string]int{"tops": 2, "tees": 1, "mugs": 1},
+				PriceRanges: priceCounts(2, 1, 1, 0),
+				InStock:     2,
+			},
+		},
+		{
+			// Category counts leave out the category selection
+			name:   "categories",
+			filter: ProductFilter{CategoryIDs: []string{"tops", "tees"}},
+			want: ProductFacets{
+				Total:       2,
+				Categories:  map[string]int{"tops": 2, "tees": 1, "mugs": 1},
+				PriceRanges: priceCounts(2, 0, 0, 0),
+				InStock:     1,
+			},
+		},
+		{
+			name:   "price and stock",
+			filter: ProductFilter{Price: PriceRange{0, 5000}, InStock: true},
+			want: ProductFacets{
+				Total:       1,
+				Categories:  map[string]int{"tops": 1, "tees": 1},
+				PriceRanges: priceCounts(1, 1, 0, 0),
+				InStock:     1,
+			},
+		},
+	}
+	for name, store := range testStores(t, listingCatalog, listingCreationTimes) {
+		categories, err := store.Categories.List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				facets, err := store.Products.Facets(ctx, tt.filter, categories)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(facets, tt.want) {
+					t.Errorf("facets = %+v, want %+v", facets, tt.want)
+				}
+			})
+		}
+	}
+}
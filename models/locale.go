@@ -0,0 +1,119 @@
+package models
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// localeFormat describes how a locale writes amounts of money.
+type localeFormat struct {
+	Decimal     string // decimal separator
+	Group       string // thousands separator
+	SymbolAfter bool   // "29,99 €" rather than "€29.99"
+}
+
+// localeFormats lists the locales prices can be formatted for.
+var localeFormats = map[string]localeFormat{
+	"en-US": {Decimal: ".", Group: ","},
+	"en-GB": {Decimal: ".", Group: ","},
+	"en-IE": {Decimal: ".", Group: ","},
+	"ja-JP": {Decimal: ".", Group: ","},
+	"de-DE": {Decimal: ",", Group: ".", SymbolAfter: true},
+	"fr-FR": {Decimal: ",", Group: " ", SymbolAfter: true},
+	"es-ES": {Decimal: ",", Group: ".", SymbolAfter: true},
+	"it-IT": {Decimal: ",", Group: ".", SymbolAfter: true},
+	"nl-NL": {Decimal: ",", Group: "."},
+}
+
+// Format writes the amount the way the given locale (e.g. "de-DE") expects,
+// falling back to the currency's own default locale.
+func (m Money) Format(locale string) string {
+	info := lookupCurrency(m.Currency)
+	format, ok := localeFormats[locale]
+	if !ok {
+		format = localeFormats[info.Locale]
+	}
+
+	decimal := m.Decimal()
+	negative := strings.HasPrefix(decimal, "-")
+	decimal = strings.TrimPrefix(decimal, "-")
+	whole, frac, _ := strings.Cut(decimal, ".")
+
+	var b strings.Builder
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(format.Group)
+		}
+		b.WriteRune(r)
+	}
+	if frac != "" {
+		b.WriteString(format.Decimal)
+		b.WriteString(frac)
+	}
+
+	symbol := strings.TrimSpace(info.Symbol)
+	var out string
+	if format.SymbolAfter {
+		out = b.String() + " " + symbol
+	} else {
+		out = info.Symbol + b.String()
+	}
+	if negative {
+		out = "-" + out
+	}
+	return out
+}
+
+// FormatMoney formats m for locale. It is registered as the "formatPrice"
+// template function.
+func FormatMoney(m Money, locale string) string {
+	return m.Format(locale)
+}
+
+// MatchLocale picks the best supported locale for an Accept-Language header,
+// falling back to the default locale of the currency being shown.
+func MatchLocale(acceptLanguage, currency string) string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var tags []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		tags = append(tags, weighted{tag: tag, q: q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	for _, t := range tags {
+		lang, region, _ := strings.Cut(t.tag, "-")
+		lang = strings.ToLower(lang)
+		if region != "" {
+			candidate := lang + "-" + strings.ToUpper(region)
+			if _, ok := localeFormats[candidate]; ok {
+				return candidate
+			}
+		}
+		// Prefer the currency's own locale when the language matches, e.g. "en" + GBP → en-GB
+		if def := lookupCurrency(currency).Locale; strings.HasPrefix(def, lang+"-") {
+			return def
+		}
+		for candidate := range localeFormats {
+			if strings.HasPrefix(candidate, lang+"-") && lang != "en" {
+				return candidate
+			}
+		}
+	}
+
+	return lookupCurrency(currency).Locale
+}
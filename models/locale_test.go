@@ -0,0 +1,54 @@
+package models
+
+import "testing"
+
+func TestMoneyFormatLocale(t *testing.T) {
+	// Symbols after the amount, and French groups, are kept on the same line
+	tests := []struct {
+		money  Money
+		locale string
+		want   string
+	}{
+		{Money{123456789, "EUR"}, "de-DE", "1.234.567,89\u00a0€"},
+		{Money{123456789, "EUR"}, "fr-FR", "1\u202f234\u202f567,89\u00a0€"},
+		{Money{123456789, "EUR"}, "nl-NL", "€1.234.567,89"},
+		{Money{123456789, "EUR"}, "en-US", "€1,234,567.89"},
+		{Money{123456789, "GBP"}, "xx-XX", "£1,234,567.89"}, // the currency's own locale
+		{Money{1500000, "JPY"}, "ja-JP", "¥1,500,000"},
+		{Money{99, "EUR"}, "de-DE", "0,99\u00a0€"},
+		{Money{-125, "EUR"}, "de-DE", "-1,25\u00a0€"},
+		{Money{1234, "CHF"}, "de-DE", "12,34\u00a0CHF"},
+		{Money{1234, "CHF"}, "en-US", "CHF 12.34"},
+	}
+	for _, tt := range tests {
+		if got := tt.money.Format(tt.locale); got != tt.want {
+			t.Errorf("%+v.Format(%q) = %q, want %q", tt.money, tt.locale, got, tt.want)
+		}
+	}
+}
+
+func TestMatchLocale(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		currency       string
+		want           string
+	}{
+		{"", "USD", "en-US"},
+		{"", "EUR", "en-IE"},
+		{"*", "JPY", "ja-JP"},
+		{"de-DE,de;q=0.9,en;q=0.8", "EUR", "de-DE"},
+		{"de", "EUR", "de-DE"},
+		{"en", "GBP", "en-GB"}, // the currency's own English
+		{"en-GB", "USD", "en-GB"},
+		{"en-AU", "USD", "en-US"},
+		{"fr-CA,en;q=0.5", "EUR", "fr-FR"},
+		{"xx,de;q=0.8", "EUR", "de-DE"},
+		{"en;q=0.2,it;q=0.9", "EUR", "it-IT"}, // by weight, not by order
+		{"DE-de", "EUR", "de-DE"},
+	}
+	for _, tt := range tests {
+		if got := MatchLocale(tt.acceptLanguage, tt.currency); got != tt.want {
+			t.Errorf("MatchLocale(%q, %s) = %q, want %q", tt.acceptLanguage, tt.currency, got, tt.want)
+		}
+	}
+}
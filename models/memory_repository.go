@@ -0,0 +1,1284 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// MemoryProductRepository is a ProductRepository that keeps products in memory.
+type MemoryProductRepository struct {
+	mu       sync.RWMutex
+	products map[string]Product
+}
+
+// NewMemoryProductRepository returns an empty in-memory product repository.
+func NewMemoryProductRepository() *MemoryProductRepository {
+	return &MemoryProductRepository{products: make(map[string]Product)}
+}
+
+// List returns the products matching the filter in merchandising order.
+func (r *MemoryProductRepository) List(ctx context.Context, filter ProductFilter) ([]Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	products := make([]Product, 0, len(r.products))
+	for _, p := range r.products {
+		if p.Matches(filter) {
+			products = append(products, copyProduct(p))
+		}
+	}
+	sort.Slice(products, func(i, j int) bool {
+		if products[i].Position != products[j].Position {
+			return products[i].Position < products[j].Position
+		}
+		return products[i].ID < products[j].ID
+	})
+	return products, nil
+}
+
+// ListPage returns one page of the products matching the request's filter.
+// The memory store keeps no sales history, so best-selling falls back to ID order.
+func (r *MemoryProductRepository) ListPage(ctx context.Context, req ProductPageRequest) (ProductPage, error) {
+	products, err := r.List(ctx, req.Filter)
+	if err != nil {
+		return ProductPage{}, err
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	sortBy := ParseProductSort(string(req.Sort))
+	desc := sortDescending(sortBy)
+
+	cursors := make(map[string]productCursor, len(products))
+	for _, p := range products {
+		c := productCursor{ID: p.ID}
+		switch sortBy {
+		case SortPriceAsc, SortPriceDesc:
+			price, _ := p.listingPrice(req.Filter.Currency)
+			c.Num = price.Amount
+		case SortName:
+			c.Str = p.Name
+		case SortNewest:
+			c.Num = p.CreatedAt.Unix()
+		case SortFeatured:
+			c.Num = int64(p.Position)
+		}
+		cursors[p.ID] = c
+	}
+	// less reports whether a comes before b in the sort order.
+	less := func(a, b productCursor) bool {
+		if a.Num != b.Num {
+			return (a.Num < b.Num) != desc
+		}
+		if a.Str != b.Str {
+			return (a.Str < b.Str) != desc
+		}
+		return a.ID < b.ID
+	}
+	sort.Slice(products, func(i, j int) bool { return less(cursors[products[i].ID], cursors[products[j].ID]) })
+
+	if req.After != "" {
+		after, err := decodeProductCursor(req.After)
+		if err != nil {
+			return ProductPage{}, err
+		}
+		start := sort.Search(len(products), func(i int) bool { return less(after, cursors[products[i].ID]) })
+		products = products[start:]
+	}
+
+	var page ProductPage
+	if len(products) > limit {
+		products = products[:limit]
+		page.NextCursor = cursors[products[limit-1].ID].encode()
+	}
+	page.Products = products
+	return page, nil
+}
+
+// Facets counts the products matching filter by category, price range and availability.
+func (r *MemoryProductRepository) Facets(ctx context.Context, filter ProductFilter, categories []Category) (ProductFacets, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	facets := ProductFacets{PriceRanges: make([]PriceRangeCount, len(PriceRanges))}
+	for i, pr := range PriceRanges {
+		facets.PriceRanges[i].PriceRange = pr
+	}
+	assigned := make(map[string][]string)
+	for _, p := range r.products {
+		if p.Matches(filter) {
+			facets.Total++
+		}
+
+		withoutCategory := filter
+		withoutCategory.CategoryIDs = nil
+		if p.Matches(withoutCategory) {
+			for _, id := range p.CategoryIDs {
+				assigned[id] = append(assigned[id], p.ID)
+			}
+		}
+
+		withoutPrice := filter
+		withoutPrice.Price = PriceRange{}
+		if p.Matches(withoutPrice) {
+			price, _ := p.listingPrice(filter.Currency)
+			for i, pr := range PriceRanges {
+				if pr.Contains(price.Amount) {
+					facets.PriceRanges[i].Count++
+				}
+			}
+		}
+
+		withoutStock := filter
+		withoutStock.InStock = true
+		if p.Matches(withoutStock) {
+			facets.InStock++
+		}
+	}
+	facets.Categories = countCategoryFacets(categories, assigned)
+	return facets, nil
+}
+
+// GetByID returns the product with the given ID.
+func (r *MemoryProductRepository) GetByID(ctx context.Context, id string) (Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, ok := r.products[id]
+	if !ok {
+		return Product{}, fmt.Errorf("product with ID %s: %w", id, ErrNotFound)
+	}
+	return copyProduct(p), nil
+}
+
+// Create adds a new product at the end of the merchandising order.
+func (r *MemoryProductRepository) Create(ctx context.Context, p Product) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.create(p)
+}
+
+// create adds a new product. The caller must hold r.mu.
+func (r *MemoryProductRepository) create(p Product) error {
+	if _, exists := r.products[p.ID]; exists {
+		return fmt.Errorf("product with ID %s: %w", p.ID, ErrConflict)
+	}
+	if err := r.checkSKUs(p); err != nil {
+		return err
+	}
+	stored := copyProduct(p)
+	stored.CreatedAt = time.Now()
+	stored.Position = len(r.products)
+	for _, other := range r.products {
+		if other.Position >= stored.Position {
+			stored.Position = other.Position + 1
+		}
+	}
+	r.products[p.ID] = stored
+	return nil
+}
+
+// Update replaces the stored product with p, keeping its creation time,
+// position, archived flag and images, and the stock of the product and its
+// existing variants, changed by stock.
+func (r *MemoryProductRepository) Update(ctx context.Context, p Product, stock StockChanges) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.update(p, stock)
+}
+
+// update replaces the stored product. The caller must hold r.mu.
+func (r *MemoryProductRepository) update(p Product, stock StockChanges) error {
+	stored, ok := r.products[p.ID]
+	if !ok {
+		return fmt.Errorf("product with ID %s: %w", p.ID, ErrNotFound)
+	}
+	if err := r.checkSKUs(p); err != nil {
+		return err
+	}
+	updated := copyProduct(p)
+	updated.CreatedAt = stored.CreatedAt
+	updated.Position = stored.Position
+	updated.Archived = stored.Archived
+	updated.Images = stored.Images
+	updated.Stock = stored.Stock + stock[""]
+	for i, v := range updated.Variants {
+		if sv, ok := stored.FindVariant(v.ID); ok {
+			updated.Variants[i].Stock = sv.Stock + stock[v.ID]
+		}
+	}
+	r.products[p.ID] = updated
+	return nil
+}
+
+// SetArchived archives or restores a product.
+func (r *MemoryProductRepository) SetArchived(ctx context.Context, id string, archived bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.setArchived(id, archived)
+}
+
+// setArchived archives or restores a product. The caller must hold r.mu.
+func (r *MemoryProductRepository) setArchived(id string, archived bool) error {
+	p, ok := r.products[id]
+	if !ok {
+		return fmt.Errorf("product with ID %s: %w", id, ErrNotFound)
+	}
+	p.Archived = archived
+	r.products[id] = p
+	return nil
+}
+
+// SaveAll makes the changes in order, putting every product back as it was
+// if one fails.
+func (r *MemoryProductRepository) SaveAll(ctx context.Context, changes []ProductChange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Stored products are replaced rather than changed, so a shallow copy can restore them
+	saved := maps.Clone(r.products)
+	for _, change := range changes {
+		p := change.Product
+		var err error
+		switch change.Action {
+		case ImportCreate:
+			err = r.create(p)
+		case ImportUpdate:
+			if err = r.update(p, change.Stock); err == nil {
+				err = r.setArchived(p.ID, p.Archived)
+			}
+		case ImportDelete:
+			err = r.setArchived(p.ID, true)
+		default:
+			err = fmt.Errorf("unknown action %q", change.Action)
+		}
+		if err != nil {
+			r.products = saved
+			return fmt.Errorf("error saving product %s (%s): %w", p.ID, change.Action, err)
+		}
+	}
+	return nil
+}
+
+// Reorder gives each listed product its index in ids as its position.
+func (r *MemoryProductRepository) Reorder(ctx context.Context, ids []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for position, id := range ids {
+		if p, ok := r.products[id]; ok {
+			p.Position = position
+			r.products[id] = p
+		}
+	}
+	return nil
+}
+
+// checkSKUs returns an error wrapping ErrConflict if another product has a
+// variant with one of p's SKUs. The caller must hold r.mu.
+func (r *MemoryProductRepository) checkSKUs(p Product) error {
+	for _, other := range r.products {
+		if other.ID == p.ID {
+			continue
+		}
+		for _, ov := range other.Variants {
+			for _, v := range p.Variants {
+				if v.SKU == ov.SKU {
+					return fmt.Errorf("%s: %w", v.SKU, ErrSKUConflict)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// MemoryCategoryRepository is a CategoryRepository that keeps categories in memory.
+type MemoryCategoryRepository struct {
+	mu         sync.RWMutex
+	categories map[string]Category
+}
+
+// NewMemoryCategoryRepository returns an empty in-memory category repository.
+func NewMemoryCategoryRepository() *MemoryCategoryRepository {
+	return &MemoryCategoryRepository{categories: make(map[string]Category)}
+}
+
+// List returns every category ordered by position and name.
+func (r *MemoryCategoryRepository) List(ctx context.Context) ([]Category, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	categories := make([]Category, 0, len(r.categories))
+	for _, c := range r.categories {
+		categories = append(categories, c)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if categories[i].Position != categories[j].Position {
+			return categories[i].Position < categories[j].Position
+		}
+		return categories[i].Name < categories[j].Name
+	})
+	return categories, nil
+}
+
+// GetBySlug returns the category with the given slug.
+func (r *MemoryCategoryRepository) GetBySlug(ctx context.Context, slug string) (Category, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, c := range r.categories {
+		if c.Slug == slug {
+			return c, nil
+		}
+	}
+	return Category{}, fmt.Errorf("category with slug %s: %w", slug, ErrNotFound)
+}
+
+// Insert adds the category unless a category with the same ID already exists.
+func (r *MemoryCategoryRepository) Insert(ctx context.Context, c Category) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.categories[c.ID]; !exists {
+		r.categories[c.ID] = c
+	}
+	return nil
+}
+
+// MemoryOrderRepository is an OrderRepository that keeps orders in memory.
+type MemoryOrderRepository struct {
+	mu         sync.RWMutex
+	orders     map[string]*Order
+	history    map[string][]OrderStatusChange // by order ID
+	reconciled map[string]time.Time           // by order ID
+	coupons    *MemoryCouponRepository        // for the limits of the coupons orders use, if set
+}
+
+// NewMemoryOrderRepository returns an empty in-memory order repository.
+func NewMemoryOrderRepository() *MemoryOrderRepository {
+	return &MemoryOrderRepository{
+		orders:     make(map[string]*Order),
+		history:    make(map[string][]OrderStatusChange),
+		reconciled: make(map[string]time.Time),
+	}
+}
+
+// Save stores a copy of the order.
+func (r *MemoryOrderRepository) Save(ctx context.Context, o *Order) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkCouponLimits(o); err != nil {
+		return err
+	}
+	stored := copyOrder(o)
+	stored.UpdatedAt = time.Now()
+	if old, ok := r.orders[o.ID]; ok {
+		stored.Status, stored.StripeID = old.Status, old.StripeID
+		stored.PaymentIntentID, stored.ChargeID, stored.DisputeStatus = old.PaymentIntentID, old.ChargeID, old.DisputeStatus
+		stored.RefundedAmount = old.RefundedAmount
+	}
+	r.orders[o.ID] = stored
+	return nil
+}
+
+// checkCouponLimits returns a *CouponError if other orders have used up one of
+// the order's coupons. The caller must hold r.mu.
+func (r *MemoryOrderRepository) checkCouponLimits(o *Order) error {
+	if r.coupons == nil {
+		return nil
+	}
+	for _, code := range o.CouponCodes() {
+		r.coupons.mu.RLock()
+		coupon, ok := r.coupons.coupons[code]
+		r.coupons.mu.RUnlock()
+		if !ok {
+			continue
+		}
+		total, byCustomer := r.couponUses(code, o.CustomerEmail, o.ID)
+		if coupon.MaxUses > 0 && total >= coupon.MaxUses {
+			return &CouponError{Code: code, Problem: CouponUsedUp}
+		}
+		if coupon.MaxUsesPerCustomer > 0 && byCustomer >= coupon.MaxUsesPerCustomer {
+			return &CouponError{Code: code, Problem: CouponUsedByCustomer}
+		}
+	}
+	return nil
+}
+
+// couponUses counts the orders other than the one with excludeID that used the
+// coupon and were not cancelled or expired: in total, and those placed with
+// customerEmail. The caller must hold r.mu.
+func (r *MemoryOrderRepository) couponUses(code, customerEmail, excludeID string) (int, int) {
+	total, byCustomer := 0, 0
+	for _, o := range r.orders {
+		if o.ID == excludeID || o.Status == OrderStatusCancelled || o.Status == OrderStatusExpired || !containsString(o.CouponCodes(), code) {
+			continue
+		}
+		total++
+		if strings.EqualFold(o.CustomerEmail, customerEmail) {
+			byCustomer++
+		}
+	}
+	return total, byCustomer
+}
+
+// SetCheckoutSession records the order's checkout session ID.
+func (r *MemoryOrderRepository) SetCheckoutSession(ctx context.Context, orderID, stripeID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.orders[orderID]
+	if !ok {
+		return fmt.Errorf("order %s: %w", orderID, ErrNotFound)
+	}
+	stored.StripeID = stripeID
+	stored.UpdatedAt = time.Now()
+	return nil
+}
+
+// GetByID returns a copy of the order with the given ID.
+func (r *MemoryOrderRepository) GetByID(ctx context.Context, id string) (*Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	o, ok := r.orders[id]
+	if !ok {
+		return nil, fmt.Errorf("order with id %s: %w", id, ErrNotFound)
+	}
+	return copyOrder(o), nil
+}
+
+// GetByStripeID returns a copy of the order with the given Stripe Checkout Session ID.
+func (r *MemoryOrderRepository) GetByStripeID(ctx context.Context, stripeID string) (*Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, o := range r.orders {
+		if o.StripeID == stripeID {
+			return copyOrder(o), nil
+		}
+	}
+	return nil, fmt.Errorf("order with Stripe ID %s: %w", stripeID, ErrNotFound)
+}
+
+// GetByPaymentIntentID returns a copy of the order paid by the Stripe PaymentIntent.
+func (r *MemoryOrderRepository) GetByPaymentIntentID(ctx context.Context, paymentIntentID string) (*Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, o := range r.orders {
+		if paymentIntentID != "" && o.PaymentIntentID == paymentIntentID {
+			return copyOrder(o), nil
+		}
+	}
+	return nil, fmt.Errorf("order with PaymentIntent ID %s: %w", paymentIntentID, ErrNotFound)
+}
+
+// UpdatePayment saves the payment fields of the order on the stored copy.
+func (r *MemoryOrderRepository) UpdatePayment(ctx context.Context, o *Order) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.orders[o.ID]
+	if !ok {
+		return fmt.Errorf("order %s: %w", o.ID, ErrNotFound)
+	}
+	now := time.Now()
+	stored.PaymentIntentID, stored.ChargeID, stored.DisputeStatus = o.PaymentIntentID, o.ChargeID, o.DisputeStatus
+	stored.UpdatedAt = now
+	o.UpdatedAt = now
+	return nil
+}
+
+// AddRefunded adds amount to the order's refunded amount, if that is still the
+// one o has.
+func (r *MemoryOrderRepository) AddRefunded(ctx context.Context, o *Order, amount Money) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.orders[o.ID]
+	if !ok {
+		return fmt.Errorf("order %s: %w", o.ID, ErrNotFound)
+	}
+	if stored.RefundedAmount.Amount != o.RefundedAmount.Amount {
+		return fmt.Errorf("refunded amount of order %s changed: %w", o.ID, ErrConflict)
+	}
+	stored.RefundedAmount.Amount += amount.Amount
+	stored.UpdatedAt = time.Now()
+	o.RefundedAmount.Amount = stored.RefundedAmount.Amount
+	return nil
+}
+
+// MarkReconciled records when the order was reconciled.
+func (r *MemoryOrderRepository) MarkReconciled(ctx context.Context, orderID string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reconciled[orderID] = at
+	return nil
+}
+
+// List returns copies of the orders matching the filter, newest first.
+func (r *MemoryOrderRepository) List(ctx context.Context, filter OrderFilter) ([]*Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var orders []*Order
+	for _, o := range r.orders {
+		if filter.Status != "" && o.Status != filter.Status {
+			continue
+		}
+		if !filter.CreatedBefore.IsZero() && !o.CreatedAt.Before(filter.CreatedBefore) {
+			continue
+		}
+		if _, ok := r.reconciled[o.ID]; filter.Unreconciled && (ok || (o.StripeID == "" && o.Status != OrderStatusPending)) {
+			continue
+		}
+		orders = append(orders, copyOrder(o))
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if !orders[i].CreatedAt.Equal(orders[j].CreatedAt) {
+			return orders[i].CreatedAt.After(orders[j].CreatedAt)
+		}
+		return orders[i].ID < orders[j].ID
+	})
+	if filter.Limit > 0 && len(orders) > filter.Limit {
+		orders = orders[:filter.Limit]
+	}
+	return orders, nil
+}
+
+// UpdateStatus moves the stored order to change.To if it still has the status
+// change.From, records the change and updates o to match.
+func (r *MemoryOrderRepository) UpdateStatus(ctx context.Context, o *Order, change OrderStatusChange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.orders[o.ID]
+	if !ok {
+		return fmt.Errorf("order %s: %w", o.ID, ErrNotFound)
+	}
+	if stored.Status != change.From {
+		return fmt.Errorf("order %s is %s, not %s: %w", o.ID, stored.Status, change.From, ErrInvalidTransition)
+	}
+	stored.Status = change.To
+	stored.UpdatedAt = change.CreatedAt
+	r.history[o.ID] = append(r.history[o.ID], change)
+	o.Status = change.To
+	o.UpdatedAt = change.CreatedAt
+	return nil
+}
+
+// StatusHistory returns the status changes of the order, oldest first.
+func (r *MemoryOrderRepository) StatusHistory(ctx context.Context, orderID string) ([]OrderStatusChange, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]OrderStatusChange(nil), r.history[orderID]...), nil
+}
+
+// MemoryCouponRepository is a CouponRepository that keeps coupons in memory
+// and counts their uses from the orders held by a MemoryOrderRepository.
+type MemoryCouponRepository struct {
+	mu      sync.RWMutex
+	coupons map[string]Coupon
+	orders  *MemoryOrderRepository
+}
+
+// NewMemoryCouponRepository returns an empty in-memory coupon repository.
+func NewMemoryCouponRepository(orders *MemoryOrderRepository) *MemoryCouponRepository {
+	return &MemoryCouponRepository{coupons: make(map[string]Coupon), orders: orders}
+}
+
+// List returns copies of every coupon, newest first.
+func (r *MemoryCouponRepository) List(ctx context.Context) ([]Coupon, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	coupons := make([]Coupon, 0, len(r.coupons))
+	for _, c := range r.coupons {
+		coupons = append(coupons, copyCoupon(c))
+	}
+	sort.Slice(coupons, func(i, j int) bool {
+		if !coupons[i].CreatedAt.Equal(coupons[j].CreatedAt) {
+			return coupons[i].CreatedAt.After(coupons[j].CreatedAt)
+		}
+		return coupons[i].Code < coupons[j].Code
+	})
+	return coupons, nil
+}
+
+// GetByCode returns a copy of the coupon with the given code, in any case.
+func (r *MemoryCouponRepository) GetByCode(ctx context.Context, code string) (Coupon, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	code = NormalizeCouponCode(code)
+	c, ok := r.coupons[code]
+	if !ok {
+		return Coupon{}, fmt.Errorf("coupon %s: %w", code, ErrNotFound)
+	}
+	return copyCoupon(c), nil
+}
+
+// Create stores a copy of a new coupon.
+func (r *MemoryCouponRepository) Create(ctx context.Context, c Coupon) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.coupons[c.Code]; ok {
+		return fmt.Errorf("coupon %s: %w", c.Code, ErrConflict)
+	}
+	r.coupons[c.Code] = copyCoupon(c)
+	return nil
+}
+
+// Update replaces the stored coupon with the same code, keeping its creation time.
+func (r *MemoryCouponRepository) Update(ctx context.Context, c Coupon) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.coupons[c.Code]
+	if !ok {
+		return fmt.Errorf("coupon %s: %w", c.Code, ErrNotFound)
+	}
+	c.CreatedAt = stored.CreatedAt
+	r.coupons[c.Code] = copyCoupon(c)
+	return nil
+}
+
+// Uses counts the orders, other than cancelled and expired ones, that used the coupon.
+func (r *MemoryCouponRepository) Uses(ctx context.Context, code, customerEmail string) (int, int, error) {
+	r.orders.mu.RLock()
+	defer r.orders.mu.RUnlock()
+
+	total, byCustomer := r.orders.couponUses(NormalizeCouponCode(code), customerEmail, "")
+	return total, byCustomer, nil
+}
+
+// MemoryCartRepository is a CartRepository that keeps carts in memory.
+type MemoryCartRepository struct {
+	mu    sync.Mutex
+	carts map[CartKey]*Cart
+}
+
+// NewMemoryCartRepository returns an empty in-memory cart repository.
+func NewMemoryCartRepository() *MemoryCartRepository {
+	return &MemoryCartRepository{carts: make(map[CartKey]*Cart)}
+}
+
+// cartKey normalises key so that a customer's cart is found whatever the session.
+func (r *MemoryCartRepository) cartKey(key CartKey) CartKey {
+	if key.CustomerID != "" {
+		return CartKey{CustomerID: key.CustomerID}
+	}
+	return CartKey{SessionID: key.SessionID}
+}
+
+// Get returns a copy of the cart owned by key.
+func (r *MemoryCartRepository) Get(ctx context.Context, key CartKey) (*Cart, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cart, ok := r.carts[r.cartKey(key)]
+	if !ok {
+		return nil, fmt.Errorf("cart of %s: %w", key, ErrNotFound)
+	}
+	return copyCart(cart), nil
+}
+
+// Save stores a copy of the cart for key, moving it from any other owner.
+func (r *MemoryCartRepository) Save(ctx context.Context, key CartKey, cart *Cart) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for k, other := range r.carts {
+		if other.ID == cart.ID {
+			delete(r.carts, k)
+		}
+	}
+	cart.UpdatedAt = time.Now()
+	r.carts[r.cartKey(key)] = copyCart(cart)
+	return nil
+}
+
+// Delete removes the cart owned by key, if any.
+func (r *MemoryCartRepository) Delete(ctx context.Context, key CartKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.carts, r.cartKey(key))
+	return nil
+}
+
+// Prune deletes the carts last saved before the cutoff.
+func (r *MemoryCartRepository) Prune(ctx context.Context, before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for k, cart := range r.carts {
+		if cart.UpdatedAt.Before(before) {
+			delete(r.carts, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// MemoryInventoryRepository is an InventoryRepository that adjusts the stock
+// of products held by a MemoryProductRepository.
+type MemoryInventoryRepository struct {
+	products     *MemoryProductRepository
+	reservations map[string][]OrderItem // outstanding reservations by order ID
+}
+
+// NewMemoryInventoryRepository returns an inventory repository for the given products.
+func NewMemoryInventoryRepository(products *MemoryProductRepository) *MemoryInventoryRepository {
+	return &MemoryInventoryRepository{products: products, reservations: make(map[string][]OrderItem)}
+}
+
+// Reserve takes stock for every item of the order, or none if any item is short.
+func (r *MemoryInventoryRepository) Reserve(ctx context.Context, orderID string, items []OrderItem) error {
+	r.products.mu.Lock()
+	defer r.products.mu.Unlock()
+
+	for _, item := range items {
+		tracked, stock, err := r.stock(item)
+		if err != nil {
+			return err
+		}
+		if tracked && stock < item.Quantity {
+			return fmt.Errorf("%s (%s): %w", item.DisplayName(), item.Key(), ErrOutOfStock)
+		}
+	}
+	for _, item := range items {
+		if tracked, _, _ := r.stock(item); tracked {
+			r.adjust(item, -item.Quantity)
+			r.reservations[orderID] = append(r.reservations[orderID], item)
+		}
+	}
+	return nil
+}
+
+// Release returns the order's outstanding reservations to stock.
+func (r *MemoryInventoryRepository) Release(ctx context.Context, orderID string) error {
+	r.products.mu.Lock()
+	defer r.products.mu.Unlock()
+
+	for _, item := range r.reservations[orderID] {
+		r.adjust(item, item.Quantity)
+	}
+	delete(r.reservations, orderID)
+	return nil
+}
+
+// stock returns whether the item's product or variant tracks inventory and how
+// much it has. The caller must hold r.products.mu.
+func (r *MemoryInventoryRepository) stock(item OrderItem) (bool, int, error) {
+	p, ok := r.products.products[item.ProductID]
+	if !ok {
+		return false, 0, fmt.Errorf("product with ID %s: %w", item.ProductID, ErrNotFound)
+	}
+	if item.VariantID == "" {
+		return p.TrackInventory, p.Stock, nil
+	}
+	v, ok := p.FindVariant(item.VariantID)
+	if !ok {
+		return false, 0, fmt.Errorf("variant %s: %w", item.VariantID, ErrNotFound)
+	}
+	return v.TrackInventory, v.Stock, nil
+}
+
+// adjust changes the stock of the item's product or variant by delta. The
+// caller must hold r.products.mu.
+func (r *MemoryInventoryRepository) adjust(item OrderItem, delta int) {
+	p, ok := r.products.products[item.ProductID]
+	if !ok {
+		return
+	}
+	if item.VariantID == "" {
+		p.Stock += delta
+	} else {
+		for i := range p.Variants {
+			if p.Variants[i].ID == item.VariantID {
+				p.Variants[i].Stock += delta
+			}
+		}
+	}
+	r.products.products[item.ProductID] = p
+}
+
+// Commit forgets the order's reservations so they can no longer be released.
+func (r *MemoryInventoryRepository) Commit(ctx context.Context, orderID string) error {
+	r.products.mu.Lock()
+	defer r.products.mu.Unlock()
+
+	delete(r.reservations, orderID)
+	return nil
+}
+
+// Restock adds the items' quantities back to the stock of tracked products and variants.
+func (r *MemoryInventoryRepository) Restock(ctx context.Context, items []OrderItem) error {
+	r.products.mu.Lock()
+	defer r.products.mu.Unlock()
+
+	for _, item := range items {
+		tracked, _, err := r.stock(item)
+		if err != nil {
+			return err
+		}
+		if tracked {
+			r.adjust(item, item.Quantity)
+		}
+	}
+	return nil
+}
+
+// copyProduct returns a copy of p that shares no maps or slices with it.
+func copyProduct(p Product) Product {
+	p.Prices = copyPrices(p.Prices)
+	p.Options = append([]string(nil), p.Options...)
+	p.CategoryIDs = append([]string(nil), p.CategoryIDs...)
+	p.Tags = append([]string(nil), p.Tags...)
+	p.Images = append([]ProductImage(nil), p.Images...)
+	variants := make([]Variant, len(p.Variants))
+	for i, v := range p.Variants {
+		v.Prices = copyPrices(v.Prices)
+		options := make(map[string]string, len(v.Options))
+		for name, value := range v.Options {
+			options[name] = value
+		}
+		v.Options = options
+		variants[i] = v
+	}
+	p.Variants = variants
+	return p
+}
+
+func copyPrices(prices map[string]Money) map[string]Money {
+	c := make(map[string]Money, len(prices))
+	for currency, price := range prices {
+		c[currency] = price
+	}
+	return c
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// copyCart returns a copy of the cart that shares no slices with it.
+func copyCart(cart *Cart) *Cart {
+	c := *cart
+	c.Items = append([]CartItem(nil), cart.Items...)
+	c.CouponCodes = append([]string(nil), cart.CouponCodes...)
+	c.Discounts = nil // worked out again when the cart is loaded
+	c.Tax = nil
+	c.ShippingRates, c.Shipping = nil, nil
+	return &c
+}
+
+// copyCoupon returns a copy of the coupon that shares no slices with it.
+func copyCoupon(c Coupon) Coupon {
+	c.ProductIDs = append([]string(nil), c.ProductIDs...)
+	c.CategoryIDs = append([]string(nil), c.CategoryIDs...)
+	return c
+}
+
+// copyOrder returns a copy of o that shares no slices with it.
+func copyOrder(o *Order) *Order {
+	c := *o
+	c.Items = append([]OrderItem(nil), o.Items...)
+	for i := range c.Items {
+		c.Items[i].Taxes = append([]TaxLine(nil), c.Items[i].Taxes...)
+	}
+	c.Discounts = append([]Discount(nil), o.Discounts...)
+	for i := range c.Discounts {
+		c.Discounts[i].ItemKeys = append([]string(nil), c.Discounts[i].ItemKeys...)
+	}
+	return &c
+}
+
+// MemorySearchRepository is a SearchRepository that scans the products held by
+// a MemoryProductRepository, matching terms against word prefixes.
+type MemorySearchRepository struct {
+	products *MemoryProductRepository
+}
+
+// NewMemorySearchRepository returns a search repository over the given products.
+func NewMemorySearchRepository(products *MemoryProductRepository) *MemorySearchRepository {
+	return &MemorySearchRepository{products: products}
+}
+
+// Search returns the products matching every term of query, weighting matches
+// in the name above tags and SKUs, and those above the description.
+func (r *MemorySearchRepository) Search(ctx context.Context, query string, limit int) ([]SearchHit, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	products, err := r.products.List(ctx, ProductFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	var hits []SearchHit
+	for _, p := range products {
+		var skus []string
+		for _, v := range p.Variants {
+			skus = append(skus, v.SKU)
+		}
+		fields := []struct {
+			text   string
+			weight float64
+		}{
+			{p.Name, 10}, {strings.Join(p.Tags, " "), 5}, {strings.Join(skus, " "), 5}, {p.Description, 1},
+		}
+
+		rank := 0.0
+		matchedAll := true
+		for _, t := range terms {
+			matched := false
+			for _, f := range fields {
+				if n := countPrefixMatches(f.text, t); n > 0 {
+					rank += f.weight * float64(n)
+					matched = true
+				}
+			}
+			if !matched {
+				matchedAll = false
+				break
+			}
+		}
+		if !matchedAll {
+			continue
+		}
+		hits = append(hits, SearchHit{
+			Product: p,
+			Name:    highlightHTML(markPrefixMatches(p.Name, terms)),
+			Snippet: highlightHTML(markPrefixMatches(p.Description, terms)),
+			Rank:    rank,
+		})
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Rank > hits[j].Rank })
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+// countPrefixMatches counts the words of text that start with term.
+func countPrefixMatches(text, term string) int {
+	n := 0
+	for _, word := range searchTerms(text) {
+		if strings.HasPrefix(word, term) {
+			n++
+		}
+	}
+	return n
+}
+
+// markPrefixMatches wraps the words of text that start with any of terms in
+// the highlight markers.
+func markPrefixMatches(text string, terms []string) string {
+	var b strings.Builder
+	start := -1
+	flush := func(end int) {
+		word := text[start:end]
+		lower := strings.ToLower(word)
+		for _, t := range terms {
+			if strings.HasPrefix(lower, t) {
+				word = highlightStart + word + highlightEnd
+				break
+			}
+		}
+		b.WriteString(word)
+		start = -1
+	}
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsNumber(r)
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord {
+			if start >= 0 {
+				flush(i)
+			}
+			b.WriteRune(r)
+		}
+	}
+	if start >= 0 {
+		flush(len(text))
+	}
+	return b.String()
+}
+
+// MemoryImageRepository is an ImageRepository that keeps images on the
+// products held by a MemoryProductRepository.
+type MemoryImageRepository struct {
+	products *MemoryProductRepository
+}
+
+// NewMemoryImageRepository returns an image repository for the given products.
+func NewMemoryImageRepository(products *MemoryProductRepository) *MemoryImageRepository {
+	return &MemoryImageRepository{products: products}
+}
+
+// List returns the product's images in display order.
+func (r *MemoryImageRepository) List(ctx context.Context, productID string) ([]ProductImage, error) {
+	r.products.mu.RLock()
+	defer r.products.mu.RUnlock()
+
+	return append([]ProductImage(nil), r.products.products[productID].Images...), nil
+}
+
+// Add appends the image to the product's images.
+func (r *MemoryImageRepository) Add(ctx context.Context, img ProductImage) error {
+	r.products.mu.Lock()
+	defer r.products.mu.Unlock()
+
+	p, ok := r.products.products[img.ProductID]
+	if !ok {
+		return fmt.Errorf("product with ID %s: %w", img.ProductID, ErrNotFound)
+	}
+	img.Position = len(p.Images)
+	p.Images = append(append([]ProductImage(nil), p.Images...), img)
+	r.products.products[p.ID] = p
+	return nil
+}
+
+// Delete removes the image from the product and returns it.
+func (r *MemoryImageRepository) Delete(ctx context.Context, productID, id string) (ProductImage, error) {
+	r.products.mu.Lock()
+	defer r.products.mu.Unlock()
+
+	p := r.products.products[productID]
+	for i, img := range p.Images {
+		if img.ID == id {
+			images := append([]ProductImage(nil), p.Images[:i]...)
+			p.Images = append(images, p.Images[i+1:]...)
+			r.products.products[productID] = p
+			return img, nil
+		}
+	}
+	return ProductImage{}, fmt.Errorf("image %s of product %s: %w", id, productID, ErrNotFound)
+}
+
+// Reorder puts the product's images in the order of ids.
+func (r *MemoryImageRepository) Reorder(ctx context.Context, productID string, ids []string) error {
+	r.products.mu.Lock()
+	defer r.products.mu.Unlock()
+
+	p, ok := r.products.products[productID]
+	if !ok {
+		return fmt.Errorf("product with ID %s: %w", productID, ErrNotFound)
+	}
+	images := make([]ProductImage, len(p.Images))
+	copy(images, p.Images)
+	for i := range images {
+		images[i].Position = len(ids) // images not listed go last
+		for position, id := range ids {
+			if images[i].ID == id {
+				images[i].Position = position
+			}
+		}
+	}
+	sort.SliceStable(images, func(i, j int) bool { return images[i].Position < images[j].Position })
+	p.Images = images
+	r.products.products[productID] = p
+	return nil
+}
+
+// MemoryWebhookEventRepository is a WebhookEventRepository that keeps events in memory.
+type MemoryWebhookEventRepository struct {
+	mu     sync.Mutex
+	events map[string]*WebhookEvent
+}
+
+// NewMemoryWebhookEventRepository returns an empty in-memory webhook event repository.
+func NewMemoryWebhookEventRepository() *MemoryWebhookEventRepository {
+	return &MemoryWebhookEventRepository{events: make(map[string]*WebhookEvent)}
+}
+
+// Claim stores the event, or takes back a failed or stale one, as processing.
+func (r *MemoryWebhookEventRepository) Claim(ctx context.Context, e WebhookEvent) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	stored, ok := r.events[e.ID]
+	if !ok {
+		e.Payload = append([]byte(nil), e.Payload...)
+		e.Outcome, e.Error, e.Attempts, e.ClaimedAt, e.ProcessedAt = WebhookProcessing, "", 1, now, time.Time{}
+		r.events[e.ID] = &e
+		return true, nil
+	}
+	stale := stored.Outcome == WebhookProcessing && stored.ClaimedAt.Before(now.Add(-webhookClaimTimeout))
+	if stored.Outcome != WebhookFailed && !stale {
+		return false, nil
+	}
+	stored.Outcome, stored.Error, stored.ClaimedAt, stored.ProcessedAt = WebhookProcessing, "", now, time.Time{}
+	stored.Attempts++
+	return true, nil
+}
+
+// Restart sets the event back to processing.
+func (r *MemoryWebhookEventRepository) Restart(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.events[id]
+	if !ok {
+		return fmt.Errorf("webhook event %s: %w", id, ErrNotFound)
+	}
+	stored.Outcome, stored.Error, stored.ClaimedAt, stored.ProcessedAt = WebhookProcessing, "", time.Now(), time.Time{}
+	stored.Attempts++
+	return nil
+}
+
+// Finish records the outcome of handling the event.
+func (r *MemoryWebhookEventRepository) Finish(ctx context.Context, id string, outcome WebhookOutcome, message string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stored, ok := r.events[id]; ok {
+		stored.Outcome, stored.Error, stored.ProcessedAt = outcome, message, time.Now()
+	}
+	return nil
+}
+
+// GetByID returns a copy of the event with the given ID.
+func (r *MemoryWebhookEventRepository) GetByID(ctx context.Context, id string) (WebhookEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.events[id]
+	if !ok {
+		return WebhookEvent{}, fmt.Errorf("webhook event %s: %w", id, ErrNotFound)
+	}
+	return *stored, nil
+}
+
+// List returns copies of up to limit events, most recently received first.
+func (r *MemoryWebhookEventRepository) List(ctx context.Context, outcome WebhookOutcome, limit int) ([]WebhookEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var events []WebhookEvent
+	for _, e := range r.events {
+		if outcome == "" || e.Outcome == outcome {
+			events = append(events, *e)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].ReceivedAt.Equal(events[j].ReceivedAt) {
+			return events[i].ReceivedAt.After(events[j].ReceivedAt)
+		}
+		return events[i].ID < events[j].ID
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// MemoryRefundRepository is a RefundRepository that keeps refunds in memory
+// and their amounts on the orders held by a MemoryOrderRepository.
+type MemoryRefundRepository struct {
+	mu      sync.Mutex
+	orders  *MemoryOrderRepository
+	refunds []Refund
+	nextID  int64
+}
+
+// NewMemoryRefundRepository returns an empty in-memory refund repository for
+// the orders.
+func NewMemoryRefundRepository(orders *MemoryOrderRepository) *MemoryRefundRepository {
+	return &MemoryRefundRepository{orders: orders}
+}
+
+// Create adds the refund's amount to the order and stores a copy of the
+// refund, setting its ID.
+func (r *MemoryRefundRepository) Create(ctx context.Context, o *Order, refund *Refund) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.orders.AddRefunded(ctx, o, refund.Amount); err != nil {
+		return err
+	}
+	r.nextID++
+	refund.ID = r.nextID
+	stored := *refund
+	stored.Items = append([]RefundItem(nil), refund.Items...)
+	r.refunds = append(r.refunds, stored)
+	return nil
+}
+
+// Update saves the refund's ProviderID and Restocked.
+func (r *MemoryRefundRepository) Update(ctx context.Context, refund *Refund) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.refunds {
+		if r.refunds[i].ID == refund.ID {
+			r.refunds[i].ProviderID, r.refunds[i].Restocked = refund.ProviderID, refund.Restocked
+			return nil
+		}
+	}
+	return fmt.Errorf("refund %d: %w", refund.ID, ErrNotFound)
+}
+
+// Delete removes the refund and takes its amount off the order.
+func (r *MemoryRefundRepository) Delete(ctx context.Context, o *Order, refund *Refund) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := slices.IndexFunc(r.refunds, func(stored Refund) bool { return stored.ID == refund.ID })
+	if i < 0 {
+		return fmt.Errorf("refund %d: %w", refund.ID, ErrNotFound)
+	}
+	r.refunds = slices.Delete(r.refunds, i, i+1)
+
+	r.orders.mu.Lock()
+	defer r.orders.mu.Unlock()
+	if stored, ok := r.orders.orders[o.ID]; ok {
+		stored.RefundedAmount.Amount -= refund.Amount.Amount
+		stored.UpdatedAt = time.Now()
+		o.RefundedAmount.Amount = stored.RefundedAmount.Amount
+	}
+	return nil
+}
+
+// ListByOrder returns copies of the refunds of the order, oldest first.
+func (r *MemoryRefundRepository) ListByOrder(ctx context.Context, orderID string) ([]Refund, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var refunds []Refund
+	for _, refund := range r.refunds {
+		if refund.OrderID == orderID {
+			refund.Items = append([]RefundItem(nil), refund.Items...)
+			refunds = append(refunds, refund)
+		}
+	}
+	return refunds, nil
+}
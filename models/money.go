@@ -0,0 +1,146 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the ISO 4217 code used when no currency is specified.
+const DefaultCurrency = "USD"
+
+// Money is an exact amount in the minor unit of its currency (e.g. cents).
+type Money struct {
+	Amount   int64  `json:"amount"`   // minor units, e.g. 2999 for $29.99
+	Currency string `json:"currency"` // ISO 4217 code, e.g. "USD"
+}
+
+// SupportedCurrencies are the currencies customers can shop in, in the order
+// they are offered by the currency selector.
+var SupportedCurrencies = []string{"USD", "EUR", "GBP"}
+
+// currencyInfo describes how a currency is written.
+type currencyInfo struct {
+	Symbol   string
+	Exponent int    // number of minor-unit digits, e.g. 2 for cents
+	Locale   string // locale used when the customer's language gives no better choice
+}
+
+// currencies lists the currencies the store knows how to format.
+var currencies = map[string]currencyInfo{
+	"USD": {Symbol: "$", Exponent: 2, Locale: "en-US"},
+	"EUR": {Symbol: "€", Exponent: 2, Locale: "en-IE"},
+	"GBP": {Symbol: "£", Exponent: 2, Locale: "en-GB"},
+	"JPY": {Symbol: "¥", Exponent: 0, Locale: "ja-JP"},
+}
+
+// IsSupportedCurrency reports whether customers can shop in the given currency.
+func IsSupportedCurrency(code string) bool {
+	for _, c := range SupportedCurrencies {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupCurrency returns the formatting info for code, falling back to two
+// minor-unit digits and the code itself as the symbol.
+func lookupCurrency(code string) currencyInfo {
+	if info, ok := currencies[code]; ok {
+		return info
+	}
+	return currencyInfo{Symbol: code + " ", Exponent: 2, Locale: "en-US"}
+}
+
+// NewMoney returns an amount of minor units in the given currency.
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: strings.ToUpper(currency)}
+}
+
+// ParseMoney parses a decimal string such as "29.99" into minor units without
+// going through floating point. An empty string is not an amount, so callers
+// with optional amounts check for it first.
+func ParseMoney(s, currency string) (Money, error) {
+	currency = strings.ToUpper(currency)
+	exponent := lookupCurrency(currency).Exponent
+
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return Money{}, fmt.Errorf("invalid amount %q", s)
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	if len(frac) > exponent {
+		return Money{}, fmt.Errorf("amount %q has more than %d decimal places", s, exponent)
+	}
+	frac += strings.Repeat("0", exponent-len(frac))
+
+	amount, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil || strings.ContainsAny(whole+frac, "+-") {
+		return Money{}, fmt.Errorf("invalid amount %q", s)
+	}
+	if negative {
+		amount = -amount
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// Add returns m + other. A zero Money with no currency takes on the currency
+// of other; otherwise mixing currencies is a programming error and panics.
+// Amounts are checked where they come in so that adding them cannot: product
+// prices by Validate and PriceIn, coupons by evaluateCoupon, shipping rates by
+// ShippingTable.Rates, tax by ApplyTax and stored refunds by RefundedAmount.
+func (m Money) Add(other Money) Money {
+	switch {
+	case m.Currency == "":
+		m.Currency = other.Currency
+	case other.Currency != "" && other.Currency != m.Currency:
+		panic(fmt.Sprintf("models: cannot add %s to %s", other.Currency, m.Currency))
+	}
+	m.Amount += other.Amount
+	return m
+}
+
+// Mul returns m multiplied by a quantity.
+func (m Money) Mul(quantity int) Money {
+	return Money{Amount: m.Amount * int64(quantity), Currency: m.Currency}
+}
+
+// IsZero reports whether the amount is zero.
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Decimal returns the amount as a plain decimal string, e.g. "29.99".
+func (m Money) Decimal() string {
+	exponent := lookupCurrency(m.Currency).Exponent
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	if exponent == 0 {
+		return sign + strconv.FormatInt(amount, 10)
+	}
+
+	digits := fmt.Sprintf("%0*d", exponent+1, amount)
+	split := len(digits) - exponent
+	return sign + digits[:split] + "." + digits[split:]
+}
+
+// String formats the amount with its currency symbol, e.g. "$29.99".
+func (m Money) String() string {
+	info := lookupCurrency(m.Currency)
+	decimal := m.Decimal()
+	if strings.HasPrefix(decimal, "-") {
+		return "-" + info.Symbol + decimal[1:]
+	}
+	return info.Symbol + decimal
+}
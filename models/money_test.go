@@ -0,0 +1,90 @@
+package models
+
+import "testing"
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in, currency string
+		want         Money
+		wantErr      bool
+	}{
+		{in: "29.99", currency: "USD", want: Money{2999, "USD"}},
+		{in: "29.9", currency: "usd", want: Money{2990, "USD"}},
+		{in: "29", currency: "EUR", want: Money{2900, "EUR"}},
+		{in: " 0.05 ", currency: "GBP", want: Money{5, "GBP"}},
+		{in: ".5", currency: "USD", want: Money{50, "USD"}},
+		{in: "-1.25", currency: "USD", want: Money{-125, "USD"}},
+		{in: "1500", currency: "JPY", want: Money{1500, "JPY"}},
+		{in: "1.5", currency: "JPY", wantErr: true},
+		{in: "1.999", currency: "USD", wantErr: true},
+		{in: "", currency: "USD", wantErr: true},
+		{in: "  ", currency: "USD", wantErr: true},
+		{in: "-", currency: "USD", wantErr: true},
+		{in: ".", currency: "USD", wantErr: true},
+		{in: "abc", currency: "USD", wantErr: true},
+		{in: "1.-5", currency: "USD", wantErr: true},
+		{in: "--1", currency: "USD", wantErr: true},
+		{in: "+1", currency: "USD", wantErr: true},
+		{in: "1,000", currency: "USD", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in+" "+tt.currency, func(t *testing.T) {
+			got, err := ParseMoney(tt.in, tt.currency)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseMoney(%q) = %v, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseMoney(%q): %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseMoney(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyFormat(t *testing.T) {
+	tests := []struct {
+		money         Money
+		decimal, text string
+	}{
+		{Money{2999, "USD"}, "29.99", "$29.99"},
+		{Money{5, "USD"}, "0.05", "$0.05"},
+		{Money{0, "EUR"}, "0.00", "€0.00"},
+		{Money{-125, "GBP"}, "-1.25", "-£1.25"},
+		{Money{1500, "JPY"}, "1500", "¥1500"},
+		{Money{-7, "JPY"}, "-7", "-¥7"},
+		{Money{1234, "CHF"}, "12.34", "CHF 12.34"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := tt.money.Decimal(); got != tt.decimal {
+				t.Errorf("%+v.Decimal() = %q, want %q", tt.money, got, tt.decimal)
+			}
+			if got := tt.money.String(); got != tt.text {
+				t.Errorf("%+v.String() = %q, want %q", tt.money, got, tt.text)
+			}
+			parsed, err := ParseMoney(tt.decimal, tt.money.Currency)
+			if err != nil || parsed != tt.money {
+				t.Errorf("ParseMoney(%q) = %+v, %v; want %+v", tt.decimal, parsed, err, tt.money)
+			}
+		})
+	}
+}
+
+func TestMoneyAdd(t *testing.T) {
+	total := Money{}.Add(Money{100, "USD"}).Add(Money{25, "USD"}).Add(Money{})
+	if want := (Money{125, "USD"}); total != want {
+		t.Errorf("sum = %+v, want %+v", total, want)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("adding EUR to USD did not panic")
+		}
+	}()
+	Money{100, "USD"}.Add(Money{100, "EUR"})
+}
@@ -0,0 +1,471 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SQLOrderRepository is an OrderRepository backed by the orders and order_items tables.
+type SQLOrderRepository struct {
+	sqlRepository
+}
+
+// Save saves the order and its items to the database. An existing order keeps
+// its status, checkout session, payment and refunded amount, which change only
+// through UpdateStatus, SetCheckoutSession, UpdatePayment and AddRefunded.
+func (r *SQLOrderRepository) Save(ctx context.Context, o *Order) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if commit fails
+
+	// Insert or update order
+	addr := o.ShippingAddress
+	_, err = tx.ExecContext(ctx,
+		r.q("INSERT INTO orders (id, customer_email, total_amount, currency, status, stripe_id, payment_intent_id, charge_id, dispute_status, tax_inclusive, "+
+			"ship_name, ship_line1, ship_line2, ship_city, ship_state, ship_postal_code, ship_country, shipping_method, shipping_name, shipping_amount, created_at, updated_at) "+
+			"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT(id) DO UPDATE SET customer_email=excluded.customer_email, total_amount=excluded.total_amount, "+
+			"currency=excluded.currency, tax_inclusive=excluded.tax_inclusive, ship_name=excluded.ship_name, ship_line1=excluded.ship_line1, "+
+			"ship_line2=excluded.ship_line2, ship_city=excluded.ship_city, ship_state=excluded.ship_state, ship_postal_code=excluded.ship_postal_code, ship_country=excluded.ship_country, "+
+			"shipping_method=excluded.shipping_method, shipping_name=excluded.shipping_name, shipping_amount=excluded.shipping_amount, updated_at=excluded.updated_at"),
+		o.ID, o.CustomerEmail, o.TotalAmount.Amount, o.TotalAmount.Currency, string(o.Status), o.StripeID, o.PaymentIntentID, o.ChargeID, o.DisputeStatus, o.TaxInclusive,
+		addr.Name, addr.Line1, addr.Line2, addr.City, addr.State, addr.PostalCode, addr.Country, o.Shipping.Method, o.Shipping.Name, o.Shipping.Amount.Amount,
+		o.CreatedAt, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("error saving order %s: %w", o.ID, err)
+	}
+
+	// Delete existing order items for this order (simpler for now, could optimize)
+	_, err = tx.ExecContext(ctx, r.q("DELETE FROM order_items WHERE order_id = ?"), o.ID)
+	if err != nil {
+		return fmt.Errorf("error deleting existing order items for order %s: %w", o.ID, err)
+	}
+
+	_, err = tx.ExecContext(ctx, r.q("DELETE FROM order_item_taxes WHERE order_id = ?"), o.ID)
+	if err != nil {
+		return fmt.Errorf("error deleting existing item taxes for order %s: %w", o.ID, err)
+	}
+
+	// Insert order items, with the taxes on each numbered by the item's place in the order
+	for i, item := range o.Items {
+		_, err := tx.ExecContext(ctx,
+			r.q("INSERT INTO order_items (order_id, product_id, product_name, variant_id, sku, variant_title, tax_class, quantity, unit_price) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+			o.ID, item.ProductID, item.ProductName, item.VariantID, item.SKU, item.VariantTitle, item.TaxClass, item.Quantity, item.UnitPrice.Amount,
+		)
+		if err != nil {
+			return fmt.Errorf("error saving order item for order %s: %w", o.ID, err)
+		}
+		for j, tax := range item.Taxes {
+			_, err := tx.ExecContext(ctx,
+				r.q("INSERT INTO order_item_taxes (order_id, item_position, position, name, rate, amount) VALUES (?, ?, ?, ?, ?, ?)"),
+				o.ID, i, j, tax.Name, tax.Rate, tax.Amount.Amount,
+			)
+			if err != nil {
+				return fmt.Errorf("error saving item tax for order %s: %w", o.ID, err)
+			}
+		}
+	}
+
+	if err := r.checkCouponLimits(ctx, tx, o); err != nil {
+		return err
+	}
+
+	// Replace the discounts the same way
+	_, err = tx.ExecContext(ctx, r.q("DELETE FROM order_discounts WHERE order_id = ?"), o.ID)
+	if err != nil {
+		return fmt.Errorf("error deleting existing discounts for order %s: %w", o.ID, err)
+	}
+	_, err = tx.ExecContext(ctx, r.q("DELETE FROM order_discount_items WHERE order_id = ?"), o.ID)
+	if err != nil {
+		return fmt.Errorf("error deleting existing discount items for order %s: %w", o.ID, err)
+	}
+	for i, d := range o.Discounts {
+		_, err := tx.ExecContext(ctx,
+			r.q("INSERT INTO order_discounts (order_id, position, code, description, amount, free_shipping) VALUES (?, ?, ?, ?, ?, ?)"),
+			o.ID, i, d.Code, d.Description, d.Amount.Amount, d.FreeShipping,
+		)
+		if err != nil {
+			return fmt.Errorf("error saving discount for order %s: %w", o.ID, err)
+		}
+		for _, key := range uniqueStrings(d.ItemKeys) {
+			_, err := tx.ExecContext(ctx, r.q("INSERT INTO order_discount_items (order_id, position, item_key) VALUES (?, ?, ?)"), o.ID, i, key)
+			if err != nil {
+				return fmt.Errorf("error saving discount item for order %s: %w", o.ID, err)
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	log.Printf("Order %s saved to database.", o.ID)
+	return nil
+}
+
+// checkCouponLimits returns a *CouponError if other orders have used up one of
+// the order's coupons. Each coupon's row is locked until tx ends, so that
+// orders using the same coupon are checked and saved one at a time and cannot
+// take it past its limits together.
+func (r *SQLOrderRepository) checkCouponLimits(ctx context.Context, tx *sql.Tx, o *Order) error {
+	codes := o.CouponCodes()
+	slices.Sort(codes) // always lock in the same order
+	for _, code := range codes {
+		var maxUses, maxUsesPerCustomer int
+		err := tx.QueryRowContext(ctx,
+			r.q("UPDATE coupons SET code = code WHERE code = ? RETURNING max_uses, max_uses_per_customer"), code,
+		).Scan(&maxUses, &maxUsesPerCustomer)
+		if err == sql.ErrNoRows {
+			continue // not a stored coupon, so it has no limits
+		}
+		if err != nil {
+			return fmt.Errorf("error locking coupon %s for order %s: %w", code, o.ID, err)
+		}
+		if maxUses == 0 && maxUsesPerCustomer == 0 {
+			continue
+		}
+
+		var total, byCustomer int
+		err = tx.QueryRowContext(ctx,
+			r.q(couponUsesQuery+" AND o.id <> ?"),
+			strings.ToLower(o.CustomerEmail), code, string(OrderStatusCancelled), string(OrderStatusExpired), o.ID,
+		).Scan(&total, &byCustomer)
+		if err != nil {
+			return fmt.Errorf("error counting uses of coupon %s: %w", code, err)
+		}
+		if maxUses > 0 && total >= maxUses {
+			return &CouponError{Code: code, Problem: CouponUsedUp}
+		}
+		if maxUsesPerCustomer > 0 && byCustomer >= maxUsesPerCustomer {
+			return &CouponError{Code: code, Problem: CouponUsedByCustomer}
+		}
+	}
+	return nil
+}
+
+// GetByID retrieves an order by its ID.
+func (r *SQLOrderRepository) GetByID(ctx context.Context, id string) (*Order, error) {
+	return r.getOrder(ctx, "id", id)
+}
+
+// GetByStripeID retrieves an order by its Stripe Checkout Session ID.
+func (r *SQLOrderRepository) GetByStripeID(ctx context.Context, stripeID string) (*Order, error) {
+	return r.getOrder(ctx, "stripe_id", stripeID)
+}
+
+// orderColumns are the columns of the orders table read by scanOrder.
+const orderColumns = "id, customer_email, total_amount, currency, status, stripe_id, payment_intent_id, charge_id, dispute_status, refunded_amount, tax_inclusive, " +
+	"ship_name, ship_line1, ship_line2, ship_city, ship_state, ship_postal_code, ship_country, shipping_method, shipping_name, shipping_amount, created_at, updated_at"
+
+// scanOrder reads an order, without its items, from a row of orderColumns.
+func scanOrder(row interface{ Scan(...any) error }) (*Order, error) {
+	order := &Order{}
+	var statusStr string
+	addr := &order.ShippingAddress
+	err := row.Scan(&order.ID, &order.CustomerEmail, &order.TotalAmount.Amount, &order.TotalAmount.Currency, &statusStr, &order.StripeID,
+		&order.PaymentIntentID, &order.ChargeID, &order.DisputeStatus, &order.RefundedAmount.Amount, &order.TaxInclusive,
+		&addr.Name, &addr.Line1, &addr.Line2, &addr.City, &addr.State, &addr.PostalCode, &addr.Country, &order.Shipping.Method, &order.Shipping.Name, &order.Shipping.Amount.Amount,
+		&order.CreatedAt, &order.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	order.Status = OrderStatus(statusStr)
+	order.Shipping.Amount.Currency = order.TotalAmount.Currency
+	order.RefundedAmount.Currency = order.TotalAmount.Currency
+	return order, nil
+}
+
+// GetByPaymentIntentID retrieves an order by its Stripe PaymentIntent ID.
+func (r *SQLOrderRepository) GetByPaymentIntentID(ctx context.Context, paymentIntentID string) (*Order, error) {
+	return r.getOrder(ctx, "payment_intent_id", paymentIntentID)
+}
+
+// getOrder retrieves a single order and its items, matching on the given column.
+func (r *SQLOrderRepository) getOrder(ctx context.Context, column, value string) (*Order, error) {
+	row := r.conn.QueryRowContext(ctx, r.q("SELECT "+orderColumns+" FROM orders WHERE "+column+" = ?"), value)
+
+	order, err := scanOrder(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("order with %s %s: %w", column, value, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching order by %s %s: %w", column, value, err)
+	}
+
+	// Fetch order items
+	rows, err := r.conn.QueryContext(ctx, r.q("SELECT id, order_id, product_id, product_name, variant_id, sku, variant_title, tax_class, quantity, unit_price FROM order_items WHERE order_id = ? ORDER BY id"), order.ID)
+	if err != nil {
+		return order, fmt.Errorf("error fetching order items for order %s: %w", order.ID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item OrderItem
+		if err := rows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.ProductName, &item.VariantID, &item.SKU, &item.VariantTitle, &item.TaxClass, &item.Quantity, &item.UnitPrice.Amount); err != nil {
+			return order, fmt.Errorf("error scanning order item row for order %s: %w", order.ID, err)
+		}
+		// Items are always charged in the order's currency
+		item.UnitPrice.Currency = order.TotalAmount.Currency
+		order.Items = append(order.Items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return order, fmt.Errorf("error after iterating through order item rows for order %s: %w", order.ID, err)
+	}
+
+	// Fetch the taxes on each item
+	taxRows, err := r.conn.QueryContext(ctx, r.q("SELECT item_position, name, rate, amount FROM order_item_taxes WHERE order_id = ? ORDER BY item_position, position"), order.ID)
+	if err != nil {
+		return order, fmt.Errorf("error fetching item taxes for order %s: %w", order.ID, err)
+	}
+	defer taxRows.Close()
+
+	for taxRows.Next() {
+		var i int
+		var tax TaxLine
+		if err := taxRows.Scan(&i, &tax.Name, &tax.Rate, &tax.Amount.Amount); err != nil {
+			return order, fmt.Errorf("error scanning item tax row for order %s: %w", order.ID, err)
+		}
+		if i < 0 || i >= len(order.Items) {
+			continue
+		}
+		tax.Amount.Currency = order.TotalAmount.Currency
+		order.Items[i].Taxes = append(order.Items[i].Taxes, tax)
+	}
+
+	if err := taxRows.Err(); err != nil {
+		return order, fmt.Errorf("error after iterating through item tax rows for order %s: %w", order.ID, err)
+	}
+
+	// Fetch the discounts
+	discountRows, err := r.conn.QueryContext(ctx, r.q("SELECT code, description, amount, free_shipping FROM order_discounts WHERE order_id = ? ORDER BY position"), order.ID)
+	if err != nil {
+		return order, fmt.Errorf("error fetching discounts for order %s: %w", order.ID, err)
+	}
+	defer discountRows.Close()
+
+	for discountRows.Next() {
+		var d Discount
+		if err := discountRows.Scan(&d.Code, &d.Description, &d.Amount.Amount, &d.FreeShipping); err != nil {
+			return order, fmt.Errorf("error scanning discount row for order %s: %w", order.ID, err)
+		}
+		d.Amount.Currency = order.TotalAmount.Currency
+		order.Discounts = append(order.Discounts, d)
+	}
+
+	if err := discountRows.Err(); err != nil {
+		return order, fmt.Errorf("error after iterating through discount rows for order %s: %w", order.ID, err)
+	}
+
+	// Fetch the lines each discount applies to
+	discountItemRows, err := r.conn.QueryContext(ctx, r.q("SELECT position, item_key FROM order_discount_items WHERE order_id = ? ORDER BY position, item_key"), order.ID)
+	if err != nil {
+		return order, fmt.Errorf("error fetching discount items for order %s: %w", order.ID, err)
+	}
+	defer discountItemRows.Close()
+
+	for discountItemRows.Next() {
+		var i int
+		var key string
+		if err := discountItemRows.Scan(&i, &key); err != nil {
+			return order, fmt.Errorf("error scanning discount item row for order %s: %w", order.ID, err)
+		}
+		if i < 0 || i >= len(order.Discounts) {
+			continue
+		}
+		order.Discounts[i].ItemKeys = append(order.Discounts[i].ItemKeys, key)
+	}
+
+	if err := discountItemRows.Err(); err != nil {
+		return order, fmt.Errorf("error after iterating through discount item rows for order %s: %w", order.ID, err)
+	}
+
+	return order, nil
+}
+
+// List returns the orders matching the filter, newest first. The orders are
+// loaded without their items, discounts and taxes.
+func (r *SQLOrderRepository) List(ctx context.Context, filter OrderFilter) ([]*Order, error) {
+	var where []string
+	var args []any
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, string(filter.Status))
+	}
+	if !filter.CreatedBefore.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, filter.CreatedBefore)
+	}
+	if filter.Unreconciled {
+		where = append(where, "reconciled_at IS NULL AND (stripe_id <> '' OR status = ?)")
+		args = append(args, string(OrderStatusPending))
+	}
+
+	query := "SELECT " + orderColumns + " FROM orders"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := r.conn.QueryContext(ctx, r.q(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing orders: %w", err)
+	}
+	defer rows.Close()
+
+	var orders []*Order
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning order row: %w", err)
+		}
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating through order rows: %w", err)
+	}
+	return orders, nil
+}
+
+// UpdateStatus moves the order to change.To if it still has the status
+// change.From, and records the change in order_status_history.
+func (r *SQLOrderRepository) UpdateStatus(ctx context.Context, o *Order, change OrderStatusChange) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if commit fails
+
+	res, err := tx.ExecContext(ctx, r.q("UPDATE orders SET status = ?, updated_at = ? WHERE id = ? AND status = ?"),
+		string(change.To), change.CreatedAt, o.ID, string(change.From))
+	if err != nil {
+		return fmt.Errorf("error updating status for order %s: %w", o.ID, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("error updating status for order %s: %w", o.ID, err)
+	} else if n == 0 {
+		// Either the order does not exist or another request moved it on first
+		var current string
+		err := tx.QueryRowContext(ctx, r.q("SELECT status FROM orders WHERE id = ?"), o.ID).Scan(&current)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("order %s: %w", o.ID, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching status of order %s: %w", o.ID, err)
+		}
+		return fmt.Errorf("order %s is %s, not %s: %w", o.ID, current, change.From, ErrInvalidTransition)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		r.q("INSERT INTO order_status_history (order_id, from_status, to_status, actor, reason, created_at) VALUES (?, ?, ?, ?, ?, ?)"),
+		o.ID, string(change.From), string(change.To), change.Actor, change.Reason, change.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("error recording status change for order %s: %w", o.ID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	o.Status = change.To
+	o.UpdatedAt = change.CreatedAt
+	log.Printf("Order %s status updated from %s to %s by %s.", o.ID, change.From, change.To, change.Actor)
+	return nil
+}
+
+// SetCheckoutSession records the ID of the order's checkout session.
+func (r *SQLOrderRepository) SetCheckoutSession(ctx context.Context, orderID, stripeID string) error {
+	res, err := r.conn.ExecContext(ctx,
+		r.q("UPDATE orders SET stripe_id = ?, updated_at = ? WHERE id = ?"),
+		stripeID, time.Now(), orderID,
+	)
+	if err != nil {
+		return fmt.Errorf("error setting checkout session of order %s: %w", orderID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("order %s: %w", orderID, ErrNotFound)
+	}
+	return nil
+}
+
+// UpdatePayment saves the order's payment intent, charge and dispute status.
+func (r *SQLOrderRepository) UpdatePayment(ctx context.Context, o *Order) error {
+	now := time.Now()
+	_, err := r.conn.ExecContext(ctx,
+		r.q("UPDATE orders SET payment_intent_id = ?, charge_id = ?, dispute_status = ?, updated_at = ? WHERE id = ?"),
+		o.PaymentIntentID, o.ChargeID, o.DisputeStatus, now, o.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating payment of order %s: %w", o.ID, err)
+	}
+	o.UpdatedAt = now
+	return nil
+}
+
+// AddRefunded adds amount to the order's refunded amount, if that is still the
+// one o has.
+func (r *SQLOrderRepository) AddRefunded(ctx context.Context, o *Order, amount Money) error {
+	res, err := r.conn.ExecContext(ctx,
+		r.q("UPDATE orders SET refunded_amount = refunded_amount + ?, updated_at = ? WHERE id = ? AND refunded_amount = ?"),
+		amount.Amount, time.Now(), o.ID, o.RefundedAmount.Amount,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating refunded amount of order %s: %w", o.ID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error updating refunded amount of order %s: %w", o.ID, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("refunded amount of order %s changed: %w", o.ID, ErrConflict)
+	}
+	o.RefundedAmount.Amount += amount.Amount
+	return nil
+}
+
+// MarkReconciled sets the order's reconciled_at.
+func (r *SQLOrderRepository) MarkReconciled(ctx context.Context, orderID string, at time.Time) error {
+	_, err := r.conn.ExecContext(ctx, r.q("UPDATE orders SET reconciled_at = ? WHERE id = ?"), at, orderID)
+	if err != nil {
+		return fmt.Errorf("error marking order %s as reconciled: %w", orderID, err)
+	}
+	return nil
+}
+
+// StatusHistory returns the status changes of the order, oldest first.
+func (r *SQLOrderRepository) StatusHistory(ctx context.Context, orderID string) ([]OrderStatusChange, error) {
+	rows, err := r.conn.QueryContext(ctx,
+		r.q("SELECT order_id, from_status, to_status, actor, reason, created_at FROM order_status_history WHERE order_id = ? ORDER BY id"), orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching status history for order %s: %w", orderID, err)
+	}
+	defer rows.Close()
+
+	var history []OrderStatusChange
+	for rows.Next() {
+		var change OrderStatusChange
+		var from, to string
+		if err := rows.Scan(&change.OrderID, &from, &to, &change.Actor, &change.Reason, &change.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning status history row for order %s: %w", orderID, err)
+		}
+		change.From, change.To = OrderStatus(from), OrderStatus(to)
+		history = append(history, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating through status history rows for order %s: %w", orderID, err)
+	}
+	return history, nil
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// OrderStatus represents the status of an order
+type OrderStatus string
+
+const (
+	// OrderStatusPending means payment is in progress
+	OrderStatusPending OrderStatus = "pending"
+	// OrderStatusPaid means payment was successful and the order is waiting to be fulfilled
+	OrderStatusPaid OrderStatus = "paid"
+	// OrderStatusProcessing means the order is being picked and packed
+	OrderStatusProcessing OrderStatus = "processing"
+	// OrderStatusShipped means the order has left the warehouse
+	OrderStatusShipped OrderStatus = "shipped"
+	// OrderStatusDelivered means the order has reached the customer
+	OrderStatusDelivered OrderStatus = "delivered"
+	// OrderStatusCancelled means the customer or the store gave up on the checkout before payment
+	OrderStatusCancelled OrderStatus = "cancelled"
+	// OrderStatusExpired means the checkout session ran out before payment
+	OrderStatusExpired OrderStatus = "expired"
+	// OrderStatusRefunded means all of the payment was given back
+	OrderStatusRefunded OrderStatus = "refunded"
+	// OrderStatusPartiallyRefunded means part of the payment was given back
+	OrderStatusPartiallyRefunded OrderStatus = "partially_refunded"
+)
+
+// OrderStatuses lists every order status, in lifecycle order.
+var OrderStatuses = []OrderStatus{
+	OrderStatusPending, OrderStatusPaid, OrderStatusProcessing, OrderStatusShipped, OrderStatusDelivered,
+	OrderStatusCancelled, OrderStatusExpired, OrderStatusRefunded, OrderStatusPartiallyRefunded,
+}
+
+// orderTransitions lists the statuses each status may move to. Cancelled,
+// expired and refunded orders are final.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusPending:           {OrderStatusPaid, OrderStatusCancelled, OrderStatusExpired},
+	OrderStatusPaid:              {OrderStatusProcessing, OrderStatusShipped, OrderStatusRefunded, OrderStatusPartiallyRefunded},
+	OrderStatusProcessing:        {OrderStatusShipped, OrderStatusRefunded, OrderStatusPartiallyRefunded},
+	OrderStatusShipped:           {OrderStatusDelivered, OrderStatusRefunded, OrderStatusPartiallyRefunded},
+	OrderStatusDelivered:         {OrderStatusRefunded, OrderStatusPartiallyRefunded},
+	OrderStatusPartiallyRefunded: {OrderStatusProcessing, OrderStatusShipped, OrderStatusDelivered, OrderStatusRefunded},
+}
+
+// Label is the status as shown to people, e.g. "Partially refunded".
+func (s OrderStatus) Label() string {
+	switch s {
+	case OrderStatusPartiallyRefunded:
+		return "Partially refunded"
+	case "":
+		return ""
+	}
+	return strings.ToUpper(string(s[:1])) + string(s[1:])
+}
+
+// Next lists the statuses the order may move to from s.
+func (s OrderStatus) Next() []OrderStatus {
+	return orderTransitions[s]
+}
+
+// CanTransitionTo reports whether an order may move from s to the given status.
+func (s OrderStatus) CanTransitionTo(to OrderStatus) bool {
+	return slices.Contains(orderTransitions[s], to)
+}
+
+// ErrInvalidTransition is returned (wrapped) when an order cannot move from
+// its status to the one asked for.
+var ErrInvalidTransition = errors.New("invalid order status transition")
+
+// Actors that cause order status changes.
+const (
+	ActorCustomer = "customer" // the shopper, e.g. by leaving the payment page
+	ActorStripe   = "stripe"   // a Stripe webhook
+	ActorAdmin    = "admin"    // someone using the admin area
+	ActorSystem   = "system"   // the store itself, e.g. when checkout fails
+)
+
+// OrderStatusChange records one move of an order from one status to another.
+type OrderStatusChange struct {
+	OrderID   string      `json:"order_id"`
+	From      OrderStatus `json:"from"`
+	To        OrderStatus `json:"to"`
+	Actor     string      `json:"actor"`  // who or what made the change, one of the Actor constants
+	Reason    string      `json:"reason"` // optional, e.g. the Stripe event ID
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// UpdateOrderStatus moves the order to the given status and records who or
+// what caused it. It does nothing if the order already has the status, and
+// returns an error wrapping ErrInvalidTransition if the order may not move to
+// it, or if the stored order was moved on by someone else in the meantime.
+func UpdateOrderStatus(ctx context.Context, orders OrderRepository, o *Order, to OrderStatus, actor, reason string) error {
+	if o.Status == to {
+		return nil
+	}
+	if !o.Status.CanTransitionTo(to) {
+		return fmt.Errorf("order %s cannot move from %s to %s: %w", o.ID, o.Status, to, ErrInvalidTransition)
+	}
+	return orders.UpdateStatus(ctx, o, OrderStatusChange{
+		OrderID:   o.ID,
+		From:      o.Status,
+		To:        to,
+		Actor:     actor,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	})
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestOrderTransitions(t *testing.T) {
+	allowed := map[OrderStatus][]OrderStatus{
+		OrderStatusPending:           {OrderStatusPaid, OrderStatusCancelled, OrderStatusExpired},
+		OrderStatusPaid:              {OrderStatusProcessing, OrderStatusShipped, OrderStatusRefunded, OrderStatusPartiallyRefunded},
+		OrderStatusProcessing:        {OrderStatusShipped, OrderStatusRefunded, OrderStatusPartiallyRefunded},
+		OrderStatusShipped:           {OrderStatusDelivered, OrderStatusRefunded, OrderStatusPartiallyRefunded},
+		OrderStatusDelivered:         {OrderStatusRefunded, OrderStatusPartiallyRefunded},
+		OrderStatusPartiallyRefunded: {OrderStatusProcessing, OrderStatusShipped, OrderStatusDelivered, OrderStatusRefunded},
+		OrderStatusCancelled:         nil,
+		OrderStatusExpired:           nil,
+		OrderStatusRefunded:          nil,
+	}
+	for _, from := range OrderStatuses {
+		for _, to := range OrderStatuses {
+			want := false
+			for _, s := range allowed[from] {
+				want = want || s == to
+			}
+			if got := from.CanTransitionTo(to); got != want {
+				t.Errorf("%s.CanTransitionTo(%s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+}
+
+func TestUpdateOrderStatus(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		from    OrderStatus
+		to      OrderStatus
+		wantErr error
+	}{
+		{"pending to paid", OrderStatusPending, OrderStatusPaid, nil},
+		{"paid to shipped", OrderStatusPaid, OrderStatusShipped, nil},
+		{"same status", OrderStatusShipped, OrderStatusShipped, nil},
+		{"paid back to pending", OrderStatusPaid, OrderStatusPending, ErrInvalidTransition},
+		{"cancelled is final", OrderStatusCancelled, OrderStatusPaid, ErrInvalidTransition},
+		{"refunded is final", OrderStatusRefunded, OrderStatusPartiallyRefunded, ErrInvalidTransition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			order := NewOrder("ann@example.com", "USD")
+			order.Status = tt.from
+			if err := store.Orders.Save(ctx, order); err != nil {
+				t.Fatal(err)
+			}
+
+			err := UpdateOrderStatus(ctx, store.Orders, order, tt.to, ActorAdmin, "test")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateOrderStatus = %v, want %v", err, tt.wantErr)
+			}
+			want := tt.to
+			if tt.wantErr != nil {
+				want = tt.from
+			}
+			stored, err := store.Orders.GetByID(ctx, order.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if order.Status != want || stored.Status != want {
+				t.Errorf("status = %s, stored %s; want %s", order.Status, stored.Status, want)
+			}
+			history, err := store.Orders.StatusHistory(ctx, order.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed := tt.wantErr == nil && tt.from != tt.to; changed != (len(history) == 1) {
+				t.Errorf("history = %+v, want a change: %v", history, changed)
+			}
+		})
+	}
+}
+
+func TestUpdateOrderStatusStale(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	order := NewOrder("ann@example.com", "USD")
+	if err := store.Orders.Save(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := store.Orders.GetByID(ctx, order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateOrderStatus(ctx, store.Orders, order, OrderStatusPaid, ActorStripe, "paid"); err != nil {
+		t.Fatal(err)
+	}
+	// Based on pending, which another change has moved on from
+	if err := UpdateOrderStatus(ctx, store.Orders, stale, OrderStatusExpired, ActorStripe, "expired"); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("UpdateOrderStatus from a stale status = %v, want ErrInvalidTransition", err)
+	}
+}
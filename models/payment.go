@@ -0,0 +1,97 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+// PaymentProvider takes payment for orders on a checkout page it hosts, and
+// reports back through signed webhooks.
+type PaymentProvider interface {
+	// Name identifies the provider, e.g. "stripe".
+	Name() string
+	// CreateCheckoutSession starts a checkout for the order, records the
+	// session ID in order.StripeID and returns the URL to send the customer to.
+	// The caller is responsible for saving the order. The provider replaces
+	// {CHECKOUT_SESSION_ID} in successURL with the session ID.
+	CreateCheckoutSession(ctx context.Context, order *Order, successURL, cancelURL string) (string, error)
+	// VerifyWebhook checks the signature of a webhook delivery and returns its event.
+	VerifyWebhook(payload []byte, signature string) (PaymentEvent, error)
+	// Refund gives back the amount of the order's payment, and returns the
+	// provider's ID for the refund.
+	Refund(ctx context.Context, order *Order, amount Money, reason string) (string, error)
+	// RetrievePayment returns the state of a checkout session, or an error
+	// wrapping ErrNotFound if the provider does not know it.
+	RetrievePayment(ctx context.Context, sessionID string) (Payment, error)
+	// ExpireCheckoutSession closes an open checkout session, so that the
+	// customer can no longer pay for an order that was cancelled. It returns
+	// an error if the session is not open.
+	ExpireCheckoutSession(ctx context.Context, sessionID string) error
+}
+
+// PaymentEvent is a webhook event from the payment provider. Events use
+// Stripe's event types and object formats, which the fake provider copies.
+type PaymentEvent struct {
+	ID     string          // e.g. "evt_1NG8Du2eZvKYlo2CUI79vXWy"
+	Type   string          // e.g. "checkout.session.completed"
+	Object json.RawMessage // the object the event is about, e.g. a checkout session
+}
+
+// CheckoutStatus is the state of a checkout session.
+type CheckoutStatus string
+
+const (
+	// CheckoutOpen means the customer has not finished the checkout yet
+	CheckoutOpen CheckoutStatus = "open"
+	// CheckoutComplete means the customer finished the checkout
+	CheckoutComplete CheckoutStatus = "complete"
+	// CheckoutExpired means the checkout ran out before the customer finished it
+	CheckoutExpired CheckoutStatus = "expired"
+)
+
+// Payment is the state of a checkout session at the payment provider.
+type Payment struct {
+	SessionID       string
+	Status          CheckoutStatus
+	Paid            bool   // the money was taken, or none was due; a complete checkout may still be waiting for it
+	PaymentIntentID string // the provider's ID for the payment, once there is one
+	Amount          Money  // the total of the checkout
+}
+
+// PaymentConfig selects and configures the payment provider.
+type PaymentConfig struct {
+	Provider string // "stripe" (default) or "fake"
+
+	// Stripe
+	StripeSecretKey     string
+	StripeWebhookSecret string
+}
+
+// PaymentConfigFromEnv reads PAYMENT_PROVIDER, STRIPE_SECRET_KEY and
+// STRIPE_WEBHOOK_SECRET.
+func PaymentConfigFromEnv() PaymentConfig {
+	return PaymentConfig{
+		Provider:            os.Getenv("PAYMENT_PROVIDER"),
+		StripeSecretKey:     os.Getenv("STRIPE_SECRET_KEY"),
+		StripeWebhookSecret: os.Getenv("STRIPE_WEBHOOK_SECRET"),
+	}
+}
+
+// NewPaymentProvider returns the payment provider described by cfg.
+func NewPaymentProvider(cfg PaymentConfig) (PaymentProvider, error) {
+	switch cfg.Provider {
+	case "", "stripe":
+		if cfg.StripeSecretKey == "" {
+			log.Println("Warning: STRIPE_SECRET_KEY environment variable not set")
+		}
+		return NewStripeProvider(cfg.StripeSecretKey, cfg.StripeWebhookSecret), nil
+	case "fake":
+		log.Println("Warning: using the fake payment provider; orders are paid without taking any money")
+		return NewFakePaymentProvider()
+	default:
+		return nil, fmt.Errorf("unknown payment provider %q (use stripe or fake)", cfg.Provider)
+	}
+}
@@ -0,0 +1,372 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+)
+
+// HandlePaymentEvent acts on a verified webhook event from the payment
+// provider. Events can arrive out of order and more than once, so each one
+// only moves the order on if its status allows it. Payments for orders given
+// up on are refunded through payments.
+func HandlePaymentEvent(ctx context.Context, store *Store, payments PaymentProvider, event PaymentEvent) error {
+	// Handle different event types
+	switch event.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded",
+		"checkout.session.async_payment_failed", "checkout.session.expired":
+		var session stripe.CheckoutSession
+		if err := json.Unmarshal(event.Object, &session); err != nil {
+			return fmt.Errorf("error parsing webhook JSON for %s: %w", event.Type, err)
+		}
+		return handleCheckoutSessionEvent(ctx, store, payments, event, &session)
+
+	case "payment_intent.succeeded", "payment_intent.payment_failed":
+		var intent stripe.PaymentIntent
+		if err := json.Unmarshal(event.Object, &intent); err != nil {
+			return fmt.Errorf("error parsing webhook JSON for %s: %w", event.Type, err)
+		}
+		return handlePaymentIntentEvent(ctx, store, event, &intent)
+
+	case "charge.refunded":
+		var charge stripe.Charge
+		if err := json.Unmarshal(event.Object, &charge); err != nil {
+			return fmt.Errorf("error parsing webhook JSON for %s: %w", event.Type, err)
+		}
+		return handleChargeRefunded(ctx, store, event, &charge)
+
+	case "charge.dispute.created", "charge.dispute.closed":
+		var dispute stripe.Dispute
+		if err := json.Unmarshal(event.Object, &dispute); err != nil {
+			return fmt.Errorf("error parsing webhook JSON for %s: %w", event.Type, err)
+		}
+		return handleDisputeEvent(ctx, store, event, &dispute)
+
+	default:
+		log.Printf("Unhandled payment event type: %s", event.Type)
+	}
+
+	return nil
+}
+
+// handleCheckoutSessionEvent pays, cancels or expires the order of a checkout session.
+func handleCheckoutSessionEvent(ctx context.Context, store *Store, payments PaymentProvider, event PaymentEvent, session *stripe.CheckoutSession) error {
+	log.Printf("Checkout session %s: %s", session.ID, event.Type)
+
+	// Retrieve the order from your database using the Stripe Session ID
+	order, err := store.Orders.GetByStripeID(ctx, session.ID)
+	if errors.Is(err, ErrNotFound) && event.Type == "checkout.session.expired" {
+		log.Printf("Warning: Order with Stripe ID %s not found in DB on expiry webhook: %v", session.ID, err)
+		return nil // Not a critical error if order isn't found on expiry
+	}
+	if err != nil {
+		// This is a critical error: received webhook for unknown order
+		return fmt.Errorf("order with Stripe ID %s not found in DB: %w", session.ID, err)
+	}
+
+	if session.PaymentIntent != nil {
+		if err := recordPayment(ctx, store, order, session.PaymentIntent.ID, ""); err != nil {
+			return err
+		}
+	}
+
+	switch event.Type {
+	case "checkout.session.completed":
+		if !sessionPaid(session) {
+			// Delayed payment methods, such as bank debits, settle later with
+			// async_payment_succeeded or async_payment_failed. Until then the
+			// order stays pending and keeps its stock.
+			log.Printf("Order %s is waiting for a delayed payment", order.ID)
+			return nil
+		}
+		return markOrderPaid(ctx, store, payments, order, ActorStripe, eventReason(event))
+	case "checkout.session.async_payment_succeeded":
+		return markOrderPaid(ctx, store, payments, order, ActorStripe, eventReason(event))
+	case "checkout.session.async_payment_failed":
+		return closeUnpaidOrder(ctx, store, order, OrderStatusCancelled, ActorStripe, eventReason(event))
+	default: // checkout.session.expired
+		return closeUnpaidOrder(ctx, store, order, OrderStatusExpired, ActorStripe, eventReason(event))
+	}
+}
+
+// markOrderPaid turns the reserved stock of a pending order into sales and
+// marks it paid. A cancelled or expired order has given its stock back, so
+// its payment is refunded instead.
+func markOrderPaid(ctx context.Context, store *Store, payments PaymentProvider, order *Order, actor, reason string) error {
+	switch order.Status {
+	case OrderStatusPending:
+		// The status is changed first: of a payment and a cancellation racing
+		// for the order, only the one that moves it on touches its stock.
+		err := UpdateOrderStatus(ctx, store.Orders, order, OrderStatusPaid, actor, reason)
+		if errors.Is(err, ErrInvalidTransition) {
+			// e.g. cancelled while the payment was on its way
+			return refundRacedPayment(ctx, store, payments, order)
+		}
+		if err != nil {
+			return fmt.Errorf("error updating order %s status to paid: %w", order.ID, err)
+		}
+	case OrderStatusPaid:
+		// Paid already, e.g. by an earlier delivery whose commit failed
+	case OrderStatusCancelled, OrderStatusExpired:
+		return refundLatePayment(ctx, store, payments, order)
+	default:
+		return nil
+	}
+
+	// The reserved stock is now sold
+	if err := store.Inventory.Commit(ctx, order.ID); err != nil {
+		return fmt.Errorf("error committing stock for order %s: %w", order.ID, err)
+	}
+
+	// TODO: Implement post-checkout actions here (e.g., send order confirmation email)
+	return nil
+}
+
+// refundRacedPayment reloads an order that was moved on by someone else
+// while it was being marked as paid, and refunds the payment if the order
+// was cancelled or expired meanwhile.
+func refundRacedPayment(ctx context.Context, store *Store, payments PaymentProvider, order *Order) error {
+	current, err := store.Orders.GetByID(ctx, order.ID)
+	if err != nil {
+		return fmt.Errorf("error reloading order %s: %w", order.ID, err)
+	}
+	switch current.Status {
+	case OrderStatusCancelled, OrderStatusExpired:
+		return refundLatePayment(ctx, store, payments, current)
+	}
+	return nil
+}
+
+// refundLatePayment gives back what is left of the payment of an order that
+// was paid after it was cancelled or expired, e.g. when the customer paid
+// while the order was being cancelled. The order keeps its status.
+func refundLatePayment(ctx context.Context, store *Store, payments PaymentProvider, order *Order) error {
+	refund := &Refund{
+		OrderID:   order.ID,
+		Amount:    Money{Amount: order.TotalAmount.Amount - order.RefundedAmount.Amount, Currency: order.Currency()},
+		Reason:    fmt.Sprintf("paid after the order was %s", order.Status),
+		CreatedAt: time.Now(),
+	}
+	if refund.Amount.Amount <= 0 {
+		return nil // refunded already, e.g. when the event is delivered again
+	}
+	log.Printf("Warning: Order %s was paid but is %s, refunding %s", order.ID, order.Status, refund.Amount)
+
+	// Recorded and claimed first, as RefundOrder does, so that the payment is
+	// only refunded once however often its events arrive
+	if err := store.Refunds.Create(ctx, order, refund); err != nil {
+		return err
+	}
+	providerID, err := payments.Refund(ctx, order, refund.Amount, refund.Reason)
+	if err != nil {
+		if deleteErr := store.Refunds.Delete(ctx, order, refund); deleteErr != nil {
+			log.Printf("Error deleting refund %d of order %s the provider did not make: %v", refund.ID, order.ID, deleteErr)
+		}
+		return err
+	}
+	refund.ProviderID = providerID
+	if err := store.Refunds.Update(ctx, refund); err != nil {
+		return fmt.Errorf("refund %s of %s for order %s was made but could not be recorded: %w", refund.ProviderID, refund.Amount, order.ID, err)
+	}
+	return nil
+}
+
+// closeUnpaidOrder moves a pending order to the status, cancelled or expired,
+// and returns its stock. An order paid meanwhile is left alone, stock and all.
+func closeUnpaidOrder(ctx context.Context, store *Store, order *Order, status OrderStatus, actor, reason string) error {
+	switch order.Status {
+	case OrderStatusPending:
+		err := UpdateOrderStatus(ctx, store.Orders, order, status, actor, reason)
+		if errors.Is(err, ErrInvalidTransition) {
+			return nil // moved on by someone else, who sees to its stock
+		}
+		if err != nil {
+			return fmt.Errorf("error updating order %s status to %s: %w", order.ID, status, err)
+		}
+	case status:
+		// Closed already, e.g. by an earlier delivery whose release failed
+	default:
+		return nil
+	}
+	if err := store.Inventory.Release(ctx, order.ID); err != nil {
+		return fmt.Errorf("error releasing stock for order %s: %w", order.ID, err)
+	}
+	return nil
+}
+
+// handlePaymentIntentEvent records the payment of an order. A failed payment
+// does not change the order: the customer can try again until the checkout
+// session expires.
+func handlePaymentIntentEvent(ctx context.Context, store *Store, event PaymentEvent, intent *stripe.PaymentIntent) error {
+	order, err := orderForPayment(ctx, store, intent.ID, intent.Metadata)
+	if errors.Is(err, ErrNotFound) {
+		log.Printf("Warning: No order for PaymentIntent %s on %s", intent.ID, event.Type)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	chargeID := ""
+	if intent.LatestCharge != nil {
+		chargeID = intent.LatestCharge.ID
+	}
+	if err := recordPayment(ctx, store, order, intent.ID, chargeID); err != nil {
+		return err
+	}
+
+	if event.Type == "payment_intent.payment_failed" {
+		reason := "unknown reason"
+		if intent.LastPaymentError != nil && intent.LastPaymentError.Msg != "" {
+			reason = intent.LastPaymentError.Msg
+		}
+		log.Printf("Payment for order %s failed: %s", order.ID, reason)
+	}
+	return nil
+}
+
+// handleChargeRefunded records how much of the order's charge was given back,
+// which includes refunds made outside the store, e.g. from the provider's
+// dashboard, and marks the order as refunded, or partially refunded if only
+// part of the charge was given back.
+func handleChargeRefunded(ctx context.Context, store *Store, event PaymentEvent, charge *stripe.Charge) error {
+	order, err := orderForCharge(ctx, store, charge)
+	if errors.Is(err, ErrNotFound) {
+		log.Printf("Warning: No order for refunded charge %s", charge.ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := recordPayment(ctx, store, order, "", charge.ID); err != nil {
+		return err
+	}
+	if !strings.EqualFold(string(charge.Currency), order.Currency()) {
+		log.Printf("Warning: Refunded charge %s is in %s but order %s is in %s", charge.ID, charge.Currency, order.ID, order.Currency())
+		return nil
+	}
+
+	// amount_refunded is the total given back so far, so events that arrive
+	// late or twice never lower it. A refund made at the same time conflicts,
+	// and the event is handled again when it is retried.
+	refunded := min(charge.AmountRefunded, order.TotalAmount.Amount)
+	if more := refunded - order.RefundedAmount.Amount; more > 0 {
+		if err := store.Orders.AddRefunded(ctx, order, Money{Amount: more, Currency: order.Currency()}); err != nil {
+			return err
+		}
+	}
+	if order.Status == OrderStatusCancelled || order.Status == OrderStatusExpired {
+		return nil // a late payment given back by refundLatePayment
+	}
+
+	status := OrderStatusPartiallyRefunded
+	if charge.Refunded || charge.AmountRefunded >= charge.Amount {
+		status = OrderStatusRefunded
+	}
+	err = UpdateOrderStatus(ctx, store.Orders, order, status, ActorStripe, eventReason(event))
+	if errors.Is(err, ErrInvalidTransition) {
+		log.Printf("Warning: Order %s was refunded but cannot be marked as %s: %v", order.ID, status, err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error updating order %s status to %s: %w", order.ID, status, err)
+	}
+	return nil
+}
+
+// handleDisputeEvent records the status of a dispute on the order of the
+// disputed charge. A lost dispute takes the disputed amount back, so that is
+// added to the order's refunded amount and the order is then refunded.
+func handleDisputeEvent(ctx context.Context, store *Store, event PaymentEvent, dispute *stripe.Dispute) error {
+	var charge stripe.Charge
+	if dispute.Charge != nil {
+		charge = *dispute.Charge
+	}
+	if charge.PaymentIntent == nil {
+		charge.PaymentIntent = dispute.PaymentIntent
+	}
+	order, err := orderForCharge(ctx, store, &charge)
+	if errors.Is(err, ErrNotFound) {
+		log.Printf("Warning: No order for disputed charge %s", charge.ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	order.DisputeStatus = string(dispute.Status)
+	if err := recordPayment(ctx, store, order, "", charge.ID); err != nil {
+		return err
+	}
+	log.Printf("Dispute %s of order %s is %s", dispute.ID, order.ID, dispute.Status)
+
+	if event.Type == "charge.dispute.closed" && dispute.Status == stripe.DisputeStatusLost {
+		// An order refunded already has had this dispute, or its whole
+		// payment, counted. A refund made at the same time conflicts, and the
+		// event is handled again when it is retried.
+		lost := min(dispute.Amount, order.TotalAmount.Amount-order.RefundedAmount.Amount)
+		if !strings.EqualFold(string(dispute.Currency), order.Currency()) {
+			log.Printf("Warning: Lost dispute %s is in %s but order %s is in %s", dispute.ID, dispute.Currency, order.ID, order.Currency())
+		} else if order.Status.CanTransitionTo(OrderStatusRefunded) && lost > 0 {
+			if err := store.Orders.AddRefunded(ctx, order, Money{Amount: lost, Currency: order.Currency()}); err != nil {
+				return err
+			}
+		}
+		err := UpdateOrderStatus(ctx, store.Orders, order, OrderStatusRefunded, ActorStripe, "dispute lost, "+eventReason(event))
+		if errors.Is(err, ErrInvalidTransition) {
+			log.Printf("Warning: Order %s lost a dispute but cannot be marked as refunded: %v", order.ID, err)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error updating order %s status to refunded: %w", order.ID, err)
+		}
+	}
+	return nil
+}
+
+// orderForPayment finds the order paid by a PaymentIntent, from the order ID
+// the checkout session put in its metadata or else from the recorded ID.
+func orderForPayment(ctx context.Context, store *Store, paymentIntentID string, metadata map[string]string) (*Order, error) {
+	if orderID := metadata["order_id"]; orderID != "" {
+		return store.Orders.GetByID(ctx, orderID)
+	}
+	if paymentIntentID == "" {
+		return nil, fmt.Errorf("payment without a PaymentIntent: %w", ErrNotFound)
+	}
+	return store.Orders.GetByPaymentIntentID(ctx, paymentIntentID)
+}
+
+// orderForCharge finds the order paid by a charge.
+func orderForCharge(ctx context.Context, store *Store, charge *stripe.Charge) (*Order, error) {
+	paymentIntentID := ""
+	if charge.PaymentIntent != nil {
+		paymentIntentID = charge.PaymentIntent.ID
+	}
+	return orderForPayment(ctx, store, paymentIntentID, charge.Metadata)
+}
+
+// recordPayment saves the IDs that are given and new on the order, along
+// with its dispute status.
+func recordPayment(ctx context.Context, store *Store, order *Order, paymentIntentID, chargeID string) error {
+	if paymentIntentID != "" {
+		order.PaymentIntentID = paymentIntentID
+	}
+	if chargeID != "" {
+		order.ChargeID = chargeID
+	}
+	if err := store.Orders.UpdatePayment(ctx, order); err != nil {
+		return fmt.Errorf("error recording payment of order %s: %w", order.ID, err)
+	}
+	return nil
+}
+
+// eventReason describes the event in an order's status history.
+func eventReason(event PaymentEvent) string {
+	return event.Type + " " + event.ID
+}
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+)
+
+// TestPaymentRacingCancellation checks that of a payment and a cancellation
+// racing for a pending order, only the one that changes its status touches
+// its stock: the other one acts on a copy of the order read before that.
+func TestPaymentRacingCancellation(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name         string
+		paidFirst    bool
+		wantStatus   OrderStatus
+		wantStock    int
+		wantRefunded int64
+	}{
+		{name: "paid first", paidFirst: true, wantStatus: OrderStatusPaid, wantStock: 3},
+		{name: "cancelled first", wantStatus: OrderStatusCancelled, wantStock: 5, wantRefunded: 2000},
+	}
+	for _, tt := range tests {
+		for name, store := range testStores(t) {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				if err := store.Products.Create(ctx, Product{ID: "tee", Name: "Tee", Price: Money{1000, "USD"}, Stock: 5, TrackInventory: true}); err != nil {
+					t.Fatal(err)
+				}
+				payments, err := NewFakePaymentProvider()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				order := NewOrder("ann@example.com", "USD")
+				order.Items = []OrderItem{{ProductID: "tee", ProductName: "Tee", Quantity: 2, UnitPrice: Money{1000, "USD"}}}
+				order.CalculateTotal()
+				order.CreatedAt = time.Now()
+				if err := store.Orders.Save(ctx, order); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.Inventory.Reserve(ctx, order.ID, order.Items); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := payments.CreateCheckoutSession(ctx, order, "", ""); err != nil {
+					t.Fatal(err)
+				}
+				payments.sessions[order.StripeID].Paid = true
+				if err := store.Orders.SetCheckoutSession(ctx, order.ID, order.StripeID); err != nil {
+					t.Fatal(err)
+				}
+
+				// Both read the order while it was pending
+				paying, cancelling := *order, *order
+				pay := func() error { return markOrderPaid(ctx, store, payments, &paying, ActorStripe, "paid") }
+				cancel := func() error {
+					return closeUnpaidOrder(ctx, store, &cancelling, OrderStatusCancelled, ActorCustomer, "left the payment page")
+				}
+				first, second := cancel, pay
+				if tt.paidFirst {
+					first, second = pay, cancel
+				}
+				if err := first(); err != nil {
+					t.Fatal(err)
+				}
+				if err := second(); err != nil {
+					t.Fatal(err)
+				}
+
+				stored, err := store.Orders.GetByID(ctx, order.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stored.Status != tt.wantStatus {
+					t.Errorf("order is %s, want %s", stored.Status, tt.wantStatus)
+				}
+				if stored.RefundedAmount.Amount != tt.wantRefunded {
+					t.Errorf("refunded %s, want %d", stored.RefundedAmount, tt.wantRefunded)
+				}
+				product, err := store.Products.GetByID(ctx, "tee")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if product.Stock != tt.wantStock {
+					t.Errorf("stock = %d, want %d", product.Stock, tt.wantStock)
+				}
+			})
+		}
+	}
+}
+
+func TestCheckoutSessionCompleted(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		paymentStatus stripe.CheckoutSessionPaymentStatus
+		wantStatus    OrderStatus
+	}{
+		{stripe.CheckoutSessionPaymentStatusPaid, OrderStatusPaid},
+		{stripe.CheckoutSessionPaymentStatusNoPaymentRequired, OrderStatusPaid},
+		{stripe.CheckoutSessionPaymentStatusUnpaid, OrderStatusPending}, // a delayed payment
+	}
+	for _, tt := range tests {
+		for name, store := range testStores(t) {
+			t.Run(string(tt.paymentStatus)+"/"+name, func(t *testing.T) {
+				order := NewOrder("ann@example.com", "USD")
+				if err := store.Orders.Save(ctx, order); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.Orders.SetCheckoutSession(ctx, order.ID, "cs_test_1"); err != nil {
+					t.Fatal(err)
+				}
+				object, err := json.Marshal(stripe.CheckoutSession{ID: "cs_test_1", Status: stripe.CheckoutSessionStatusComplete, PaymentStatus: tt.paymentStatus})
+				if err != nil {
+					t.Fatal(err)
+				}
+				event := PaymentEvent{ID: "evt_test_1", Type: "checkout.session.completed", Object: object}
+				if err := HandlePaymentEvent(ctx, store, nil, event); err != nil {
+					t.Fatal(err)
+				}
+
+				stored, err := store.Orders.GetByID(ctx, order.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stored.Status != tt.wantStatus {
+					t.Errorf("order is %s, want %s", stored.Status, tt.wantStatus)
+				}
+			})
+		}
+	}
+}
+
+func TestDisputeLost(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name         string
+		refunded     int64 // refunded before the dispute
+		disputed     int64
+		wantRefunded int64
+	}{
+		{name: "whole payment", disputed: 2000, wantRefunded: 2000},
+		{name: "after a partial refund", refunded: 500, disputed: 1500, wantRefunded: 2000},
+		{name: "more than is left", refunded: 500, disputed: 2000, wantRefunded: 2000},
+	}
+	for _, tt := range tests {
+		for name, store := range testStores(t) {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				order := NewOrder("ann@example.com", "USD")
+				order.Items = []OrderItem{{ProductID: "tee", ProductName: "Tee", Quantity: 2, UnitPrice: Money{1000, "USD"}}}
+				order.CalculateTotal()
+				order.Status = OrderStatusPaid
+				if err := store.Orders.Save(ctx, order); err != nil {
+					t.Fatal(err)
+				}
+				if tt.refunded > 0 {
+					if err := store.Orders.AddRefunded(ctx, order, Money{tt.refunded, "USD"}); err != nil {
+						t.Fatal(err)
+					}
+					if err := UpdateOrderStatus(ctx, store.Orders, order, OrderStatusPartiallyRefunded, ActorAdmin, "returned"); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				object, err := json.Marshal(stripe.Dispute{
+					ID: "dp_test_1", Status: stripe.DisputeStatusLost, Amount: tt.disputed, Currency: "usd",
+					Charge: &stripe.Charge{ID: "ch_test_1", Metadata: map[string]string{"order_id": order.ID}},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				event := PaymentEvent{ID: "evt_test_1", Type: "charge.dispute.closed", Object: object}
+				// Delivered twice, the dispute is counted once
+				for range 2 {
+					if err := HandlePaymentEvent(ctx, store, nil, event); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				stored, err := store.Orders.GetByID(ctx, order.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stored.Status != OrderStatusRefunded || stored.RefundedAmount.Amount != tt.wantRefunded {
+					t.Errorf("order is %s with %s refunded, want refunded with %d", stored.Status, stored.RefundedAmount, tt.wantRefunded)
+				}
+				if stored.DisputeStatus != string(stripe.DisputeStatusLost) {
+					t.Errorf("dispute status = %q, want lost", stored.DisputeStatus)
+				}
+			})
+		}
+	}
+}
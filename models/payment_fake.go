@@ -0,0 +1,417 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stripe/stripe-go/v74"
+	"github.com/stripe/stripe-go/v74/webhook"
+)
+
+// FakeCheckoutPath is where the fake provider's checkout pages are served,
+// followed by the session ID.
+const FakeCheckoutPath = "/fake-checkout/"
+
+// FakePaymentProvider is a PaymentProvider for development and tests that
+// takes no money. Its checkout pages are served by the app itself (see
+// handlers.FakeCheckoutHandler), and paying or expiring a session there sends
+// the signed webhooks Stripe would send. Payments can also be declined, or
+// made with a delayed payment method that settles later. Sessions are kept in
+// memory, so they are forgotten on restart.
+type FakePaymentProvider struct {
+	mu       sync.Mutex
+	sessions map[string]*FakeCheckoutSession
+	secret   string // signs the webhooks
+	client   *http.Client
+}
+
+// FakeCheckoutSession is a checkout session of the fake provider.
+type FakeCheckoutSession struct {
+	ID              string
+	OrderID         string
+	CustomerEmail   string
+	Items           []OrderItem
+	Total           Money
+	Refunded        Money
+	Status          CheckoutStatus
+	Paid            bool
+	Declined        bool // the last payment attempt was declined
+	PaymentIntentID string
+	ChargeID        string
+	SuccessURL      string
+	CancelURL       string
+	WebhookURL      string // where the session's events were sent, for refunds
+	CreatedAt       time.Time
+}
+
+// Pending reports whether the session was completed with a delayed payment
+// method that has not settled yet.
+func (s FakeCheckoutSession) Pending() bool {
+	return s.Status == CheckoutComplete && !s.Paid && !s.Declined
+}
+
+// NewFakePaymentProvider returns a fake provider with a random webhook signing secret.
+func NewFakePaymentProvider() (*FakePaymentProvider, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("error generating webhook secret: %w", err)
+	}
+	return &FakePaymentProvider{
+		sessions: make(map[string]*FakeCheckoutSession),
+		secret:   "whsec_fake_" + hex.EncodeToString(secret),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Name returns "fake".
+func (p *FakePaymentProvider) Name() string {
+	return "fake"
+}
+
+// CreateCheckoutSession opens a session for the order, to be paid on the
+// local checkout page it returns the path of.
+func (p *FakePaymentProvider) CreateCheckoutSession(ctx context.Context, order *Order, successURL, cancelURL string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := &FakeCheckoutSession{
+		ID:            fakeID("cs_fake_"),
+		OrderID:       order.ID,
+		CustomerEmail: order.CustomerEmail,
+		Items:         append([]OrderItem(nil), order.Items...),
+		Total:         order.TotalAmount,
+		Refunded:      Money{Currency: order.TotalAmount.Currency},
+		Status:        CheckoutOpen,
+		CancelURL:     cancelURL,
+		CreatedAt:     time.Now(),
+	}
+	s.SuccessURL = strings.ReplaceAll(successURL, "{CHECKOUT_SESSION_ID}", s.ID)
+	p.sessions[s.ID] = s
+	order.StripeID = s.ID
+	return FakeCheckoutPath + s.ID, nil
+}
+
+// Session returns a copy of the session with the given ID.
+func (p *FakePaymentProvider) Session(id string) (FakeCheckoutSession, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.sessions[id]
+	if !ok {
+		return FakeCheckoutSession{}, fmt.Errorf("checkout session %s: %w", id, ErrNotFound)
+	}
+	return *s, nil
+}
+
+// Pay completes an open session as paid and sends payment_intent.succeeded
+// and checkout.session.completed to the webhook URL. It returns the session's
+// success URL.
+func (p *FakePaymentProvider) Pay(ctx context.Context, id, webhookURL string) (string, error) {
+	return p.finish(ctx, id, webhookURL, func(s *FakeCheckoutSession) (string, []string, error) {
+		if s.Status != CheckoutOpen {
+			return "", nil, fmt.Errorf("checkout session %s is %s", s.ID, s.Status)
+		}
+		s.Status = CheckoutComplete
+		s.Paid = true
+		s.Declined = false
+		s.charge()
+		return s.SuccessURL, []string{"payment_intent.succeeded", "checkout.session.completed"}, nil
+	})
+}
+
+// Decline fails a payment attempt on an open session and sends
+// payment_intent.payment_failed to the webhook URL. The session stays open
+// for the customer to try again; Decline returns its checkout page.
+func (p *FakePaymentProvider) Decline(ctx context.Context, id, webhookURL string) (string, error) {
+	return p.finish(ctx, id, webhookURL, func(s *FakeCheckoutSession) (string, []string, error) {
+		if s.Status != CheckoutOpen {
+			return "", nil, fmt.Errorf("checkout session %s is %s", s.ID, s.Status)
+		}
+		s.Declined = true
+		if s.PaymentIntentID == "" {
+			s.PaymentIntentID = fakeID("pi_fake_")
+		}
+		return FakeCheckoutPath + s.ID, []string{"payment_intent.payment_failed"}, nil
+	})
+}
+
+// PayLater completes an open session with a delayed payment method, such as a
+// bank debit, and sends checkout.session.completed with the payment still
+// unpaid. The payment is settled with Settle. It returns the session's
+// success URL.
+func (p *FakePaymentProvider) PayLater(ctx context.Context, id, webhookURL string) (string, error) {
+	return p.finish(ctx, id, webhookURL, func(s *FakeCheckoutSession) (string, []string, error) {
+		if s.Status != CheckoutOpen {
+			return "", nil, fmt.Errorf("checkout session %s is %s", s.ID, s.Status)
+		}
+		s.Status = CheckoutComplete
+		s.Declined = false
+		if s.PaymentIntentID == "" {
+			s.PaymentIntentID = fakeID("pi_fake_")
+		}
+		return s.SuccessURL, []string{"checkout.session.completed"}, nil
+	})
+}
+
+// Settle ends the delayed payment of a session paid with PayLater, sending
+// payment_intent.succeeded and checkout.session.async_payment_succeeded, or
+// checkout.session.async_payment_failed. It returns the session's checkout page.
+func (p *FakePaymentProvider) Settle(ctx context.Context, id, webhookURL string, succeeded bool) (string, error) {
+	return p.finish(ctx, id, webhookURL, func(s *FakeCheckoutSession) (string, []string, error) {
+		if !s.Pending() {
+			return "", nil, fmt.Errorf("checkout session %s has no payment to settle", s.ID)
+		}
+		if !succeeded {
+			s.Declined = true
+			return FakeCheckoutPath + s.ID, []string{"checkout.session.async_payment_failed"}, nil
+		}
+		s.Paid = true
+		s.charge()
+		return FakeCheckoutPath + s.ID, []string{"payment_intent.succeeded", "checkout.session.async_payment_succeeded"}, nil
+	})
+}
+
+// Expire expires an open session and sends checkout.session.expired to the
+// webhook URL. It returns the session's cancel URL.
+func (p *FakePaymentProvider) Expire(ctx context.Context, id, webhookURL string) (string, error) {
+	return p.finish(ctx, id, webhookURL, func(s *FakeCheckoutSession) (string, []string, error) {
+		if s.Status != CheckoutOpen {
+			return "", nil, fmt.Errorf("checkout session %s is %s", s.ID, s.Status)
+		}
+		s.Status = CheckoutExpired
+		return s.CancelURL, []string{"checkout.session.expired"}, nil
+	})
+}
+
+// charge records the money as taken by a charge on the session's PaymentIntent.
+func (s *FakeCheckoutSession) charge() {
+	if s.PaymentIntentID == "" {
+		s.PaymentIntentID = fakeID("pi_fake_")
+	}
+	s.ChargeID = fakeID("ch_fake_")
+}
+
+// finish moves a session on with the change, which returns the URL to send
+// the customer to and the types of the events to send, and sends the events
+// in order.
+func (p *FakePaymentProvider) finish(ctx context.Context, id, webhookURL string, change func(*FakeCheckoutSession) (string, []string, error)) (string, error) {
+	p.mu.Lock()
+	s, ok := p.sessions[id]
+	if !ok {
+		p.mu.Unlock()
+		return "", fmt.Errorf("checkout session %s: %w", id, ErrNotFound)
+	}
+	next, eventTypes, err := change(s)
+	if err != nil {
+		p.mu.Unlock()
+		return "", err
+	}
+	s.WebhookURL = webhookURL
+	objects := make([]map[string]any, len(eventTypes))
+	for i, eventType := range eventTypes {
+		objects[i] = fakeEventObject(s, eventType)
+	}
+	p.mu.Unlock()
+
+	for i, eventType := range eventTypes {
+		if err := p.sendEvent(ctx, webhookURL, eventType, objects[i]); err != nil {
+			return next, err
+		}
+	}
+	return next, nil
+}
+
+// fakeEventObject is the object an event of the type is about, in Stripe's
+// JSON format.
+func fakeEventObject(s *FakeCheckoutSession, eventType string) map[string]any {
+	switch {
+	case strings.HasPrefix(eventType, "payment_intent."):
+		return fakePaymentIntentObject(s)
+	case strings.HasPrefix(eventType, "charge."):
+		return fakeChargeObject(s)
+	default:
+		return fakeSessionObject(s)
+	}
+}
+
+// fakeSessionObject is the session in the JSON format of a Stripe Checkout Session.
+func fakeSessionObject(s *FakeCheckoutSession) map[string]any {
+	paymentStatus := stripe.CheckoutSessionPaymentStatusUnpaid
+	if s.Paid {
+		paymentStatus = stripe.CheckoutSessionPaymentStatusPaid
+	}
+	object := map[string]any{
+		"id":             s.ID,
+		"object":         "checkout.session",
+		"status":         string(s.Status),
+		"payment_status": string(paymentStatus),
+		"amount_total":   s.Total.Amount,
+		"currency":       strings.ToLower(s.Total.Currency),
+		"customer_email": s.CustomerEmail,
+		"success_url":    s.SuccessURL,
+		"cancel_url":     s.CancelURL,
+		"created":        s.CreatedAt.Unix(),
+		"metadata":       map[string]string{"order_id": s.OrderID},
+	}
+	if s.PaymentIntentID != "" {
+		object["payment_intent"] = s.PaymentIntentID
+	}
+	return object
+}
+
+// fakePaymentIntentObject is the session's payment in the JSON format of a
+// Stripe PaymentIntent.
+func fakePaymentIntentObject(s *FakeCheckoutSession) map[string]any {
+	object := map[string]any{
+		"id":       s.PaymentIntentID,
+		"object":   "payment_intent",
+		"amount":   s.Total.Amount,
+		"currency": strings.ToLower(s.Total.Currency),
+		"status":   string(stripe.PaymentIntentStatusRequiresPaymentMethod),
+		"created":  s.CreatedAt.Unix(),
+		"metadata": map[string]string{"order_id": s.OrderID},
+	}
+	if s.Paid {
+		object["status"] = string(stripe.PaymentIntentStatusSucceeded)
+		object["amount_received"] = s.Total.Amount
+		object["latest_charge"] = s.ChargeID
+	}
+	if s.Declined {
+		object["last_payment_error"] = map[string]any{
+			"type":         "card_error",
+			"code":         "card_declined",
+			"decline_code": "generic_decline",
+			"message":      "Your card was declined.",
+		}
+	}
+	return object
+}
+
+// fakeChargeObject is the session's charge in the JSON format of a Stripe Charge.
+func fakeChargeObject(s *FakeCheckoutSession) map[string]any {
+	return map[string]any{
+		"id":              s.ChargeID,
+		"object":          "charge",
+		"amount":          s.Total.Amount,
+		"amount_captured": s.Total.Amount,
+		"amount_refunded": s.Refunded.Amount,
+		"refunded":        s.Refunded.Amount >= s.Total.Amount,
+		"currency":        strings.ToLower(s.Total.Currency),
+		"paid":            true,
+		"status":          "succeeded",
+		"payment_intent":  s.PaymentIntentID,
+		"created":         s.CreatedAt.Unix(),
+		"metadata":        map[string]string{"order_id": s.OrderID},
+	}
+}
+
+// fakeID returns a new random ID with the prefix.
+func fakeID(prefix string) string {
+	return prefix + strings.ReplaceAll(uuid.NewString(), "-", "")
+}
+
+// sendEvent posts a Stripe-format event about the object to the webhook URL,
+// signed the way Stripe signs them.
+func (p *FakePaymentProvider) sendEvent(ctx context.Context, webhookURL, eventType string, object map[string]any) error {
+	now := time.Now()
+	payload, err := json.Marshal(map[string]any{
+		"id":          fakeID("evt_fake_"),
+		"object":      "event",
+		"api_version": stripe.APIVersion,
+		"created":     now.Unix(),
+		"type":        eventType,
+		"livemode":    false,
+		"data":        map[string]any{"object": object},
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding %s event: %w", eventType, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("error creating webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", now.Unix(), hex.EncodeToString(webhook.ComputeSignature(now, payload, p.secret))))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending %s webhook: %w", eventType, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s webhook was answered with status %d", eventType, resp.StatusCode)
+	}
+	return nil
+}
+
+// VerifyWebhook checks that the webhook was signed by this provider.
+func (p *FakePaymentProvider) VerifyWebhook(payload []byte, signature string) (PaymentEvent, error) {
+	return verifyStripeEvent(payload, signature, p.secret)
+}
+
+// Refund records the refund on the order's paid session, and then sends
+// charge.refunded to the session's webhook URL, as Stripe would, without
+// waiting for it to be handled.
+func (p *FakePaymentProvider) Refund(ctx context.Context, order *Order, amount Money, reason string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.sessions[order.StripeID]
+	if !ok {
+		return "", fmt.Errorf("checkout session %s: %w", order.StripeID, ErrNotFound)
+	}
+	if !s.Paid {
+		return "", fmt.Errorf("order %s has no payment to refund", order.ID)
+	}
+	if amount.Currency != s.Total.Currency || amount.Amount <= 0 || s.Refunded.Amount+amount.Amount > s.Total.Amount {
+		return "", fmt.Errorf("cannot refund %s of order %s: %s paid, %s already refunded", amount, order.ID, s.Total, s.Refunded)
+	}
+	s.Refunded.Amount += amount.Amount
+
+	if s.WebhookURL != "" {
+		webhookURL, object := s.WebhookURL, fakeChargeObject(s)
+		go func() {
+			if err := p.sendEvent(context.Background(), webhookURL, "charge.refunded", object); err != nil {
+				log.Printf("Warning: fake refund of order %s: %v", order.ID, err)
+			}
+		}()
+	}
+	return fakeID("re_fake_"), nil
+}
+
+// RetrievePayment returns the state of the session.
+func (p *FakePaymentProvider) RetrievePayment(ctx context.Context, sessionID string) (Payment, error) {
+	s, err := p.Session(sessionID)
+	if err != nil {
+		return Payment{}, err
+	}
+	return Payment{SessionID: s.ID, Status: s.Status, Paid: s.Paid, PaymentIntentID: s.PaymentIntentID, Amount: s.Total}, nil
+}
+
+// ExpireCheckoutSession expires an open session. Unlike Expire it sends no
+// event: it is used for orders that were closed already.
+func (p *FakePaymentProvider) ExpireCheckoutSession(ctx context.Context, sessionID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.sessions[sessionID]
+	if !ok {
+		return fmt.Errorf("checkout session %s: %w", sessionID, ErrNotFound)
+	}
+	if s.Status != CheckoutOpen {
+		return fmt.Errorf("checkout session %s is %s", s.ID, s.Status)
+	}
+	s.Status = CheckoutExpired
+	return nil
+}
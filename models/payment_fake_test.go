@@ -0,0 +1,266 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// webhookRecorder is a webhook endpoint that checks each event's signature
+// with the provider and keeps the events it was sent.
+type webhookRecorder struct {
+	mu     sync.Mutex
+	events []PaymentEvent
+}
+
+func (r *webhookRecorder) handler(t *testing.T, payments *FakePaymentProvider) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		payload, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		event, err := payments.VerifyWebhook(payload, req.Header.Get("Stripe-Signature"))
+		if err != nil {
+			t.Errorf("webhook does not verify: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.mu.Lock()
+		r.events = append(r.events, event)
+		r.mu.Unlock()
+	})
+}
+
+// types returns the types of the events received, in order.
+func (r *webhookRecorder) types() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var types []string
+	for _, event := range r.events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+// object decodes the object of the last event of the type.
+func (r *webhookRecorder) object(t *testing.T, eventType string) map[string]any {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.events) - 1; i >= 0; i-- {
+		if r.events[i].Type == eventType {
+			var object map[string]any
+			if err := json.Unmarshal(r.events[i].Object, &object); err != nil {
+				t.Fatal(err)
+			}
+			return object
+		}
+	}
+	t.Fatalf("no %s event", eventType)
+	return nil
+}
+
+func TestFakePaymentWebhooks(t *testing.T) {
+	ctx := context.Background()
+	type action func(p *FakePaymentProvider, id, webhookURL string) (string, error)
+	pay := (*FakePaymentProvider).Pay
+	payLater := (*FakePaymentProvider).PayLater
+	decline := (*FakePaymentProvider).Decline
+	expire := (*FakePaymentProvider).Expire
+	bind := func(f func(*FakePaymentProvider, context.Context, string, string) (string, error)) action {
+		return func(p *FakePaymentProvider, id, webhookURL string) (string, error) { return f(p, ctx, id, webhookURL) }
+	}
+
+	tests := []struct {
+		name       string
+		actions    []action
+		wantNext   string // of the last action: "success", "cancel" or "checkout"
+		wantEvents []string
+		wantStatus CheckoutStatus
+		wantPaid   bool
+		// the payment_status of the last session event, and the status of the
+		// last payment_intent event; empty if there is none
+		wantPaymentStatus string
+		wantIntentStatus  string
+	}{
+		{
+			name:              "pay",
+			actions:           []action{bind(pay)},
+			wantNext:          "success",
+			wantEvents:        []string{"payment_intent.succeeded", "checkout.session.completed"},
+			wantStatus:        CheckoutComplete,
+			wantPaid:          true,
+			wantPaymentStatus: "paid",
+			wantIntentStatus:  "succeeded",
+		},
+		{
+			name:             "decline",
+			actions:          []action{bind(decline)},
+			wantNext:         "checkout",
+			wantEvents:       []string{"payment_intent.payment_failed"},
+			wantStatus:       CheckoutOpen,
+			wantIntentStatus: "requires_payment_method",
+		},
+		{
+			name:              "decline then pay",
+			actions:           []action{bind(decline), bind(pay)},
+			wantNext:          "success",
+			wantEvents:        []string{"payment_intent.payment_failed", "payment_intent.succeeded", "checkout.session.completed"},
+			wantStatus:        CheckoutComplete,
+			wantPaid:          true,
+			wantPaymentStatus: "paid",
+			wantIntentStatus:  "succeeded",
+		},
+		{
+			name:              "pay later",
+			actions:           []action{bind(payLater)},
+			wantNext:          "success",
+			wantEvents:        []string{"checkout.session.completed"},
+			wantStatus:        CheckoutComplete,
+			wantPaymentStatus: "unpaid",
+		},
+		{
+			name:              "expire",
+			actions:           []action{bind(expire)},
+			wantNext:          "cancel",
+			wantEvents:        []string{"checkout.session.expired"},
+			wantStatus:        CheckoutExpired,
+			wantPaymentStatus: "unpaid",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payments, err := NewFakePaymentProvider()
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorder := &webhookRecorder{}
+			server := httptest.NewServer(recorder.handler(t, payments))
+			defer server.Close()
+
+			order := NewOrder("ann@example.com", "USD")
+			order.Items = []OrderItem{{ProductID: "tee", ProductName: "Tee", Quantity: 2, UnitPrice: Money{1000, "USD"}}}
+			order.CalculateTotal()
+			checkoutPath, err := payments.CreateCheckoutSession(ctx, order, "/checkout/success?session_id={CHECKOUT_SESSION_ID}", "/checkout/cancel")
+			if err != nil {
+				t.Fatal(err)
+			}
+			id := order.StripeID
+			if checkoutPath != FakeCheckoutPath+id {
+				t.Errorf("checkout page = %q, want %q", checkoutPath, FakeCheckoutPath+id)
+			}
+
+			var next string
+			for _, act := range tt.actions {
+				if next, err = act(payments, id, server.URL); err != nil {
+					t.Fatal(err)
+				}
+			}
+			wantNext := map[string]string{
+				"success":  "/checkout/success?session_id=" + id,
+				"cancel":   "/checkout/cancel",
+				"checkout": FakeCheckoutPath + id,
+			}[tt.wantNext]
+			if next != wantNext {
+				t.Errorf("sent the customer to %q, want %q", next, wantNext)
+			}
+			if got := recorder.types(); !reflect.DeepEqual(got, tt.wantEvents) {
+				t.Errorf("events = %v, want %v", got, tt.wantEvents)
+			}
+
+			session, err := payments.Session(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if session.Status != tt.wantStatus || session.Paid != tt.wantPaid {
+				t.Errorf("session is %s, paid %v; want %s, paid %v", session.Status, session.Paid, tt.wantStatus, tt.wantPaid)
+			}
+			payment, err := payments.RetrievePayment(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if payment.Status != session.Status || payment.Paid != session.Paid || payment.Amount != (Money{2000, "USD"}) {
+				t.Errorf("RetrievePayment = %+v, want the session's state", payment)
+			}
+
+			if tt.wantPaymentStatus != "" {
+				event := tt.wantEvents[len(tt.wantEvents)-1]
+				object := recorder.object(t, event)
+				meta, _ := object["metadata"].(map[string]any)
+				if object["id"] != id || object["payment_status"] != tt.wantPaymentStatus || meta["order_id"] != order.ID ||
+					object["amount_total"] != float64(2000) || object["currency"] != "usd" {
+					t.Errorf("%s session = %v, want session %s of order %s, %s, for 20.00 usd", event, object, id, order.ID, tt.wantPaymentStatus)
+				}
+			}
+			if tt.wantIntentStatus != "" {
+				event := "payment_intent.succeeded"
+				if !tt.wantPaid {
+					event = "payment_intent.payment_failed"
+				}
+				object := recorder.object(t, event)
+				if object["id"] != session.PaymentIntentID || object["status"] != tt.wantIntentStatus {
+					t.Errorf("%s payment = %v, want %s, %s", event, object, session.PaymentIntentID, tt.wantIntentStatus)
+				}
+				if _, declined := object["last_payment_error"]; declined != (event == "payment_intent.payment_failed") {
+					t.Errorf("%s payment has last_payment_error %v", event, object["last_payment_error"])
+				}
+			}
+
+			// A session that is no longer open cannot be finished again, and
+			// sends nothing more
+			if tt.wantStatus != CheckoutOpen {
+				for name, act := range map[string]action{"pay": bind(pay), "pay later": bind(payLater), "decline": bind(decline), "expire": bind(expire)} {
+					if next, err := act(payments, id, server.URL); err == nil || next != "" {
+						t.Errorf("%s on a %s session = %q, %v; want an error", name, tt.wantStatus, next, err)
+					}
+				}
+				if got := recorder.types(); !reflect.DeepEqual(got, tt.wantEvents) {
+					t.Errorf("events after finishing again = %v, want %v", got, tt.wantEvents)
+				}
+			}
+		})
+	}
+
+	t.Run("unknown session", func(t *testing.T) {
+		payments, err := NewFakePaymentProvider()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := payments.Pay(ctx, "cs_fake_missing", "http://127.0.0.1:0"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Pay = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("webhook refused", func(t *testing.T) {
+		payments, err := NewFakePaymentProvider()
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		order := NewOrder("ann@example.com", "USD")
+		order.Items = []OrderItem{{ProductID: "tee", ProductName: "Tee", Quantity: 1, UnitPrice: Money{1000, "USD"}}}
+		order.CalculateTotal()
+		if _, err := payments.CreateCheckoutSession(ctx, order, "/checkout/success", "/checkout/cancel"); err != nil {
+			t.Fatal(err)
+		}
+		// The payment is made anyway, as Stripe would retry the webhook later
+		next, err := payments.Pay(ctx, order.StripeID, server.URL)
+		if err == nil || next != "/checkout/success" {
+			t.Errorf("Pay = %q, %v; want the success page and an error", next, err)
+		}
+		if session, _ := payments.Session(order.StripeID); !session.Paid {
+			t.Error("session is not paid")
+		}
+	})
+}
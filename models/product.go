@@ -1,110 +1,141 @@
 package models
 
-import (
-	"database/sql"
-	"ecommerce-app/db"
-	"fmt"
-)
+import "time"
 
 // Product represents an item available for purchase
 type Product struct {
-	ID          string  `json:"id"`
-	Name        string  `json:"name"`
-	Description string  `json:"description"`
-	Price       float64 `json:"price"`
-	ImageURL    string  `json:"image_url"`
+	ID          string           `json:"id"`
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Price       Money            `json:"price"`  // base price, or the localized price after InCurrency
+	Prices      map[string]Money `json:"prices"` // price list keyed by currency code
+	ImageURL    string           `json:"image_url"`
+	// Stock is only meaningful when TrackInventory is set; untracked products never run out.
+	Stock          int  `json:"stock"`
+	TrackInventory bool `json:"track_inventory"`
+	// TaxClass selects the tax rates that apply, one of TaxClasses.
+	TaxClass string `json:"tax_class"`
+	// Weight is the shipping weight in grams, used by weight-based shipping rates.
+	Weight int `json:"weight"`
+	// Options names the ways variants differ (e.g. "Size", "Color"), in display order.
+	// A product with variants is always bought as one of them, using their stock.
+	Options  []string  `json:"options"`
+	Variants []Variant `json:"variants"`
+	// CategoryIDs lists the categories the product is filed under; Tags are free-form labels.
+	CategoryIDs []string  `json:"category_ids"`
+	Tags        []string  `json:"tags"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Position is the product's place in the merchandised ("featured") order.
+	Position int `json:"position"`
+	// Archived products are hidden from the storefront but kept for past orders.
+	Archived bool `json:"archived"`
+	// Images are the uploaded images in display order; ImageURL is used when there are none.
+	Images []ProductImage `json:"images"`
 }
 
-// FormatPrice returns a formatted price string
-func (p *Product) FormatPrice() string {
-	return fmt.Sprintf("$%.2f", p.Price)
+// EffectiveTaxClass returns the product's tax class, TaxClassStandard if it has none.
+func (p *Product) EffectiveTaxClass() string {
+	if p.TaxClass == "" {
+		return TaxClassStandard
+	}
+	return p.TaxClass
 }
 
-// SeedProducts inserts initial product data into the database.
-func SeedProducts() error {
-	products := []Product{
-		{
-			ID:          "prod_1",
-			Name:        "Premium T-Shirt",
-			Description: "High quality cotton t-shirt with logo",
-			Price:       29.99,
-			ImageURL:    "/static/img/placeholder.svg",
-		},
-		{
-			ID:          "prod_2",
-			Name:        "Designer Jeans",
-			Description: "Comfortable jeans for everyday wear",
-			Price:       89.99,
-			ImageURL:    "/static/img/placeholder.svg",
-		},
-		{
-			ID:          "prod_3",
-			Name:        "Running Shoes",
-			Description: "Lightweight shoes for optimal performance",
-			Price:       119.99,
-			ImageURL:    "/static/img/placeholder.svg",
-		},
+// Matches reports whether the product passes the filter.
+func (p *Product) Matches(filter ProductFilter) bool {
+	if p.Archived && !filter.IncludeArchived {
+		return false
 	}
-
-	for _, p := range products {
-		// Check if product already exists
-		var count int
-		row := db.DB.QueryRow("SELECT COUNT(*) FROM products WHERE id = ?", p.ID)
-		err := row.Scan(&count)
-		if err != nil && err != sql.ErrNoRows {
-			return fmt.Errorf("error checking product existence: %w", err)
-		}
-
-		if count == 0 {
-			// Insert product if it doesn't exist
-			_, err := db.DB.Exec(
-				"INSERT INTO products (id, name, description, price, image_url) VALUES (?, ?, ?, ?, ?)",
-				p.ID, p.Name, p.Description, p.Price, p.ImageURL,
-			)
-			if err != nil {
-				return fmt.Errorf("error inserting product %s: %w", p.ID, err)
+	if len(filter.CategoryIDs) > 0 {
+		found := false
+		for _, id := range filter.CategoryIDs {
+			if containsString(p.CategoryIDs, id) {
+				found = true
+				break
 			}
 		}
+		if !found {
+			return false
+		}
+	}
+	if filter.Tag != "" && !containsString(p.Tags, filter.Tag) {
+		return false
+	}
+	if filter.Currency != "" || !filter.Price.IsZero() {
+		price, ok := p.listingPrice(filter.Currency)
+		if !ok || !filter.Price.Contains(price.Amount) {
+			return false
+		}
 	}
-	return nil
+	if filter.InStock && !p.InStock() {
+		return false
+	}
+	return true
 }
 
-// GetProducts returns a list of all products from the database.
-func GetProducts() ([]Product, error) {
-	rows, err := db.DB.Query("SELECT id, name, description, price, image_url FROM products")
-	if err != nil {
-		return nil, fmt.Errorf("error fetching products: %w", err)
+// listingPrice is the price the product is filtered and sorted by: its price
+// in currency, or its base price if currency is empty.
+func (p *Product) listingPrice(currency string) (Money, bool) {
+	if currency == "" {
+		return p.Price, true
 	}
-	defer rows.Close()
+	return p.PriceIn(currency)
+}
 
-	var products []Product
-	for rows.Next() {
-		var p Product
-		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.ImageURL); err != nil {
-			return nil, fmt.Errorf("error scanning product row: %w", err)
+// InStock reports whether at least one unit (of any variant) can be bought.
+func (p *Product) InStock() bool {
+	if p.HasVariants() {
+		for _, v := range p.Variants {
+			if v.InStock() {
+				return true
+			}
 		}
-		products = append(products, p)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error after iterating through product rows: %w", err)
+		return false
 	}
+	return p.CanFulfil(1)
+}
 
-	return products, nil
+// CanFulfil reports whether quantity units of a product without variants are available right now.
+func (p *Product) CanFulfil(quantity int) bool {
+	return !p.TrackInventory || p.Stock >= quantity
 }
 
-// GetProductByID returns a product with the specified ID from the database.
-func GetProductByID(id string) (Product, error) {
-	row := db.DB.QueryRow("SELECT id, name, description, price, image_url FROM products WHERE id = ?", id)
+// PriceIn returns the product's price in the given currency, if it has one.
+// A listed price in some other currency is not one.
+func (p *Product) PriceIn(currency string) (Money, bool) {
+	if price, ok := p.Prices[currency]; ok && price.Currency == currency {
+		return price, true
+	}
+	if p.Price.Currency == currency {
+		return p.Price, true
+	}
+	return Money{}, false
+}
 
-	var p Product
-	err := row.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.ImageURL)
-	if err == sql.ErrNoRows {
-		return Product{}, fmt.Errorf("product with ID %s not found", id)
+// InCurrency returns a copy of the product priced in the given currency, or
+// false if the product has no price in that currency.
+func (p Product) InCurrency(currency string) (Product, bool) {
+	price, ok := p.PriceIn(currency)
+	if !ok {
+		return p, false
 	}
-	if err != nil {
-		return Product{}, fmt.Errorf("error fetching product by ID %s: %w", id, err)
+	p.Price = price
+	return p, true
+}
+
+// LocalizeProducts prices each product in the given currency, dropping those
+// that cannot be bought in it.
+func LocalizeProducts(products []Product, currency string) []Product {
+	localized := make([]Product, 0, len(products))
+	for _, p := range products {
+		if lp, ok := p.InCurrency(currency); ok {
+			localized = append(localized, lp)
+		}
 	}
+	return localized
+}
 
-	return p, nil
+// FormatPrice returns a formatted price string
+func (p *Product) FormatPrice() string {
+	return p.Price.String()
 }
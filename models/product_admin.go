@@ -0,0 +1,186 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ValidationErrors maps the name of each invalid field to what is wrong with
+// it, e.g. "name" or "prices.EUR" or "variants.2.sku".
+type ValidationErrors map[string]string
+
+// Error lists the invalid fields and their problems in field order.
+func (v ValidationErrors) Error() string {
+	fields := make([]string, 0, len(v))
+	for field := range v {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	msgs := make([]string, len(fields))
+	for i, field := range fields {
+		msgs[i] = field + ": " + v[field]
+	}
+	return "invalid product: " + strings.Join(msgs, "; ")
+}
+
+// validID matches product and variant IDs, which appear in URLs.
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// NewProductID returns a fresh ID for a product created without one.
+func NewProductID() string {
+	return "prod_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:12]
+}
+
+// Validate checks the product before it is saved and normalizes its tags.
+// It returns nil if the product is valid.
+func (p *Product) Validate() ValidationErrors {
+	errs := ValidationErrors{}
+
+	if !validID.MatchString(p.ID) || len(p.ID) > 64 {
+		errs["id"] = "Use lower-case letters, digits, '-' and '_' only"
+	}
+	p.Name = strings.TrimSpace(p.Name)
+	switch {
+	case p.Name == "":
+		errs["name"] = "Name is required"
+	case len(p.Name) > 200:
+		errs["name"] = "Name must be at most 200 characters"
+	}
+
+	if p.Price.Currency == "" {
+		p.Price.Currency = DefaultCurrency
+	}
+	if !IsSupportedCurrency(p.Price.Currency) {
+		errs["prices."+p.Price.Currency] = "Unsupported currency"
+	} else if p.Price.Amount <= 0 {
+		errs["prices."+p.Price.Currency] = "Price must be greater than zero"
+	}
+	for currency, price := range p.Prices {
+		if !IsSupportedCurrency(currency) || price.Currency != currency {
+			errs["prices."+currency] = "Unsupported currency"
+		} else if price.Amount <= 0 {
+			errs["prices."+currency] = "Price must be greater than zero"
+		}
+	}
+
+	if p.Stock < 0 {
+		errs["stock"] = "Stock cannot be negative"
+	}
+	if p.Weight < 0 {
+		errs["weight"] = "Weight cannot be negative"
+	}
+	if p.TaxClass = p.EffectiveTaxClass(); !IsTaxClass(p.TaxClass) {
+		errs["tax_class"] = "Choose a tax class"
+	}
+
+	seen := make(map[string]bool)
+	for i, v := range p.Variants {
+		field := "variants." + strconv.Itoa(i)
+		sku := strings.TrimSpace(v.SKU)
+		switch {
+		case sku == "":
+			errs[field+".sku"] = "SKU is required"
+		case seen[sku]:
+			errs[field+".sku"] = "SKU is used by another variant"
+		}
+		seen[sku] = true
+		p.Variants[i].SKU = sku
+		if v.Stock < 0 {
+			errs[field+".stock"] = "Stock cannot be negative"
+		}
+		for currency, price := range v.Prices {
+			if !IsSupportedCurrency(currency) || price.Currency != currency {
+				errs[field+".prices."+currency] = "Unsupported currency"
+			} else if price.Amount <= 0 {
+				errs[field+".prices."+currency] = "Price must be greater than zero"
+			}
+		}
+		if !validID.MatchString(v.ID) {
+			errs[field+".id"] = "Invalid variant ID"
+		}
+	}
+
+	var tags []string
+	for _, tag := range p.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !containsString(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	p.Tags = tags
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// CreateProduct validates p and adds it to the catalog. Invalid products are
+// rejected with ValidationErrors; a taken ID or SKU is reported the same way.
+func CreateProduct(ctx context.Context, products ProductRepository, p Product) error {
+	if errs := p.Validate(); errs != nil {
+		return errs
+	}
+	err := products.Create(ctx, p)
+	switch {
+	case errors.Is(err, ErrSKUConflict):
+		return ValidationErrors{"variants": err.Error()}
+	case errors.Is(err, ErrConflict):
+		return ValidationErrors{"id": "A product with this ID already exists"}
+	}
+	return err
+}
+
+// UpdateProduct validates p and replaces the stored product with it, changing
+// the stored stock by stock.
+func UpdateProduct(ctx context.Context, products ProductRepository, p Product, stock StockChanges) error {
+	if errs := p.Validate(); errs != nil {
+		return errs
+	}
+	err := products.Update(ctx, p, stock)
+	if errors.Is(err, ErrSKUConflict) {
+		return ValidationErrors{"variants": err.Error()}
+	}
+	return err
+}
+
+// ArchiveProduct hides a product from the storefront. It stays in the
+// database so that past orders can still refer to it.
+func ArchiveProduct(ctx context.Context, products ProductRepository, id string) error {
+	return products.SetArchived(ctx, id, true)
+}
+
+// RestoreProduct puts an archived product back on the storefront.
+func RestoreProduct(ctx context.Context, products ProductRepository, id string) error {
+	return products.SetArchived(ctx, id, false)
+}
+
+// MoveProduct moves a product one place up (offset -1) or down (offset 1) in
+// the merchandising order.
+func MoveProduct(ctx context.Context, products ProductRepository, id string, offset int) error {
+	all, err := products.List(ctx, ProductFilter{IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+	ids := make([]string, len(all))
+	from := -1
+	for i, p := range all {
+		ids[i] = p.ID
+		if p.ID == id {
+			from = i
+		}
+	}
+	to := from + offset
+	if from < 0 || to < 0 || to >= len(ids) {
+		return nil
+	}
+	ids[from], ids[to] = ids[to], ids[from]
+	return products.Reorder(ctx, ids)
+}
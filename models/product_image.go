@@ -0,0 +1,170 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"ecommerce-app/imaging"
+	"ecommerce-app/storage"
+
+	"github.com/google/uuid"
+)
+
+// PlaceholderImageURL is shown for products without any image.
+const PlaceholderImageURL = "/static/img/placeholder.svg"
+
+// ProductImage is an uploaded product image, stored as thumbnail, medium and
+// large renditions (see imaging.Sizes).
+type ProductImage struct {
+	ID        string `json:"id"`
+	ProductID string `json:"product_id"`
+	Position  int    `json:"position"`
+	Alt       string `json:"alt"`
+	// StorageKey is the blob key the renditions are named after, e.g.
+	// "products/prod_1/img_ab12" for "products/prod_1/img_ab12-thumb.jpg".
+	StorageKey string `json:"-"`
+	Ext        string `json:"-"`
+	Width      int    `json:"width"` // of the original upload
+	Height     int    `json:"height"`
+	ThumbURL   string `json:"thumb_url"`
+	MediumURL  string `json:"medium_url"`
+	LargeURL   string `json:"large_url"`
+}
+
+// URL is the image's default, medium-sized rendition.
+func (img ProductImage) URL() string {
+	return img.MediumURL
+}
+
+// SrcSet lists the renditions with their widths for an <img srcset>
+// attribute, or returns "" for an image without renditions.
+func (img ProductImage) SrcSet() string {
+	if img.StorageKey == "" {
+		return ""
+	}
+	urls := map[string]string{"thumb": img.ThumbURL, "medium": img.MediumURL, "large": img.LargeURL}
+	var set []string
+	last := 0
+	for _, size := range imaging.Sizes {
+		width := size.Width
+		if img.Width < width {
+			width = img.Width
+		}
+		if width == last {
+			continue // smaller originals give several renditions the same width
+		}
+		last = width
+		set = append(set, urls[size.Name]+" "+strconv.Itoa(width)+"w")
+	}
+	return strings.Join(set, ", ")
+}
+
+// renditionKey is the blob key of one rendition of the image.
+func (img ProductImage) renditionKey(size string) string {
+	return img.StorageKey + "-" + size + img.Ext
+}
+
+// Image returns the product's main image: its first uploaded image, else its
+// image URL, else the placeholder.
+func (p *Product) Image() ProductImage {
+	if len(p.Images) > 0 {
+		return p.Images[0]
+	}
+	url := p.ImageURL
+	if url == "" {
+		url = PlaceholderImageURL
+	}
+	return ProductImage{ProductID: p.ID, Alt: p.Name, ThumbURL: url, MediumURL: url, LargeURL: url}
+}
+
+// UploadProductImage resizes an uploaded image into its renditions, stores
+// them in blobs and adds the image after the product's other images. It
+// returns an error wrapping imaging.ErrUnsupportedImage for files that are not images.
+func UploadProductImage(ctx context.Context, blobs storage.BlobStore, images ImageRepository, productID string, r io.Reader, alt string) (ProductImage, error) {
+	result, err := imaging.Process(r)
+	if err != nil {
+		return ProductImage{}, err
+	}
+
+	id := "img_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:12]
+	img := ProductImage{
+		ID:         id,
+		ProductID:  productID,
+		Alt:        strings.TrimSpace(alt),
+		StorageKey: "products/" + productID + "/" + id,
+		Ext:        result.Ext,
+		Width:      result.Width,
+		Height:     result.Height,
+	}
+	for _, rendition := range result.Renditions {
+		key := img.renditionKey(rendition.Name)
+		if err := blobs.Put(ctx, key, bytes.NewReader(rendition.Data), result.ContentType); err != nil {
+			return ProductImage{}, fmt.Errorf("error storing image %s: %w", key, err)
+		}
+		switch rendition.Name {
+		case "thumb":
+			img.ThumbURL = blobs.URL(key)
+		case "medium":
+			img.MediumURL = blobs.URL(key)
+		case "large":
+			img.LargeURL = blobs.URL(key)
+		}
+	}
+
+	if err := images.Add(ctx, img); err != nil {
+		deleteRenditions(ctx, blobs, img)
+		return ProductImage{}, err
+	}
+	return img, nil
+}
+
+// DeleteProductImage removes an image from the product and its renditions
+// from blobs.
+func DeleteProductImage(ctx context.Context, blobs storage.BlobStore, images ImageRepository, productID, imageID string) error {
+	img, err := images.Delete(ctx, productID, imageID)
+	if err != nil {
+		return err
+	}
+	return deleteRenditions(ctx, blobs, img)
+}
+
+// deleteRenditions removes every rendition of the image from blobs.
+func deleteRenditions(ctx context.Context, blobs storage.BlobStore, img ProductImage) error {
+	var firstErr error
+	for _, size := range imaging.Sizes {
+		if err := blobs.Delete(ctx, img.renditionKey(size.Name)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// MoveProductImage moves an image one place earlier (offset -1) or later
+// (offset 1) among the product's images. The first image is the main one.
+func MoveProductImage(ctx context.Context, images ImageRepository, productID, imageID string, offset int) error {
+	list, err := images.List(ctx, productID)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, len(list))
+	from := -1
+	for i, img := range list {
+		ids[i] = img.ID
+		if img.ID == imageID {
+			from = i
+		}
+	}
+	if from < 0 {
+		return fmt.Errorf("image %s of product %s: %w", imageID, productID, ErrNotFound)
+	}
+	to := from + offset
+	if to < 0 || to >= len(ids) {
+		return nil
+	}
+	ids[from], ids[to] = ids[to], ids[from]
+	return images.Reorder(ctx, productID, ids)
+}
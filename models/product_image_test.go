@@ -0,0 +1,172 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"ecommerce-app/imaging"
+	"ecommerce-app/storage"
+)
+
+func TestSrcSet(t *testing.T) {
+	img := ProductImage{StorageKey: "products/tee/img_1", ThumbURL: "/t.jpg", MediumURL: "/m.jpg", LargeURL: "/l.jpg"}
+	tests := []struct {
+		width int
+		want  string
+	}{
+		{2000, "/t.jpg 160w, /m.jpg 480w, /l.jpg 1200w"},
+		{1200, "/t.jpg 160w, /m.jpg 480w, /l.jpg 1200w"},
+		{300, "/t.jpg 160w, /m.jpg 300w"}, // medium and large are both 300 wide
+		{100, "/t.jpg 100w"},              // every rendition is the original
+	}
+	for _, tt := range tests {
+		img.Width = tt.width
+		if got := img.SrcSet(); got != tt.want {
+			t.Errorf("SrcSet of a %dpx wide image = %q, want %q", tt.width, got, tt.want)
+		}
+	}
+	if got := (ProductImage{ThumbURL: "/img.jpg", MediumURL: "/img.jpg", LargeURL: "/img.jpg"}).SrcSet(); got != "" {
+		t.Errorf("SrcSet of an image URL = %q, want none", got)
+	}
+}
+
+// testPNG returns a width x height opaque PNG file.
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.SetNRGBA(x, y, color.NRGBA{R: 80, G: 160, B: 240, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// storedBlobs returns the keys of the blobs stored under dir.
+func storedBlobs(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "products", "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, f := range files {
+		rel, err := filepath.Rel(dir, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, filepath.ToSlash(rel))
+	}
+	return keys
+}
+
+// unsavedImages is an ImageRepository that cannot add images.
+type unsavedImages struct {
+	ImageRepository
+}
+
+func (unsavedImages) Add(ctx context.Context, img ProductImage) error {
+	return errors.New("database is down")
+}
+
+func TestUploadProductImage(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range testStores(t, withCatalog(nil, []Product{{ID: "tee", Name: "Tee", Price: Money{1000, "USD"}}})) {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			blobs := storage.NewLocalStore(dir, "/static/uploads")
+
+			img, err := UploadProductImage(ctx, blobs, store.Images, "tee", bytes.NewReader(testPNG(t, 600, 400)), "  Front  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Alt != "Front" || img.Width != 600 || img.Height != 400 || img.Ext != ".jpg" {
+				t.Errorf("uploaded %+v, want a 600x400 JPEG with alt Front", img)
+			}
+			want := []string{img.StorageKey + "-large.jpg", img.StorageKey + "-medium.jpg", img.StorageKey + "-thumb.jpg"}
+			if got := storedBlobs(t, dir); !reflect.DeepEqual(got, want) {
+				t.Errorf("stored %v, want %v", got, want)
+			}
+			if img.ThumbURL != "/static/uploads/"+img.StorageKey+"-thumb.jpg" || !strings.HasPrefix(img.StorageKey, "products/tee/img_") {
+				t.Errorf("thumbnail at %s, want it under /static/uploads/products/tee", img.ThumbURL)
+			}
+			images, err := store.Images.List(ctx, "tee")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(images) != 1 || images[0].ID != img.ID || images[0].MediumURL != img.MediumURL {
+				t.Errorf("product images = %+v, want the upload", images)
+			}
+
+			// Nothing is stored for a file that is not an image
+			if _, err := UploadProductImage(ctx, blobs, store.Images, "tee", strings.NewReader("not an image"), ""); !errors.Is(err, imaging.ErrUnsupportedImage) {
+				t.Errorf("uploading text = %v, want ErrUnsupportedImage", err)
+			}
+			// The renditions are deleted again if the image cannot be added
+			if _, err := UploadProductImage(ctx, blobs, unsavedImages{store.Images}, "tee", bytes.NewReader(testPNG(t, 20, 20)), ""); err == nil {
+				t.Error("upload succeeded without adding the image")
+			}
+			if got := storedBlobs(t, dir); !reflect.DeepEqual(got, want) {
+				t.Errorf("after failed uploads stored %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestMoveProductImage(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		imageID string
+		offset  int
+		want    []string
+		wantErr error
+	}{
+		{name: "earlier", imageID: "img_b", offset: -1, want: []string{"img_b", "img_a", "img_c"}},
+		{name: "later", imageID: "img_b", offset: 1, want: []string{"img_a", "img_c", "img_b"}},
+		{name: "first stays first", imageID: "img_a", offset: -1, want: []string{"img_a", "img_b", "img_c"}},
+		{name: "last stays last", imageID: "img_c", offset: 1, want: []string{"img_a", "img_b", "img_c"}},
+		{name: "unknown image", imageID: "img_x", offset: 1, want: []string{"img_a", "img_b", "img_c"}, wantErr: ErrNotFound},
+	}
+	for _, tt := range tests {
+		for name, store := range testStores(t, withCatalog(nil, []Product{{ID: "tee", Name: "Tee", Price: Money{1000, "USD"}}})) {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				for _, id := range []string{"img_a", "img_b", "img_c"} {
+					key := "products/tee/" + id
+					img := ProductImage{ID: id, ProductID: "tee", StorageKey: key, Ext: ".jpg", Width: 600, Height: 400,
+						ThumbURL: key + "-thumb.jpg", MediumURL: key + "-medium.jpg", LargeURL: key + "-large.jpg"}
+					if err := store.Images.Add(ctx, img); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				if err := MoveProductImage(ctx, store.Images, "tee", tt.imageID, tt.offset); !errors.Is(err, tt.wantErr) {
+					t.Fatalf("MoveProductImage = %v, want %v", err, tt.wantErr)
+				}
+				images, err := store.Images.List(ctx, "tee")
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, img := range images {
+					got = append(got, img.ID)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("images in order %v, want %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
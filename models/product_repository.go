@@ -0,0 +1,581 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// SQLProductRepository is a ProductRepository backed by the products table
+// and its price, option, variant, category and tag tables.
+type SQLProductRepository struct {
+	sqlRepository
+}
+
+// productColumns are the products columns read by scanProduct, for a query
+// that aliases the products table as p.
+const productColumns = "p.id, p.name, p.description, p.price, p.currency, p.image_url, p.stock, p.track_inventory, p.tax_class, p.weight, p.created_at, p.position, p.archived"
+
+// scanProduct reads the productColumns of a row, followed by any extra destinations.
+func scanProduct(scanner interface{ Scan(...any) error }, p *Product, extra ...any) error {
+	dest := []any{&p.ID, &p.Name, &p.Description, &p.Price.Amount, &p.Price.Currency, &p.ImageURL, &p.Stock, &p.TrackInventory, &p.TaxClass, &p.Weight, &p.CreatedAt, &p.Position, &p.Archived}
+	return scanner.Scan(append(dest, extra...)...)
+}
+
+// List returns the products matching the filter from the database.
+func (r *SQLProductRepository) List(ctx context.Context, filter ProductFilter) ([]Product, error) {
+	from, args := r.listingFrom(filter, false)
+	where, whereArgs := r.listingWhere(filter, "")
+	query := "SELECT " + productColumns + from + whereClause(where) + " ORDER BY p.position, p.id"
+
+	rows, err := r.conn.QueryContext(ctx, r.q(query), append(args, whereArgs...)...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching products: %w", err)
+	}
+	defer rows.Close()
+
+	var products []Product
+	for rows.Next() {
+		var p Product
+		if err := scanProduct(rows, &p); err != nil {
+			return nil, fmt.Errorf("error scanning product row: %w", err)
+		}
+		products = append(products, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating through product rows: %w", err)
+	}
+	rows.Close()
+
+	if err := r.loadDetails(ctx, products); err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
+
+// GetByID returns a product with the specified ID from the database.
+func (r *SQLProductRepository) GetByID(ctx context.Context, id string) (Product, error) {
+	row := r.conn.QueryRowContext(ctx, r.q("SELECT "+productColumns+" FROM products p WHERE p.id = ?"), id)
+
+	var p Product
+	err := scanProduct(row, &p)
+	if err == sql.ErrNoRows {
+		return Product{}, fmt.Errorf("product with ID %s: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return Product{}, fmt.Errorf("error fetching product by ID %s: %w", id, err)
+	}
+
+	products := []Product{p}
+	if err := r.loadDetails(ctx, products); err != nil {
+		return Product{}, err
+	}
+
+	return products[0], nil
+}
+
+// loadDetails fills in the price lists, options, variants, categories, tags
+// and images of products, reading only the rows that belong to them.
+func (r *SQLProductRepository) loadDetails(ctx context.Context, products []Product) error {
+	byID := make(map[string]*Product, len(products))
+	productIDs := make([]string, len(products))
+	for i := range products {
+		byID[products[i].ID] = &products[i]
+		productIDs[i] = products[i].ID
+	}
+
+	// Price lists
+	err := r.scanRows(ctx, "SELECT product_id, currency, amount FROM product_prices", "product_id", productIDs, func(rows *sql.Rows) error {
+		var id string
+		var m Money
+		if err := rows.Scan(&id, &m.Currency, &m.Amount); err != nil {
+			return err
+		}
+		if p := byID[id]; p != nil {
+			if p.Prices == nil {
+				p.Prices = make(map[string]Money)
+			}
+			p.Prices[m.Currency] = m
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error fetching product prices: %w", err)
+	}
+
+	// Option names in display order
+	err = r.scanRows(ctx, "SELECT product_id, name FROM product_options", "product_id", productIDs, func(rows *sql.Rows) error {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		if p := byID[id]; p != nil {
+			p.Options = append(p.Options, name)
+		}
+		return nil
+	}, "position")
+	if err != nil {
+		return fmt.Errorf("error fetching product options: %w", err)
+	}
+
+	// Variants, then their option values and price overrides
+	variants := make(map[string]*Variant)
+	var variantOrder []string
+	err = r.scanRows(ctx, "SELECT id, product_id, sku, image_url, stock, track_inventory FROM product_variants", "product_id", productIDs, func(rows *sql.Rows) error {
+		v := &Variant{Options: make(map[string]string)}
+		if err := rows.Scan(&v.ID, &v.ProductID, &v.SKU, &v.ImageURL, &v.Stock, &v.TrackInventory); err != nil {
+			return err
+		}
+		variants[v.ID] = v
+		variantOrder = append(variantOrder, v.ID)
+		return nil
+	}, "position", "id")
+	if err != nil {
+		return fmt.Errorf("error fetching product variants: %w", err)
+	}
+
+	err = r.scanRows(ctx, "SELECT o.variant_id, o.option_name, o.value FROM product_variant_options o JOIN product_variants v ON v.id = o.variant_id", "v.product_id", productIDs, func(rows *sql.Rows) error {
+		var variantID, name, value string
+		if err := rows.Scan(&variantID, &name, &value); err != nil {
+			return err
+		}
+		if v := variants[variantID]; v != nil {
+			v.Options[name] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error fetching variant options: %w", err)
+	}
+
+	err = r.scanRows(ctx, "SELECT vp.variant_id, vp.currency, vp.amount FROM product_variant_prices vp JOIN product_variants v ON v.id = vp.variant_id", "v.product_id", productIDs, func(rows *sql.Rows) error {
+		var variantID string
+		var m Money
+		if err := rows.Scan(&variantID, &m.Currency, &m.Amount); err != nil {
+			return err
+		}
+		if v := variants[variantID]; v != nil {
+			if v.Prices == nil {
+				v.Prices = make(map[string]Money)
+			}
+			v.Prices[m.Currency] = m
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error fetching variant prices: %w", err)
+	}
+
+	for _, id := range variantOrder {
+		v := variants[id]
+		if p := byID[v.ProductID]; p != nil {
+			p.Variants = append(p.Variants, *v)
+		}
+	}
+
+	// Category assignments and tags
+	err = r.scanRows(ctx, "SELECT product_id, category_id FROM product_categories", "product_id", productIDs, func(rows *sql.Rows) error {
+		var id, categoryID string
+		if err := rows.Scan(&id, &categoryID); err != nil {
+			return err
+		}
+		if p := byID[id]; p != nil {
+			p.CategoryIDs = append(p.CategoryIDs, categoryID)
+		}
+		return nil
+	}, "category_id")
+	if err != nil {
+		return fmt.Errorf("error fetching product categories: %w", err)
+	}
+
+	err = r.scanRows(ctx, "SELECT product_id, tag FROM product_tags", "product_id", productIDs, func(rows *sql.Rows) error {
+		var id, tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return err
+		}
+		if p := byID[id]; p != nil {
+			p.Tags = append(p.Tags, tag)
+		}
+		return nil
+	}, "tag")
+	if err != nil {
+		return fmt.Errorf("error fetching product tags: %w", err)
+	}
+
+	// Uploaded images in display order
+	err = r.scanRows(ctx, "SELECT "+imageColumns+" FROM product_images", "product_id", productIDs, func(rows *sql.Rows) error {
+		var img ProductImage
+		if err := scanImage(rows, &img); err != nil {
+			return err
+		}
+		if p := byID[img.ProductID]; p != nil {
+			p.Images = append(p.Images, img)
+		}
+		return nil
+	}, "position", "id")
+	if err != nil {
+		return fmt.Errorf("error fetching product images: %w", err)
+	}
+
+	return nil
+}
+
+// scanRows runs query, restricted to rows where column is one of productIDs,
+// and calls scan for each row.
+func (r *SQLProductRepository) scanRows(ctx context.Context, query, column string, productIDs []string, scan func(*sql.Rows) error, orderBy ...string) error {
+	if len(productIDs) == 0 {
+		return nil
+	}
+	query += " WHERE " + column + " IN (" + placeholders(len(productIDs)) + ")"
+	args := make([]any, len(productIDs))
+	for i, id := range productIDs {
+		args[i] = id
+	}
+	for i, col := range orderBy {
+		if i == 0 {
+			query += " ORDER BY " + col
+		} else {
+			query += ", " + col
+		}
+	}
+
+	rows, err := r.conn.QueryContext(ctx, r.q(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// nextPosition places a new product after every existing one.
+const nextPosition = "(SELECT COALESCE(MAX(position) + 1, 0) FROM products)"
+
+// Create adds a new product with its details at the end of the merchandising order.
+func (r *SQLProductRepository) Create(ctx context.Context, p Product) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if commit fails
+
+	if err := r.create(ctx, tx, p); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// create adds a new product within tx.
+func (r *SQLProductRepository) create(ctx context.Context, tx *sql.Tx, p Product) error {
+	var exists int
+	if err := tx.QueryRowContext(ctx, r.q("SELECT COUNT(*) FROM products WHERE id = ?"), p.ID).Scan(&exists); err != nil {
+		return fmt.Errorf("error checking product %s: %w", p.ID, err)
+	}
+	if exists > 0 {
+		return fmt.Errorf("product with ID %s: %w", p.ID, ErrConflict)
+	}
+	if err := r.checkSKUs(ctx, tx, p); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx,
+		r.q("INSERT INTO products (id, name, description, price, currency, image_url, stock, track_inventory, tax_class, weight, created_at, position, archived) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, "+nextPosition+", ?)"),
+		p.ID, p.Name, p.Description, p.Price.Amount, p.Price.Currency, p.ImageURL, p.Stock, p.TrackInventory, p.EffectiveTaxClass(), p.Weight, p.Archived,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating product %s: %w", p.ID, err)
+	}
+	return r.insertDetails(ctx, tx, p)
+}
+
+// Update replaces the stored product and its details with p. Its position
+// and archived flag are left alone; see Reorder and SetArchived. Stock is
+// only changed by stock, relative to what is stored.
+func (r *SQLProductRepository) Update(ctx context.Context, p Product, stock StockChanges) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if commit fails
+
+	if err := r.update(ctx, tx, p, stock); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// update replaces the stored product within tx.
+func (r *SQLProductRepository) update(ctx context.Context, tx *sql.Tx, p Product, stock StockChanges) error {
+	res, err := tx.ExecContext(ctx,
+		r.q("UPDATE products SET name = ?, description = ?, price = ?, currency = ?, image_url = ?, stock = stock + ?, track_inventory = ?, tax_class = ?, weight = ? WHERE id = ?"),
+		p.Name, p.Description, p.Price.Amount, p.Price.Currency, p.ImageURL, stock[""], p.TrackInventory, p.EffectiveTaxClass(), p.Weight, p.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating product %s: %w", p.ID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("product with ID %s: %w", p.ID, ErrNotFound)
+	}
+	if err := r.checkSKUs(ctx, tx, p); err != nil {
+		return err
+	}
+
+	// Replace the price list, options, categories and tags outright
+	for _, table := range []string{"product_prices", "product_options", "product_categories", "product_tags"} {
+		if _, err := tx.ExecContext(ctx, r.q("DELETE FROM "+table+" WHERE product_id = ?"), p.ID); err != nil {
+			return fmt.Errorf("error clearing %s of product %s: %w", table, p.ID, err)
+		}
+	}
+
+	// Variants are updated in place, so that their IDs stay valid in carts and orders
+	variantIDs := []any{p.ID}
+	for _, v := range p.Variants {
+		variantIDs = append(variantIDs, v.ID)
+	}
+	query := "DELETE FROM product_variants WHERE product_id = ?"
+	if len(p.Variants) > 0 {
+		query += " AND id NOT IN (" + placeholders(len(p.Variants)) + ")"
+	}
+	if _, err := tx.ExecContext(ctx, r.q(query), variantIDs...); err != nil {
+		return fmt.Errorf("error deleting removed variants of product %s: %w", p.ID, err)
+	}
+	for position, v := range p.Variants {
+		_, err := tx.ExecContext(ctx,
+			r.q(`INSERT INTO product_variants (id, product_id, sku, image_url, stock, track_inventory, position) VALUES (?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT(id) DO UPDATE SET sku = excluded.sku, image_url = excluded.image_url, stock = product_variants.stock + ?,
+				track_inventory = excluded.track_inventory, position = excluded.position
+				WHERE product_variants.product_id = excluded.product_id`),
+			v.ID, p.ID, v.SKU, v.ImageURL, v.Stock, v.TrackInventory, position, stock[v.ID],
+		)
+		if err != nil {
+			return fmt.Errorf("error saving variant %s (%s): %w", v.ID, v.SKU, err)
+		}
+		for _, table := range []string{"product_variant_options", "product_variant_prices"} {
+			if _, err := tx.ExecContext(ctx, r.q("DELETE FROM "+table+" WHERE variant_id = ?"), v.ID); err != nil {
+				return fmt.Errorf("error clearing %s of variant %s: %w", table, v.ID, err)
+			}
+		}
+		if err := r.insertVariantValues(ctx, tx, v); err != nil {
+			return err
+		}
+	}
+
+	details := p
+	details.Variants = nil
+	return r.insertDetails(ctx, tx, details)
+}
+
+// SetArchived archives or restores a product.
+func (r *SQLProductRepository) SetArchived(ctx context.Context, id string, archived bool) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if commit fails
+
+	if err := r.setArchived(ctx, tx, id, archived); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// setArchived archives or restores a product within tx.
+func (r *SQLProductRepository) setArchived(ctx context.Context, tx *sql.Tx, id string, archived bool) error {
+	res, err := tx.ExecContext(ctx, r.q("UPDATE products SET archived = ? WHERE id = ?"), archived, id)
+	if err != nil {
+		return fmt.Errorf("error archiving product %s: %w", id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("product with ID %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// SaveAll makes the changes in a single transaction, in order.
+func (r *SQLProductRepository) SaveAll(ctx context.Context, changes []ProductChange) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if any change fails
+
+	for _, change := range changes {
+		p := change.Product
+		switch change.Action {
+		case ImportCreate:
+			err = r.create(ctx, tx, p)
+		case ImportUpdate:
+			if err = r.update(ctx, tx, p, change.Stock); err == nil {
+				err = r.setArchived(ctx, tx, p.ID, p.Archived)
+			}
+		case ImportDelete:
+			err = r.setArchived(ctx, tx, p.ID, true)
+		default:
+			err = fmt.Errorf("unknown action %q", change.Action)
+		}
+		if err != nil {
+			return fmt.Errorf("error saving product %s (%s): %w", p.ID, change.Action, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// Reorder gives each listed product its index in ids as its position.
+func (r *SQLProductRepository) Reorder(ctx context.Context, ids []string) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if commit fails
+
+	for position, id := range ids {
+		if _, err := tx.ExecContext(ctx, r.q("UPDATE products SET position = ? WHERE id = ?"), position, id); err != nil {
+			return fmt.Errorf("error moving product %s: %w", id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// checkSKUs returns an error wrapping ErrConflict if another product has a
+// variant with one of p's SKUs.
+func (r *SQLProductRepository) checkSKUs(ctx context.Context, tx *sql.Tx, p Product) error {
+	if len(p.Variants) == 0 {
+		return nil
+	}
+	args := []any{p.ID}
+	for _, v := range p.Variants {
+		args = append(args, v.SKU)
+	}
+	var sku string
+	err := tx.QueryRowContext(ctx,
+		r.q("SELECT sku FROM product_variants WHERE product_id <> ? AND sku IN ("+placeholders(len(p.Variants))+")"),
+		args...,
+	).Scan(&sku)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error checking SKUs of product %s: %w", p.ID, err)
+	}
+	return fmt.Errorf("%s: %w", sku, ErrSKUConflict)
+}
+
+// insertDetails adds the prices, options, variants, categories and tags of p
+// that are not stored yet.
+func (r *SQLProductRepository) insertDetails(ctx context.Context, tx *sql.Tx, p Product) error {
+	prices := []Money{p.Price}
+	for _, price := range p.Prices {
+		prices = append(prices, price)
+	}
+	for _, price := range prices {
+		_, err := tx.ExecContext(ctx,
+			r.q("INSERT INTO product_prices (product_id, currency, amount) VALUES (?, ?, ?) ON CONFLICT(product_id, currency) DO NOTHING"),
+			p.ID, price.Currency, price.Amount,
+		)
+		if err != nil {
+			return fmt.Errorf("error inserting %s price for product %s: %w", price.Currency, p.ID, err)
+		}
+	}
+
+	for position, name := range p.Options {
+		_, err := tx.ExecContext(ctx,
+			r.q("INSERT INTO product_options (product_id, name, position) VALUES (?, ?, ?) ON CONFLICT(product_id, name) DO NOTHING"),
+			p.ID, name, position,
+		)
+		if err != nil {
+			return fmt.Errorf("error inserting option %s for product %s: %w", name, p.ID, err)
+		}
+	}
+
+	for position, v := range p.Variants {
+		if err := r.insertVariant(ctx, tx, p.ID, position, v); err != nil {
+			return err
+		}
+	}
+
+	for _, categoryID := range p.CategoryIDs {
+		_, err := tx.ExecContext(ctx,
+			r.q("INSERT INTO product_categories (product_id, category_id) VALUES (?, ?) ON CONFLICT(product_id, category_id) DO NOTHING"),
+			p.ID, categoryID,
+		)
+		if err != nil {
+			return fmt.Errorf("error assigning product %s to category %s: %w", p.ID, categoryID, err)
+		}
+	}
+
+	for _, tag := range p.Tags {
+		_, err := tx.ExecContext(ctx,
+			r.q("INSERT INTO product_tags (product_id, tag) VALUES (?, ?) ON CONFLICT(product_id, tag) DO NOTHING"),
+			p.ID, tag,
+		)
+		if err != nil {
+			return fmt.Errorf("error tagging product %s with %s: %w", p.ID, tag, err)
+		}
+	}
+	return nil
+}
+
+// insertVariant adds a variant with its option values and price overrides
+// unless a variant with the same ID already exists.
+func (r *SQLProductRepository) insertVariant(ctx context.Context, tx *sql.Tx, productID string, position int, v Variant) error {
+	res, err := tx.ExecContext(ctx,
+		r.q("INSERT INTO product_variants (id, product_id, sku, image_url, stock, track_inventory, position) VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT(id) DO NOTHING"),
+		v.ID, productID, v.SKU, v.ImageURL, v.Stock, v.TrackInventory, position,
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting variant %s (%s): %w", v.ID, v.SKU, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	return r.insertVariantValues(ctx, tx, v)
+}
+
+// insertVariantValues adds the option values and price overrides of a variant.
+func (r *SQLProductRepository) insertVariantValues(ctx context.Context, tx *sql.Tx, v Variant) error {
+	for name, value := range v.Options {
+		_, err := tx.ExecContext(ctx,
+			r.q("INSERT INTO product_variant_options (variant_id, option_name, value) VALUES (?, ?, ?)"),
+			v.ID, name, value,
+		)
+		if err != nil {
+			return fmt.Errorf("error inserting option %s for variant %s: %w", name, v.ID, err)
+		}
+	}
+	for _, price := range v.Prices {
+		_, err := tx.ExecContext(ctx,
+			r.q("INSERT INTO product_variant_prices (variant_id, currency, amount) VALUES (?, ?, ?)"),
+			v.ID, price.Currency, price.Amount,
+		)
+		if err != nil {
+			return fmt.Errorf("error inserting %s price for variant %s: %w", price.Currency, v.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package models
+
+import (
+	"context"
+	"testing"
+)
+
+func TestProductPriceIn(t *testing.T) {
+	p := Product{
+		Price:  Money{2500, "USD"},
+		Prices: map[string]Money{"EUR": {2300, "EUR"}, "GBP": {2000, "USD"}},
+	}
+	variant := Variant{ID: "v1", Prices: map[string]Money{"USD": {2700, "USD"}, "EUR": {2400, "GBP"}}}
+
+	tests := []struct {
+		currency   string
+		variant    bool
+		want       Money
+		wantPriced bool
+	}{
+		{currency: "USD", want: Money{2500, "USD"}, wantPriced: true},
+		{currency: "EUR", want: Money{2300, "EUR"}, wantPriced: true},
+		{currency: "GBP"}, // listed, but in dollars
+		{currency: "JPY"},
+		{currency: "USD", variant: true, want: Money{2700, "USD"}, wantPriced: true},
+		{currency: "EUR", variant: true, want: Money{2300, "EUR"}, wantPriced: true}, // the override is in pounds
+		{currency: "GBP", variant: true},
+	}
+	for _, tt := range tests {
+		got, ok := p.PriceIn(tt.currency)
+		if tt.variant {
+			got, ok = p.VariantPriceIn(variant, tt.currency)
+		}
+		if ok != tt.wantPriced || got != tt.want {
+			t.Errorf("price in %s (variant %v) = %v, %v, want %v, %v", tt.currency, tt.variant, got, ok, tt.want, tt.wantPriced)
+		}
+	}
+}
+
+func TestLocalizeProducts(t *testing.T) {
+	ctx := context.Background()
+	products := []Product{
+		{ID: "tee", Name: "Tee", Price: Money{2500, "USD"}, Prices: map[string]Money{"EUR": {2300, "EUR"}},
+			Options: []string{"Size"}, Variants: []Variant{{ID: "tee-m", SKU: "TEE-M", Options: map[string]string{"Size": "M"},
+				Prices: map[string]Money{"EUR": {2400, "EUR"}}}}},
+		{ID: "mug", Name: "Mug", Price: Money{1500, "USD"}},
+	}
+	for name, store := range testStores(t, withCatalog(nil, products)) {
+		t.Run(name, func(t *testing.T) {
+			// The price lists come back from the store as they were saved
+			stored, err := store.Products.List(ctx, ProductFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			localized := LocalizeProducts(stored, "EUR")
+			if len(localized) != 1 || localized[0].ID != "tee" || localized[0].Price != (Money{2300, "EUR"}) {
+				t.Fatalf("in EUR: %+v, want only the tee at 23.00 EUR", localized)
+			}
+			if price, ok := localized[0].VariantPriceIn(localized[0].Variants[0], "EUR"); !ok || price != (Money{2400, "EUR"}) {
+				t.Errorf("tee in M costs %v, %v in EUR; want 24.00 EUR", price, ok)
+			}
+			if got := LocalizeProducts(stored, "USD"); len(got) != 2 {
+				t.Errorf("in USD: %d products, want both", len(got))
+			}
+			if got := LocalizeProducts(stored, "GBP"); len(got) != 0 {
+				t.Errorf("in GBP: %+v, want none", got)
+			}
+		})
+	}
+}
+
+func TestProductValidatePrices(t *testing.T) {
+	tests := []struct {
+		name      string
+		product   Product
+		wantField string // empty if valid
+	}{
+		{"valid", Product{Price: Money{2500, "USD"}, Prices: map[string]Money{"EUR": {2300, "EUR"}}}, ""},
+		{"default currency", Product{Price: Money{Amount: 2500}}, ""},
+		{"zero", Product{Price: Money{0, "USD"}}, "prices.USD"},
+		{"unsupported base", Product{Price: Money{2500, "CHF"}}, "prices.CHF"},
+		{"mislabelled", Product{Price: Money{2500, "USD"}, Prices: map[string]Money{"EUR": {2300, "GBP"}}}, "prices.EUR"},
+		{"mislabelled variant", Product{Price: Money{2500, "USD"}, Variants: []Variant{{ID: "v1", SKU: "V1", Prices: map[string]Money{"EUR": {2300, "USD"}}}}}, "variants.0.prices.EUR"},
+		{"free variant", Product{Price: Money{2500, "USD"}, Variants: []Variant{{ID: "v1", SKU: "V1", Prices: map[string]Money{"USD": {}}}}}, "variants.0.prices.USD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.product
+			p.ID, p.Name = "tee", "Tee"
+			errs := p.Validate()
+			if tt.wantField == "" {
+				if errs != nil {
+					t.Fatalf("Validate() = %v, want no errors", errs)
+				}
+				return
+			}
+			if _, ok := errs[tt.wantField]; !ok || len(errs) != 1 {
+				t.Errorf("Validate() = %v, want an error for %s", errs, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestProductUpdateStock(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			tee := Product{ID: "tee", Name: "Tee", Price: Money{1000, "USD"}, Stock: 5, TrackInventory: true}
+			hoodie := Product{ID: "hoodie", Name: "Hoodie", Price: Money{4000, "USD"}, Options: []string{"Size"},
+				Variants: []Variant{{ID: "var_m", SKU: "HOOD-M", Options: map[string]string{"Size": "M"}, Stock: 5, TrackInventory: true}}}
+			for _, p := range []Product{tee, hoodie} {
+				if err := store.Products.Create(ctx, p); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// The admin loads the forms, then two units of each are reserved
+			err := store.Inventory.Reserve(ctx, "order-1", []OrderItem{
+				{ProductID: "tee", Quantity: 2},
+				{ProductID: "hoodie", VariantID: "var_m", Quantity: 2},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Saving the tee with its stock unchanged keeps the reservation
+			tee.Name = "Plain Tee"
+			if err := store.Products.Update(ctx, tee, StockChangesBetween(tee, tee)); err != nil {
+				t.Fatal(err)
+			}
+			// Adding 4 units to the hoodie adds them to what is left, and a new variant gets its stock
+			edited := copyProduct(hoodie)
+			edited.Variants[0].Stock = 9
+			edited.Variants = append(edited.Variants, Variant{ID: "var_l", SKU: "HOOD-L", Options: map[string]string{"Size": "L"}, Stock: 7, TrackInventory: true})
+			if err := store.Products.Update(ctx, edited, StockChangesBetween(hoodie, edited)); err != nil {
+				t.Fatal(err)
+			}
+
+			stored, err := store.Products.GetByID(ctx, "tee")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Name != "Plain Tee" || stored.Stock != 3 {
+				t.Errorf("tee = %q with %d in stock, want %q with 3", stored.Name, stored.Stock, "Plain Tee")
+			}
+			stored, err = store.Products.GetByID(ctx, "hoodie")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for id, want := range map[string]int{"var_m": 7, "var_l": 7} {
+				if v, ok := stored.FindVariant(id); !ok || v.Stock != want {
+					t.Errorf("variant %s stock = %d (found %v), want %d", id, v.Stock, ok, want)
+				}
+			}
+		})
+	}
+}
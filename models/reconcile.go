@@ -0,0 +1,156 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// PaymentMismatch is an order whose status disagrees with its checkout
+// session at the payment provider, for staff to look into: paid here but not
+// at the provider, or cancelled or expired here but paid there. The payment
+// of the latter is refunded by the time it is reported.
+type PaymentMismatch struct {
+	OrderID  string
+	Status   OrderStatus // the order's status
+	Payment  Payment     // the checkout session at the provider
+	Refunded Money       // what the order has had refunded
+}
+
+// String describes the mismatch for logs and reports.
+func (m PaymentMismatch) String() string {
+	if m.Payment.Paid {
+		return fmt.Sprintf("order %s is %s, but checkout session %s was paid; %s refunded", m.OrderID, m.Status, m.Payment.SessionID, m.Refunded)
+	}
+	return fmt.Sprintf("order %s is %s, but checkout session %s is %s and unpaid", m.OrderID, m.Status, m.Payment.SessionID, m.Payment.Status)
+}
+
+// ReconcileReport says what a run of ReconcilePayments found and did.
+type ReconcileReport struct {
+	Checked    int // orders compared with the provider
+	Paid       int // pending orders found paid and marked paid
+	Expired    int // pending orders whose checkout session expired
+	Cancelled  int // pending orders without a checkout session, or whose session the provider does not know
+	Closed     int // open checkout sessions of cancelled or expired orders, now expired
+	Refunded   int // cancelled or expired orders found paid, and refunded; each is a mismatch too
+	Mismatches []PaymentMismatch
+	Errors     int // orders that could not be checked
+}
+
+// ReconcilePayments brings the orders placed before minAge ago that are not
+// reconciled yet in line with their checkout sessions, catching up on missed
+// webhooks. Pending orders are marked paid, expired or cancelled with their
+// stock released; cancelled or expired orders have open sessions expired and
+// payments refunded. Mismatches are reported for staff. An order is not
+// checked again once its session can no longer change.
+func ReconcilePayments(ctx context.Context, store *Store, payments PaymentProvider, minAge time.Duration) (ReconcileReport, error) {
+	var report ReconcileReport
+	orders, err := store.Orders.List(ctx, OrderFilter{Unreconciled: true, CreatedBefore: time.Now().Add(-minAge)})
+	if err != nil {
+		return report, err
+	}
+	for _, order := range orders {
+		if err := reconcileOrder(ctx, store, payments, order, &report); err != nil {
+			report.Errors++
+			log.Printf("Error reconciling order %s: %v", order.ID, err)
+		}
+	}
+	return report, nil
+}
+
+// reconcileOrder compares one order with its checkout session and adds the
+// outcome to the report.
+func reconcileOrder(ctx context.Context, store *Store, payments PaymentProvider, order *Order, report *ReconcileReport) error {
+	if order.StripeID == "" {
+		// The server stopped, or recording the session failed, between saving
+		// the order and recording its checkout session: nothing can be paid
+		// that would match the order, so its stock is given back
+		report.Checked++
+		if err := closeUnpaidOrder(ctx, store, order, OrderStatusCancelled, ActorSystem, "no checkout session was recorded"); err != nil {
+			return err
+		}
+		report.Cancelled++
+		return store.Orders.MarkReconciled(ctx, order.ID, time.Now())
+	}
+
+	payment, err := payments.RetrievePayment(ctx, order.StripeID)
+	if errors.Is(err, ErrNotFound) {
+		// e.g. the fake provider was restarted: the session can never be paid
+		report.Checked++
+		if order.Status == OrderStatusPending {
+			reason := fmt.Sprintf("checkout session %s not found at %s", order.StripeID, payments.Name())
+			if err := closeUnpaidOrder(ctx, store, order, OrderStatusCancelled, ActorSystem, reason); err != nil {
+				return err
+			}
+			report.Cancelled++
+		}
+		return store.Orders.MarkReconciled(ctx, order.ID, time.Now())
+	}
+	if err != nil {
+		return err
+	}
+	report.Checked++
+
+	if payment.PaymentIntentID != "" && order.PaymentIntentID == "" {
+		if err := recordPayment(ctx, store, order, payment.PaymentIntentID, ""); err != nil {
+			return err
+		}
+	}
+
+	reason := fmt.Sprintf("reconciled with checkout session %s", payment.SessionID)
+	switch order.Status {
+	case OrderStatusPending:
+		switch {
+		case payment.Paid:
+			if err := markOrderPaid(ctx, store, payments, order, ActorSystem, reason); err != nil {
+				return err
+			}
+			report.Paid++
+		case payment.Status == CheckoutExpired:
+			if err := closeUnpaidOrder(ctx, store, order, OrderStatusExpired, ActorSystem, reason); err != nil {
+				return err
+			}
+			report.Expired++
+		default:
+			return nil // still open, or a delayed payment that has not settled
+		}
+
+	case OrderStatusCancelled, OrderStatusExpired:
+		if payment.Status == CheckoutOpen {
+			// e.g. expiring it failed when the order was cancelled. Should the
+			// customer pay meanwhile, expiring fails and the next run finds
+			// the payment.
+			if err := payments.ExpireCheckoutSession(ctx, payment.SessionID); err != nil {
+				return err
+			}
+			report.Closed++
+			log.Printf("Expired checkout session %s of %s order %s", payment.SessionID, order.Status, order.ID)
+		} else if payment.Paid {
+			// e.g. the webhook for the payment was lost. Refunded on purpose
+			// rather than left to staff: the stock is gone and the customer
+			// gets nothing for the money.
+			if order.RefundedAmount.Amount < order.TotalAmount.Amount {
+				if err := refundLatePayment(ctx, store, payments, order); err != nil {
+					return err
+				}
+				report.Refunded++
+			}
+			report.mismatch(order, payment)
+		}
+
+	default: // paid, and possibly fulfilled or refunded since
+		if !payment.Paid {
+			report.mismatch(order, payment)
+		}
+	}
+	return store.Orders.MarkReconciled(ctx, order.ID, time.Now())
+}
+
+// mismatch logs and reports an order that disagrees with the provider.
+func (r *ReconcileReport) mismatch(order *Order, payment Payment) {
+	m := PaymentMismatch{OrderID: order.ID, Status: order.Status, Payment: payment, Refunded: order.RefundedAmount}
+	log.Printf("Warning: payment mismatch: %s", m)
+	r.Mismatches = append(r.Mismatches, m)
+}
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReconcilePayments(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		status  OrderStatus
+		session func(s *FakeCheckoutSession) // changes the order's session; nil for an order without one
+		unknown bool                         // the provider does not know the session
+		new     bool                         // the order was placed too recently to reconcile
+
+		want           ReconcileReport // without Mismatches
+		wantMismatch   bool
+		wantStatus     OrderStatus
+		wantStock      int // tees left in stock
+		wantReconciled bool
+		wantSession    CheckoutStatus // the session's status afterwards, if it has one
+	}{
+		{
+			name: "paid", status: OrderStatusPending, session: func(s *FakeCheckoutSession) { s.Status, s.Paid = CheckoutComplete, true },
+			want: ReconcileReport{Checked: 1, Paid: 1}, wantStatus: OrderStatusPaid, wantStock: 3, wantReconciled: true, wantSession: CheckoutComplete,
+		},
+		{
+			name: "expired", status: OrderStatusPending, session: func(s *FakeCheckoutSession) { s.Status = CheckoutExpired },
+			want: ReconcileReport{Checked: 1, Expired: 1}, wantStatus: OrderStatusExpired, wantStock: 5, wantReconciled: true, wantSession: CheckoutExpired,
+		},
+		{
+			name: "session unknown to the provider", status: OrderStatusPending, session: func(s *FakeCheckoutSession) {}, unknown: true,
+			want: ReconcileReport{Checked: 1, Cancelled: 1}, wantStatus: OrderStatusCancelled, wantStock: 5, wantReconciled: true,
+		},
+		{
+			name: "no session recorded", status: OrderStatusPending,
+			want: ReconcileReport{Checked: 1, Cancelled: 1}, wantStatus: OrderStatusCancelled, wantStock: 5, wantReconciled: true,
+		},
+		{
+			name: "still open", status: OrderStatusPending, session: func(s *FakeCheckoutSession) {},
+			want: ReconcileReport{Checked: 1}, wantStatus: OrderStatusPending, wantStock: 3, wantSession: CheckoutOpen,
+		},
+		{
+			name: "delayed payment not settled", status: OrderStatusPending, session: func(s *FakeCheckoutSession) { s.Status = CheckoutComplete },
+			want: ReconcileReport{Checked: 1}, wantStatus: OrderStatusPending, wantStock: 3, wantSession: CheckoutComplete,
+		},
+		{
+			name: "placed too recently", status: OrderStatusPending, new: true,
+			want: ReconcileReport{}, wantStatus: OrderStatusPending, wantStock: 3,
+		},
+		{
+			name: "cancelled with an open session", status: OrderStatusCancelled, session: func(s *FakeCheckoutSession) {},
+			want: ReconcileReport{Checked: 1, Closed: 1}, wantStatus: OrderStatusCancelled, wantStock: 5, wantReconciled: true, wantSession: CheckoutExpired,
+		},
+		{
+			name: "cancelled but paid", status: OrderStatusCancelled, session: func(s *FakeCheckoutSession) { s.Status, s.Paid = CheckoutComplete, true },
+			want: ReconcileReport{Checked: 1, Refunded: 1}, wantMismatch: true, wantStatus: OrderStatusCancelled, wantStock: 5, wantReconciled: true, wantSession: CheckoutComplete,
+		},
+		{
+			name: "paid but not at the provider", status: OrderStatusPaid, session: func(s *FakeCheckoutSession) { s.Status = CheckoutExpired },
+			want: ReconcileReport{Checked: 1}, wantMismatch: true, wantStatus: OrderStatusPaid, wantStock: 3, wantReconciled: true, wantSession: CheckoutExpired,
+		},
+		{
+			name: "paid", status: OrderStatusPaid, session: func(s *FakeCheckoutSession) { s.Status, s.Paid = CheckoutComplete, true },
+			want: ReconcileReport{Checked: 1}, wantStatus: OrderStatusPaid, wantStock: 3, wantReconciled: true, wantSession: CheckoutComplete,
+		},
+	}
+	for _, tt := range tests {
+		for name, store := range testStores(t) {
+			t.Run(string(tt.status)+" "+tt.name+"/"+name, func(t *testing.T) {
+				if err := store.Products.Create(ctx, Product{ID: "tee", Name: "Tee", Price: Money{1000, "USD"}, Stock: 5, TrackInventory: true}); err != nil {
+					t.Fatal(err)
+				}
+				payments, err := NewFakePaymentProvider()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				order := NewOrder("ann@example.com", "USD")
+				order.Items = []OrderItem{{ProductID: "tee", ProductName: "Tee", Quantity: 2, UnitPrice: Money{1000, "USD"}}}
+				order.CalculateTotal()
+				order.Status = tt.status
+				if !tt.new {
+					order.CreatedAt = time.Now().Add(-time.Hour)
+				}
+				if err := store.Orders.Save(ctx, order); err != nil {
+					t.Fatal(err)
+				}
+				if tt.status != OrderStatusCancelled {
+					// Pending orders hold their stock, and paid ones have sold it
+					if err := store.Inventory.Reserve(ctx, order.ID, order.Items); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if tt.session != nil {
+					if _, err := payments.CreateCheckoutSession(ctx, order, "", ""); err != nil {
+						t.Fatal(err)
+					}
+					tt.session(payments.sessions[order.StripeID])
+					if tt.unknown {
+						delete(payments.sessions, order.StripeID)
+					}
+					if err := store.Orders.SetCheckoutSession(ctx, order.ID, order.StripeID); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				report, err := ReconcilePayments(ctx, store, payments, 30*time.Minute)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := len(report.Mismatches) == 1; got != tt.wantMismatch || len(report.Mismatches) > 1 {
+					t.Errorf("mismatches = %v, want one: %v", report.Mismatches, tt.wantMismatch)
+				}
+				report.Mismatches = nil
+				if !reflect.DeepEqual(report, tt.want) {
+					t.Errorf("report = %+v, want %+v", report, tt.want)
+				}
+
+				stored, err := store.Orders.GetByID(ctx, order.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stored.Status != tt.wantStatus {
+					t.Errorf("order is %s, want %s", stored.Status, tt.wantStatus)
+				}
+				product, err := store.Products.GetByID(ctx, "tee")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if product.Stock != tt.wantStock {
+					t.Errorf("stock = %d, want %d", product.Stock, tt.wantStock)
+				}
+				if s, ok := payments.sessions[order.StripeID]; ok && s.Status != tt.wantSession {
+					t.Errorf("session is %s, want %s", s.Status, tt.wantSession)
+				}
+				if s, ok := payments.sessions[order.StripeID]; ok && s.Refunded.Amount != stored.RefundedAmount.Amount {
+					t.Errorf("order refunded %s, provider refunded %s", stored.RefundedAmount, s.Refunded)
+				}
+
+				// Reconciled orders are not looked up again
+				again, err := ReconcilePayments(ctx, store, payments, 30*time.Minute)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if reconciled := again.Checked == 0; reconciled != tt.wantReconciled && !tt.new {
+					t.Errorf("reconciled = %v, want %v", reconciled, tt.wantReconciled)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrInvalidRefund is returned (wrapped) when a refund asks for nothing, for
+// more than is left to refund, or for an order that was not paid.
+var ErrInvalidRefund = errors.New("invalid refund")
+
+// ErrRefundNotRecorded is returned (wrapped) when the payment provider gave
+// the money back but the refund could not be saved afterwards.
+var ErrRefundNotRecorded = errors.New("refund made but not recorded")
+
+// Refund is money given back to the customer for an order, as a whole or for
+// some of its items.
+type Refund struct {
+	ID         int64        `json:"id"` // Database ID for the refund
+	OrderID    string       `json:"order_id"`
+	ProviderID string       `json:"provider_id"` // the payment provider's ID for the refund
+	Amount     Money        `json:"amount"`
+	Reason     string       `json:"reason"`
+	Restocked  bool         `json:"restocked"` // the refunded units were put back in stock
+	Items      []RefundItem `json:"items"`
+	CreatedAt  time.Time    `json:"created_at"`
+}
+
+// RefundItem is the part of a refund for one line of the order.
+type RefundItem struct {
+	ProductID string `json:"product_id"`
+	VariantID string `json:"variant_id,omitempty"`
+	Quantity  int    `json:"quantity"`
+	Amount    Money  `json:"amount"` // what the customer paid for the units
+}
+
+// Key identifies the order line the item refunds, as OrderItem.Key does.
+func (i RefundItem) Key() string {
+	if i.VariantID != "" {
+		return i.VariantID
+	}
+	return i.ProductID
+}
+
+// RefundRequest describes a refund for RefundOrder to make.
+type RefundRequest struct {
+	// Whole refunds everything not refunded yet: the remaining units of every
+	// item, and shipping. Otherwise Quantities gives the units to refund by
+	// the Key of the order line, each refunded at what the customer paid.
+	Whole      bool
+	Quantities map[string]int
+	Reason     string
+	Restock    bool // put the refunded units back in stock
+}
+
+// RefundedQuantities adds up the units the refunds were for, by order line key.
+func RefundedQuantities(refunds []Refund) map[string]int {
+	quantities := make(map[string]int)
+	for _, refund := range refunds {
+		for _, item := range refund.Items {
+			quantities[item.Key()] += item.Quantity
+		}
+	}
+	return quantities
+}
+
+// refundedItemAmounts adds up what the refunds gave back, by order line key.
+func refundedItemAmounts(refunds []Refund) map[string]int64 {
+	amounts := make(map[string]int64)
+	for _, refund := range refunds {
+		for _, item := range refund.Items {
+			amounts[item.Key()] += item.Amount.Amount
+		}
+	}
+	return amounts
+}
+
+// RefundedAmount adds up the amounts of the refunds. Refunds are read back
+// from storage, so one in another currency is an error rather than a panic.
+func RefundedAmount(refunds []Refund, currency string) (Money, error) {
+	total := Money{Currency: currency}
+	for _, refund := range refunds {
+		if refund.Amount.Currency != currency {
+			return Money{}, fmt.Errorf("refund %d of order %s is in %s, not %s", refund.ID, refund.OrderID, refund.Amount.Currency, currency)
+		}
+		total = total.Add(refund.Amount)
+	}
+	return total, nil
+}
+
+// PaidForItems is what the customer paid for each of the order's items: its
+// price less its share of the discounts that apply to it, plus its tax when
+// that was charged on top of the price. The discounts are shared out as
+// ApplyTax does, so the amounts add up to the order's total less shipping.
+func (o *Order) PaidForItems() []Money {
+	keys := make([]string, len(o.Items))
+	totals := make([]int64, len(o.Items))
+	for i, item := range o.Items {
+		keys[i], totals[i] = item.Key(), item.UnitPrice.Mul(item.Quantity).Amount
+	}
+	off := shareDiscounts(keys, totals, o.Discounts)
+	paid := make([]Money, len(o.Items))
+	for i, item := range o.Items {
+		amount := item.UnitPrice.Mul(item.Quantity)
+		amount.Amount -= off[i]
+		if !o.TaxInclusive {
+			for _, tax := range item.Taxes {
+				amount.Amount += tax.Amount.Amount
+			}
+		}
+		paid[i] = Money{Amount: amount.Amount, Currency: o.Currency()}
+	}
+	return paid
+}
+
+// RefundOrder gives back money for a paid order through the payment provider,
+// records the refund, puts the refunded units back in stock if asked to, and
+// moves the order to refunded, or to partially refunded while some of the
+// payment is left. It returns an error wrapping ErrInvalidRefund if the order
+// was not paid or the request asks for nothing or for more than is left, and
+// one wrapping ErrConflict if another refund of the order was made meanwhile.
+// Once the provider has made the refund it is always returned, with an error
+// wrapping ErrRefundNotRecorded if saving its outcome failed.
+// Refunds are made by staff, so the status change is recorded as theirs.
+func RefundOrder(ctx context.Context, store *Store, payments PaymentProvider, order *Order, req RefundRequest) (*Refund, error) {
+	if !order.Status.CanTransitionTo(OrderStatusRefunded) {
+		return nil, fmt.Errorf("order %s cannot be refunded while %s: %w", order.ID, order.Status, ErrInvalidRefund)
+	}
+	previous, err := store.Refunds.ListByOrder(ctx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := RefundedAmount(previous, order.Currency()); err != nil {
+		return nil, err
+	}
+	remaining := order.TotalAmount.Amount - order.RefundedAmount.Amount
+	refunded := RefundedQuantities(previous)
+	refundedAmounts := refundedItemAmounts(previous)
+	paid := order.PaidForItems()
+
+	refund := &Refund{
+		OrderID:   order.ID,
+		Amount:    Money{Currency: order.Currency()},
+		Reason:    req.Reason,
+		CreatedAt: time.Now(),
+	}
+	var units []OrderItem // the refunded units, to restock
+	for i, item := range order.Items {
+		left := item.Quantity - refunded[item.Key()]
+		quantity := req.Quantities[item.Key()]
+		if req.Whole {
+			quantity = left
+		}
+		if quantity < 0 || quantity > left {
+			return nil, fmt.Errorf("cannot refund %d of %s, %d left to refund: %w", quantity, item.DisplayName(), left, ErrInvalidRefund)
+		}
+		if quantity == 0 {
+			continue
+		}
+		amount := Money{Amount: paid[i].Amount * int64(quantity) / int64(item.Quantity), Currency: order.Currency()}
+		if quantity == left {
+			// The last units take what is left of the item, so that rounding
+			// never leaves part of it unrefunded
+			amount.Amount = paid[i].Amount - refundedAmounts[item.Key()]
+		}
+		refund.Items = append(refund.Items, RefundItem{ProductID: item.ProductID, VariantID: item.VariantID, Quantity: quantity, Amount: amount})
+		refund.Amount = refund.Amount.Add(amount)
+		item.Quantity = quantity
+		units = append(units, item)
+	}
+	if req.Whole {
+		refund.Amount.Amount = remaining
+	}
+	// Rounding must never take the refunds past what was paid
+	refund.Amount.Amount = min(refund.Amount.Amount, remaining)
+	if refund.Amount.Amount <= 0 {
+		return nil, fmt.Errorf("nothing to refund on order %s: %w", order.ID, ErrInvalidRefund)
+	}
+
+	// Record the refund and claim its amount first: only one of two refunds
+	// made at the same time gets past here, and the other has to be made
+	// again with what is left, which takes in the units refunded here.
+	if err := store.Refunds.Create(ctx, order, refund); err != nil {
+		return nil, err
+	}
+	refund.ProviderID, err = payments.Refund(ctx, order, refund.Amount, req.Reason)
+	if err != nil {
+		if deleteErr := store.Refunds.Delete(ctx, order, refund); deleteErr != nil {
+			log.Printf("Error deleting refund %d of order %s the provider did not make: %v", refund.ID, order.ID, deleteErr)
+		}
+		return nil, err
+	}
+	log.Printf("Refunded %s of order %s (%s).", refund.Amount, order.ID, refund.ProviderID)
+
+	if req.Restock && len(units) > 0 {
+		if err := store.Inventory.Restock(ctx, units); err != nil {
+			log.Printf("Error restocking refunded items of order %s: %v", order.ID, err)
+		} else {
+			refund.Restocked = true
+		}
+	}
+
+	if err := store.Refunds.Update(ctx, refund); err != nil {
+		// The money has been given back, so say so rather than just failing
+		return refund, fmt.Errorf("%w: refund %s of %s for order %s: %w", ErrRefundNotRecorded, refund.ProviderID, refund.Amount, order.ID, err)
+	}
+
+	status := OrderStatusPartiallyRefunded
+	if refund.Amount.Amount >= remaining {
+		status = OrderStatusRefunded
+	}
+	err = UpdateOrderStatus(ctx, store.Orders, order, status, ActorAdmin, fmt.Sprintf("refunded %s: %s", refund.Amount, req.Reason))
+	if errors.Is(err, ErrInvalidTransition) {
+		// The provider's charge.refunded webhook can get there first
+		log.Printf("Warning: Order %s was refunded but cannot be marked as %s: %v", order.ID, status, err)
+		return refund, nil
+	}
+	if err != nil {
+		return refund, fmt.Errorf("%w: error updating order %s status to %s: %w", ErrRefundNotRecorded, order.ID, status, err)
+	}
+	return refund, nil
+}
@@ -0,0 +1,160 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SQLRefundRepository is a RefundRepository backed by the refunds and refund_items tables.
+type SQLRefundRepository struct {
+	sqlRepository
+}
+
+// Create adds the refund's amount to the order's refunded_amount and inserts
+// the refund and its items, in a single transaction.
+func (r *SQLRefundRepository) Create(ctx context.Context, o *Order, refund *Refund) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if commit fails
+
+	res, err := tx.ExecContext(ctx,
+		r.q("UPDATE orders SET refunded_amount = refunded_amount + ?, updated_at = ? WHERE id = ? AND refunded_amount = ?"),
+		refund.Amount.Amount, time.Now(), o.ID, o.RefundedAmount.Amount,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating refunded amount of order %s: %w", o.ID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error updating refunded amount of order %s: %w", o.ID, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("refunded amount of order %s changed: %w", o.ID, ErrConflict)
+	}
+
+	err = tx.QueryRowContext(ctx,
+		r.q("INSERT INTO refunds (order_id, provider_id, amount, currency, reason, restocked, created_at) VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id"),
+		refund.OrderID, refund.ProviderID, refund.Amount.Amount, refund.Amount.Currency, refund.Reason, refund.Restocked, refund.CreatedAt,
+	).Scan(&refund.ID)
+	if err != nil {
+		return fmt.Errorf("error saving refund of order %s: %w", refund.OrderID, err)
+	}
+	for _, item := range refund.Items {
+		_, err := tx.ExecContext(ctx,
+			r.q("INSERT INTO refund_items (refund_id, product_id, variant_id, quantity, amount) VALUES (?, ?, ?, ?, ?)"),
+			refund.ID, item.ProductID, item.VariantID, item.Quantity, item.Amount.Amount,
+		)
+		if err != nil {
+			return fmt.Errorf("error saving refunded item %s of order %s: %w", item.Key(), refund.OrderID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	o.RefundedAmount.Amount += refund.Amount.Amount
+	return nil
+}
+
+// Update saves the refund's provider_id and restocked.
+func (r *SQLRefundRepository) Update(ctx context.Context, refund *Refund) error {
+	_, err := r.conn.ExecContext(ctx,
+		r.q("UPDATE refunds SET provider_id = ?, restocked = ? WHERE id = ?"),
+		refund.ProviderID, refund.Restocked, refund.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating refund %d of order %s: %w", refund.ID, refund.OrderID, err)
+	}
+	return nil
+}
+
+// Delete removes the refund and its items and takes its amount off the
+// order's refunded_amount, in a single transaction.
+func (r *SQLRefundRepository) Delete(ctx context.Context, o *Order, refund *Refund) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if commit fails
+
+	if _, err := tx.ExecContext(ctx, r.q("DELETE FROM refund_items WHERE refund_id = ?"), refund.ID); err != nil {
+		return fmt.Errorf("error deleting items of refund %d: %w", refund.ID, err)
+	}
+	if _, err := tx.ExecContext(ctx, r.q("DELETE FROM refunds WHERE id = ?"), refund.ID); err != nil {
+		return fmt.Errorf("error deleting refund %d: %w", refund.ID, err)
+	}
+	var refunded int64
+	err = tx.QueryRowContext(ctx,
+		r.q("UPDATE orders SET refunded_amount = refunded_amount - ?, updated_at = ? WHERE id = ? RETURNING refunded_amount"),
+		refund.Amount.Amount, time.Now(), o.ID,
+	).Scan(&refunded)
+	if err != nil {
+		return fmt.Errorf("error updating refunded amount of order %s: %w", o.ID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	o.RefundedAmount.Amount = refunded
+	return nil
+}
+
+// ListByOrder returns the refunds of the order with their items, oldest first.
+func (r *SQLRefundRepository) ListByOrder(ctx context.Context, orderID string) ([]Refund, error) {
+	rows, err := r.conn.QueryContext(ctx,
+		r.q("SELECT id, order_id, provider_id, amount, currency, reason, restocked, created_at FROM refunds WHERE order_id = ? ORDER BY id"),
+		orderID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching refunds of order %s: %w", orderID, err)
+	}
+	var refunds []Refund
+	for rows.Next() {
+		var refund Refund
+		if err := rows.Scan(&refund.ID, &refund.OrderID, &refund.ProviderID, &refund.Amount.Amount, &refund.Amount.Currency,
+			&refund.Reason, &refund.Restocked, &refund.CreatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning refund row of order %s: %w", orderID, err)
+		}
+		refunds = append(refunds, refund)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating through refund rows of order %s: %w", orderID, err)
+	}
+
+	for i := range refunds {
+		if refunds[i].Items, err = r.items(ctx, refunds[i]); err != nil {
+			return nil, err
+		}
+	}
+	return refunds, nil
+}
+
+// items returns the items of the refund.
+func (r *SQLRefundRepository) items(ctx context.Context, refund Refund) ([]RefundItem, error) {
+	rows, err := r.conn.QueryContext(ctx,
+		r.q("SELECT product_id, variant_id, quantity, amount FROM refund_items WHERE refund_id = ? ORDER BY product_id, variant_id"),
+		refund.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching items of refund %d: %w", refund.ID, err)
+	}
+	defer rows.Close()
+
+	var items []RefundItem
+	for rows.Next() {
+		item := RefundItem{Amount: Money{Currency: refund.Amount.Currency}}
+		if err := rows.Scan(&item.ProductID, &item.VariantID, &item.Quantity, &item.Amount.Amount); err != nil {
+			return nil, fmt.Errorf("error scanning item row of refund %d: %w", refund.ID, err)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating through item rows of refund %d: %w", refund.ID, err)
+	}
+	return items, nil
+}
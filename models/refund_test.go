@@ -0,0 +1,332 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// refundCatalog is a setup for testStores adding the tee and mug that
+// refundTestOrder sells.
+var refundCatalog = withCatalog(nil, []Product{
+	{ID: "tee", Name: "Tee", Stock: 5, TrackInventory: true},
+	{ID: "mug", Name: "Mug", Stock: 5, TrackInventory: true},
+})
+
+// refundTestOrder stores a paid order in a store set up with refundCatalog,
+// with a paid session at the fake provider, for 3 tees at $10.00 taxed at
+// 7.25% and a $30.00 mug, less a $10.00 discount, plus $5.00 shipping: $56.81
+// in all. The tees were paid $26.81 and the mug $25.00.
+func refundTestOrder(t *testing.T, ctx context.Context, store *Store) (*FakePaymentProvider, *Order) {
+	t.Helper()
+	payments, err := NewFakePaymentProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := NewOrder("ann@example.com", "USD")
+	order.Items = []OrderItem{
+		{ProductID: "tee", ProductName: "Tee", Quantity: 3, UnitPrice: Money{1000, "USD"},
+			Taxes: []TaxLine{{Name: "CA Sales Tax", Rate: 7250, Amount: Money{181, "USD"}}}},
+		{ProductID: "mug", ProductName: "Mug", Quantity: 1, UnitPrice: Money{3000, "USD"}},
+	}
+	order.Discounts = []Discount{{Code: "TEN", Amount: Money{1000, "USD"}}}
+	order.Shipping = ShippingRate{Method: "standard", Amount: Money{500, "USD"}}
+	order.CalculateTotal()
+	order.Status = OrderStatusPaid
+	if order.TotalAmount.Amount != 5681 {
+		t.Fatalf("order total = %d, want 5681", order.TotalAmount.Amount)
+	}
+	if err := store.Orders.Save(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := payments.CreateCheckoutSession(ctx, order, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Orders.SetCheckoutSession(ctx, order.ID, order.StripeID); err != nil {
+		t.Fatal(err)
+	}
+	payments.sessions[order.StripeID].Paid = true
+	return payments, order
+}
+
+func TestRefundOrder(t *testing.T) {
+	type step struct {
+		req        RefundRequest
+		wantAmount int64
+		wantErr    error
+	}
+	units := func(quantities map[string]int) RefundRequest {
+		return RefundRequest{Quantities: quantities, Reason: "returned"}
+	}
+	whole := RefundRequest{Whole: true, Reason: "returned"}
+
+	tests := []struct {
+		name       string
+		steps      []step
+		wantStatus OrderStatus
+	}{
+		{"one unit", []step{{units(map[string]int{"tee": 1}), 893, nil}}, OrderStatusPartiallyRefunded},
+		{"last units take the rounding remainder", []step{
+			{units(map[string]int{"tee": 1}), 893, nil},
+			{units(map[string]int{"tee": 1}), 893, nil},
+			{units(map[string]int{"tee": 1}), 895, nil},
+		}, OrderStatusPartiallyRefunded},
+		{"every item leaves shipping", []step{
+			{units(map[string]int{"tee": 2}), 1787, nil},
+			{units(map[string]int{"tee": 1, "mug": 1}), 3394, nil},
+		}, OrderStatusPartiallyRefunded},
+		{"whole order", []step{{whole, 5681, nil}}, OrderStatusRefunded},
+		{"rest after items", []step{
+			{units(map[string]int{"mug": 1}), 2500, nil},
+			{whole, 3181, nil},
+		}, OrderStatusRefunded},
+		{"more units than left", []step{
+			{units(map[string]int{"tee": 2}), 1787, nil},
+			{units(map[string]int{"tee": 2}), 0, ErrInvalidRefund},
+		}, OrderStatusPartiallyRefunded},
+		{"nothing", []step{{units(nil), 0, ErrInvalidRefund}}, OrderStatusPaid},
+		{"negative quantity", []step{{units(map[string]int{"tee": -1}), 0, ErrInvalidRefund}}, OrderStatusPaid},
+		{"after refunding everything", []step{
+			{whole, 5681, nil},
+			{whole, 0, ErrInvalidRefund},
+		}, OrderStatusRefunded},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		for name, store := range testStores(t, refundCatalog) {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				payments, order := refundTestOrder(t, ctx, store)
+
+				var refunded int64
+				for i, s := range tt.steps {
+					refund, err := RefundOrder(ctx, store, payments, order, s.req)
+					if !errors.Is(err, s.wantErr) {
+						t.Fatalf("step %d: RefundOrder = %v, want %v", i, err, s.wantErr)
+					}
+					if err != nil {
+						continue
+					}
+					if refund.Amount != (Money{s.wantAmount, "USD"}) {
+						t.Errorf("step %d: refunded %+v, want %d", i, refund.Amount, s.wantAmount)
+					}
+					refunded += refund.Amount.Amount
+				}
+
+				stored, err := store.Orders.GetByID(ctx, order.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stored.Status != tt.wantStatus {
+					t.Errorf("status = %s, want %s", stored.Status, tt.wantStatus)
+				}
+				if stored.RefundedAmount.Amount != refunded {
+					t.Errorf("refunded amount = %d, want %d", stored.RefundedAmount.Amount, refunded)
+				}
+				refunds, err := store.Refunds.ListByOrder(ctx, order.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, err := RefundedAmount(refunds, "USD"); err != nil || got.Amount != refunded {
+					t.Errorf("recorded refunds add up to %s (%v), want %d", got, err, refunded)
+				}
+				if session, _ := payments.Session(order.StripeID); session.Refunded.Amount != refunded {
+					t.Errorf("provider refunded %d, want %d", session.Refunded.Amount, refunded)
+				}
+			})
+		}
+	}
+}
+
+func TestRefundOrderRestock(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range testStores(t, refundCatalog) {
+		t.Run(name, func(t *testing.T) {
+			payments, order := refundTestOrder(t, ctx, store)
+
+			req := RefundRequest{Quantities: map[string]int{"tee": 2}, Reason: "returned", Restock: true}
+			refund, err := RefundOrder(ctx, store, payments, order, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !refund.Restocked {
+				t.Error("refund not marked restocked")
+			}
+			tee, err := store.Products.GetByID(ctx, "tee")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tee.Stock != 7 {
+				t.Errorf("tee stock = %d, want 7", tee.Stock)
+			}
+		})
+	}
+}
+
+func TestRefundOrderConflict(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range testStores(t, refundCatalog) {
+		t.Run(name, func(t *testing.T) {
+			payments, order := refundTestOrder(t, ctx, store)
+			// A second admin has the order open from before the first refund
+			stale, err := store.Orders.GetByID(ctx, order.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := RefundOrder(ctx, store, payments, order, RefundRequest{Whole: true, Reason: "returned"}); err != nil {
+				t.Fatal(err)
+			}
+			stale.Status = OrderStatusPaid
+			_, err = RefundOrder(ctx, store, payments, stale, RefundRequest{Whole: true, Reason: "returned"})
+			if !errors.Is(err, ErrConflict) {
+				t.Fatalf("second refund = %v, want ErrConflict", err)
+			}
+			if session, _ := payments.Session(order.StripeID); session.Refunded.Amount != 5681 {
+				t.Errorf("provider refunded %d, want 5681", session.Refunded.Amount)
+			}
+		})
+	}
+}
+
+func TestRefundOrderProviderFails(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range testStores(t, refundCatalog) {
+		t.Run(name, func(t *testing.T) {
+			payments, order := refundTestOrder(t, ctx, store)
+			payments.sessions[order.StripeID].Paid = false // the provider refuses the refund
+
+			if _, err := RefundOrder(ctx, store, payments, order, RefundRequest{Whole: true, Reason: "returned"}); err == nil {
+				t.Fatal("RefundOrder succeeded without a payment to refund")
+			}
+			stored, err := store.Orders.GetByID(ctx, order.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.RefundedAmount.Amount != 0 || stored.Status != OrderStatusPaid {
+				t.Errorf("after a failed refund: refunded %d, status %s; want 0, paid", stored.RefundedAmount.Amount, stored.Status)
+			}
+			if refunds, _ := store.Refunds.ListByOrder(ctx, order.ID); len(refunds) != 0 {
+				t.Errorf("recorded %d refunds, want none", len(refunds))
+			}
+		})
+	}
+}
+
+func TestRefundedAmountMixedCurrencies(t *testing.T) {
+	refunds := []Refund{{ID: 1, OrderID: "o1", Amount: Money{500, "USD"}}, {ID: 2, OrderID: "o1", Amount: Money{300, "EUR"}}}
+	if total, err := RefundedAmount(refunds, "USD"); err == nil {
+		t.Errorf("RefundedAmount = %s, want an error for the refund in EUR", total)
+	}
+	if total, err := RefundedAmount(refunds[:1], "USD"); err != nil || total != (Money{500, "USD"}) {
+		t.Errorf("RefundedAmount = %s, %v; want $5.00", total, err)
+	}
+}
+
+// unsavedRefunds is a RefundRepository that cannot save a refund's outcome.
+type unsavedRefunds struct {
+	RefundRepository
+}
+
+func (unsavedRefunds) Update(ctx context.Context, refund *Refund) error {
+	return errors.New("database is down")
+}
+
+func TestRefundOrderNotRecorded(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range testStores(t, refundCatalog) {
+		t.Run(name, func(t *testing.T) {
+			payments, order := refundTestOrder(t, ctx, store)
+			store.Refunds = unsavedRefunds{store.Refunds}
+
+			refund, err := RefundOrder(ctx, store, payments, order, RefundRequest{Whole: true, Reason: "returned"})
+			if !errors.Is(err, ErrRefundNotRecorded) {
+				t.Fatalf("err = %v, want ErrRefundNotRecorded", err)
+			}
+			if refund == nil || refund.ProviderID == "" {
+				t.Errorf("refund = %+v, want the one the provider made", refund)
+			}
+		})
+	}
+}
+
+func TestRefundOrderWhileAnotherIsMade(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range testStores(t, refundCatalog) {
+		t.Run(name, func(t *testing.T) {
+			payments, order := refundTestOrder(t, ctx, store)
+			// Another admin's refund of two tees has been recorded, but the provider
+			// has not made it yet
+			other := &Refund{OrderID: order.ID, Amount: Money{1787, "USD"}, Reason: "returned",
+				Items: []RefundItem{{ProductID: "tee", Quantity: 2, Amount: Money{1787, "USD"}}}}
+			if err := store.Refunds.Create(ctx, order, other); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := RefundOrder(ctx, store, payments, order, RefundRequest{Quantities: map[string]int{"tee": 2}, Reason: "returned"})
+			if !errors.Is(err, ErrInvalidRefund) {
+				t.Fatalf("refunding the same tees again = %v, want ErrInvalidRefund", err)
+			}
+			if _, err := RefundOrder(ctx, store, payments, order, RefundRequest{Quantities: map[string]int{"tee": 1}, Reason: "returned"}); err != nil {
+				t.Errorf("refunding the last tee: %v", err)
+			}
+		})
+	}
+}
+
+func TestRefundRepository(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			order := NewOrder("ann@example.com", "USD")
+			order.Items = []OrderItem{{ProductID: "tee", ProductName: "Tee", Quantity: 2, UnitPrice: Money{1000, "USD"}}}
+			order.CalculateTotal()
+			order.Status = OrderStatusPaid
+			if err := store.Orders.Save(ctx, order); err != nil {
+				t.Fatal(err)
+			}
+			stale := *order
+			refund := &Refund{OrderID: order.ID, Amount: Money{1000, "USD"}, Reason: "returned", CreatedAt: time.Now(),
+				Items: []RefundItem{{ProductID: "tee", Quantity: 1, Amount: Money{1000, "USD"}}}}
+			if err := store.Refunds.Create(ctx, order, refund); err != nil {
+				t.Fatal(err)
+			}
+			if order.RefundedAmount.Amount != 1000 {
+				t.Errorf("order refunded %s, want 1000", order.RefundedAmount)
+			}
+
+			// A refund claiming from a stale copy of the order is not stored
+			again := &Refund{OrderID: order.ID, Amount: Money{1000, "USD"}, Reason: "returned", CreatedAt: time.Now()}
+			if err := store.Refunds.Create(ctx, &stale, again); !errors.Is(err, ErrConflict) {
+				t.Fatalf("stale Create = %v, want ErrConflict", err)
+			}
+
+			refund.ProviderID, refund.Restocked = "re_1", true
+			if err := store.Refunds.Update(ctx, refund); err != nil {
+				t.Fatal(err)
+			}
+			refunds, err := store.Refunds.ListByOrder(ctx, order.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(refunds) != 1 || refunds[0].ProviderID != "re_1" || !refunds[0].Restocked || len(refunds[0].Items) != 1 {
+				t.Fatalf("refunds = %+v, want the one refund, updated", refunds)
+			}
+
+			if err := store.Refunds.Delete(ctx, order, refund); err != nil {
+				t.Fatal(err)
+			}
+			if refunds, _ := store.Refunds.ListByOrder(ctx, order.ID); len(refunds) != 0 {
+				t.Errorf("refunds = %+v after deleting, want none", refunds)
+			}
+			stored, err := store.Orders.GetByID(ctx, order.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.RefundedAmount.Amount != 0 || order.RefundedAmount.Amount != 0 {
+				t.Errorf("refunded %s (stored %s) after deleting, want 0", order.RefundedAmount, stored.RefundedAmount)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"ecommerce-app/db"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotFound is returned (wrapped) by repositories when a record does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrOutOfStock is returned (wrapped) when a reservation asks for more units than are available.
+var ErrOutOfStock = errors.New("out of stock")
+
+// ErrConflict is returned (wrapped) when creating a record whose ID or unique key is already taken.
+var ErrConflict = errors.New("already exists")
+
+// ErrSKUConflict is the ErrConflict returned when a variant SKU belongs to another product.
+var ErrSKUConflict = fmt.Errorf("SKU %w", ErrConflict)
+
+// ProductFilter narrows down a product listing. The zero value matches every product.
+type ProductFilter struct {
+	CategoryIDs []string // products assigned to any of these categories
+	Tag         string   // products carrying this tag
+	// Currency prices the products for Price and the price sorts; products
+	// without a price in it are left out. Empty uses each product's base price.
+	Currency string
+	Price    PriceRange // products priced within this range
+	InStock  bool       // only products with at least one unit available
+	// IncludeArchived lists archived products too; the storefront never sets it.
+	IncludeArchived bool
+}
+
+// ProductChange is one change saved by ProductRepository.SaveAll.
+type ProductChange struct {
+	// Action creates the product, updates it like Update, also setting its
+	// archived flag, or deletes it, which archives it
+	Action  ImportAction
+	Product Product      // only the ID is used to delete
+	Stock   StockChanges // the stock changes of an update
+}
+
+// ProductRepository provides access to the product catalog.
+type ProductRepository interface {
+	// List returns the products matching the filter.
+	List(ctx context.Context, filter ProductFilter) ([]Product, error)
+	// ListPage returns one page of the products matching the request's filter,
+	// in its sort order, or an error wrapping ErrInvalidCursor.
+	ListPage(ctx context.Context, req ProductPageRequest) (ProductPage, error)
+	// Facets counts the products matching filter by category, price range and availability.
+	Facets(ctx context.Context, filter ProductFilter, categories []Category) (ProductFacets, error)
+	// Create adds a new product at the end of the merchandising order, or
+	// returns an error wrapping ErrConflict if its ID or a variant SKU is taken.
+	Create(ctx context.Context, p Product) error
+	// Update replaces the stored product, including its prices, options,
+	// variants, categories and tags, with p. Variants missing from p are
+	// deleted. The stock of the product and of its existing variants is left
+	// as stored, other than being changed by stock; new variants start with
+	// their Stock.
+	Update(ctx context.Context, p Product, stock StockChanges) error
+	// SetArchived archives or restores the product with the given ID.
+	SetArchived(ctx context.Context, id string, archived bool) error
+	// SaveAll makes the changes in order, in a single transaction: if one
+	// fails, none of them are saved.
+	SaveAll(ctx context.Context, changes []ProductChange) error
+	// Reorder sets the merchandising order of the given products to their order in ids.
+	Reorder(ctx context.Context, ids []string) error
+	// GetByID returns the product with the given ID or an error wrapping ErrNotFound.
+	GetByID(ctx context.Context, id string) (Product, error)
+}
+
+// OrderRepository persists orders and their items.
+type OrderRepository interface {
+	// Save inserts or updates the order together with its items. Updating an
+	// order leaves its status, checkout session, payment IDs and refunded
+	// amount as stored; they are changed with UpdateStatus, SetCheckoutSession,
+	// UpdatePayment and AddRefunded.
+	// It returns a *CouponError, and saves nothing, if other orders have used
+	// one of the order's coupons as many times as its limits allow.
+	Save(ctx context.Context, o *Order) error
+	// GetByID returns the order with the given ID or an error wrapping ErrNotFound.
+	GetByID(ctx context.Context, id string) (*Order, error)
+	// GetByStripeID returns the order for a Stripe Checkout Session ID or an error wrapping ErrNotFound.
+	GetByStripeID(ctx context.Context, stripeID string) (*Order, error)
+	// GetByPaymentIntentID returns the order paid by a Stripe PaymentIntent or an error wrapping ErrNotFound.
+	GetByPaymentIntentID(ctx context.Context, paymentIntentID string) (*Order, error)
+	// List returns the orders matching the filter, newest first.
+	List(ctx context.Context, filter OrderFilter) ([]*Order, error)
+	// UpdateStatus records the change and moves the order to change.To, if it
+	// still has the status change.From, and updates o to match. Otherwise it
+	// returns an error wrapping ErrInvalidTransition. Use UpdateOrderStatus,
+	// which checks that the transition is allowed.
+	UpdateStatus(ctx context.Context, o *Order, change OrderStatusChange) error
+	// SetCheckoutSession records the order's checkout session ID, or returns an
+	// error wrapping ErrNotFound.
+	SetCheckoutSession(ctx context.Context, orderID, stripeID string) error
+	// UpdatePayment saves the PaymentIntentID, ChargeID and DisputeStatus of the order.
+	UpdatePayment(ctx context.Context, o *Order) error
+	// AddRefunded adds amount, which may be negative, to the order's refunded
+	// amount if that is still o.RefundedAmount, and updates o to match.
+	// Otherwise, e.g. when another refund of the order got there first, it
+	// returns an error wrapping ErrConflict.
+	AddRefunded(ctx context.Context, o *Order, amount Money) error
+	// MarkReconciled records that the order's status agrees with the payment
+	// provider for good, so that it is no longer listed as unreconciled.
+	MarkReconciled(ctx context.Context, orderID string, at time.Time) error
+	// StatusHistory returns the status changes of the order, oldest first.
+	StatusHistory(ctx context.Context, orderID string) ([]OrderStatusChange, error)
+}
+
+// OrderFilter narrows down an order listing. The zero value matches every order.
+type OrderFilter struct {
+	Status        OrderStatus // orders with this status
+	CreatedBefore time.Time   // orders placed before this time, if set
+	// Unreconciled selects the orders not reconciled with the payment provider
+	// yet: those with a checkout session, and pending ones that never got one
+	Unreconciled bool
+	Limit        int // at most this many orders; 0 for no limit
+}
+
+// InventoryRepository manages stock levels and the reservations held by
+// orders that are waiting for payment.
+type InventoryRepository interface {
+	// Reserve atomically takes stock for every item of the order. If any item is
+	// short, nothing is reserved and the error wraps ErrOutOfStock.
+	Reserve(ctx context.Context, orderID string, items []OrderItem) error
+	// Release returns the order's outstanding reservations to stock.
+	Release(ctx context.Context, orderID string) error
+	// Commit turns the order's outstanding reservations into permanent sales.
+	Commit(ctx context.Context, orderID string) error
+	// Restock puts the items back in stock, e.g. after they were refunded.
+	// Items whose product or variant does not track inventory are skipped.
+	Restock(ctx context.Context, items []OrderItem) error
+}
+
+// CartRepository keeps shopping carts between visits, one per owner.
+type CartRepository interface {
+	// Get returns the cart owned by key or an error wrapping ErrNotFound.
+	Get(ctx context.Context, key CartKey) (*Cart, error)
+	// Save stores the cart, with its items and coupons, as the cart owned by key,
+	// replacing the owner's previous cart and taking the cart from any other owner.
+	Save(ctx context.Context, key CartKey, cart *Cart) error
+	// Delete removes the cart owned by key, if there is one.
+	Delete(ctx context.Context, key CartKey) error
+	// Prune deletes the carts last saved before the cutoff and returns how many there were.
+	Prune(ctx context.Context, before time.Time) (int, error)
+}
+
+// CouponRepository stores discount codes and counts the orders that used them.
+type CouponRepository interface {
+	// List returns every coupon, newest first.
+	List(ctx context.Context) ([]Coupon, error)
+	// GetByCode returns the coupon with the given code, in any case, or an error wrapping ErrNotFound.
+	GetByCode(ctx context.Context, code string) (Coupon, error)
+	// Create adds a coupon, or returns an error wrapping ErrConflict if its code is taken.
+	Create(ctx context.Context, c Coupon) error
+	// Update replaces the coupon with the same code, including the products and
+	// categories it is limited to, or returns an error wrapping ErrNotFound.
+	Update(ctx context.Context, c Coupon) error
+	// Uses counts the orders that used the coupon and were not cancelled or
+	// expired: in total, and those placed with customerEmail.
+	Uses(ctx context.Context, code, customerEmail string) (total, byCustomer int, err error)
+}
+
+// CategoryRepository provides access to the category tree.
+type CategoryRepository interface {
+	// List returns every category.
+	List(ctx context.Context) ([]Category, error)
+	// GetBySlug returns the category with the given slug or an error wrapping ErrNotFound.
+	GetBySlug(ctx context.Context, slug string) (Category, error)
+	// Insert adds a category, doing nothing if a category with the same ID exists.
+	Insert(ctx context.Context, c Category) error
+}
+
+// ImageRepository stores the records of uploaded product images; the image
+// files themselves are kept in a storage.BlobStore.
+type ImageRepository interface {
+	// List returns the product's images in display order.
+	List(ctx context.Context, productID string) ([]ProductImage, error)
+	// Add adds an image after the product's other images.
+	Add(ctx context.Context, img ProductImage) error
+	// Delete removes the image and returns it, or returns an error wrapping ErrNotFound.
+	Delete(ctx context.Context, productID, id string) (ProductImage, error)
+	// Reorder sets the display order of the product's images to their order in ids.
+	Reorder(ctx context.Context, productID string, ids []string) error
+}
+
+// SearchRepository finds products by full-text search over their name,
+// description, tags and variant SKUs.
+type SearchRepository interface {
+	// Search returns up to limit products matching every term of query, best
+	// match first. Terms match word prefixes too, so partial input works for typeahead.
+	Search(ctx context.Context, query string, limit int) ([]SearchHit, error)
+}
+
+// WebhookEventRepository keeps the webhook events received from the payment
+// provider, so that each is handled once and can be replayed.
+type WebhookEventRepository interface {
+	// Claim stores a newly received event with the outcome processing and
+	// reports true, or, if the event is stored already and its handling failed
+	// or was claimed more than webhookClaimTimeout ago and never finished, sets
+	// it back to processing and reports true. It reports false for events that
+	// were handled or are being handled.
+	Claim(ctx context.Context, e WebhookEvent) (bool, error)
+	// Restart sets a stored event back to processing, whatever its outcome, or
+	// returns an error wrapping ErrNotFound.
+	Restart(ctx context.Context, id string) error
+	// Finish records the outcome of handling the event.
+	Finish(ctx context.Context, id string, outcome WebhookOutcome, message string) error
+	// GetByID returns the event with the given ID or an error wrapping ErrNotFound.
+	GetByID(ctx context.Context, id string) (WebhookEvent, error)
+	// List returns up to limit events, with the outcome if one is given, most recently received first.
+	List(ctx context.Context, outcome WebhookOutcome, limit int) ([]WebhookEvent, error)
+}
+
+// RefundRepository keeps the refunds made for orders.
+type RefundRepository interface {
+	// Create stores the refund with its items, sets its ID and adds its amount
+	// to the order's refunded amount, all at once, if that is still
+	// o.RefundedAmount, and updates o to match. Otherwise, e.g. when another
+	// refund of the order got there first, it stores nothing and returns an
+	// error wrapping ErrConflict. Refunds are stored before the money moves,
+	// so that two refunds of an order always see each other's items.
+	Create(ctx context.Context, o *Order, refund *Refund) error
+	// Update saves the refund's ProviderID and Restocked.
+	Update(ctx context.Context, refund *Refund) error
+	// Delete removes a refund the payment provider did not make and takes its
+	// amount off the order's refunded amount, updating o to match.
+	Delete(ctx context.Context, o *Order, refund *Refund) error
+	// ListByOrder returns the refunds of the order, oldest first.
+	ListByOrder(ctx context.Context, orderID string) ([]Refund, error)
+}
+
+// Store groups the repositories used by the application.
+type Store struct {
+	Products      ProductRepository
+	Orders        OrderRepository
+	Carts         CartRepository
+	Coupons       CouponRepository
+	Inventory     InventoryRepository
+	Categories    CategoryRepository
+	Search        SearchRepository
+	Images        ImageRepository
+	WebhookEvents WebhookEventRepository
+	Refunds       RefundRepository
+}
+
+// NewSQLStore returns a Store backed by the given database connection.
+func NewSQLStore(conn *sql.DB, dialect string) *Store {
+	base := sqlRepository{conn: conn, dialect: dialect}
+	products := &SQLProductRepository{base}
+	return &Store{
+		Products:      products,
+		Orders:        &SQLOrderRepository{base},
+		Carts:         &SQLCartRepository{base},
+		Coupons:       &SQLCouponRepository{base},
+		Inventory:     &SQLInventoryRepository{base},
+		Categories:    &SQLCategoryRepository{base},
+		Search:        &SQLSearchRepository{sqlRepository: base, products: products},
+		Images:        &SQLImageRepository{base},
+		WebhookEvents: &SQLWebhookEventRepository{base},
+		Refunds:       &SQLRefundRepository{base},
+	}
+}
+
+// NewMemoryStore returns a Store that keeps everything in memory. It is
+// intended for tests and for running handlers without a database.
+func NewMemoryStore() *Store {
+	products := NewMemoryProductRepository()
+	orders := NewMemoryOrderRepository()
+	coupons := NewMemoryCouponRepository(orders)
+	orders.coupons = coupons
+	return &Store{
+		Products:      products,
+		Orders:        orders,
+		Carts:         NewMemoryCartRepository(),
+		Coupons:       coupons,
+		Inventory:     NewMemoryInventoryRepository(products),
+		Categories:    NewMemoryCategoryRepository(),
+		Search:        NewMemorySearchRepository(products),
+		Images:        NewMemoryImageRepository(products),
+		WebhookEvents: NewMemoryWebhookEventRepository(),
+		Refunds:       NewMemoryRefundRepository(orders),
+	}
+}
+
+// sqlRepository holds what every SQL-backed repository needs.
+type sqlRepository struct {
+	conn    *sql.DB
+	dialect string
+}
+
+// q adapts a query written with "?" placeholders to the repository's dialect.
+func (r sqlRepository) q(query string) string {
+	return db.Rebind(r.dialect, query)
+}
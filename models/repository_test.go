@@ -0,0 +1,58 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"ecommerce-app/db"
+	"testing"
+)
+
+// newSQLiteStore returns a Store backed by a migrated in-memory SQLite
+// database, closed when the test ends, and the connection to it.
+func newSQLiteStore(t *testing.T) (*Store, *sql.DB) {
+	t.Helper()
+	conn, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetMaxOpenConns(1) // every connection would get its own in-memory database
+	t.Cleanup(func() { conn.Close() })
+	if _, err := db.MigrateUp(conn, "sqlite"); err != nil {
+		t.Fatal(err)
+	}
+	return NewSQLStore(conn, "sqlite"), conn
+}
+
+// testStores returns a memory store and a SQLite store, by name, for tests
+// that check both behave the same. Each setup is run on both, e.g. to give
+// them the same catalog with withCatalog.
+func testStores(t *testing.T, setup ...func(t *testing.T, store *Store)) map[string]*Store {
+	t.Helper()
+	sqlite, _ := newSQLiteStore(t)
+	stores := map[string]*Store{"memory": NewMemoryStore(), "sqlite": sqlite}
+	for _, store := range stores {
+		for _, s := range setup {
+			s(t, store)
+		}
+	}
+	return stores
+}
+
+// withCatalog is a setup for testStores that adds the categories, then the
+// products.
+func withCatalog(categories []Category, products []Product) func(t *testing.T, store *Store) {
+	return func(t *testing.T, store *Store) {
+		t.Helper()
+		ctx := context.Background()
+		for _, c := range categories {
+			if err := store.Categories.Insert(ctx, c); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, p := range products {
+			if err := store.Products.Create(ctx, p); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"html"
+	"html/template"
+	"strings"
+	"unicode"
+)
+
+// Markers the search index wraps around matched terms. They are control
+// characters so they cannot clash with product text, and are turned into
+// <mark> tags only after the text has been HTML-escaped.
+const (
+	highlightStart = "\x02"
+	highlightEnd   = "\x03"
+)
+
+// SearchHit is a product matching a search query.
+type SearchHit struct {
+	Product Product
+	// Name and Snippet are HTML with the matched terms wrapped in <mark>.
+	Name    template.HTML
+	Snippet template.HTML
+	Rank    float64 // higher is a better match
+}
+
+// searchTerms splits a query into lower-case words, dropping punctuation and
+// any search syntax so that user input is always treated as plain text.
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// highlightHTML escapes text and turns the index's match markers into <mark> tags.
+func highlightHTML(text string) template.HTML {
+	escaped := html.EscapeString(text)
+	escaped = strings.ReplaceAll(escaped, highlightStart, "<mark>")
+	escaped = strings.ReplaceAll(escaped, highlightEnd, "</mark>")
+	return template.HTML(escaped)
+}
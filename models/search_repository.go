@@ -0,0 +1,87 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// SQLSearchRepository is a SearchRepository backed by the products_fts FTS5
+// table on SQLite and the product_search tsvector table on PostgreSQL.
+type SQLSearchRepository struct {
+	sqlRepository
+	products ProductRepository
+}
+
+// Search returns the products best matching query.
+func (r *SQLSearchRepository) Search(ctx context.Context, query string, limit int) ([]SearchHit, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	var sqlQuery string
+	var args []any
+	if r.dialect == "postgres" {
+		// Every term may match as a prefix; ts_rank is higher for better matches
+		for i, t := range terms {
+			terms[i] = t + ":*"
+		}
+		options := "StartSel=" + highlightStart + ", StopSel=" + highlightEnd
+		sqlQuery = `SELECT ps.product_id,
+				ts_headline('simple', ps.name, q, ?),
+				ts_headline('simple', ps.description, q, ?),
+				ts_rank(ps.document, q)
+			FROM product_search ps JOIN products p ON p.id = ps.product_id, to_tsquery('simple', ?) q
+			WHERE ps.document @@ q AND p.archived = FALSE
+			ORDER BY ts_rank(ps.document, q) DESC, ps.product_id
+			LIMIT ?`
+		args = []any{options + ", HighlightAll=true", options + ", MaxWords=24, MinWords=12", strings.Join(terms, " & "), limit}
+	} else {
+		// Quoting each term keeps FTS5 syntax out of the query; bm25 is lower
+		// for better matches and weights name, description, tags and SKUs.
+		for i, t := range terms {
+			terms[i] = `"` + t + `"*`
+		}
+		sqlQuery = `SELECT products_fts.product_id,
+				highlight(products_fts, 1, ?, ?),
+				snippet(products_fts, 2, ?, ?, '…', 24),
+				-bm25(products_fts, 0.0, 10.0, 1.0, 5.0, 5.0) AS rank
+			FROM products_fts JOIN products p ON p.id = products_fts.product_id
+			WHERE products_fts MATCH ? AND p.archived = FALSE
+			ORDER BY rank DESC, products_fts.product_id
+			LIMIT ?`
+		args = []any{highlightStart, highlightEnd, highlightStart, highlightEnd, strings.Join(terms, " "), limit}
+	}
+
+	rows, err := r.conn.QueryContext(ctx, r.q(sqlQuery), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error searching products for %q: %w", query, err)
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		var hit SearchHit
+		var name, snippet string
+		if err := rows.Scan(&hit.Product.ID, &name, &snippet, &hit.Rank); err != nil {
+			return nil, fmt.Errorf("error scanning search result row: %w", err)
+		}
+		hit.Name = highlightHTML(name)
+		hit.Snippet = highlightHTML(snippet)
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating through search result rows: %w", err)
+	}
+	rows.Close()
+
+	for i := range hits {
+		p, err := r.products.GetByID(ctx, hits[i].Product.ID)
+		if err != nil {
+			return nil, err
+		}
+		hits[i].Product = p
+	}
+	return hits, nil
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"context"
+	"html/template"
+	"reflect"
+	"testing"
+)
+
+// searchCatalog is a setup for testStores adding the same products to each
+// store: "cotton" is in the tee's name but only in the mug's description, and
+// the cap that also has it in its name is archived.
+var searchCatalog = withCatalog(nil, []Product{
+	{ID: "tee", Name: "Organic Cotton Tee", Description: "A soft shirt for every day.", Price: Money{2000, "USD"},
+		Tags: []string{"summer"}, Options: []string{"Size"},
+		Variants: []Variant{{ID: "tee-m", SKU: "CT100", Options: map[string]string{"Size": "M"}}}},
+	{ID: "mug", Name: "Camping Mug", Description: "Enamel mug with a cotton sleeve.", Price: Money{1500, "USD"}},
+	{ID: "mill", Name: "Salt & Pepper Mill", Description: "Grinds both.", Price: Money{3000, "USD"}},
+	{ID: "cap", Name: "Cotton Cap", Price: Money{1200, "USD"}, Archived: true},
+})
+
+// hitIDs returns the IDs of the hits' products, in order.
+func hitIDs(hits []SearchHit) []string {
+	var ids []string
+	for _, hit := range hits {
+		ids = append(ids, hit.Product.ID)
+	}
+	return ids
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		query string
+		limit int
+		want  []string
+	}{
+		{name: "name before description", query: "cotton", want: []string{"tee", "mug"}},
+		{name: "word prefix", query: "cot", want: []string{"tee", "mug"}},
+		{name: "limit", query: "cotton", limit: 1, want: []string{"tee"}},
+		{name: "every term", query: "organic tee", want: []string{"tee"}},
+		{name: "not every term", query: "organic mug", want: nil},
+		{name: "tag", query: "summer", want: []string{"tee"}},
+		{name: "SKU", query: "ct100", want: []string{"tee"}},
+		{name: "any case", query: "CAMPING", want: []string{"mug"}},
+		{name: "search syntax as text", query: `(camp* "mug`, want: []string{"mug"}},
+		{name: "no terms", query: `"*"`, want: nil},
+	}
+	for name, store := range testStores(t, searchCatalog) {
+		for _, tt := range tests {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				limit := tt.limit
+				if limit == 0 {
+					limit = 10
+				}
+				hits, err := store.Search.Search(ctx, tt.query, limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := hitIDs(hits); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+				}
+			})
+		}
+
+		t.Run("highlighting/"+name, func(t *testing.T) {
+			hits, err := store.Search.Search(ctx, "cotton", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(hits) == 0 {
+				t.Fatal("no hits")
+			}
+			if want := template.HTML("Organic <mark>Cotton</mark> Tee"); hits[0].Name != want {
+				t.Errorf("name = %q, want %q", hits[0].Name, want)
+			}
+			if hits[0].Product.Price != (Money{2000, "USD"}) {
+				t.Errorf("hit has price %s, want the stored product's", hits[0].Product.Price)
+			}
+
+			// Product text is escaped, and only the markers become tags
+			hits, err = store.Search.Search(ctx, "salt", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := template.HTML("<mark>Salt</mark> &amp; Pepper Mill"); len(hits) != 1 || hits[0].Name != want {
+				t.Errorf("hits = %+v, want one named %q", hits, want)
+			}
+		})
+	}
+}
+
+func TestSearchFollowsProductChanges(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range testStores(t, searchCatalog) {
+		t.Run(name, func(t *testing.T) {
+			mug, err := store.Products.GetByID(ctx, "mug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			mug.Name = "Hiking Mug"
+			if err := store.Products.Update(ctx, mug, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Products.SetArchived(ctx, "tee", true); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Products.SetArchived(ctx, "cap", false); err != nil {
+				t.Fatal(err)
+			}
+
+			for query, want := range map[string][]string{
+				"hiking":  {"mug"},
+				"camping": nil,
+				"cotton":  {"cap", "mug"},
+			} {
+				hits, err := store.Search.Search(ctx, query, 10)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := hitIDs(hits); !reflect.DeepEqual(got, want) {
+					t.Errorf("Search(%q) = %v, want %v", query, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+)
+
+// ShippingRateType says how a shipping method is priced.
+type ShippingRateType string
+
+const (
+	// ShippingFlat charges the same price for every order
+	ShippingFlat ShippingRateType = "flat"
+	// ShippingWeight charges by the weight of the order, in brackets
+	ShippingWeight ShippingRateType = "weight"
+	// ShippingFreeOver charges a flat price, or nothing once the order reaches a minimum
+	ShippingFreeOver ShippingRateType = "free_over"
+)
+
+// WeightBracket is the price of a weight-based method for orders up to a weight.
+type WeightBracket struct {
+	MaxWeight int              // grams; 0 for no limit
+	Prices    map[string]Money // by currency code
+}
+
+// ShippingMethod is a way of delivering orders to a zone, such as standard or
+// express delivery.
+type ShippingMethod struct {
+	ID   string
+	Name string // what the customer sees, e.g. "Express"
+	Type ShippingRateType
+	// Prices is the price by currency code, for flat and free_over methods
+	Prices map[string]Money
+	// FreeOver is the order subtotal, after discounts, from which free_over
+	// methods cost nothing
+	FreeOver map[string]Money
+	// Brackets are the prices of weight methods, by increasing MaxWeight
+	Brackets []WeightBracket
+	// MinDays and MaxDays estimate the delivery time in business days; 0 if unknown
+	MinDays int
+	MaxDays int
+}
+
+// Quote returns what the method charges for an order of the given weight and
+// subtotal, or false if it cannot deliver it in the currency.
+func (m ShippingMethod) Quote(currency string, weight int, subtotal Money) (Money, bool) {
+	switch m.Type {
+	case ShippingFlat:
+		price, ok := m.Prices[currency]
+		return price, ok
+	case ShippingFreeOver:
+		price, ok := m.Prices[currency]
+		if threshold, set := m.FreeOver[currency]; set && subtotal.Amount >= threshold.Amount {
+			return Money{Currency: currency}, ok
+		}
+		return price, ok
+	case ShippingWeight:
+		for _, b := range m.Brackets {
+			if b.MaxWeight == 0 || weight <= b.MaxWeight {
+				price, ok := b.Prices[currency]
+				return price, ok
+			}
+		}
+	}
+	return Money{}, false
+}
+
+// ShippingZone is a group of countries sharing the same shipping methods.
+type ShippingZone struct {
+	Name      string
+	Countries []string // ISO codes, or "*" for every country not in another zone
+	Methods   []ShippingMethod
+}
+
+// ShippingRate is the price of one shipping method for a cart or order.
+type ShippingRate struct {
+	Method  string `json:"method"` // ShippingMethod.ID
+	Name    string `json:"name"`
+	Amount  Money  `json:"amount"`
+	MinDays int    `json:"min_days,omitempty"`
+	MaxDays int    `json:"max_days,omitempty"`
+}
+
+// Estimate describes the delivery time, e.g. "3–5 business days", or returns
+// "" if it is unknown.
+func (r ShippingRate) Estimate() string {
+	switch {
+	case r.MaxDays == 0:
+		return ""
+	case r.MinDays == 0 || r.MinDays == r.MaxDays:
+		if r.MaxDays == 1 {
+			return "1 business day"
+		}
+		return strconv.Itoa(r.MaxDays) + " business days"
+	default:
+		return fmt.Sprintf("%d–%d business days", r.MinDays, r.MaxDays)
+	}
+}
+
+// freeShippingMethod is the only method of a ShippingTable without zones.
+const freeShippingMethod = "free"
+
+// ShippingTable prices the shipping methods of each zone. A table without
+// zones ships everywhere for free.
+type ShippingTable struct {
+	Zones []ShippingZone
+}
+
+// Zone returns the zone that lists the country, or else the zone for every
+// country, or nil if there is neither.
+func (t *ShippingTable) Zone(country string) *ShippingZone {
+	var fallback *ShippingZone
+	for i, zone := range t.Zones {
+		if slices.Contains(zone.Countries, country) {
+			return &t.Zones[i]
+		}
+		if fallback == nil && slices.Contains(zone.Countries, "*") {
+			fallback = &t.Zones[i]
+		}
+	}
+	return fallback
+}
+
+// Rates returns the price of every method that can deliver an order of the
+// given weight and subtotal to the address, in the order they are listed.
+// It returns no rates if the store does not ship there.
+func (t *ShippingTable) Rates(to Address, currency string, weight int, subtotal Money) []ShippingRate {
+	if len(t.Zones) == 0 {
+		return []ShippingRate{{Method: freeShippingMethod, Name: "Free shipping", Amount: Money{Currency: currency}}}
+	}
+	to.Normalize()
+	zone := t.Zone(to.Country)
+	if zone == nil {
+		return nil
+	}
+	var rates []ShippingRate
+	for _, m := range zone.Methods {
+		if amount, ok := m.Quote(currency, weight, subtotal); ok && amount.Currency == currency {
+			rates = append(rates, ShippingRate{Method: m.ID, Name: m.Name, Amount: amount, MinDays: m.MinDays, MaxDays: m.MaxDays})
+		}
+	}
+	return rates
+}
+
+// shippingFile is the JSON form of a ShippingTable, with prices as decimal
+// strings by currency code, like catalog files.
+type shippingFile struct {
+	Zones []struct {
+		Name      string   `json:"name"`
+		Countries []string `json:"countries"`
+		Methods   []struct {
+			ID       string            `json:"id"`
+			Name     string            `json:"name"`
+			Type     ShippingRateType  `json:"type"`
+			Prices   map[string]string `json:"prices"`
+			FreeOver map[string]string `json:"free_over"`
+			Brackets []struct {
+				MaxWeight int               `json:"max_weight"`
+				Prices    map[string]string `json:"prices"`
+			} `json:"brackets"`
+			MinDays int `json:"min_days"`
+			MaxDays int `json:"max_days"`
+		} `json:"methods"`
+	} `json:"zones"`
+}
+
+// parseShippingPrices reads decimal prices by currency code.
+func parseShippingPrices(prices map[string]string) (map[string]Money, error) {
+	parsed := make(map[string]Money, len(prices))
+	for currency, price := range prices {
+		if !IsSupportedCurrency(currency) {
+			return nil, fmt.Errorf("unsupported currency %q", currency)
+		}
+		amount, err := ParseMoney(price, currency)
+		if err != nil {
+			return nil, err
+		}
+		parsed[currency] = amount
+	}
+	return parsed, nil
+}
+
+// ParseShippingTable reads a shipping table from JSON and checks it.
+func ParseShippingTable(data []byte) (*ShippingTable, error) {
+	var file shippingFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing shipping rates: %w", err)
+	}
+
+	table := &ShippingTable{}
+	for _, fz := range file.Zones {
+		if len(fz.Countries) == 0 {
+			return nil, fmt.Errorf("shipping zone %q lists no countries", fz.Name)
+		}
+		zone := ShippingZone{Name: fz.Name, Countries: fz.Countries}
+		for _, fm := range fz.Methods {
+			where := fmt.Sprintf("shipping method %q of zone %q", fm.ID, fz.Name)
+			if fm.ID == "" || fm.Name == "" {
+				return nil, fmt.Errorf("%s: id and name are required", where)
+			}
+			m := ShippingMethod{ID: fm.ID, Name: fm.Name, Type: fm.Type, MinDays: fm.MinDays, MaxDays: fm.MaxDays}
+			var err error
+			switch fm.Type {
+			case ShippingFlat, ShippingFreeOver:
+				if m.Prices, err = parseShippingPrices(fm.Prices); err != nil {
+					return nil, fmt.Errorf("%s: %w", where, err)
+				}
+				if m.FreeOver, err = parseShippingPrices(fm.FreeOver); err != nil {
+					return nil, fmt.Errorf("%s: %w", where, err)
+				}
+			case ShippingWeight:
+				for _, fb := range fm.Brackets {
+					b := WeightBracket{MaxWeight: fb.MaxWeight}
+					if b.Prices, err = parseShippingPrices(fb.Prices); err != nil {
+						return nil, fmt.Errorf("%s: %w", where, err)
+					}
+					m.Brackets = append(m.Brackets, b)
+				}
+				slices.SortStableFunc(m.Brackets, func(a, b WeightBracket) int {
+					// Unlimited brackets go last
+					if a.MaxWeight == 0 || b.MaxWeight == 0 {
+						return min(b.MaxWeight, 1) - min(a.MaxWeight, 1)
+					}
+					return a.MaxWeight - b.MaxWeight
+				})
+			default:
+				return nil, fmt.Errorf("%s: type must be flat, weight or free_over", where)
+			}
+			zone.Methods = append(zone.Methods, m)
+		}
+		table.Zones = append(table.Zones, zone)
+	}
+	return table, nil
+}
+
+// ShippingConfig configures the shipping rates.
+type ShippingConfig struct {
+	RatesFile string // JSON file of shipping zones and methods; empty for free shipping
+}
+
+// ShippingConfigFromEnv reads SHIPPING_RATES_FILE.
+func ShippingConfigFromEnv() ShippingConfig {
+	cfg := ShippingConfig{RatesFile: "data/shipping_rates.json"}
+	if file, ok := os.LookupEnv("SHIPPING_RATES_FILE"); ok {
+		cfg.RatesFile = file
+	}
+	return cfg
+}
+
+// NewShippingTable loads the shipping table from the configured file.
+func NewShippingTable(cfg ShippingConfig) (*ShippingTable, error) {
+	if cfg.RatesFile == "" {
+		return &ShippingTable{}, nil
+	}
+	data, err := os.ReadFile(cfg.RatesFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading shipping rates: %w", err)
+	}
+	table, err := ParseShippingTable(data)
+	if err != nil {
+		return nil, fmt.Errorf("error loading %s: %w", cfg.RatesFile, err)
+	}
+	return table, nil
+}
+
+// ApplyShipping prices the shipping methods for the cart's address and sets
+// cart.ShippingRates, and cart.Shipping to the rate of the chosen method, or
+// of the first method if none was chosen yet. A cart without an address has
+// no rates yet. A free shipping coupon makes every method free. ApplyCoupons
+// must run first, since free_over methods use the discounted subtotal.
+func ApplyShipping(table *ShippingTable, cart *Cart) {
+	cart.ShippingRates, cart.Shipping = nil, nil
+	if cart.ShippingAddress.IsZero() || cart.IsEmpty() {
+		return
+	}
+
+	subtotal := cart.Subtotal()
+	subtotal.Amount -= TotalDiscount(cart.Discounts, cart.Currency).Amount
+	cart.ShippingRates = table.Rates(cart.ShippingAddress, cart.Currency, cart.Weight(), subtotal)
+	if slices.ContainsFunc(cart.Discounts, func(d Discount) bool { return d.FreeShipping }) {
+		for i := range cart.ShippingRates {
+			cart.ShippingRates[i].Amount.Amount = 0
+		}
+	}
+
+	for i, rate := range cart.ShippingRates {
+		if rate.Method == cart.ShippingMethod || (i == 0 && cart.Shipping == nil) {
+			cart.Shipping = &cart.ShippingRates[i]
+		}
+	}
+}
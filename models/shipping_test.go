@@ -0,0 +1,199 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShippingTableRates(t *testing.T) {
+	table, err := ParseShippingTable([]byte(`{"zones": [
+		{"name": "US", "countries": ["US"], "methods": [
+			{"id": "standard", "name": "Standard", "type": "free_over", "prices": {"USD": "5.99", "EUR": "5.49"}, "free_over": {"USD": "50.00"}},
+			{"id": "express", "name": "Express", "type": "weight", "brackets": [
+				{"prices": {"USD": "39.99"}},
+				{"max_weight": 1000, "prices": {"USD": "14.99"}}
+			]}
+		]},
+		{"name": "Rest of the world", "countries": ["*"], "methods": [
+			{"id": "intl", "name": "International", "type": "flat", "prices": {"USD": "19.99"}}
+		]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A method built in code rather than parsed can list a price under the wrong currency
+	table.Zones[1].Methods = append(table.Zones[1].Methods, ShippingMethod{
+		ID: "courier", Name: "Courier", Type: ShippingFlat, Prices: map[string]Money{"EUR": {999, "USD"}},
+	})
+
+	tests := []struct {
+		name     string
+		country  string
+		currency string
+		weight   int
+		subtotal int64
+		want     map[string]int64 // amount by method
+	}{
+		{"under the free threshold", "US", "USD", 500, 4999, map[string]int64{"standard": 599, "express": 1499}},
+		{"free over the threshold", "US", "USD", 500, 5000, map[string]int64{"standard": 0, "express": 1499}},
+		{"heavy", "US", "USD", 1001, 4999, map[string]int64{"standard": 599, "express": 3999}},
+		{"no threshold in the currency", "US", "EUR", 500, 9999, map[string]int64{"standard": 549}},
+		{"other countries", "JP", "USD", 500, 1000, map[string]int64{"intl": 1999}},
+		{"mislabelled price", "JP", "EUR", 500, 1000, map[string]int64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rates := table.Rates(Address{Country: tt.country}, tt.currency, tt.weight, Money{tt.subtotal, tt.currency})
+			if len(rates) != len(tt.want) {
+				t.Fatalf("Rates() = %v, want %v", rates, tt.want)
+			}
+			for _, rate := range rates {
+				if want, ok := tt.want[rate.Method]; !ok || rate.Amount != (Money{want, tt.currency}) {
+					t.Errorf("rate of %s = %v, want %d %s", rate.Method, rate.Amount, want, tt.currency)
+				}
+			}
+		})
+	}
+}
+
+func TestShippingTableZone(t *testing.T) {
+	flat := func(id string) []ShippingMethod {
+		return []ShippingMethod{{ID: id, Name: id, Type: ShippingFlat, Prices: map[string]Money{"USD": {500, "USD"}}}}
+	}
+	table := &ShippingTable{Zones: []ShippingZone{
+		{Name: "Everywhere else", Countries: []string{"*"}, Methods: flat("intl")},
+		{Name: "North America", Countries: []string{"US", "CA"}, Methods: flat("domestic")},
+		{Name: "Fallback again", Countries: []string{"*"}, Methods: flat("unused")},
+	}}
+
+	tests := []struct {
+		country string
+		want    string // method of the zone
+	}{
+		{"CA", "domestic"},   // a listed country wins over an earlier "*"
+		{" us ", "domestic"}, // addresses are normalized
+		{"FR", "intl"},       // the first "*" zone
+		{"", "intl"},
+	}
+	for _, tt := range tests {
+		rates := table.Rates(Address{Country: tt.country}, "USD", 0, Money{1000, "USD"})
+		if len(rates) != 1 || rates[0].Method != tt.want {
+			t.Errorf("Rates(%q) = %v, want %s", tt.country, rates, tt.want)
+		}
+	}
+
+	// Without a "*" zone, other countries cannot be shipped to
+	table.Zones = table.Zones[1:2]
+	if rates := table.Rates(Address{Country: "FR"}, "USD", 0, Money{1000, "USD"}); rates != nil {
+		t.Errorf("Rates(FR) = %v, want none", rates)
+	}
+	// A table without zones ships everywhere for free
+	rates := (&ShippingTable{}).Rates(Address{Country: "FR"}, "EUR", 5000, Money{1000, "EUR"})
+	if len(rates) != 1 || rates[0].Method != freeShippingMethod || rates[0].Amount != (Money{0, "EUR"}) {
+		t.Errorf("Rates() without zones = %v, want free shipping", rates)
+	}
+}
+
+func TestShippingWeightBrackets(t *testing.T) {
+	table, err := ParseShippingTable([]byte(`{"zones": [{"name": "US", "countries": ["US"], "methods": [
+		{"id": "parcel", "name": "Parcel", "type": "weight", "brackets": [
+			{"max_weight": 5000, "prices": {"USD": "12.00"}},
+			{"max_weight": 500, "prices": {"USD": "4.00"}},
+			{"max_weight": 2000, "prices": {"USD": "8.00"}}
+		]},
+		{"id": "freight", "name": "Freight", "type": "weight", "brackets": [
+			{"prices": {"USD": "50.00"}},
+			{"max_weight": 20000, "prices": {"USD": "30.00"}}
+		]}
+	]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		weight int
+		want   map[string]int64 // amount by method
+	}{
+		{0, map[string]int64{"parcel": 400, "freight": 3000}},
+		{500, map[string]int64{"parcel": 400, "freight": 3000}}, // brackets include their maximum
+		{501, map[string]int64{"parcel": 800, "freight": 3000}},
+		{2000, map[string]int64{"parcel": 800, "freight": 3000}},
+		{5000, map[string]int64{"parcel": 1200, "freight": 3000}},
+		{5001, map[string]int64{"freight": 3000}}, // too heavy for parcels
+		{20001, map[string]int64{"freight": 5000}},
+	}
+	for _, tt := range tests {
+		got := map[string]int64{}
+		for _, rate := range table.Rates(Address{Country: "US"}, "USD", tt.weight, Money{1000, "USD"}) {
+			got[rate.Method] = rate.Amount.Amount
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("rates for %dg = %v, want %v", tt.weight, got, tt.want)
+		}
+	}
+}
+
+func TestApplyShipping(t *testing.T) {
+	table, err := ParseShippingTable([]byte(`{"zones": [{"name": "US", "countries": ["US"], "methods": [
+		{"id": "standard", "name": "Standard", "type": "free_over", "prices": {"USD": "5.00"}, "free_over": {"USD": "50.00"}},
+		{"id": "express", "name": "Express", "type": "weight", "brackets": [
+			{"max_weight": 1000, "prices": {"USD": "10.00"}},
+			{"prices": {"USD": "20.00"}}
+		]}
+	]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCart := func() *Cart {
+		cart := NewCart("USD")
+		cart.Items = []CartItem{{ProductID: "kettle", Quantity: 2, UnitPrice: Money{3000, "USD"}, Weight: 600}}
+		cart.ShippingAddress = Address{Name: "Ann", Line1: "1 Main St", City: "Springfield", PostalCode: "12345", Country: "US"}
+		return cart
+	}
+	amounts := func(cart *Cart) map[string]int64 {
+		got := map[string]int64{}
+		for _, rate := range cart.ShippingRates {
+			got[rate.Method] = rate.Amount.Amount
+		}
+		return got
+	}
+
+	// 60.00 is over the threshold, and 1.2kg is over the first express bracket
+	cart := newCart()
+	ApplyShipping(table, cart)
+	if want := map[string]int64{"standard": 0, "express": 2000}; !reflect.DeepEqual(amounts(cart), want) {
+		t.Errorf("rates = %v, want %v", amounts(cart), want)
+	}
+	if cart.Shipping == nil || cart.Shipping.Method != "standard" {
+		t.Errorf("shipping = %v, want the first method", cart.Shipping)
+	}
+
+	// The threshold applies to the subtotal after discounts
+	cart = newCart()
+	cart.Discounts = []Discount{{Code: "FIFTEEN", Amount: Money{1500, "USD"}}}
+	cart.ShippingMethod = "express"
+	ApplyShipping(table, cart)
+	if want := map[string]int64{"standard": 500, "express": 2000}; !reflect.DeepEqual(amounts(cart), want) {
+		t.Errorf("discounted rates = %v, want %v", amounts(cart), want)
+	}
+	if cart.Shipping == nil || cart.Shipping.Method != "express" {
+		t.Errorf("shipping = %v, want the chosen method", cart.Shipping)
+	}
+
+	// A free shipping coupon makes every method free
+	cart = newCart()
+	cart.Discounts = []Discount{{Code: "SHIPFREE", FreeShipping: true}}
+	ApplyShipping(table, cart)
+	if want := map[string]int64{"standard": 0, "express": 0}; !reflect.DeepEqual(amounts(cart), want) {
+		t.Errorf("rates with free shipping = %v, want %v", amounts(cart), want)
+	}
+
+	// Carts without an address have no rates yet, and a chosen rate goes away
+	cart = newCart()
+	ApplyShipping(table, cart)
+	cart.ShippingAddress = Address{}
+	ApplyShipping(table, cart)
+	if cart.ShippingRates != nil || cart.Shipping != nil {
+		t.Errorf("cart without an address has rates %v and shipping %v", cart.ShippingRates, cart.Shipping)
+	}
+}
@@ -1,45 +1,76 @@
 package models
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"net/http"
+	"strings"
 
 	"github.com/stripe/stripe-go/v74"
-	checkoutsession "github.com/stripe/stripe-go/v74/checkout/session"
+	"github.com/stripe/stripe-go/v74/client"
 	"github.com/stripe/stripe-go/v74/webhook"
 )
 
-// Initialize Stripe with your API key
-func InitStripe() {
-	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
-	if stripe.Key == "" {
-		fmt.Println("Warning: STRIPE_SECRET_KEY environment variable not set")
-		// For development purposes you might set a default key here
-		// stripe.Key = "sk_test_..."
-	}
+// StripeProvider is the PaymentProvider for Stripe Checkout.
+type StripeProvider struct {
+	api           *client.API
+	webhookSecret string
+}
+
+// NewStripeProvider returns a StripeProvider using the secret API key, and
+// the signing secret of the webhook endpoint.
+func NewStripeProvider(secretKey, webhookSecret string) *StripeProvider {
+	return &StripeProvider{api: client.New(secretKey, nil), webhookSecret: webhookSecret}
+}
+
+// Name returns "stripe".
+func (p *StripeProvider) Name() string {
+	return "stripe"
 }
 
-// CreateCheckoutSession creates a new Stripe checkout session for the order
-func CreateCheckoutSession(order *Order, successURL, cancelURL string) (string, error) {
+// CreateCheckoutSession creates a new Stripe checkout session for the order and
+// records its ID on the order. The caller is responsible for saving the order.
+func (p *StripeProvider) CreateCheckoutSession(ctx context.Context, order *Order, successURL, cancelURL string) (string, error) {
 	// Create line items from order items
 	var lineItems []*stripe.CheckoutSessionLineItemParams
 
 	for _, item := range order.Items {
 		lineItems = append(lineItems, &stripe.CheckoutSessionLineItemParams{
 			PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
-				Currency: stripe.String("usd"),
+				Currency: stripe.String(strings.ToLower(item.UnitPrice.Currency)),
 				ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
-					Name:        stripe.String(item.ProductName),
-					Description: stripe.String(fmt.Sprintf("Order Item: %s", item.ProductID)),
+					Name:        stripe.String(item.DisplayName()),
+					Description: stripe.String(lineItemDescription(item)),
+					Metadata: map[string]string{
+						"product_id": item.ProductID,
+						"variant_id": item.VariantID,
+						"sku":        item.SKU,
+					},
 				},
-				UnitAmount: stripe.Int64(int64(item.UnitPrice * 100)), // Convert to cents
+				UnitAmount: stripe.Int64(item.UnitPrice.Amount), // Already in minor units
 			},
 			Quantity: stripe.Int64(int64(item.Quantity)),
 		})
 	}
 
+	// Tax added to the prices is charged as one line per tax, so that the
+	// session total matches the order total
+	if !order.TaxInclusive {
+		for _, tax := range order.TaxLines() {
+			lineItems = append(lineItems, &stripe.CheckoutSessionLineItemParams{
+				PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
+					Currency: stripe.String(strings.ToLower(tax.Amount.Currency)),
+					ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
+						Name: stripe.String(tax.Label()),
+					},
+					UnitAmount: stripe.Int64(tax.Amount.Amount),
+				},
+				Quantity: stripe.Int64(1),
+			})
+		}
+	}
+
 	// Create checkout session parameters
 	params := &stripe.CheckoutSessionParams{
 		PaymentMethodTypes: stripe.StringSlice([]string{
@@ -49,99 +80,224 @@ func CreateCheckoutSession(order *Order, successURL, cancelURL string) (string,
 		Mode:       stripe.String(string(stripe.CheckoutSessionModePayment)),
 		SuccessURL: stripe.String(successURL),
 		CancelURL:  stripe.String(cancelURL),
-		// CustomerEmail: stripe.String(order.CustomerEmail), // TODO: Add customer email to order or retrieve from user session
+		Params:     stripe.Params{Context: ctx},
+	}
+
+	// The email given at checkout is filled in on the payment page and gets the receipt
+	if order.CustomerEmail != "" {
+		params.CustomerEmail = stripe.String(order.CustomerEmail)
+	}
+
+	// The payment and its charges carry the order ID, so that payment, refund
+	// and dispute events can be matched to the order
+	params.PaymentIntentData = &stripe.CheckoutSessionPaymentIntentDataParams{
+		Metadata: map[string]string{"order_id": order.ID},
+	}
+
+	// The shipping method chosen at checkout is the session's only shipping
+	// option, and the address goes on the payment for the dashboard and receipts
+	if order.Shipping.Method != "" {
+		params.ShippingOptions = []*stripe.CheckoutSessionShippingOptionParams{{ShippingRateData: stripeShippingRate(order.Shipping)}}
+	}
+	if addr := order.ShippingAddress; !addr.IsZero() {
+		params.PaymentIntentData.Shipping = &stripe.ShippingDetailsParams{
+			Name: stripe.String(addr.Name),
+			Address: &stripe.AddressParams{
+				Line1:      stripe.String(addr.Line1),
+				Line2:      stripe.String(addr.Line2),
+				City:       stripe.String(addr.City),
+				State:      stripe.String(addr.State),
+				PostalCode: stripe.String(addr.PostalCode),
+				Country:    stripe.String(addr.Country),
+			},
+		}
+	}
+
+	// Tax included in the prices is shown next to the pay button
+	if order.TaxInclusive {
+		if note := includedTaxNote(order); note != "" {
+			params.CustomText = &stripe.CheckoutSessionCustomTextParams{
+				Submit: &stripe.CheckoutSessionCustomTextSubmitParams{Message: stripe.String(note)},
+			}
+		}
+	}
+
+	// Take the order's discounts off the session total, so that the customer is
+	// charged exactly the order total
+	if discount := order.Discount(); discount.Amount > 0 {
+		couponID, err := p.createCoupon(ctx, order, discount)
+		if err != nil {
+			return "", err
+		}
+		params.Discounts = []*stripe.CheckoutSessionDiscountParams{{Coupon: stripe.String(couponID)}}
 	}
 
 	// Create the checkout session
-	s, err := checkoutsession.New(params)
+	s, err := p.api.CheckoutSessions.New(params)
 	if err != nil {
 		return "", fmt.Errorf("failed to create checkout session: %w", err)
 	}
 
 	// Update order with Stripe session ID *after* successful session creation
 	order.StripeID = s.ID
-	// Save the order with the Stripe ID. This is crucial.
-	err = order.Save()
-	if err != nil {
-		// Log error but continue, as the Stripe session is created
-		log.Printf("Warning: Could not update order %s with Stripe ID %s: %v", order.ID, s.ID, err)
-		// Depending on requirements, you might want to cancel the Stripe session here.
-	}
 
 	return s.URL, nil
 }
 
-// HandleStripeWebhook processes Stripe webhook events
-func HandleStripeWebhook(payload []byte, signature string) error {
-	endpointSecret := os.Getenv("STRIPE_WEBHOOK_SECRET")
-	if endpointSecret == "" {
-		return fmt.Errorf("STRIPE_WEBHOOK_SECRET environment variable not set")
+// stripeShippingRate describes a shipping rate as a fixed-amount Stripe shipping rate.
+func stripeShippingRate(rate ShippingRate) *stripe.CheckoutSessionShippingOptionShippingRateDataParams {
+	data := &stripe.CheckoutSessionShippingOptionShippingRateDataParams{
+		DisplayName: stripe.String(rate.Name),
+		Type:        stripe.String("fixed_amount"),
+		FixedAmount: &stripe.CheckoutSessionShippingOptionShippingRateDataFixedAmountParams{
+			Amount:   stripe.Int64(rate.Amount.Amount),
+			Currency: stripe.String(strings.ToLower(rate.Amount.Currency)),
+		},
+		Metadata: map[string]string{"method": rate.Method},
 	}
-
-	event, err := webhook.ConstructEvent(payload, signature, endpointSecret)
-	if err != nil {
-		return fmt.Errorf("error verifying webhook signature: %w", err)
+	if rate.MaxDays > 0 {
+		unit := stripe.String("business_day")
+		data.DeliveryEstimate = &stripe.CheckoutSessionShippingOptionShippingRateDataDeliveryEstimateParams{
+			Minimum: &stripe.CheckoutSessionShippingOptionShippingRateDataDeliveryEstimateMinimumParams{Unit: unit, Value: stripe.Int64(int64(max(rate.MinDays, 1)))},
+			Maximum: &stripe.CheckoutSessionShippingOptionShippingRateDataDeliveryEstimateMaximumParams{Unit: unit, Value: stripe.Int64(int64(rate.MaxDays))},
+		}
 	}
+	return data
+}
 
-	// Handle different event types
-	switch event.Type {
-	case "checkout.session.completed":
-		// Payment is successful, update order status
-		var stripeSessionData stripe.CheckoutSession              // Use the correct type
-		err := json.Unmarshal(event.Data.Raw, &stripeSessionData) // Use json.Unmarshal
-		if err != nil {
-			return fmt.Errorf("error parsing webhook JSON for checkout.session.completed: %w", err)
-		}
+// includedTaxNote describes the tax included in the order's prices, e.g.
+// "Prices include VAT 20%: £10.00", or returns "" if there is none.
+func includedTaxNote(order *Order) string {
+	var parts []string
+	for _, tax := range order.TaxLines() {
+		parts = append(parts, tax.Label()+": "+tax.Amount.String())
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "Prices include " + strings.Join(parts, ", ")
+}
 
-		log.Printf("Checkout session completed: %s", stripeSessionData.ID)
+// createCoupon creates a single-use Stripe coupon for the amount the order's
+// coupons take off. A Checkout Session accepts only one discount, so the
+// order's discounts are combined, under the names of their codes.
+func (p *StripeProvider) createCoupon(ctx context.Context, order *Order, discount Money) (string, error) {
+	name := "Discount: " + strings.Join(order.CouponCodes(), ", ")
+	if len(name) > 40 { // Stripe's limit for coupon names
+		name = "Discount"
+	}
+	c, err := p.api.Coupons.New(&stripe.CouponParams{
+		AmountOff:      stripe.Int64(discount.Amount),
+		Currency:       stripe.String(strings.ToLower(discount.Currency)),
+		Duration:       stripe.String(string(stripe.CouponDurationOnce)),
+		MaxRedemptions: stripe.Int64(1),
+		Name:           stripe.String(name),
+		Params: stripe.Params{
+			Context:  ctx,
+			Metadata: map[string]string{"order_id": order.ID},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create Stripe coupon for order %s: %w", order.ID, err)
+	}
+	return c.ID, nil
+}
 
-		// Retrieve the order from your database using the Stripe Session ID
-		order, err := GetOrderByStripeID(stripeSessionData.ID)
-		if err != nil {
-			// This is a critical error: received webhook for unknown order
-			return fmt.Errorf("order with Stripe ID %s not found in DB: %w", stripeSessionData.ID, err)
-		}
+// lineItemDescription identifies an order item for the Stripe checkout page and dashboard
+func lineItemDescription(item OrderItem) string {
+	if item.SKU != "" {
+		return fmt.Sprintf("SKU: %s", item.SKU)
+	}
+	return fmt.Sprintf("Order Item: %s", item.ProductID)
+}
 
-		// Update the order status to completed
-		if order.Status != OrderStatusCompleted {
-			err = order.UpdateOrderStatus(OrderStatusCompleted)
-			if err != nil {
-				return fmt.Errorf("error updating order %s status to completed: %w", order.ID, err)
-			}
-			log.Printf("Order %s status updated to completed.", order.ID)
+// VerifyWebhook checks the Stripe-Signature header of a webhook delivery
+// against the endpoint's signing secret.
+func (p *StripeProvider) VerifyWebhook(payload []byte, signature string) (PaymentEvent, error) {
+	if p.webhookSecret == "" {
+		return PaymentEvent{}, fmt.Errorf("STRIPE_WEBHOOK_SECRET environment variable not set")
+	}
+	return verifyStripeEvent(payload, signature, p.webhookSecret)
+}
 
-			// TODO: Implement post-checkout actions here (e.g., send order confirmation email)
-		}
+// verifyStripeEvent checks the signature of a Stripe-format webhook payload
+// made with the secret and returns its event.
+func verifyStripeEvent(payload []byte, signature, secret string) (PaymentEvent, error) {
+	event, err := webhook.ConstructEvent(payload, signature, secret)
+	if err != nil {
+		return PaymentEvent{}, fmt.Errorf("error verifying webhook signature: %w", err)
+	}
+	return PaymentEvent{ID: event.ID, Type: string(event.Type), Object: event.Data.Raw}, nil
+}
 
-	case "checkout.session.expired":
-		// Payment expired, update order status
-		var stripeSessionData stripe.CheckoutSession              // Use the correct type
-		err := json.Unmarshal(event.Data.Raw, &stripeSessionData) // Use json.Unmarshal
+// Refund refunds the amount of the order's PaymentIntent, looking it up on the
+// checkout session if the order has not recorded it.
+func (p *StripeProvider) Refund(ctx context.Context, order *Order, amount Money, reason string) (string, error) {
+	paymentIntentID := order.PaymentIntentID
+	if paymentIntentID == "" {
+		payment, err := p.RetrievePayment(ctx, order.StripeID)
 		if err != nil {
-			return fmt.Errorf("error parsing webhook JSON for checkout.session.expired: %w", err)
+			return "", err
 		}
+		paymentIntentID = payment.PaymentIntentID
+	}
+	if paymentIntentID == "" {
+		return "", fmt.Errorf("order %s has no payment to refund", order.ID)
+	}
+	r, err := p.api.Refunds.New(&stripe.RefundParams{
+		PaymentIntent: stripe.String(paymentIntentID),
+		Amount:        stripe.Int64(amount.Amount),
+		Params: stripe.Params{
+			Context:  ctx,
+			Metadata: map[string]string{"order_id": order.ID, "reason": reason},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to refund order %s: %w", order.ID, err)
+	}
+	return r.ID, nil
+}
 
-		log.Printf("Checkout session expired: %s", stripeSessionData.ID)
-
-		// Retrieve the order from your database using the Stripe Session ID
-		order, err := GetOrderByStripeID(stripeSessionData.ID)
-		if err != nil {
-			log.Printf("Warning: Order with Stripe ID %s not found in DB on expiry webhook: %v", stripeSessionData.ID, err)
-			return nil // Not a critical error if order isn't found on expiry
-		}
+// RetrievePayment fetches the Checkout Session from Stripe.
+func (p *StripeProvider) RetrievePayment(ctx context.Context, sessionID string) (Payment, error) {
+	s, err := p.api.CheckoutSessions.Get(sessionID, &stripe.CheckoutSessionParams{Params: stripe.Params{Context: ctx}})
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) && stripeErr.HTTPStatusCode == http.StatusNotFound {
+		return Payment{}, fmt.Errorf("checkout session %s: %w", sessionID, ErrNotFound)
+	}
+	if err != nil {
+		return Payment{}, fmt.Errorf("failed to retrieve checkout session %s: %w", sessionID, err)
+	}
+	return stripePayment(s), nil
+}
 
-		// Update the order status to failed (or expired)
-		if order.Status == OrderStatusPending {
-			err = order.UpdateOrderStatus(OrderStatusFailed)
-			if err != nil {
-				return fmt.Errorf("error updating order %s status to failed on expiry: %w", order.ID, err)
-			}
-			log.Printf("Order %s status updated to failed (expired).", order.ID)
-		}
+// ExpireCheckoutSession expires the Checkout Session at Stripe, which then
+// sends checkout.session.expired.
+func (p *StripeProvider) ExpireCheckoutSession(ctx context.Context, sessionID string) error {
+	_, err := p.api.CheckoutSessions.Expire(sessionID, &stripe.CheckoutSessionExpireParams{Params: stripe.Params{Context: ctx}})
+	if err != nil {
+		return fmt.Errorf("failed to expire checkout session %s: %w", sessionID, err)
+	}
+	return nil
+}
 
-	default:
-		log.Printf("Unhandled Stripe event type: %s", event.Type)
+// stripePayment describes a Checkout Session as a Payment.
+func stripePayment(s *stripe.CheckoutSession) Payment {
+	payment := Payment{
+		SessionID: s.ID,
+		Status:    CheckoutStatus(s.Status),
+		Paid:      sessionPaid(s),
+		Amount:    Money{Amount: s.AmountTotal, Currency: strings.ToUpper(string(s.Currency))},
+	}
+	if s.PaymentIntent != nil {
+		payment.PaymentIntentID = s.PaymentIntent.ID
 	}
+	return payment
+}
 
-	return nil
+// sessionPaid reports whether the checkout session was paid, or had nothing
+// to pay, e.g. when a coupon took off the whole total.
+func sessionPaid(s *stripe.CheckoutSession) bool {
+	return s.PaymentStatus == stripe.CheckoutSessionPaymentStatusPaid ||
+		s.PaymentStatus == stripe.CheckoutSessionPaymentStatusNoPaymentRequired
 }
@@ -0,0 +1,362 @@
+package models
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Tax classes group products taxed at the same rate. Products are
+// TaxClassStandard unless set otherwise.
+const (
+	TaxClassStandard = "standard"
+	TaxClassReduced  = "reduced"
+	TaxClassZero     = "zero"
+)
+
+// TaxClasses lists the tax classes a product can have.
+var TaxClasses = []string{TaxClassStandard, TaxClassReduced, TaxClassZero}
+
+// IsTaxClass reports whether class is one of TaxClasses.
+func IsTaxClass(class string) bool {
+	return slices.Contains(TaxClasses, class)
+}
+
+// TaxLine is one tax charged on an item, such as a state sales tax or VAT.
+type TaxLine struct {
+	Name   string `json:"name"`   // e.g. "VAT" or "CA State Tax"
+	Rate   int    `json:"rate"`   // in thousandths of a percent, e.g. 7250 for 7.25%
+	Amount Money  `json:"amount"` // tax on the item's line total
+}
+
+// Label names the tax with its rate, e.g. "VAT 20%".
+func (l TaxLine) Label() string {
+	return l.Name + " " + FormatTaxRate(l.Rate)
+}
+
+// FormatTaxRate writes a rate in thousandths of a percent as a percentage, e.g. "7.25%".
+func FormatTaxRate(rate int) string {
+	s := strconv.FormatFloat(float64(rate)/1000, 'f', -1, 64)
+	return s + "%"
+}
+
+// ParseTaxRate reads a percentage such as "7.25" or "20%" into thousandths of a percent.
+func ParseTaxRate(s string) (int, error) {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "%")
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" || len(frac) > 3 {
+		return 0, fmt.Errorf("invalid tax rate %q: use a percentage with up to 3 decimals", s)
+	}
+	frac += strings.Repeat("0", 3-len(frac))
+	rate, err := strconv.Atoi(whole + frac)
+	if err != nil || rate < 0 || rate > 100000 {
+		return 0, fmt.Errorf("invalid tax rate %q: use a percentage between 0 and 100", s)
+	}
+	return rate, nil
+}
+
+// TaxableItem is an item to be taxed: its tax class and what the customer
+// pays for the line, after discounts.
+type TaxableItem struct {
+	TaxClass string
+	Amount   Money
+}
+
+// TaxBreakdown is the tax on each item of a cart or order.
+type TaxBreakdown struct {
+	// Inclusive means the prices already include the tax, which is shown
+	// but not added to the total
+	Inclusive bool
+	Items     [][]TaxLine // tax lines for each item, in item order
+}
+
+// check returns an error unless the breakdown has the tax lines of count items,
+// all in currency. A TaxCalculator may be a remote service, so ApplyTax checks
+// what it returns once, before Total and sumTaxLines add it up.
+func (b *TaxBreakdown) check(count int, currency string) error {
+	if len(b.Items) != count {
+		return fmt.Errorf("tax for %d items, want %d", len(b.Items), count)
+	}
+	for _, lines := range b.Items {
+		for _, line := range lines {
+			if line.Amount.Currency != currency {
+				return fmt.Errorf("%s in %s, want %s", line.Label(), line.Amount.Currency, currency)
+			}
+		}
+	}
+	return nil
+}
+
+// Total adds up the tax on every item.
+func (b *TaxBreakdown) Total(currency string) Money {
+	total := Money{Currency: currency}
+	for _, lines := range b.Items {
+		for _, line := range lines {
+			total = total.Add(line.Amount)
+		}
+	}
+	return total
+}
+
+// sumTaxLines adds up the tax lines of every item by name and rate, in the
+// order the taxes first appear.
+func sumTaxLines(items [][]TaxLine) []TaxLine {
+	var sums []TaxLine
+	for _, lines := range items {
+		for _, line := range lines {
+			i := slices.IndexFunc(sums, func(s TaxLine) bool { return s.Name == line.Name && s.Rate == line.Rate })
+			if i < 0 {
+				sums = append(sums, line)
+				continue
+			}
+			sums[i].Amount = sums[i].Amount.Add(line.Amount)
+		}
+	}
+	return sums
+}
+
+// TaxCalculator works out the tax on items delivered to an address.
+type TaxCalculator interface {
+	// Calculate returns the tax lines for each of the items, in order.
+	Calculate(ctx context.Context, to Address, items []TaxableItem) (*TaxBreakdown, error)
+}
+
+// TaxRule is one row of a TaxRuleTable.
+type TaxRule struct {
+	Country string // ISO code, or "*" for every country
+	State   string // state code, or "" for the whole country
+	// PostalCode is a postal code, a prefix ending in "*" such as "100*", or
+	// "" for the whole state or country
+	PostalCode string
+	TaxClass   string // the class taxed, or "" for every class
+	Rate       int    // in thousandths of a percent
+	Name       string
+	// Priority separates taxes that add up, such as a federal and a provincial
+	// tax: for each priority, the most specific matching rule applies
+	Priority int
+}
+
+// specificity ranks how closely the rule matches an address and tax class,
+// or returns -1 if it does not match.
+func (r TaxRule) specificity(to Address, taxClass string) int {
+	score := 0
+	switch {
+	case r.Country == to.Country:
+		score += 1
+	case r.Country != "*":
+		return -1
+	}
+	if r.State != "" {
+		if r.State != to.State {
+			return -1
+		}
+		score += 10
+	}
+	if prefix, ok := strings.CutSuffix(r.PostalCode, "*"); ok {
+		if !strings.HasPrefix(to.PostalCode, prefix) {
+			return -1
+		}
+		score += 100 + len(prefix) // longer prefixes are more specific
+	} else if r.PostalCode != "" {
+		if r.PostalCode != to.PostalCode {
+			return -1
+		}
+		score += 1000
+	}
+	if r.TaxClass != "" {
+		if r.TaxClass != taxClass {
+			return -1
+		}
+		score += 10000
+	}
+	return score
+}
+
+// TaxRuleTable is a TaxCalculator that looks up rates in a table of rules by
+// country, state, postal code and tax class. Addresses no rule matches are
+// not taxed.
+type TaxRuleTable struct {
+	Rules []TaxRule
+	// Inclusive means catalog prices include tax: the tax is worked out of
+	// the price at the destination's rate instead of being added to it
+	Inclusive bool
+}
+
+// rules returns the rules that apply to an item of the tax class delivered
+// to the address, one per priority, in priority order.
+func (t *TaxRuleTable) rules(to Address, taxClass string) []TaxRule {
+	best := make(map[int]TaxRule)
+	scores := make(map[int]int)
+	for _, rule := range t.Rules {
+		score := rule.specificity(to, taxClass)
+		if prev, ok := scores[rule.Priority]; score < 0 || (ok && prev >= score) {
+			continue
+		}
+		best[rule.Priority] = rule
+		scores[rule.Priority] = score
+	}
+
+	var rules []TaxRule
+	for _, rule := range best {
+		rules = append(rules, rule)
+	}
+	slices.SortFunc(rules, func(a, b TaxRule) int { return a.Priority - b.Priority })
+	return rules
+}
+
+// Calculate looks up the rates for each item and rounds each tax to the
+// nearest minor unit. With inclusive prices, the tax is the part of the
+// amount that the rates make up.
+func (t *TaxRuleTable) Calculate(ctx context.Context, to Address, items []TaxableItem) (*TaxBreakdown, error) {
+	to.Normalize()
+	breakdown := &TaxBreakdown{Inclusive: t.Inclusive, Items: make([][]TaxLine, len(items))}
+	for i, item := range items {
+		rules := t.rules(to, item.TaxClass)
+		combined := 0
+		for _, rule := range rules {
+			combined += rule.Rate
+		}
+		for _, rule := range rules {
+			if rule.Rate == 0 {
+				continue
+			}
+			// Rates are in thousandths of a percent: 100000 is 100%
+			divisor := int64(100000)
+			if t.Inclusive {
+				divisor += int64(combined)
+			}
+			amount := (item.Amount.Amount*int64(rule.Rate) + divisor/2) / divisor
+			breakdown.Items[i] = append(breakdown.Items[i], TaxLine{
+				Name:   rule.Name,
+				Rate:   rule.Rate,
+				Amount: Money{Amount: amount, Currency: item.Amount.Currency},
+			})
+		}
+	}
+	return breakdown, nil
+}
+
+// taxRuleColumns is the header of a tax rules file.
+var taxRuleColumns = []string{"country", "state", "postal_code", "tax_class", "rate", "name", "priority"}
+
+// ReadTaxRules reads tax rules from CSV with the columns country, state,
+// postal_code, tax_class, rate (a percentage), name and priority.
+func ReadTaxRules(r io.Reader) ([]TaxRule, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading tax rules header: %w", err)
+	}
+	if !slices.Equal(header, taxRuleColumns) {
+		return nil, fmt.Errorf("tax rules must have the columns %s", strings.Join(taxRuleColumns, ","))
+	}
+
+	var rules []TaxRule
+	for {
+		fields, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading tax rules: %w", err)
+		}
+		line, _ := cr.FieldPos(0)
+		rule := TaxRule{
+			Country:    strings.ToUpper(strings.TrimSpace(fields[0])),
+			State:      strings.ToUpper(strings.TrimSpace(fields[1])),
+			PostalCode: strings.ToUpper(strings.TrimSpace(fields[2])),
+			TaxClass:   strings.TrimSpace(fields[3]),
+			Name:       strings.TrimSpace(fields[5]),
+		}
+		if rule.Country == "" {
+			return nil, fmt.Errorf("tax rules line %d: country is required, or * for every country", line)
+		}
+		if rule.TaxClass != "" && !IsTaxClass(rule.TaxClass) {
+			return nil, fmt.Errorf("tax rules line %d: unknown tax class %q", line, rule.TaxClass)
+		}
+		if rule.Rate, err = ParseTaxRate(fields[4]); err != nil {
+			return nil, fmt.Errorf("tax rules line %d: %w", line, err)
+		}
+		if priority := strings.TrimSpace(fields[6]); priority != "" {
+			if rule.Priority, err = strconv.Atoi(priority); err != nil {
+				return nil, fmt.Errorf("tax rules line %d: priority must be a whole number", line)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// TaxConfig configures the tax calculator.
+type TaxConfig struct {
+	RulesFile        string // CSV file of tax rules; empty for no tax
+	PricesIncludeTax bool
+}
+
+// TaxConfigFromEnv reads TAX_RULES_FILE and PRICES_INCLUDE_TAX.
+func TaxConfigFromEnv() TaxConfig {
+	cfg := TaxConfig{RulesFile: "data/tax_rules.csv"}
+	if file, ok := os.LookupEnv("TAX_RULES_FILE"); ok {
+		cfg.RulesFile = file
+	}
+	cfg.PricesIncludeTax, _ = strconv.ParseBool(os.Getenv("PRICES_INCLUDE_TAX"))
+	return cfg
+}
+
+// NewTaxCalculator returns a TaxRuleTable with the rules of the configured file.
+func NewTaxCalculator(cfg TaxConfig) (TaxCalculator, error) {
+	table := &TaxRuleTable{Inclusive: cfg.PricesIncludeTax}
+	if cfg.RulesFile == "" {
+		return table, nil
+	}
+	f, err := os.Open(cfg.RulesFile)
+	if err != nil {
+		return nil, fmt.Errorf("error opening tax rules: %w", err)
+	}
+	defer f.Close()
+	if table.Rules, err = ReadTaxRules(f); err != nil {
+		return nil, fmt.Errorf("error loading %s: %w", cfg.RulesFile, err)
+	}
+	return table, nil
+}
+
+// ApplyTax works out the tax on the cart for its shipping address with the
+// calculator and sets cart.Tax; a cart without an address is not taxed yet.
+// Each discount is shared out over the lines it applies to (see
+// shareDiscounts), so each line is taxed on what the customer pays for it.
+func ApplyTax(ctx context.Context, taxes TaxCalculator, cart *Cart) error {
+	cart.Tax = nil
+	if cart.ShippingAddress.IsZero() || cart.IsEmpty() {
+		return nil
+	}
+
+	keys := make([]string, len(cart.Items))
+	totals := make([]int64, len(cart.Items))
+	for i, item := range cart.Items {
+		keys[i], totals[i] = item.Key(), item.LineTotal().Amount
+	}
+	off := shareDiscounts(keys, totals, cart.Discounts)
+	items := make([]TaxableItem, len(cart.Items))
+	for i, item := range cart.Items {
+		amount := item.LineTotal()
+		amount.Amount -= off[i]
+		items[i] = TaxableItem{TaxClass: item.TaxClass, Amount: amount}
+	}
+
+	breakdown, err := taxes.Calculate(ctx, cart.ShippingAddress, items)
+	if err != nil {
+		return fmt.Errorf("error calculating tax: %w", err)
+	}
+	if err := breakdown.check(len(items), cart.Currency); err != nil {
+		return fmt.Errorf("error calculating tax: %w", err)
+	}
+	cart.Tax = breakdown
+	return nil
+}
@@ -0,0 +1,209 @@
+package models
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+// sampleTaxRules mirror data/tax_rules.csv.
+var sampleTaxRules = []TaxRule{
+	{Country: "US", State: "CA", Rate: 7250, Name: "CA Sales Tax", Priority: 1},
+	{Country: "US", State: "NY", Rate: 4000, Name: "NY State Tax", Priority: 1},
+	{Country: "US", State: "NY", PostalCode: "100*", Rate: 4500, Name: "NYC Sales Tax", Priority: 2},
+	{Country: "US", State: "NY", PostalCode: "100*", TaxClass: TaxClassZero, Rate: 0, Name: "NYC Sales Tax", Priority: 2},
+	{Country: "US", TaxClass: TaxClassZero, Rate: 0, Name: "Sales Tax", Priority: 1},
+	{Country: "GB", TaxClass: TaxClassStandard, Rate: 20000, Name: "VAT", Priority: 1},
+	{Country: "GB", TaxClass: TaxClassReduced, Rate: 5000, Name: "VAT", Priority: 1},
+	{Country: "FR", TaxClass: TaxClassReduced, Rate: 5500, Name: "TVA", Priority: 1},
+	{Country: "CA", Rate: 5000, Name: "GST", Priority: 1},
+	{Country: "CA", State: "BC", Rate: 7000, Name: "PST", Priority: 2},
+}
+
+func TestTaxRuleTableCalculate(t *testing.T) {
+	tests := []struct {
+		name      string
+		inclusive bool
+		to        Address
+		class     string
+		amount    int64
+		want      []TaxLine // amounts in USD
+	}{
+		{
+			name: "state rate", to: Address{Country: "US", State: "CA", PostalCode: "94103"},
+			class: TaxClassStandard, amount: 10000,
+			want: []TaxLine{{Name: "CA Sales Tax", Rate: 7250, Amount: Money{725, "USD"}}},
+		},
+		{
+			name: "rounded to the nearest cent", to: Address{Country: "US", State: "CA"},
+			class: TaxClassStandard, amount: 999,
+			want: []TaxLine{{Name: "CA Sales Tax", Rate: 7250, Amount: Money{72, "USD"}}},
+		},
+		{
+			name: "postal code prefix adds a city tax", to: Address{Country: "us", State: "ny", PostalCode: "10001"},
+			class: TaxClassStandard, amount: 10000,
+			want: []TaxLine{
+				{Name: "NY State Tax", Rate: 4000, Amount: Money{400, "USD"}},
+				{Name: "NYC Sales Tax", Rate: 4500, Amount: Money{450, "USD"}},
+			},
+		},
+		{
+			name: "outside the postal code prefix", to: Address{Country: "US", State: "NY", PostalCode: "12207"},
+			class: TaxClassStandard, amount: 10000,
+			want: []TaxLine{{Name: "NY State Tax", Rate: 4000, Amount: Money{400, "USD"}}},
+		},
+		{
+			name: "tax class beats the prefix", to: Address{Country: "US", State: "NY", PostalCode: "10001"},
+			class: TaxClassZero, amount: 10000,
+			want: nil,
+		},
+		{
+			name: "GST and PST stack", to: Address{Country: "CA", State: "BC", PostalCode: "V6B 1A1"},
+			class: TaxClassStandard, amount: 10000,
+			want: []TaxLine{
+				{Name: "GST", Rate: 5000, Amount: Money{500, "USD"}},
+				{Name: "PST", Rate: 7000, Amount: Money{700, "USD"}},
+			},
+		},
+		{
+			name: "GST only outside BC", to: Address{Country: "CA", State: "ON"},
+			class: TaxClassStandard, amount: 10000,
+			want: []TaxLine{{Name: "GST", Rate: 5000, Amount: Money{500, "USD"}}},
+		},
+		{
+			name: "reduced rate", to: Address{Country: "FR"},
+			class: TaxClassReduced, amount: 1000,
+			want: []TaxLine{{Name: "TVA", Rate: 5500, Amount: Money{55, "USD"}}},
+		},
+		{
+			name: "no matching rule", to: Address{Country: "JP"},
+			class: TaxClassStandard, amount: 10000,
+			want: nil,
+		},
+		{
+			name: "inclusive VAT", inclusive: true, to: Address{Country: "GB"},
+			class: TaxClassStandard, amount: 1200,
+			want: []TaxLine{{Name: "VAT", Rate: 20000, Amount: Money{200, "USD"}}},
+		},
+		{
+			name: "inclusive stacked taxes use the combined rate", inclusive: true, to: Address{Country: "CA", State: "BC"},
+			class: TaxClassStandard, amount: 11200,
+			want: []TaxLine{
+				{Name: "GST", Rate: 5000, Amount: Money{500, "USD"}},
+				{Name: "PST", Rate: 7000, Amount: Money{700, "USD"}},
+			},
+		},
+		{
+			name: "inclusive with the postal code prefix", inclusive: true, to: Address{Country: "US", State: "NY", PostalCode: "10018"},
+			class: TaxClassStandard, amount: 10850,
+			want: []TaxLine{
+				{Name: "NY State Tax", Rate: 4000, Amount: Money{400, "USD"}},
+				{Name: "NYC Sales Tax", Rate: 4500, Amount: Money{450, "USD"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := &TaxRuleTable{Rules: sampleTaxRules, Inclusive: tt.inclusive}
+			items := []TaxableItem{{TaxClass: tt.class, Amount: Money{tt.amount, "USD"}}}
+			breakdown, err := table.Calculate(context.Background(), tt.to, items)
+			if err != nil {
+				t.Fatalf("Calculate: %v", err)
+			}
+			if breakdown.Inclusive != tt.inclusive {
+				t.Errorf("Inclusive = %v, want %v", breakdown.Inclusive, tt.inclusive)
+			}
+			if len(breakdown.Items) != 1 {
+				t.Fatalf("got tax for %d items, want 1", len(breakdown.Items))
+			}
+			if got := breakdown.Items[0]; !slices.Equal(got, tt.want) {
+				t.Errorf("tax lines = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTaxBreakdownTotals(t *testing.T) {
+	table := &TaxRuleTable{Rules: sampleTaxRules}
+	items := []TaxableItem{
+		{TaxClass: TaxClassStandard, Amount: Money{10000, "USD"}},
+		{TaxClass: TaxClassStandard, Amount: Money{2000, "USD"}},
+	}
+	breakdown, err := table.Calculate(context.Background(), Address{Country: "CA", State: "BC"}, items)
+	if err != nil {
+		t.Fatalf("Calculate: %v", err)
+	}
+	if got, want := breakdown.Total("USD"), (Money{1440, "USD"}); got != want {
+		t.Errorf("Total = %+v, want %+v", got, want)
+	}
+	want := []TaxLine{
+		{Name: "GST", Rate: 5000, Amount: Money{600, "USD"}},
+		{Name: "PST", Rate: 7000, Amount: Money{840, "USD"}},
+	}
+	if got := sumTaxLines(breakdown.Items); !slices.Equal(got, want) {
+		t.Errorf("sumTaxLines = %+v, want %+v", got, want)
+	}
+
+	if err := breakdown.check(2, "USD"); err != nil {
+		t.Errorf("check: %v", err)
+	}
+	if err := breakdown.check(3, "USD"); err == nil {
+		t.Error("check accepted tax for too few items")
+	}
+	if err := breakdown.check(2, "EUR"); err == nil {
+		t.Error("check accepted tax in another currency")
+	}
+}
+
+func TestApplyTaxScopedDiscount(t *testing.T) {
+	ctx := context.Background()
+	taxes := &TaxRuleTable{Rules: sampleTaxRules}
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// 10% off the book only: the tee is taxed at 20% on its full price, and
+			// the book at 5% on its discounted price
+			if err := store.Coupons.Create(ctx, Coupon{Code: "BOOKS", Kind: CouponPercentOff, PercentOff: 10, ProductIDs: []string{"book"}, Active: true}); err != nil {
+				t.Fatal(err)
+			}
+			cart := NewCart("GBP")
+			cart.Items = []CartItem{
+				{ProductID: "tee", TaxClass: TaxClassStandard, Quantity: 2, UnitPrice: Money{1000, "GBP"}},
+				{ProductID: "book", TaxClass: TaxClassReduced, Quantity: 1, UnitPrice: Money{3000, "GBP"}},
+			}
+			cart.CouponCodes = []string{"BOOKS"}
+			cart.ShippingAddress = Address{Name: "Ann", Line1: "1 High St", City: "London", PostalCode: "N1 1AA", Country: "GB"}
+
+			problems, err := ApplyCoupons(ctx, store, cart, "")
+			if err != nil || len(problems) > 0 {
+				t.Fatalf("ApplyCoupons: %v %v", problems, err)
+			}
+			if err := ApplyTax(ctx, taxes, cart); err != nil {
+				t.Fatal(err)
+			}
+			if got := cart.Tax.Items[0][0].Amount.Amount; got != 400 {
+				t.Errorf("tax on the tees = %d, want 400", got)
+			}
+			if got := cart.Tax.Items[1][0].Amount.Amount; got != 135 {
+				t.Errorf("tax on the book = %d, want 135", got)
+			}
+			if got := cart.Totals().Total.Amount; got != 5235 {
+				t.Errorf("total = %d, want 5235", got)
+			}
+
+			// The order remembers which lines the discount came off, so refunds
+			// give back what was paid for each
+			order := cart.ToOrder("ann@example.com")
+			if err := store.Orders.Save(ctx, order); err != nil {
+				t.Fatal(err)
+			}
+			stored, err := store.Orders.GetByID(ctx, order.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paid := stored.PaidForItems()
+			if paid[0].Amount != 2400 || paid[1].Amount != 2835 {
+				t.Errorf("paid for items = %v, want 2400 and 2835", paid)
+			}
+		})
+	}
+}
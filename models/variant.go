@@ -0,0 +1,101 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Variant is a purchasable version of a product, such as one size and color
+type Variant struct {
+	ID             string            `json:"id"`
+	ProductID      string            `json:"product_id"`
+	SKU            string            `json:"sku"`
+	Options        map[string]string `json:"options"` // option name to value, e.g. "Size": "M"
+	Prices         map[string]Money  `json:"prices"`  // per-currency overrides of the product price
+	ImageURL       string            `json:"image_url"`
+	Stock          int               `json:"stock"`
+	TrackInventory bool              `json:"track_inventory"`
+}
+
+// InStock reports whether at least one unit of the variant can be bought.
+func (v *Variant) InStock() bool {
+	return v.CanFulfil(1)
+}
+
+// CanFulfil reports whether quantity units of the variant are available right now.
+func (v *Variant) CanFulfil(quantity int) bool {
+	return !v.TrackInventory || v.Stock >= quantity
+}
+
+// HasVariants reports whether the product must be bought as one of its variants.
+func (p *Product) HasVariants() bool {
+	return len(p.Variants) > 0
+}
+
+// FindVariant returns the product's variant with the given ID.
+func (p *Product) FindVariant(id string) (Variant, bool) {
+	for _, v := range p.Variants {
+		if v.ID == id {
+			return v, true
+		}
+	}
+	return Variant{}, false
+}
+
+// VariantPriceIn returns the price of a variant in the given currency: its own
+// override if it has one, otherwise the product's price.
+func (p *Product) VariantPriceIn(v Variant, currency string) (Money, bool) {
+	if price, ok := v.Prices[currency]; ok && price.Currency == currency {
+		return price, true
+	}
+	return p.PriceIn(currency)
+}
+
+// VariantPrice returns the price of a variant in the currency the product is
+// currently priced in (see InCurrency).
+func (p *Product) VariantPrice(v Variant) Money {
+	price, ok := p.VariantPriceIn(v, p.Price.Currency)
+	if !ok {
+		return p.Price
+	}
+	return price
+}
+
+// VariantTitle describes a variant by its option values in option order, e.g. "M / Black".
+func (p *Product) VariantTitle(v Variant) string {
+	values := make([]string, 0, len(v.Options))
+	for _, name := range p.Options {
+		if value, ok := v.Options[name]; ok {
+			values = append(values, value)
+		}
+	}
+	return strings.Join(values, " / ")
+}
+
+// VariantImage returns the variant's own image, falling back to the product's
+// main image.
+func (p *Product) VariantImage(v Variant) string {
+	if v.ImageURL != "" {
+		return v.ImageURL
+	}
+	return p.Image().URL()
+}
+
+// resolveVariant checks that variantID is valid for the product: required when
+// the product has variants and forbidden when it has none.
+func (p *Product) resolveVariant(variantID string) (*Variant, error) {
+	if !p.HasVariants() {
+		if variantID != "" {
+			return nil, fmt.Errorf("product %s has no variants", p.ID)
+		}
+		return nil, nil
+	}
+	if variantID == "" {
+		return nil, fmt.Errorf("product %s requires a variant to be chosen", p.ID)
+	}
+	v, ok := p.FindVariant(variantID)
+	if !ok {
+		return nil, fmt.Errorf("variant %s of product %s: %w", variantID, p.ID, ErrNotFound)
+	}
+	return &v, nil
+}
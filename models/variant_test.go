@@ -0,0 +1,108 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// hoodie has a size M that tracks its stock and a size L that does not.
+var hoodie = Product{ID: "hoodie", Name: "Hoodie", Price: Money{4000, "USD"}, Options: []string{"Size", "Color"},
+	Variants: []Variant{
+		{ID: "hood-m", SKU: "HOOD-M", Options: map[string]string{"Color": "Black", "Size": "M"}, Stock: 2, TrackInventory: true},
+		{ID: "hood-l", SKU: "HOOD-L", Options: map[string]string{"Size": "L"}},
+	}}
+
+func TestResolveVariant(t *testing.T) {
+	mug := Product{ID: "mug", Name: "Mug", Price: Money{1500, "USD"}}
+	tests := []struct {
+		name      string
+		product   Product
+		variantID string
+		want      string // ID of the variant, empty for none
+		wantErr   bool
+	}{
+		{name: "no variants", product: mug},
+		{name: "variant of a product without any", product: mug, variantID: "hood-m", wantErr: true},
+		{name: "variant", product: hoodie, variantID: "hood-l", want: "hood-l"},
+		{name: "no variant chosen", product: hoodie, wantErr: true},
+		{name: "unknown variant", product: hoodie, variantID: "hood-xl", wantErr: true},
+	}
+	for _, tt := range tests {
+		v, err := tt.product.resolveVariant(tt.variantID)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want an error: %v", tt.name, err, tt.wantErr)
+		}
+		var got string
+		if v != nil {
+			got = v.ID
+		}
+		if got != tt.want {
+			t.Errorf("%s: resolved %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVariantTitleAndStock(t *testing.T) {
+	p := hoodie
+	if got := p.VariantTitle(p.Variants[0]); got != "M / Black" {
+		t.Errorf("title = %q, want the values in option order", got)
+	}
+	if got := p.VariantTitle(p.Variants[1]); got != "L" {
+		t.Errorf("title = %q, want L", got)
+	}
+
+	if !p.Variants[0].CanFulfil(2) || p.Variants[0].CanFulfil(3) || !p.Variants[1].CanFulfil(1000) {
+		t.Error("variants should fulfil up to their tracked stock, and anything when untracked")
+	}
+	p.Variants = []Variant{p.Variants[0]}
+	p.Variants[0].Stock = 0
+	if p.InStock() {
+		t.Error("product in stock with its only variant sold out")
+	}
+	p.Stock, p.TrackInventory = 10, true // the product's own stock does not count
+	if p.InStock() {
+		t.Error("product in stock through its own stock despite having variants")
+	}
+}
+
+func TestVariantSKUsAndStock(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range testStores(t, withCatalog(nil, []Product{hoodie})) {
+		t.Run(name, func(t *testing.T) {
+			// SKUs are unique across products
+			other := Product{ID: "tee", Name: "Tee", Price: Money{1000, "USD"}, Options: []string{"Size"},
+				Variants: []Variant{{ID: "tee-m", SKU: "HOOD-M", Options: map[string]string{"Size": "M"}}}}
+			if err := store.Products.Create(ctx, other); !errors.Is(err, ErrSKUConflict) {
+				t.Errorf("creating a product reusing SKU HOOD-M = %v, want ErrSKUConflict", err)
+			}
+
+			// Stock is taken from the variant ordered
+			m := OrderItem{ProductID: "hoodie", VariantID: "hood-m", Quantity: 2}
+			l := OrderItem{ProductID: "hoodie", VariantID: "hood-l", Quantity: 50}
+			if err := store.Inventory.Reserve(ctx, "order-1", []OrderItem{m, l}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Inventory.Reserve(ctx, "order-2", []OrderItem{l, m}); !errors.Is(err, ErrOutOfStock) {
+				t.Fatalf("reserving sold out size M = %v, want ErrOutOfStock", err)
+			}
+			p, err := store.Products.GetByID(ctx, "hoodie")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v, _ := p.FindVariant("hood-m"); v.Stock != 0 {
+				t.Errorf("size M has %d units left, want 0", v.Stock)
+			}
+			if err := store.Inventory.Release(ctx, "order-1"); err != nil {
+				t.Fatal(err)
+			}
+			p, err = store.Products.GetByID(ctx, "hoodie")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v, _ := p.FindVariant("hood-m"); v.Stock != 2 || v.SKU != "HOOD-M" || v.Options["Color"] != "Black" {
+				t.Errorf("size M came back as %+v, want its 2 units back", v)
+			}
+		})
+	}
+}
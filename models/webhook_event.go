@@ -0,0 +1,121 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// WebhookOutcome says what became of a webhook event.
+type WebhookOutcome string
+
+const (
+	// WebhookProcessing means the event is being handled, or handling it was cut short
+	WebhookProcessing WebhookOutcome = "processing"
+	// WebhookProcessed means the event was handled
+	WebhookProcessed WebhookOutcome = "processed"
+	// WebhookFailed means handling the event returned an error
+	WebhookFailed WebhookOutcome = "failed"
+)
+
+// webhookClaimTimeout is how long an event can be processing before a
+// redelivery takes it over, taking the earlier attempt to have been cut short,
+// e.g. by a crash. Handling an event takes well under a second.
+const webhookClaimTimeout = 5 * time.Minute
+
+// WebhookEvent is a webhook event as it was received, and what became of it.
+type WebhookEvent struct {
+	ID          string // the provider's event ID
+	Type        string
+	Payload     []byte // the request body, as signed by the provider
+	Outcome     WebhookOutcome
+	Error       string // why handling failed
+	Attempts    int    // times handling was started, replays included
+	ReceivedAt  time.Time
+	ClaimedAt   time.Time // when handling last started
+	ProcessedAt time.Time // when handling last finished; zero while processing
+}
+
+// PrettyPayload is the payload indented for reading, or as it is if it is not JSON.
+func (e WebhookEvent) PrettyPayload() string {
+	var v any
+	if err := json.Unmarshal(e.Payload, &v); err != nil {
+		return string(e.Payload)
+	}
+	pretty, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return string(e.Payload)
+	}
+	return string(pretty)
+}
+
+// ProcessWebhookEvent records a verified webhook event and handles it, unless
+// an earlier delivery of the same event was handled or is being handled.
+// Providers deliver events at least once, so this keeps their effects from
+// happening twice. An event whose handling failed, or has been processing for
+// longer than webhookClaimTimeout, is handled again when it is delivered again.
+func ProcessWebhookEvent(ctx context.Context, store *Store, payments PaymentProvider, event PaymentEvent, payload []byte) error {
+	claimed, err := store.WebhookEvents.Claim(ctx, WebhookEvent{
+		ID:         event.ID,
+		Type:       event.Type,
+		Payload:    payload,
+		ReceivedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		log.Printf("Skipping webhook event %s (%s): already handled", event.ID, event.Type)
+		return nil
+	}
+	return handleWebhookEvent(ctx, store, payments, event)
+}
+
+// ReplayWebhookEvent handles a stored webhook event again, whatever became of
+// it before, e.g. after fixing the bug that made it fail. The event was
+// verified when it was received.
+func ReplayWebhookEvent(ctx context.Context, store *Store, payments PaymentProvider, id string) error {
+	stored, err := store.WebhookEvents.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	event, err := parsePaymentEvent(stored.Payload)
+	if err != nil {
+		return fmt.Errorf("error parsing stored webhook event %s: %w", id, err)
+	}
+	if err := store.WebhookEvents.Restart(ctx, id); err != nil {
+		return err
+	}
+	log.Printf("Replaying webhook event %s (%s)", event.ID, event.Type)
+	return handleWebhookEvent(ctx, store, payments, event)
+}
+
+// handleWebhookEvent handles a claimed event and records the outcome.
+func handleWebhookEvent(ctx context.Context, store *Store, payments PaymentProvider, event PaymentEvent) error {
+	handleErr := HandlePaymentEvent(ctx, store, payments, event)
+	outcome, message := WebhookProcessed, ""
+	if handleErr != nil {
+		outcome, message = WebhookFailed, handleErr.Error()
+	}
+	if err := store.WebhookEvents.Finish(ctx, event.ID, outcome, message); err != nil {
+		log.Printf("Error recording outcome of webhook event %s: %v", event.ID, err)
+	}
+	return handleErr
+}
+
+// parsePaymentEvent reads a Stripe-format event from a webhook payload.
+func parsePaymentEvent(payload []byte) (PaymentEvent, error) {
+	var raw struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object json.RawMessage `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return PaymentEvent{}, err
+	}
+	return PaymentEvent{ID: raw.ID, Type: raw.Type, Object: raw.Data.Object}, nil
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SQLWebhookEventRepository is a WebhookEventRepository backed by the webhook_events table.
+type SQLWebhookEventRepository struct {
+	sqlRepository
+}
+
+// Claim inserts the event, or takes back a failed or stale one, as processing.
+func (r *SQLWebhookEventRepository) Claim(ctx context.Context, e WebhookEvent) (bool, error) {
+	now := time.Now()
+	res, err := r.conn.ExecContext(ctx,
+		r.q("INSERT INTO webhook_events (id, type, payload, outcome, error, attempts, received_at, claimed_at) VALUES (?, ?, ?, ?, '', 1, ?, ?) ON CONFLICT(id) DO NOTHING"),
+		e.ID, e.Type, string(e.Payload), string(WebhookProcessing), e.ReceivedAt, now,
+	)
+	if err != nil {
+		return false, fmt.Errorf("error saving webhook event %s: %w", e.ID, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return false, fmt.Errorf("error saving webhook event %s: %w", e.ID, err)
+	} else if n == 1 {
+		return true, nil
+	}
+
+	// Delivered before: only a failed event, or one whose handling was cut
+	// short, is handled again
+	res, err = r.conn.ExecContext(ctx,
+		r.q("UPDATE webhook_events SET outcome = ?, error = '', attempts = attempts + 1, claimed_at = ?, processed_at = NULL "+
+			"WHERE id = ? AND (outcome = ? OR (outcome = ? AND (claimed_at IS NULL OR claimed_at < ?)))"),
+		string(WebhookProcessing), now, e.ID, string(WebhookFailed), string(WebhookProcessing), now.Add(-webhookClaimTimeout),
+	)
+	if err != nil {
+		return false, fmt.Errorf("error claiming webhook event %s: %w", e.ID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error claiming webhook event %s: %w", e.ID, err)
+	}
+	return n == 1, nil
+}
+
+// Restart sets the event back to processing.
+func (r *SQLWebhookEventRepository) Restart(ctx context.Context, id string) error {
+	res, err := r.conn.ExecContext(ctx,
+		r.q("UPDATE webhook_events SET outcome = ?, error = '', attempts = attempts + 1, claimed_at = ?, processed_at = NULL WHERE id = ?"),
+		string(WebhookProcessing), time.Now(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("error restarting webhook event %s: %w", id, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("webhook event %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// Finish records the outcome of handling the event.
+func (r *SQLWebhookEventRepository) Finish(ctx context.Context, id string, outcome WebhookOutcome, message string) error {
+	_, err := r.conn.ExecContext(ctx,
+		r.q("UPDATE webhook_events SET outcome = ?, error = ?, processed_at = ? WHERE id = ?"),
+		string(outcome), message, time.Now(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving outcome of webhook event %s: %w", id, err)
+	}
+	return nil
+}
+
+// webhookEventColumns are the columns of the webhook_events table read by scanWebhookEvent.
+const webhookEventColumns = "id, type, payload, outcome, error, attempts, received_at, claimed_at, processed_at"
+
+// scanWebhookEvent reads an event from a row of webhookEventColumns.
+func scanWebhookEvent(row interface{ Scan(...any) error }) (WebhookEvent, error) {
+	var e WebhookEvent
+	var payload, outcome string
+	var claimedAt, processedAt sql.NullTime
+	if err := row.Scan(&e.ID, &e.Type, &payload, &outcome, &e.Error, &e.Attempts, &e.ReceivedAt, &claimedAt, &processedAt); err != nil {
+		return WebhookEvent{}, err
+	}
+	e.Payload = []byte(payload)
+	e.Outcome = WebhookOutcome(outcome)
+	e.ClaimedAt = claimedAt.Time
+	e.ProcessedAt = processedAt.Time
+	return e, nil
+}
+
+// GetByID returns the event with the given ID.
+func (r *SQLWebhookEventRepository) GetByID(ctx context.Context, id string) (WebhookEvent, error) {
+	row := r.conn.QueryRowContext(ctx, r.q("SELECT "+webhookEventColumns+" FROM webhook_events WHERE id = ?"), id)
+	e, err := scanWebhookEvent(row)
+	if err == sql.ErrNoRows {
+		return WebhookEvent{}, fmt.Errorf("webhook event %s: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return WebhookEvent{}, fmt.Errorf("error fetching webhook event %s: %w", id, err)
+	}
+	return e, nil
+}
+
+// List returns up to limit events, most recently received first.
+func (r *SQLWebhookEventRepository) List(ctx context.Context, outcome WebhookOutcome, limit int) ([]WebhookEvent, error) {
+	query := "SELECT " + webhookEventColumns + " FROM webhook_events"
+	var args []any
+	if outcome != "" {
+		query += " WHERE outcome = ?"
+		args = append(args, string(outcome))
+	}
+	query += " ORDER BY received_at DESC, id LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := r.conn.QueryContext(ctx, r.q(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing webhook events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []WebhookEvent
+	for rows.Next() {
+		e, err := scanWebhookEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning webhook event row: %w", err)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating through webhook event rows: %w", err)
+	}
+	return events, nil
+}
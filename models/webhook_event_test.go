@@ -0,0 +1,117 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// ageWebhookEvent moves back the time the event was last claimed by d.
+func ageWebhookEvent(t *testing.T, repo WebhookEventRepository, id string, d time.Duration) {
+	t.Helper()
+	switch repo := repo.(type) {
+	case *MemoryWebhookEventRepository:
+		repo.mu.Lock()
+		defer repo.mu.Unlock()
+		repo.events[id].ClaimedAt = repo.events[id].ClaimedAt.Add(-d)
+	case *SQLWebhookEventRepository:
+		e, err := repo.GetByID(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.conn.Exec("UPDATE webhook_events SET claimed_at = ? WHERE id = ?", e.ClaimedAt.Add(-d), id); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatalf("cannot age events in a %T", repo)
+	}
+}
+
+func TestWebhookEventClaim(t *testing.T) {
+	type step struct {
+		before       func(t *testing.T, repo WebhookEventRepository) // e.g. how the last attempt ended
+		wantClaimed  bool
+		wantAttempts int
+	}
+	finish := func(outcome WebhookOutcome) func(*testing.T, WebhookEventRepository) {
+		return func(t *testing.T, repo WebhookEventRepository) {
+			if err := repo.Finish(context.Background(), "evt_1", outcome, ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	stall := func(d time.Duration) func(*testing.T, WebhookEventRepository) {
+		return func(t *testing.T, repo WebhookEventRepository) { ageWebhookEvent(t, repo, "evt_1", d) }
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"new event", []step{{nil, true, 1}}},
+		{"redelivered while processing", []step{{nil, true, 1}, {nil, false, 1}}},
+		{"redelivered after processing", []step{{nil, true, 1}, {finish(WebhookProcessed), false, 1}}},
+		{"redelivered after failing", []step{{nil, true, 1}, {finish(WebhookFailed), true, 2}, {nil, false, 2}}},
+		{"processing for a while", []step{{nil, true, 1}, {stall(webhookClaimTimeout / 2), false, 1}}},
+		{"left processing", []step{{nil, true, 1}, {stall(webhookClaimTimeout + time.Minute), true, 2}, {nil, false, 2}}},
+		{"processed long ago", []step{{nil, true, 1}, {finish(WebhookProcessed), false, 1}, {stall(time.Hour), false, 1}}},
+	}
+	for _, tt := range tests {
+		for name, store := range testStores(t) {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				repo := store.WebhookEvents
+				ctx := context.Background()
+				for i, s := range tt.steps {
+					if s.before != nil {
+						s.before(t, repo)
+					}
+					claimed, err := repo.Claim(ctx, WebhookEvent{ID: "evt_1", Type: "checkout.session.completed", Payload: []byte("{}"), ReceivedAt: time.Now()})
+					if err != nil {
+						t.Fatalf("step %d: Claim: %v", i, err)
+					}
+					if claimed != s.wantClaimed {
+						t.Errorf("step %d: claimed = %v, want %v", i, claimed, s.wantClaimed)
+					}
+					e, err := repo.GetByID(ctx, "evt_1")
+					if err != nil {
+						t.Fatal(err)
+					}
+					if e.Attempts != s.wantAttempts {
+						t.Errorf("step %d: attempts = %d, want %d", i, e.Attempts, s.wantAttempts)
+					}
+					if claimed && (e.Outcome != WebhookProcessing || !e.ProcessedAt.IsZero()) {
+						t.Errorf("step %d: claimed event is %s, processed at %v", i, e.Outcome, e.ProcessedAt)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestWebhookEventRestart(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			repo := store.WebhookEvents
+			ctx := context.Background()
+			if err := repo.Restart(ctx, "evt_missing"); err == nil {
+				t.Error("restarted an unknown event")
+			}
+			if _, err := repo.Claim(ctx, WebhookEvent{ID: "evt_1", Type: "charge.refunded", Payload: []byte("{}"), ReceivedAt: time.Now()}); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.Finish(ctx, "evt_1", WebhookProcessed, ""); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.Restart(ctx, "evt_1"); err != nil {
+				t.Fatal(err)
+			}
+			e, err := repo.GetByID(ctx, "evt_1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.Outcome != WebhookProcessing || e.Attempts != 2 {
+				t.Errorf("restarted event is %s after %d attempts, want processing after 2", e.Outcome, e.Attempts)
+			}
+		})
+	}
+}
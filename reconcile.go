@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"ecommerce-app/models"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	// defaultReconcileInterval is how often orders are reconciled with the payment provider
+	defaultReconcileInterval = 10 * time.Minute
+	// defaultReconcileAfter is how old an order must be before it is reconciled,
+	// leaving its webhooks time to arrive
+	defaultReconcileAfter = 30 * time.Minute
+)
+
+// reconcileConfigFromEnv reads RECONCILE_INTERVAL, where 0 turns the
+// reconciler off, and RECONCILE_AFTER, e.g. "15m", falling back to the defaults.
+func reconcileConfigFromEnv() (interval, after time.Duration) {
+	interval, after = defaultReconcileInterval, defaultReconcileAfter
+	if v := os.Getenv("RECONCILE_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Printf("Invalid RECONCILE_INTERVAL %q, using %s", v, defaultReconcileInterval)
+		} else {
+			interval = d
+		}
+	}
+	if v := os.Getenv("RECONCILE_AFTER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Printf("Invalid RECONCILE_AFTER %q, using %s", v, defaultReconcileAfter)
+		} else {
+			after = d
+		}
+	}
+	return interval, after
+}
+
+// reconcilePayments reconciles orders older than after with the payment
+// provider, now and then every interval.
+func reconcilePayments(store *models.Store, payments models.PaymentProvider, interval, after time.Duration) {
+	for {
+		report, err := models.ReconcilePayments(context.Background(), store, payments, after)
+		if err != nil {
+			log.Printf("Error reconciling payments: %v", err)
+		} else if report.Paid+report.Expired+report.Cancelled+report.Closed+report.Refunded+len(report.Mismatches)+report.Errors > 0 {
+			log.Printf("Reconciled payments: %s", reconcileSummary(report))
+		}
+		time.Sleep(interval)
+	}
+}
+
+// runReconcileCommand handles `reconcile [-after duration]`: it reconciles
+// orders with the payment provider once and prints what it found.
+func runReconcileCommand(store *models.Store, payments models.PaymentProvider, args []string) {
+	_, defaultAfter := reconcileConfigFromEnv()
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	after := flags.Duration("after", defaultAfter, "only orders placed at least this long ago")
+	flags.Parse(args)
+
+	report, err := models.ReconcilePayments(context.Background(), store, payments, *after)
+	if err != nil {
+		log.Fatalf("Error reconciling payments: %v", err)
+	}
+	fmt.Println(reconcileSummary(report))
+	for _, m := range report.Mismatches {
+		fmt.Printf("  mismatch: %s\n", m)
+	}
+	if len(report.Mismatches) > 0 || report.Errors > 0 {
+		os.Exit(1)
+	}
+}
+
+// reconcileSummary describes a reconciliation run in one line.
+func reconcileSummary(r models.ReconcileReport) string {
+	return fmt.Sprintf("%d order(s) checked, %d marked paid, %d expired, %d cancelled, %d session(s) closed, %d refunded, %d mismatch(es), %d error(s)",
+		r.Checked, r.Paid, r.Expired, r.Cancelled, r.Closed, r.Refunded, len(r.Mismatches), r.Errors)
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore keeps blobs in a directory that the app serves as static files.
+type LocalStore struct {
+	dir     string
+	baseURL string
+}
+
+// NewLocalStore returns a store writing to dir, whose contents are served at baseURL.
+func NewLocalStore(dir, baseURL string) *LocalStore {
+	return &LocalStore{dir: dir, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// Put writes the blob to a temporary file first and renames it into place,
+// so that a blob is never served half-written.
+func (s *LocalStore) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return fmt.Errorf("error creating directory for %s: %w", key, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", key, err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing %s: %w", key, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("error writing %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return fmt.Errorf("error saving %s: %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes the blob's file.
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error deleting %s: %w", key, err)
+	}
+	return nil
+}
+
+// URL returns the blob's path under the static file route.
+func (s *LocalStore) URL(key string) string {
+	return s.baseURL + "/" + key
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// S3Config configures an S3-compatible object store (AWS S3, MinIO, R2, ...).
+type S3Config struct {
+	Endpoint        string // e.g. https://s3.eu-west-1.amazonaws.com or http://localhost:9000
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PublicURL is where the bucket's objects can be read, e.g. a CDN. It
+	// defaults to the bucket's address at Endpoint.
+	PublicURL string
+	// PathStyle addresses the bucket as Endpoint/bucket rather than as a
+	// bucket.Endpoint subdomain. Most S3-compatible servers need it.
+	PathStyle bool
+}
+
+// S3Store keeps blobs in an S3 bucket, signing requests with AWS Signature
+// Version 4. Objects must be publicly readable, e.g. through a bucket policy.
+type S3Store struct {
+	cfg    S3Config
+	bucket *url.URL // base URL of the bucket
+	client *http.Client
+}
+
+// NewS3Store returns a store for the bucket described by cfg.
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" || cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, errors.New("S3 storage needs S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY")
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT %q", cfg.Endpoint)
+	}
+
+	bucket := *endpoint
+	if cfg.PathStyle {
+		bucket.Path += "/" + cfg.Bucket
+	} else {
+		bucket.Host = cfg.Bucket + "." + bucket.Host
+	}
+	if cfg.PublicURL == "" {
+		cfg.PublicURL = bucket.String()
+	}
+	cfg.PublicURL = strings.TrimSuffix(cfg.PublicURL, "/")
+	return &S3Store{cfg: cfg, bucket: &bucket, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// Put uploads the blob with a PUT Object request.
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", key, err)
+	}
+	headers := http.Header{}
+	headers.Set("Content-Type", contentType)
+	headers.Set("Cache-Control", "public, max-age=31536000, immutable")
+	return s.do(ctx, http.MethodPut, key, body, headers)
+}
+
+// Delete removes the blob with a DELETE Object request.
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	return s.do(ctx, http.MethodDelete, key, nil, http.Header{})
+}
+
+// URL returns the blob's address under the public URL.
+func (s *S3Store) URL(key string) string {
+	return s.cfg.PublicURL + "/" + escapePath(key)
+}
+
+// do sends a signed request for the object stored under key.
+func (s *S3Store) do(ctx context.Context, method, key string, body []byte, headers http.Header) error {
+	u := *s.bucket
+	u.Path += "/" + key
+	u.RawPath = s.bucket.EscapedPath() + "/" + escapePath(key)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = headers
+	s.sign(req, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending %s %s to S3: %w", method, key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 && !(method == http.MethodDelete && resp.StatusCode == http.StatusNotFound) {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("S3 %s %s failed with %s: %s", method, key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req, signing
+// its headers as they are set now.
+func (s *S3Store) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Every header set on the request is signed, as well as the host
+	values := map[string]string{"host": req.URL.Host}
+	for name, v := range req.Header {
+		values[strings.ToLower(name)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	signed := slices.Sorted(maps.Keys(values))
+	var canonicalHeaders strings.Builder
+	for _, name := range signed {
+		canonicalHeaders.WriteString(name + ":" + values[name] + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"", // no query string
+		canonicalHeaders.String(),
+		strings.Join(signed, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), date)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKeyID, scope, strings.Join(signed, ";"), signature))
+}
+
+// escapePath URI-encodes each segment of a slash-separated key the way
+// Signature Version 4 expects.
+func escapePath(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,87 @@
+// Package storage keeps uploaded files (product images) in a blob store: the
+// local filesystem by default, or an S3-compatible object store.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// ErrInvalidKey is returned for keys that are empty or try to escape the store.
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// BlobStore stores files under slash-separated keys such as
+// "products/prod_1/img_ab12-thumb.jpg" and serves them from public URLs.
+type BlobStore interface {
+	// Put stores the content under key, replacing any existing blob.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Delete removes the blob stored under key. Deleting a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns the public URL of the blob stored under key.
+	URL(key string) string
+}
+
+// Config selects and configures the blob store.
+type Config struct {
+	Driver string // "local" (default) or "s3"
+
+	// Local filesystem
+	Dir     string // directory blobs are written to
+	BaseURL string // URL path the directory is served from
+
+	// S3-compatible object store
+	S3 S3Config
+}
+
+// ConfigFromEnv builds a Config from STORAGE_* and S3_* environment
+// variables, defaulting to ./static/uploads served as /static/uploads.
+func ConfigFromEnv() Config {
+	return Config{
+		Driver:  envString("STORAGE_DRIVER", "local"),
+		Dir:     envString("STORAGE_DIR", "./static/uploads"),
+		BaseURL: envString("STORAGE_BASE_URL", "/static/uploads"),
+		S3: S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          envString("S3_REGION", "us-east-1"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PublicURL:       os.Getenv("S3_PUBLIC_URL"),
+			PathStyle:       os.Getenv("S3_PATH_STYLE") != "false",
+		},
+	}
+}
+
+// New returns the blob store described by cfg.
+func New(cfg Config) (BlobStore, error) {
+	switch cfg.Driver {
+	case "", "local":
+		return NewLocalStore(cfg.Dir, cfg.BaseURL), nil
+	case "s3":
+		return NewS3Store(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q (use local or s3)", cfg.Driver)
+	}
+}
+
+// cleanKey checks that key is a relative slash-separated path that stays
+// inside the store.
+func cleanKey(key string) (string, error) {
+	cleaned := path.Clean("/" + key)[1:]
+	if key == "" || cleaned != key || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return cleaned, nil
+}
+
+func envString(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
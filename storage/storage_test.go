@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCleanKey(t *testing.T) {
+	for key, valid := range map[string]bool{
+		"products/prod_1/img_ab12-thumb.jpg": true,
+		"":                                   false,
+		"/products/img.jpg":                  false,
+		"../img.jpg":                         false,
+		"products/../../img.jpg":             false,
+		"products/./img.jpg":                 false,
+		"products//img.jpg":                  false,
+		`products\..\img.jpg`:                false,
+	} {
+		cleaned, err := cleanKey(key)
+		if valid && (err != nil || cleaned != key) {
+			t.Errorf("cleanKey(%q) = %q, %v; want it unchanged", key, cleaned, err)
+		}
+		if !valid && !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("cleanKey(%q) = %q, %v; want ErrInvalidKey", key, cleaned, err)
+		}
+	}
+}
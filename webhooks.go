@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"ecommerce-app/models"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// runWebhooksCommand handles `webhooks list [-outcome o] [-n count]` and
+// `webhooks replay <event-id>`. Replayed events refund through payments.
+func runWebhooksCommand(store *models.Store, payments models.PaymentProvider, args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: webhooks list [-outcome processing|processed|failed] [-n count]")
+		fmt.Fprintln(os.Stderr, "       webhooks replay <event-id>")
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "list":
+		flags := flag.NewFlagSet("webhooks list", flag.ExitOnError)
+		outcome := flags.String("outcome", "", "only events with this outcome")
+		count := flags.Int("n", 20, "how many events to list")
+		flags.Parse(args[1:])
+
+		events, err := store.WebhookEvents.List(ctx, models.WebhookOutcome(*outcome), *count)
+		if err != nil {
+			log.Fatalf("Error listing webhook events: %v", err)
+		}
+		for _, e := range events {
+			fmt.Printf("%s  %-40s  %-34s  %-10s  %d\n", e.ReceivedAt.Format("2006-01-02 15:04:05"), e.ID, e.Type, e.Outcome, e.Attempts)
+			if e.Error != "" {
+				fmt.Printf("    %s\n", e.Error)
+			}
+		}
+
+	case "replay":
+		if len(args) != 2 {
+			usage()
+		}
+		if err := models.ReplayWebhookEvent(ctx, store, payments, args[1]); err != nil {
+			log.Fatalf("Replay failed: %v", err)
+		}
+		fmt.Printf("Replayed webhook event %s\n", args[1])
+
+	default:
+		usage()
+	}
+}